@@ -106,7 +106,7 @@ type Connect interface {
 	GetActiveConns(ResultMap) ResultMap
 	ClearConns() ResultMap
 	ScanStorage() (ClientCtrlrMap, ClientModuleMap)
-	FormatStorage() (ClientCtrlrMap, ClientMountMap)
+	FormatStorage(*pb.FormatStorageReq) (ClientCtrlrMap, ClientMountMap)
 	UpdateStorage(*pb.UpdateStorageReq) (ClientCtrlrMap, ClientModuleMap)
 	// TODO: implement Burnin client features
 	//BurninStorage() (ClientCtrlrMap, ClientModuleMap)
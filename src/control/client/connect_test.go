@@ -197,7 +197,7 @@ func TestFormatStorage(t *testing.T) {
 			MockModuleResults, MockMountResults, nil, tt.formatRet, nil, nil,
 			nil, nil)
 
-		cNvmeMap, cMountMap := cc.FormatStorage()
+		cNvmeMap, cMountMap := cc.FormatStorage(new(pb.FormatStorageReq))
 
 		if tt.formatRet != nil {
 			for _, addr := range MockServers {
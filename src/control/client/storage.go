@@ -186,7 +186,7 @@ func (c *connList) ScanStorage() (ClientCtrlrMap, ClientModuleMap) {
 // Calls control formatStorage routine which activates FormatStorage service rpc
 // and returns an open stream handle. Receive on stream and send ClientResult
 // over channel for each.
-func formatStorageRequest(mc Control, parms interface{}, ch chan ClientResult) {
+func formatStorageRequest(mc Control, req interface{}, ch chan ClientResult) {
 	sRes := StorageResult{}
 
 	// Maximum time limit for format is 2hrs to account for lengthy low
@@ -194,7 +194,20 @@ func formatStorageRequest(mc Control, parms interface{}, ch chan ClientResult) {
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Minute)
 	defer cancel()
 
-	stream, err := mc.getCtlClient().FormatStorage(ctx, &pb.FormatStorageReq{})
+	var formatReq *pb.FormatStorageReq
+	switch v := req.(type) {
+	case *pb.FormatStorageReq:
+		formatReq = v
+	default:
+		err := errors.Errorf(
+			msgTypeAssert, pb.FormatStorageReq{}, req)
+
+		log.Errorf(err.Error())
+		ch <- ClientResult{mc.getAddress(), nil, err}
+		return // type err
+	}
+
+	stream, err := mc.getCtlClient().FormatStorage(ctx, formatReq)
 	if err != nil {
 		ch <- ClientResult{mc.getAddress(), nil, err}
 		return // stream err
@@ -221,8 +234,10 @@ func formatStorageRequest(mc Control, parms interface{}, ch chan ClientResult) {
 
 // FormatStorage prepares nonvolatile storage devices attached to each
 // remote server in the connection list for use with DAOS.
-func (c *connList) FormatStorage() (ClientCtrlrMap, ClientMountMap) {
-	cResults := c.makeRequests(nil, formatStorageRequest)
+func (c *connList) FormatStorage(req *pb.FormatStorageReq) (
+	ClientCtrlrMap, ClientMountMap) {
+
+	cResults := c.makeRequests(req, formatStorageRequest)
 	cCtrlrResults := make(ClientCtrlrMap) // srv address:NVMe SSDs
 	cMountResults := make(ClientMountMap) // srv address:SCM mounts
 
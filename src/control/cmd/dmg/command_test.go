@@ -78,8 +78,8 @@ func (tc *testConn) ScanStorage() (client.ClientCtrlrMap, client.ClientModuleMap
 	return nil, nil
 }
 
-func (tc *testConn) FormatStorage() (client.ClientCtrlrMap, client.ClientMountMap) {
-	tc.appendInvocation("FormatStorage")
+func (tc *testConn) FormatStorage(req *pb.FormatStorageReq) (client.ClientCtrlrMap, client.ClientMountMap) {
+	tc.appendInvocation(fmt.Sprintf("FormatStorage-%s", req))
 	return nil, nil
 }
 
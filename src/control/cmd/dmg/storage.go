@@ -34,7 +34,7 @@ import (
 type StorCmd struct {
 	Scan   ScanStorCmd   `command:"scan" alias:"s" description:"Scan SCM and NVMe storage attached to remote servers."`
 	Format FormatStorCmd `command:"format" alias:"f" description:"Format SCM and NVMe storage attached to remote servers."`
-	Update UpdateStorCmd `command:"fwupdate" alias:"u" description:"Update firmware on NVMe storage attached to remote servers."`
+	Update UpdateStorCmd `command:"fwupdate" alias:"u" description:"Update firmware on NVMe and SCM storage attached to remote servers."`
 }
 
 // ScanStorCmd is the struct representing the scan storage subcommand.
@@ -60,11 +60,13 @@ func (s *ScanStorCmd) Execute(args []string) error {
 type FormatStorCmd struct {
 	broadcastCmd
 	connectedCmd
-	Force bool `short:"f" long:"force" description:"Perform format without prompting for confirmation"`
+	Force    bool `short:"f" long:"force" description:"Perform format without prompting for confirmation"`
+	Reformat bool `long:"reformat" description:"Reformat SCM even if already formatted, destroying existing data"`
+	DryRun   bool `long:"dry-run" description:"Plan SCM format without making changes, planned commands are logged by each server"`
 }
 
 // run NVMe and SCM storage format on all connected servers
-func formatStor(conns client.Connect, force bool) {
+func formatStor(conns client.Connect, req *pb.FormatStorageReq, force bool) {
 	fmt.Println(
 		"This is a destructive operation and storage devices " +
 			"specified in the server config file will be erased.\n" +
@@ -72,7 +74,7 @@ func formatStor(conns client.Connect, force bool) {
 
 	if force || getConsent() {
 		fmt.Println("")
-		cCtrlrResults, cMountResults := conns.FormatStorage()
+		cCtrlrResults, cMountResults := conns.FormatStorage(req)
 		fmt.Printf("NVMe storage format results:\n%s", cCtrlrResults)
 		fmt.Printf("SCM storage format results:\n%s", cMountResults)
 	}
@@ -80,7 +82,13 @@ func formatStor(conns client.Connect, force bool) {
 
 // Execute is run when FormatStorCmd activates
 func (s *FormatStorCmd) Execute(args []string) error {
-	formatStor(s.conns, s.Force)
+	req := &pb.FormatStorageReq{
+		Scm: &pb.FormatScmReq{Force: s.Reformat, DryRun: s.DryRun},
+	}
+
+	// nothing is erased in dry run so no confirmation is needed
+	formatStor(s.conns, req, s.Force || s.DryRun)
+
 	return nil
 }
 
@@ -93,6 +101,7 @@ type UpdateStorCmd struct {
 	NVMeStartRev string `short:"r" long:"nvme-fw-rev" description:"Only update firmware on NVMe SSDs currently running this firmware revision." required:"1"`
 	NVMeFwPath   string `short:"p" long:"nvme-fw-path" description:"Update firmware on NVMe SSDs with image file at this path (path must be accessible on all servers)." required:"1"`
 	NVMeFwSlot   int    `short:"s" default:"0" long:"nvme-fw-slot" description:"Update firmware on NVMe SSDs to this firmware register."`
+	ScmFwPath    string `long:"scm-fw-path" description:"Update firmware on SCM modules with image file at this path, image metadata must be in a file at the same path with a .meta suffix (paths must be accessible on all servers)."`
 }
 
 // run NVMe and SCM storage update on all connected servers
@@ -113,15 +122,18 @@ func updateStor(conns client.Connect, req *pb.UpdateStorageReq, force bool) {
 
 // Execute is run when UpdateStorCmd activates
 func (u *UpdateStorCmd) Execute(args []string) error {
-	// only populate nvme fwupdate params for the moment
-	updateStor(
-		u.conns,
-		&pb.UpdateStorageReq{
-			Nvme: &pb.UpdateNvmeReq{
-				Model: u.NVMeModel, Startrev: u.NVMeStartRev,
-				Path: u.NVMeFwPath, Slot: int32(u.NVMeFwSlot),
-			},
-		}, u.Force)
+	req := &pb.UpdateStorageReq{
+		Nvme: &pb.UpdateNvmeReq{
+			Model: u.NVMeModel, Startrev: u.NVMeStartRev,
+			Path: u.NVMeFwPath, Slot: int32(u.NVMeFwSlot),
+		},
+	}
+	// scm modules are only updated if an image is specified
+	if u.ScmFwPath != "" {
+		req.Scm = &pb.UpdateScmReq{Path: u.ScmFwPath}
+	}
+
+	updateStor(u.conns, req, u.Force)
 
 	return nil
 }
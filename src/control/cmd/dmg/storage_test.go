@@ -23,7 +23,36 @@ func TestStorageCommands(t *testing.T) {
 		{
 			"Format with force",
 			"storage format --force",
-			"ConnectClients FormatStorage",
+			strings.Join([]string{
+				"ConnectClients",
+				fmt.Sprintf("FormatStorage-%s", &pb.FormatStorageReq{
+					Scm: &pb.FormatScmReq{},
+				}),
+			}, " "),
+			nil,
+			cmdSuccess,
+		},
+		{
+			"Format with scm reformat",
+			"storage format --force --reformat",
+			strings.Join([]string{
+				"ConnectClients",
+				fmt.Sprintf("FormatStorage-%s", &pb.FormatStorageReq{
+					Scm: &pb.FormatScmReq{Force: true},
+				}),
+			}, " "),
+			nil,
+			cmdSuccess,
+		},
+		{
+			"Format dry run",
+			"storage format --dry-run",
+			strings.Join([]string{
+				"ConnectClients",
+				fmt.Sprintf("FormatStorage-%s", &pb.FormatStorageReq{
+					Scm: &pb.FormatScmReq{DryRun: true},
+				}),
+			}, " "),
 			nil,
 			cmdSuccess,
 		},
@@ -58,6 +87,23 @@ func TestStorageCommands(t *testing.T) {
 			nil,
 			cmdSuccess,
 		},
+		{
+			"Update with scm firmware",
+			"storage fwupdate --force --nvme-model foo --nvme-fw-path bar --nvme-fw-rev 123 --scm-fw-path baz",
+			strings.Join([]string{
+				"ConnectClients",
+				fmt.Sprintf("UpdateStorage-%s", &pb.UpdateStorageReq{
+					Nvme: &pb.UpdateNvmeReq{
+						Model:    "foo",
+						Startrev: "123",
+						Path:     "bar",
+					},
+					Scm: &pb.UpdateScmReq{Path: "baz"},
+				}),
+			}, " "),
+			nil,
+			cmdSuccess,
+		},
 		{
 			"Scan",
 			"storage scan",
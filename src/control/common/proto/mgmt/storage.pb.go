@@ -54,6 +54,7 @@ type ScanStorageResp struct {
 	Nvmestate            *ResponseState    `protobuf:"bytes,2,opt,name=nvmestate,proto3" json:"nvmestate,omitempty"`
 	Modules              []*ScmModule      `protobuf:"bytes,3,rep,name=modules,proto3" json:"modules,omitempty"`
 	Scmstate             *ResponseState    `protobuf:"bytes,4,opt,name=scmstate,proto3" json:"scmstate,omitempty"`
+	Pmems                []*PmemDevice     `protobuf:"bytes,5,rep,name=pmems,proto3" json:"pmems,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
 	XXX_unrecognized     []byte            `json:"-"`
 	XXX_sizecache        int32             `json:"-"`
@@ -111,10 +112,19 @@ func (m *ScanStorageResp) GetScmstate() *ResponseState {
 	return nil
 }
 
+func (m *ScanStorageResp) GetPmems() []*PmemDevice {
+	if m != nil {
+		return m.Pmems
+	}
+	return nil
+}
+
 type FormatStorageReq struct {
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Nvme                 *FormatNvmeReq `protobuf:"bytes,1,opt,name=nvme,proto3" json:"nvme,omitempty"`
+	Scm                  *FormatScmReq  `protobuf:"bytes,2,opt,name=scm,proto3" json:"scm,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
 }
 
 func (m *FormatStorageReq) Reset()         { *m = FormatStorageReq{} }
@@ -141,6 +151,20 @@ func (m *FormatStorageReq) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_FormatStorageReq proto.InternalMessageInfo
 
+func (m *FormatStorageReq) GetNvme() *FormatNvmeReq {
+	if m != nil {
+		return m.Nvme
+	}
+	return nil
+}
+
+func (m *FormatStorageReq) GetScm() *FormatScmReq {
+	if m != nil {
+		return m.Scm
+	}
+	return nil
+}
+
 type FormatStorageResp struct {
 	Crets                []*NvmeControllerResult `protobuf:"bytes,1,rep,name=crets,proto3" json:"crets,omitempty"`
 	Mrets                []*ScmMountResult       `protobuf:"bytes,2,rep,name=mrets,proto3" json:"mrets,omitempty"`
@@ -385,27 +409,28 @@ func init() {
 func init() { proto.RegisterFile("storage.proto", fileDescriptor_storage_fbaf07d8be8e1d9c) }
 
 var fileDescriptor_storage_fbaf07d8be8e1d9c = []byte{
-	// 349 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x93, 0xdd, 0x4e, 0xe3, 0x30,
-	0x10, 0x85, 0x95, 0xfe, 0xed, 0xee, 0x74, 0x77, 0xdb, 0x18, 0x90, 0xa2, 0x5c, 0x55, 0x11, 0x12,
-	0xe5, 0x47, 0x05, 0xca, 0x1b, 0x80, 0xc4, 0x1d, 0x5c, 0x38, 0xe2, 0x1a, 0x05, 0xd7, 0xaa, 0x90,
-	0x32, 0x76, 0x62, 0x3b, 0x7d, 0x4c, 0x9e, 0x09, 0xd9, 0x4e, 0x68, 0x1a, 0x81, 0x2a, 0x21, 0x71,
-	0x7b, 0xe6, 0x9b, 0x39, 0x3e, 0x33, 0x32, 0xfc, 0xd3, 0x46, 0xaa, 0x6c, 0xcd, 0x17, 0x85, 0x92,
-	0x46, 0x92, 0x01, 0xae, 0xd1, 0xc4, 0x7f, 0x99, 0x44, 0x94, 0xc2, 0x6b, 0x31, 0xa9, 0x91, 0x67,
-	0xb1, 0xc1, 0x9a, 0x8b, 0xc3, 0x46, 0xd3, 0x0c, 0xbd, 0x94, 0x4c, 0xe1, 0x7f, 0xca, 0x32, 0x91,
-	0xfa, 0x02, 0xe5, 0x65, 0xf2, 0x16, 0xc0, 0x64, 0x47, 0xd2, 0x05, 0xb9, 0x80, 0x11, 0x33, 0x2a,
-	0x57, 0x3a, 0x0a, 0x66, 0xfd, 0xf9, 0x78, 0x79, 0xb8, 0xb0, 0x8e, 0x8b, 0xc7, 0x0d, 0xf2, 0x3b,
-	0x29, 0x8c, 0x92, 0x79, 0xce, 0x15, 0xad, 0x19, 0x72, 0x0d, 0x7f, 0xac, 0xa9, 0x36, 0x99, 0xe1,
-	0x51, 0x6f, 0x16, 0xcc, 0xc7, 0xcb, 0x03, 0xdf, 0x60, 0x87, 0x49, 0xa1, 0x79, 0x6a, 0x4b, 0x74,
-	0x4b, 0x91, 0x53, 0xf8, 0x85, 0x72, 0x55, 0xe5, 0x5c, 0x47, 0x7d, 0xe7, 0x30, 0xf1, 0x0d, 0x29,
-	0xc3, 0x07, 0xa7, 0xd3, 0xa6, 0x4e, 0x2e, 0xe1, 0xb7, 0x66, 0xe8, 0x87, 0x0f, 0xbe, 0x1e, 0xfe,
-	0x01, 0x25, 0x04, 0xa6, 0xf7, 0x52, 0x61, 0x66, 0x5a, 0x21, 0x4b, 0x08, 0x3b, 0x9a, 0x2e, 0xc8,
-	0x15, 0x0c, 0x99, 0xe2, 0xa6, 0x09, 0x19, 0x7f, 0x1a, 0x92, 0xeb, 0x2a, 0x37, 0xd4, 0x83, 0xe4,
-	0x0c, 0x86, 0xe8, 0x3a, 0x7a, 0xed, 0xb5, 0xb8, 0x47, 0x57, 0xc2, 0x34, 0xac, 0x43, 0x92, 0x0c,
-	0xa6, 0x4f, 0xc5, 0x2a, 0x33, 0x7c, 0xfb, 0x0c, 0x72, 0x02, 0x03, 0xbb, 0x83, 0x28, 0x68, 0xe7,
-	0xf0, 0x94, 0xb5, 0xa5, 0xbc, 0xa4, 0x0e, 0x20, 0xc7, 0xd0, 0xd7, 0x0c, 0xeb, 0x65, 0x92, 0x36,
-	0x97, 0x32, 0xb4, 0x98, 0x2d, 0x27, 0x0a, 0xc2, 0x8e, 0xc5, 0xb7, 0x52, 0x9d, 0xef, 0xa6, 0x3a,
-	0xea, 0x9e, 0xa2, 0x1b, 0xeb, 0xb6, 0x52, 0xe2, 0x55, 0xec, 0x8b, 0xe5, 0xa9, 0xfd, 0xb1, 0xea,
-	0x69, 0xad, 0x58, 0x25, 0x84, 0x1d, 0x8b, 0x9f, 0x3e, 0xd6, 0xcb, 0xc8, 0xfd, 0x8e, 0x9b, 0xf7,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0x69, 0x61, 0x59, 0x37, 0x69, 0x03, 0x00, 0x00,
+	// 366 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb5, 0x93, 0x5b, 0x4b, 0xc3, 0x40,
+	0x10, 0x85, 0xe9, 0x25, 0x55, 0xc7, 0x4b, 0xdb, 0x55, 0x21, 0xe4, 0xa9, 0x04, 0xf1, 0x4e, 0xbc,
+	0xfd, 0x03, 0x15, 0xdf, 0x14, 0x49, 0xf0, 0x59, 0xe2, 0x76, 0x29, 0x85, 0xec, 0x6e, 0xba, 0xbb,
+	0xe9, 0x4f, 0xf7, 0xd9, 0xbd, 0x24, 0x36, 0x0d, 0x4a, 0x40, 0xf0, 0x75, 0xe6, 0x9b, 0x73, 0xf6,
+	0xcc, 0x24, 0xb0, 0x2b, 0x15, 0x17, 0xe9, 0x8c, 0x44, 0xb9, 0xe0, 0x8a, 0xa3, 0x3e, 0x9d, 0x51,
+	0x15, 0xec, 0x60, 0x4e, 0x29, 0x67, 0xae, 0x16, 0xa0, 0x12, 0x79, 0x67, 0x4b, 0x5a, 0x72, 0xc1,
+	0xb8, 0xaa, 0x49, 0x4c, 0x5d, 0x29, 0x1c, 0xc1, 0x5e, 0x82, 0x53, 0x96, 0xb8, 0x46, 0x4c, 0x16,
+	0xe1, 0x67, 0x07, 0x86, 0x6b, 0x25, 0x99, 0xa3, 0x4b, 0x18, 0x60, 0x25, 0x32, 0x21, 0xfd, 0xce,
+	0xa4, 0x77, 0xba, 0x7d, 0x7b, 0x10, 0x19, 0xc7, 0xe8, 0x45, 0x4b, 0x3f, 0x70, 0xa6, 0x04, 0xcf,
+	0x32, 0x22, 0xe2, 0x92, 0x41, 0x37, 0xb0, 0x65, 0x4c, 0xa5, 0x4a, 0x15, 0xf1, 0xbb, 0x93, 0x8e,
+	0x1e, 0xd8, 0x77, 0x03, 0x46, 0x8c, 0x33, 0x49, 0x12, 0xd3, 0x8a, 0x57, 0x14, 0x3a, 0x83, 0x0d,
+	0xca, 0xa7, 0x45, 0x46, 0xa4, 0xdf, 0xb3, 0x0e, 0x43, 0x37, 0x90, 0x60, 0xfa, 0x6c, 0xeb, 0x71,
+	0xd5, 0x47, 0x57, 0xb0, 0xa9, 0x9f, 0xef, 0xc4, 0xfb, 0xbf, 0x8b, 0x7f, 0x43, 0xe8, 0x18, 0xbc,
+	0x9c, 0x12, 0x2a, 0x7d, 0xcf, 0x2a, 0x8f, 0x1c, 0xfd, 0xaa, 0x4b, 0x8f, 0x64, 0x39, 0xc7, 0x24,
+	0x76, 0xed, 0x30, 0x85, 0xd1, 0x13, 0x17, 0x34, 0x55, 0xab, 0x65, 0xa0, 0x13, 0xe8, 0x9b, 0x47,
+	0xea, 0xd8, 0x35, 0x23, 0x47, 0x99, 0xf0, 0x1a, 0x89, 0x2d, 0x80, 0x8e, 0xa0, 0xa7, 0x0d, 0xcb,
+	0xb4, 0xa8, 0xce, 0xe9, 0x08, 0x06, 0x33, 0xed, 0x70, 0x01, 0xe3, 0x86, 0x85, 0x5e, 0xee, 0x35,
+	0x78, 0x58, 0x10, 0x55, 0xed, 0x36, 0xf8, 0x71, 0xb7, 0x44, 0x16, 0x99, 0x8a, 0x1d, 0x88, 0xce,
+	0xc1, 0xa3, 0x76, 0xa2, 0x5b, 0xbf, 0x86, 0xdd, 0x55, 0xc1, 0x54, 0xc5, 0x5a, 0xc4, 0xa4, 0x7a,
+	0xcb, 0xa7, 0x7a, 0x0f, 0x6d, 0xa9, 0x1c, 0xd5, 0x9e, 0xaa, 0x54, 0xab, 0xa5, 0x12, 0x30, 0x6e,
+	0x58, 0xfc, 0x29, 0xd5, 0xc5, 0x7a, 0xaa, 0xc3, 0xe6, 0x17, 0xd0, 0x8c, 0x75, 0x5f, 0x08, 0x36,
+	0x67, 0x6d, 0xb1, 0x1c, 0xd5, 0x1e, 0xab, 0x54, 0x5b, 0x3f, 0x56, 0xc3, 0xe2, 0xbf, 0x8f, 0xf5,
+	0x31, 0xb0, 0x3f, 0xe5, 0xdd, 0x17, 0xd9, 0xcb, 0x79, 0xd2, 0xe0, 0x03, 0x00, 0x00,
 }
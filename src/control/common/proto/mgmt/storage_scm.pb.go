@@ -313,6 +313,8 @@ func (m *ScanScmReq) XXX_DiscardUnknown() {
 var xxx_messageInfo_ScanScmReq proto.InternalMessageInfo
 
 type FormatScmReq struct {
+	Force                bool     `protobuf:"varint,1,opt,name=force,proto3" json:"force,omitempty"`
+	DryRun               bool     `protobuf:"varint,2,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -342,7 +344,22 @@ func (m *FormatScmReq) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_FormatScmReq proto.InternalMessageInfo
 
+func (m *FormatScmReq) GetForce() bool {
+	if m != nil {
+		return m.Force
+	}
+	return false
+}
+
+func (m *FormatScmReq) GetDryRun() bool {
+	if m != nil {
+		return m.DryRun
+	}
+	return false
+}
+
 type UpdateScmReq struct {
+	Path                 string   `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -372,6 +389,13 @@ func (m *UpdateScmReq) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_UpdateScmReq proto.InternalMessageInfo
 
+func (m *UpdateScmReq) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
 type BurninScmReq struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -402,6 +426,61 @@ func (m *BurninScmReq) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_BurninScmReq proto.InternalMessageInfo
 
+// PmemDevice represents SCM persistent memory namespace block device.
+type PmemDevice struct {
+	Uuid                 string   `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Blockdev             string   `protobuf:"bytes,2,opt,name=blockdev,proto3" json:"blockdev,omitempty"`
+	NumaNode             uint32   `protobuf:"varint,3,opt,name=numa_node,json=numaNode,proto3" json:"numa_node,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PmemDevice) Reset()         { *m = PmemDevice{} }
+func (m *PmemDevice) String() string { return proto.CompactTextString(m) }
+func (*PmemDevice) ProtoMessage()    {}
+func (*PmemDevice) Descriptor() ([]byte, []int) {
+	return fileDescriptor_storage_scm_0bc9936a1221be65, []int{8}
+}
+func (m *PmemDevice) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PmemDevice.Unmarshal(m, b)
+}
+func (m *PmemDevice) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PmemDevice.Marshal(b, m, deterministic)
+}
+func (dst *PmemDevice) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PmemDevice.Merge(dst, src)
+}
+func (m *PmemDevice) XXX_Size() int {
+	return xxx_messageInfo_PmemDevice.Size(m)
+}
+func (m *PmemDevice) XXX_DiscardUnknown() {
+	xxx_messageInfo_PmemDevice.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PmemDevice proto.InternalMessageInfo
+
+func (m *PmemDevice) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *PmemDevice) GetBlockdev() string {
+	if m != nil {
+		return m.Blockdev
+	}
+	return ""
+}
+
+func (m *PmemDevice) GetNumaNode() uint32 {
+	if m != nil {
+		return m.NumaNode
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*ScmModule)(nil), "mgmt.ScmModule")
 	proto.RegisterType((*ScmModule_Location)(nil), "mgmt.ScmModule.Location")
@@ -412,31 +491,38 @@ func init() {
 	proto.RegisterType((*FormatScmReq)(nil), "mgmt.FormatScmReq")
 	proto.RegisterType((*UpdateScmReq)(nil), "mgmt.UpdateScmReq")
 	proto.RegisterType((*BurninScmReq)(nil), "mgmt.BurninScmReq")
+	proto.RegisterType((*PmemDevice)(nil), "mgmt.PmemDevice")
 }
 
 func init() { proto.RegisterFile("storage_scm.proto", fileDescriptor_storage_scm_0bc9936a1221be65) }
 
 var fileDescriptor_storage_scm_0bc9936a1221be65 = []byte{
-	// 334 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x92, 0xbd, 0x4e, 0xc3, 0x30,
-	0x14, 0x85, 0x15, 0x5a, 0xfa, 0x73, 0xfb, 0x27, 0x8c, 0x84, 0xa2, 0x0e, 0xa8, 0xca, 0x94, 0x32,
-	0x64, 0x28, 0x6f, 0xc0, 0xc0, 0x04, 0x03, 0x8e, 0x10, 0x23, 0x32, 0xae, 0xd5, 0x46, 0xc4, 0xbe,
-	0x26, 0xbe, 0x91, 0xe8, 0x43, 0xf3, 0x0e, 0x28, 0x6e, 0x7e, 0x2a, 0x06, 0xd4, 0x2d, 0xe7, 0xdc,
-	0x13, 0x7f, 0xe7, 0x5a, 0x86, 0x2b, 0x47, 0x58, 0x88, 0x9d, 0x7a, 0x77, 0x52, 0x27, 0xb6, 0x40,
-	0x42, 0xd6, 0xd7, 0x3b, 0x4d, 0xcb, 0xa9, 0x44, 0xad, 0xd1, 0x1c, 0xbd, 0xe8, 0x27, 0x80, 0x71,
-	0x2a, 0xf5, 0x33, 0x6e, 0xcb, 0x5c, 0xb1, 0x5b, 0x00, 0xbb, 0x3f, 0xb8, 0x4c, 0x8a, 0x3c, 0xdb,
-	0x86, 0xc1, 0x2a, 0x88, 0x67, 0xfc, 0xc4, 0x61, 0x4b, 0x18, 0x49, 0x61, 0x85, 0xcc, 0xe8, 0x10,
-	0x5e, 0xac, 0x82, 0xb8, 0xcf, 0x5b, 0xcd, 0xee, 0xa0, 0x97, 0xa3, 0x0c, 0x7b, 0xab, 0x20, 0x9e,
-	0x6c, 0xc2, 0xa4, 0x62, 0x25, 0xed, 0xc9, 0xc9, 0x13, 0x4a, 0x41, 0x19, 0x1a, 0x5e, 0x85, 0x96,
-	0xdf, 0x30, 0x6a, 0x0c, 0x16, 0xc2, 0x50, 0xee, 0x85, 0x31, 0x2a, 0xaf, 0x81, 0x8d, 0xac, 0xda,
-	0xd4, 0x9f, 0x16, 0x9d, 0xe7, 0xcd, 0xf8, 0x89, 0x53, 0xb5, 0xd1, 0x4a, 0x4b, 0x2a, 0xf2, 0xc2,
-	0x63, 0x67, 0xbc, 0xd5, 0xec, 0x06, 0x06, 0x0e, 0xe5, 0xa7, 0xa2, 0xb0, 0xef, 0x27, 0xb5, 0x8a,
-	0x5e, 0x60, 0xe4, 0x4b, 0x95, 0x86, 0xfc, 0xff, 0x86, 0x2c, 0x66, 0x86, 0x3c, 0x7a, 0xcc, 0x5b,
-	0xcd, 0xd6, 0x30, 0xd4, 0xbe, 0x79, 0x05, 0xee, 0xc5, 0x93, 0xcd, 0xe2, 0xcf, 0x46, 0xbc, 0x99,
-	0x47, 0x7b, 0x58, 0x74, 0xae, 0x72, 0x65, 0x4e, 0xcd, 0x5d, 0x04, 0x67, 0xdc, 0x05, 0x5b, 0xc3,
-	0xa5, 0x23, 0x41, 0xca, 0x2f, 0x38, 0xd9, 0x5c, 0x1f, 0xd3, 0x5c, 0x39, 0x8b, 0xc6, 0xa9, 0xb4,
-	0x1a, 0xf1, 0x63, 0x22, 0x7a, 0x83, 0x79, 0x53, 0xbe, 0x06, 0xfd, 0xbf, 0xc2, 0xd9, 0x07, 0x4f,
-	0x01, 0x52, 0x29, 0x4c, 0x2a, 0x35, 0x57, 0x5f, 0xd1, 0x1c, 0xa6, 0x8f, 0x58, 0x68, 0x41, 0x9d,
-	0x7e, 0xb5, 0x5b, 0x41, 0xaa, 0xd3, 0x0f, 0x65, 0x61, 0xb2, 0x3a, 0xff, 0x31, 0xf0, 0x4f, 0xe9,
-	0xfe, 0x37, 0x00, 0x00, 0xff, 0xff, 0x10, 0x03, 0x88, 0x7d, 0x73, 0x02, 0x00, 0x00,
+	// 418 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8d, 0x52, 0x3d, 0x4f, 0xc3, 0x30,
+	0x10, 0x55, 0x69, 0x68, 0xd3, 0x23, 0x6d, 0x85, 0x41, 0x10, 0x15, 0x09, 0xa1, 0x4c, 0xc0, 0xd0,
+	0xa1, 0xcc, 0x2c, 0x08, 0x31, 0x01, 0x02, 0x57, 0x08, 0x31, 0x55, 0xc6, 0x31, 0x34, 0x6a, 0x6c,
+	0x07, 0xc7, 0xae, 0xe8, 0x8f, 0xe6, 0x3f, 0x60, 0x3b, 0x49, 0x8b, 0x18, 0x10, 0x9b, 0xdf, 0xbb,
+	0x8f, 0xf7, 0xee, 0x7c, 0xb0, 0x5b, 0x6a, 0xa9, 0xc8, 0x3b, 0x9b, 0x95, 0x94, 0x8f, 0x0b, 0x25,
+	0xb5, 0x44, 0x01, 0x7f, 0xe7, 0x7a, 0x14, 0x51, 0xc9, 0xb9, 0x14, 0x15, 0x97, 0x7c, 0xb5, 0xa0,
+	0x37, 0xa5, 0xfc, 0x4e, 0xa6, 0x26, 0x67, 0xe8, 0x18, 0xa0, 0x98, 0xaf, 0xca, 0x8c, 0x92, 0x3c,
+	0x4b, 0xe3, 0xd6, 0x49, 0xeb, 0xb4, 0x8f, 0x7f, 0x30, 0x68, 0x04, 0x21, 0x25, 0x05, 0xa1, 0x99,
+	0x5e, 0xc5, 0x5b, 0x36, 0x1a, 0xe0, 0x35, 0x46, 0xe7, 0xd0, 0xce, 0x25, 0x8d, 0xdb, 0x96, 0xde,
+	0x99, 0xc4, 0x63, 0xa7, 0x35, 0x5e, 0x77, 0x1e, 0xdf, 0x4a, 0x4a, 0x74, 0x26, 0x05, 0x76, 0x49,
+	0xa3, 0x4f, 0x08, 0x1b, 0x02, 0xc5, 0xd0, 0xa5, 0x73, 0x22, 0x04, 0xcb, 0x6b, 0xc1, 0x06, 0x3a,
+	0x37, 0xf5, 0xb3, 0x90, 0xa5, 0xd7, 0xb3, 0x6e, 0x36, 0x8c, 0x73, 0xc3, 0x19, 0xa7, 0x5a, 0xe5,
+	0xca, 0xcb, 0xf6, 0xf1, 0x1a, 0xa3, 0x03, 0xe8, 0x94, 0x92, 0x2e, 0x98, 0x8e, 0x03, 0x1f, 0xa9,
+	0x51, 0xf2, 0x08, 0xa1, 0x37, 0x65, 0x84, 0xf6, 0xf5, 0x42, 0x17, 0x32, 0x13, 0xda, 0x4b, 0xf7,
+	0xf0, 0x1a, 0xa3, 0x33, 0xe8, 0x72, 0xef, 0xdc, 0x09, 0xb7, 0xed, 0x44, 0xc3, 0x5f, 0x13, 0xe1,
+	0x26, 0x9e, 0xcc, 0x61, 0xb8, 0x61, 0x59, 0x69, 0x72, 0xdd, 0xec, 0xa2, 0xf5, 0x8f, 0x5d, 0x58,
+	0xa5, 0xed, 0x52, 0x13, 0xcd, 0xfc, 0x80, 0x3b, 0x93, 0xbd, 0x2a, 0xdb, 0x36, 0x2a, 0xa4, 0x28,
+	0xd9, 0xd4, 0x85, 0x70, 0x95, 0x91, 0x3c, 0xc3, 0xa0, 0x31, 0x5f, 0x0b, 0xfd, 0x3d, 0xc2, 0xbf,
+	0x1b, 0x47, 0x00, 0x53, 0x4a, 0x84, 0x6d, 0x8e, 0xd9, 0x47, 0x72, 0x09, 0xd1, 0x8d, 0x54, 0x9c,
+	0xe8, 0x0a, 0xa3, 0x7d, 0xd8, 0x7e, 0x93, 0x8a, 0x32, 0xaf, 0x10, 0xe2, 0x0a, 0xa0, 0x43, 0xe8,
+	0xa6, 0x6a, 0x35, 0x53, 0x46, 0x78, 0x81, 0x10, 0x77, 0x2c, 0xc4, 0x46, 0x24, 0x09, 0x44, 0x4f,
+	0x45, 0x6a, 0xdb, 0xd6, 0xe5, 0x08, 0x82, 0x82, 0xe8, 0x79, 0xed, 0xcf, 0xbf, 0x93, 0x01, 0x44,
+	0x57, 0x46, 0x89, 0xac, 0x91, 0x7c, 0x01, 0x78, 0xb0, 0x7f, 0x77, 0xcd, 0x96, 0x99, 0x6d, 0x6d,
+	0x2b, 0x8c, 0xa9, 0x0f, 0xd0, 0x56, 0xb8, 0xb7, 0x9b, 0xf4, 0xd5, 0xae, 0x6b, 0x91, 0xb2, 0xa5,
+	0xd7, 0xb3, 0x93, 0x36, 0x18, 0x1d, 0x41, 0x4f, 0x18, 0x4e, 0x66, 0x42, 0xa6, 0xac, 0xb9, 0x04,
+	0x47, 0xdc, 0x5b, 0xfc, 0xda, 0xf1, 0x87, 0x7e, 0xf1, 0x0d, 0x98, 0xf3, 0x77, 0x38, 0x11, 0x03,
+	0x00, 0x00,
 }
@@ -26,19 +26,94 @@ package faults
 // Code represents a stable fault code.
 //
 // NB: All control plane errors should register their codes in the
-// following block in order to avoid conflicts.
+// block for their domain in order to avoid conflicts. Canonical faults
+// registered with MustRegister are checked for conflicting codes at init.
+//
+// Each block has its own explicit base so that appending a code to one
+// block never shifts the values of codes in another. The bases preserve the
+// values the codes had before the blocks were split. New codes must only
+// ever be added at the end of a block, before its end marker, and must have
+// a canonical fault registered in known.go.
 type Code int
 
+// general fault codes
 const (
-	// general fault codes
-	CodeUnknown Code = iota
+	CodeUnknown Code = 0
+)
 
-	// storage fault codes
-	CodeStorageUnknown Code = iota + 100
+// storage fault codes
+const (
+	CodeStorageUnknown Code = iota + 101
 	CodeStorageAlreadyFormatted
 	CodeStorageFilesystemMounted
 	CodeStorageFormatCheckFailed
+	CodeStorageScmDegradedRegion
+	CodeStorageTmpfsNoMemory
+	CodeStorageDuplicateScmMount
+	CodeStorageDuplicateScmDevice
+	CodeStorageScmMismatchedCapacities
+	CodeStorageScmGoalMismatch
+	CodeStorageScmPartitionedDevice
+	CodeStorageScmDaxUnsupported
+	CodeStorageScmNotPmemNamespace
+	CodeStorageScmNoRegions
+	CodeStorageScmFirmwareIncompatible
+	CodeStorageScmVerifyFailed
+	CodeStorageScmMissingModules
+	CodeStorageScmClassChanged
+	CodeStorageScmMountNotWritable
+	CodeStorageScmModulesChanged
+	CodeStorageScmMaintenanceMode
+	CodeStorageScmMountBaseReadOnly
+	CodeStorageScmFormatCancelled
+	CodeStorageScmConfirmUnavailable
+	CodeStorageScmPartialRegions
+	CodeStorageBadTmpfsSize
+	CodeStorageScmUnhealthyModules
+	CodeStorageScmBadMountFlag
+
+	// codeStorageEnd follows the last storage code
+	codeStorageEnd
+)
+
+// security fault codes
+const (
+	CodeSecurityUnknown Code = iota + 205
+
+	// codeSecurityEnd follows the last security code
+	codeSecurityEnd
+)
+
+const (
+	codeBlockSize     = 100
+	codeBlockStorage  = 100
+	codeBlockSecurity = 200
+)
 
-	// security fault codes
-	CodeSecurityUnknown Code = iota + 200
+const (
+	domainStorage  = "storage"
+	domainSecurity = "security"
 )
+
+// Domain returns the fault domain implied by the block the code is registered
+// in, empty for general codes which don't imply a domain.
+func (c Code) Domain() string {
+	switch {
+	case c.IsStorage():
+		return domainStorage
+	case c.IsSecurity():
+		return domainSecurity
+	default:
+		return ""
+	}
+}
+
+// IsStorage indicates whether the code is registered in the storage block.
+func (c Code) IsStorage() bool {
+	return c >= codeBlockStorage && c < codeBlockStorage+codeBlockSize
+}
+
+// IsSecurity indicates whether the code is registered in the security block.
+func (c Code) IsSecurity() bool {
+	return c >= codeBlockSecurity && c < codeBlockSecurity+codeBlockSize
+}
@@ -24,10 +24,15 @@
 package faults
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
+
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
 )
 
 const (
@@ -48,10 +53,10 @@ const (
 
 var (
 	// UnknownFault represents an unknown fault.
-	UnknownFault = &Fault{
+	UnknownFault = MustRegister(&Fault{
 		Code:       CodeUnknown,
 		Resolution: ResolutionUnknown,
-	}
+	})
 )
 
 // Fault represents a well-known error specific to a domain,
@@ -76,6 +81,148 @@ type Fault struct {
 	// Resolution is used to suggest possible solutions for
 	// the fault, if appropriate.
 	Resolution string
+	// Key optionally identifies the fault's messages in a localization
+	// catalog. The embedded Description and Resolution are used if no key
+	// is set or the catalog has no matching entry.
+	Key string
+}
+
+// Observer is notified of each fault raised.
+type Observer func(*Fault)
+
+type observerEntry struct {
+	id       int
+	observer Observer
+}
+
+var (
+	observersMu    sync.RWMutex
+	observers      []observerEntry
+	nextObserverID int
+)
+
+// RegisterObserver adds an observer to be notified of every fault raised, in
+// order of registration. The returned function unregisters the observer and
+// may safely be called more than once.
+func RegisterObserver(o Observer) (unregister func()) {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+
+	nextObserverID++
+	id := nextObserverID
+	observers = append(observers, observerEntry{id: id, observer: o})
+
+	return func() {
+		observersMu.Lock()
+		defer observersMu.Unlock()
+
+		for i, entry := range observers {
+			if entry.id == id {
+				observers = append(observers[:i], observers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// ValidateDomain verifies the fault's Domain matches the domain implied by
+// the block its Code is registered in. Faults with general codes, such as
+// UnknownFault, may have any domain.
+func (f *Fault) ValidateDomain() error {
+	implied := f.Code.Domain()
+	if implied == "" || f.Domain == implied {
+		return nil
+	}
+
+	return errors.Errorf("fault domain %q does not match %q domain of code %d",
+		f.Domain, implied, f.Code)
+}
+
+// Raise notifies registered observers of the fault and returns it, intended
+// to wrap construction of a fault e.g. return faults.Raise(&faults.Fault{}).
+//
+// A fault with a Domain inconsistent with its Code is not modified, instead
+// a copy with the domain implied by the Code is notified and returned, so
+// that raising a shared fault (e.g. a package level variable) is safe.
+//
+// Observers are called synchronously without locks held, so they may
+// register or unregister observers.
+func Raise(f *Fault) *Fault {
+	if f.ValidateDomain() != nil {
+		normalized := *f
+		normalized.Domain = f.Code.Domain()
+		f = &normalized
+	}
+
+	observersMu.RLock()
+	current := make([]observerEntry, len(observers))
+	copy(current, observers)
+	observersMu.RUnlock()
+
+	for _, entry := range current {
+		entry.observer(f)
+	}
+
+	return f
+}
+
+// Message holds localized text for a fault.
+type Message struct {
+	Description string
+	Resolution  string
+}
+
+// Catalog maps fault keys to localized messages.
+type Catalog map[string]Message
+
+var (
+	catalogMu sync.RWMutex
+	catalog   Catalog
+)
+
+// SetCatalog installs a copy of the localization catalog used when displaying
+// faults, later changes to c have no effect. A nil catalog restores the
+// embedded messages.
+func SetCatalog(c Catalog) {
+	var installed Catalog
+	if c != nil {
+		installed = make(Catalog, len(c))
+		for key, msg := range c {
+			installed[key] = msg
+		}
+	}
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	catalog = installed
+}
+
+// localized returns the catalog message for the fault's key, if any.
+func (f *Fault) localized() (Message, bool) {
+	if f.Key == "" {
+		return Message{}, false
+	}
+
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	msg, ok := catalog[f.Key]
+	return msg, ok
+}
+
+func (f *Fault) description() string {
+	if msg, ok := f.localized(); ok && msg.Description != "" {
+		return msg.Description
+	}
+	return f.Description
+}
+
+func (f *Fault) resolution() string {
+	if msg, ok := f.localized(); ok && msg.Resolution != "" {
+		return msg.Resolution
+	}
+	return f.Resolution
 }
 
 func sanitizeDomain(inDomain string) (outDomain string) {
@@ -100,7 +247,78 @@ func sanitizeDescription(inDescription string) (outDescription string) {
 
 func (f *Fault) Error() string {
 	return fmt.Sprintf("%s: code = %d description = %q",
-		sanitizeDomain(f.Domain), f.Code, sanitizeDescription(f.Description))
+		sanitizeDomain(f.Domain), f.Code, sanitizeDescription(f.description()))
+}
+
+// jsonFault is the stable JSON representation of a Fault.
+type jsonFault struct {
+	Domain      string `json:"domain"`
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+	Reason      string `json:"reason"`
+	Resolution  string `json:"resolution"`
+	Key         string `json:"key"`
+}
+
+// MarshalJSON encodes all fields of the fault so that it can be reported
+// with its resolution by clients, the code is encoded as its integer value.
+func (f *Fault) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFault{
+		Domain:      f.Domain,
+		Code:        int(f.Code),
+		Description: f.Description,
+		Reason:      f.Reason,
+		Resolution:  f.Resolution,
+		Key:         f.Key,
+	})
+}
+
+// UnmarshalJSON reconstructs a fault from the representation produced by
+// MarshalJSON.
+func (f *Fault) UnmarshalJSON(data []byte) error {
+	var jf jsonFault
+	if err := json.Unmarshal(data, &jf); err != nil {
+		return err
+	}
+
+	*f = Fault{
+		Domain:      jf.Domain,
+		Code:        Code(jf.Code),
+		Description: jf.Description,
+		Reason:      jf.Reason,
+		Resolution:  jf.Resolution,
+		Key:         jf.Key,
+	}
+
+	return nil
+}
+
+// responseStatus maps the fault code to the protobuf response status
+// reported to clients.
+func (f *Fault) responseStatus() pb.ResponseStatus {
+	switch {
+	case f.Code.IsStorage(), f.Code.IsSecurity():
+		return pb.ResponseStatus_CTRL_ERR_APP
+	default:
+		return pb.ResponseStatus_CTRL_ERR_UNKNOWN
+	}
+}
+
+// ResponseState renders the fault as a protobuf ResponseState suitable for
+// returning to clients. The fault reason is reported as the error (falling
+// back to the description if no reason is set) and any resolution is
+// reported as info.
+func (f *Fault) ResponseState() *pb.ResponseState {
+	errMsg := f.Reason
+	if errMsg == "" {
+		errMsg = sanitizeDescription(f.description())
+	}
+
+	return &pb.ResponseState{
+		Status: f.responseStatus(),
+		Error:  errMsg,
+		Info:   f.resolution(),
+	}
 }
 
 // Equals attempts to compare the given error to this one. If they both
@@ -124,18 +342,195 @@ func ShowResolutionFor(raw error) string {
 	if !ok {
 		return fmt.Sprintf(fmtStr, UnknownDomainStr, CodeUnknown, ResolutionUnknown)
 	}
-	if f.Resolution == ResolutionEmpty {
+	if f.resolution() == ResolutionEmpty {
 		return fmt.Sprintf(fmtStr, sanitizeDomain(f.Domain), f.Code, ResolutionUnknown)
 	}
-	return fmt.Sprintf(fmtStr, sanitizeDomain(f.Domain), f.Code, f.Resolution)
+	return fmt.Sprintf(fmtStr, sanitizeDomain(f.Domain), f.Code, f.resolution())
 }
 
 // HasResolution indicates whether or not the error has a resolution
 // defined.
 func HasResolution(raw error) bool {
 	f, ok := errors.Cause(raw).(*Fault)
-	if !ok || f.Resolution == ResolutionEmpty {
+	if !ok || f.resolution() == ResolutionEmpty {
 		return false
 	}
 	return true
 }
+
+// Annotate adds context to the fault with a formatted message while keeping
+// the fault discoverable with errors.Cause, so that Equals, HasResolution and
+// ShowResolutionFor work on the returned error.
+func Annotate(f *Fault, format string, args ...interface{}) error {
+	return errors.Wrapf(f, format, args...)
+}
+
+// wrappedFault associates a lower-level error with the known fault it
+// resulted in.
+type wrappedFault struct {
+	fault *Fault
+	err   error
+}
+
+func (w *wrappedFault) Error() string {
+	return fmt.Sprintf("%s: %s", w.fault, w.err)
+}
+
+// Cause returns the fault so that it is recovered by errors.Cause.
+func (w *wrappedFault) Cause() error {
+	return w.fault
+}
+
+// Wrap associates a lower-level error with a known fault, the message of the
+// returned error includes both and errors.Cause yields the fault.
+//
+// The fault is returned unchanged if err is nil.
+func Wrap(err error, f *Fault) error {
+	if err == nil {
+		return f
+	}
+
+	return &wrappedFault{fault: f, err: err}
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[Code]*Fault)
+)
+
+// Register adds the canonical fault for its code to the registry of known
+// faults, returning an error if a fault is already registered with the code.
+func Register(f *Fault) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, exists := registry[f.Code]; exists {
+		return errors.Errorf("fault code %d already registered", f.Code)
+	}
+	registry[f.Code] = f
+
+	return nil
+}
+
+// MustRegister registers the canonical fault for its code and returns it,
+// panicking if the code is already registered. Intended for registration of
+// package level faults at init so that code collisions are caught early.
+func MustRegister(f *Fault) *Fault {
+	if err := Register(f); err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+// Registered returns all registered faults ordered by code.
+func Registered() []*Fault {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registered := make([]*Fault, 0, len(registry))
+	for _, f := range registry {
+		registered = append(registered, f)
+	}
+	sort.Slice(registered, func(i, j int) bool {
+		return registered[i].Code < registered[j].Code
+	})
+
+	return registered
+}
+
+// LookupByCode returns the registered fault with the given code, for example
+// to render the resolution of a code reported in a log or RPC. All allocated
+// codes have a canonical fault registered at init (see known.go), so
+// UnknownFault is only returned (with false) for unallocated codes.
+func LookupByCode(code Code) (*Fault, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	f, ok := registry[code]
+	if !ok {
+		return UnknownFault, false
+	}
+
+	return f, true
+}
+
+// LookupByDomain returns the registered faults in the given domain ordered
+// by code.
+func LookupByDomain(domain string) []*Fault {
+	var inDomain []*Fault
+	for _, f := range Registered() {
+		if f.Domain == domain {
+			inDomain = append(inDomain, f)
+		}
+	}
+
+	return inDomain
+}
+
+// WithoutResolution returns registered faults with no resolution, for
+// which ShowResolutionFor would report ResolutionUnknown, ordered by code.
+func WithoutResolution() []*Fault {
+	var unresolved []*Fault
+	for _, f := range Registered() {
+		if f.resolution() == ResolutionEmpty {
+			unresolved = append(unresolved, f)
+		}
+	}
+
+	return unresolved
+}
+
+// Diff describes the differences between two sets of faults, identified
+// by fault code.
+type Diff struct {
+	// Added lists codes only present in the new set.
+	Added []Code
+	// Removed lists codes only present in the old set.
+	Removed []Code
+	// Changed lists codes present in both sets whose resolution differs.
+	Changed []Code
+}
+
+// IsEmpty indicates whether the fault sets are equivalent.
+func (d Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func sortCodes(codes []Code) {
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+}
+
+// DiffFaults compares an old and new set of faults, reporting codes added,
+// removed or with changed resolutions in ascending code order.
+func DiffFaults(oldFaults, newFaults []*Fault) (diff Diff) {
+	oldByCode := make(map[Code]*Fault)
+	for _, f := range oldFaults {
+		oldByCode[f.Code] = f
+	}
+	newByCode := make(map[Code]*Fault)
+	for _, f := range newFaults {
+		newByCode[f.Code] = f
+	}
+
+	for code, nf := range newByCode {
+		of, ok := oldByCode[code]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, code)
+		case of.Resolution != nf.Resolution:
+			diff.Changed = append(diff.Changed, code)
+		}
+	}
+	for code := range oldByCode {
+		if _, ok := newByCode[code]; !ok {
+			diff.Removed = append(diff.Removed, code)
+		}
+	}
+
+	sortCodes(diff.Added)
+	sortCodes(diff.Removed)
+	sortCodes(diff.Changed)
+
+	return
+}
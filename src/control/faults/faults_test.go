@@ -24,12 +24,15 @@
 package faults_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/pkg/errors"
 
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
 	"github.com/daos-stack/daos/src/control/faults"
 )
 
@@ -161,3 +164,588 @@ func TestFaultComparison(t *testing.T) {
 		})
 	}
 }
+
+func TestFaultResponseState(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fault    *faults.Fault
+		expState *pb.ResponseState
+	}{
+		{
+			name:  "empty fault",
+			fault: &faults.Fault{},
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_UNKNOWN,
+				Error:  faults.UnknownDescriptionStr,
+			},
+		},
+		{
+			name: "storage fault",
+			fault: &faults.Fault{
+				Domain:      "storage",
+				Code:        faults.CodeStorageAlreadyFormatted,
+				Description: "storage has already been formatted",
+				Reason:      "already formatted",
+				Resolution:  "reformat with force",
+			},
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  "already formatted",
+				Info:   "reformat with force",
+			},
+		},
+		{
+			name: "storage fault without reason",
+			fault: &faults.Fault{
+				Domain:      "storage",
+				Code:        faults.CodeStorageFilesystemMounted,
+				Description: "filesystem is mounted",
+			},
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  "filesystem is mounted",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := tc.fault.ResponseState()
+			if actual.Status != tc.expState.Status {
+				t.Fatalf("expected status %s, got %s", tc.expState.Status, actual.Status)
+			}
+			if actual.Error != tc.expState.Error {
+				t.Fatalf("expected error %q, got %q", tc.expState.Error, actual.Error)
+			}
+			if actual.Info != tc.expState.Info {
+				t.Fatalf("expected info %q, got %q", tc.expState.Info, actual.Info)
+			}
+		})
+	}
+}
+
+func TestFaultLocalization(t *testing.T) {
+	testFault := &faults.Fault{
+		Domain:      "test",
+		Code:        123,
+		Description: "the world is on fire",
+		Resolution:  "go jump in the lake",
+		Key:         "test.fire",
+	}
+
+	for _, tc := range []struct {
+		name        string
+		catalog     faults.Catalog
+		fault       *faults.Fault
+		expFaultStr string
+		expFaultRes string
+	}{
+		{
+			name:        "no catalog",
+			fault:       testFault,
+			expFaultStr: "test: code = 123 description = \"the world is on fire\"",
+			expFaultRes: "test: code = 123 resolution = \"go jump in the lake\"",
+		},
+		{
+			name: "catalog with key",
+			catalog: faults.Catalog{
+				"test.fire": {
+					Description: "le monde est en feu",
+					Resolution:  "va sauter dans le lac",
+				},
+			},
+			fault:       testFault,
+			expFaultStr: "test: code = 123 description = \"le monde est en feu\"",
+			expFaultRes: "test: code = 123 resolution = \"va sauter dans le lac\"",
+		},
+		{
+			name: "catalog with partial entry",
+			catalog: faults.Catalog{
+				"test.fire": {Description: "le monde est en feu"},
+			},
+			fault:       testFault,
+			expFaultStr: "test: code = 123 description = \"le monde est en feu\"",
+			expFaultRes: "test: code = 123 resolution = \"go jump in the lake\"",
+		},
+		{
+			name: "catalog without key",
+			catalog: faults.Catalog{
+				"test.other": {Description: "autre chose"},
+			},
+			fault:       testFault,
+			expFaultStr: "test: code = 123 description = \"the world is on fire\"",
+			expFaultRes: "test: code = 123 resolution = \"go jump in the lake\"",
+		},
+		{
+			name: "fault without key",
+			catalog: faults.Catalog{
+				"": {Description: "le monde est en feu"},
+			},
+			fault: &faults.Fault{
+				Domain:      "test",
+				Code:        123,
+				Description: "the world is on fire",
+			},
+			expFaultStr: "test: code = 123 description = \"the world is on fire\"",
+			expFaultRes: "test: code = 123 resolution = \"no known resolution\"",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			faults.SetCatalog(tc.catalog)
+			defer faults.SetCatalog(nil)
+
+			if tc.fault.Error() != tc.expFaultStr {
+				t.Fatalf("expected %q, got %q", tc.expFaultStr, tc.fault.Error())
+			}
+			actual := faults.ShowResolutionFor(tc.fault)
+			if actual != tc.expFaultRes {
+				t.Fatalf("expected %q, got %q", tc.expFaultRes, actual)
+			}
+		})
+	}
+}
+
+func TestFaultCatalogCopied(t *testing.T) {
+	testFault := &faults.Fault{
+		Domain:      "test",
+		Code:        123,
+		Description: "the world is on fire",
+		Key:         "test.fire",
+	}
+	catalog := faults.Catalog{
+		"test.fire": {Description: "le monde est en feu"},
+	}
+
+	faults.SetCatalog(catalog)
+	defer faults.SetCatalog(nil)
+
+	// changes after installation must not affect the installed catalog
+	catalog["test.fire"] = faults.Message{Description: "die Welt brennt"}
+	delete(catalog, "test.fire")
+
+	expFaultStr := "test: code = 123 description = \"le monde est en feu\""
+	if testFault.Error() != expFaultStr {
+		t.Fatalf("expected %q, got %q", expFaultStr, testFault.Error())
+	}
+}
+
+func TestDiffFaults(t *testing.T) {
+	oldFaults := []*faults.Fault{
+		{Code: 100, Resolution: "reformat with force"},
+		{Code: 101, Resolution: "unmount filesystem"},
+		{Code: 102, Resolution: "replace module"},
+	}
+
+	for _, tc := range []struct {
+		name      string
+		newFaults []*faults.Fault
+		expDiff   faults.Diff
+	}{
+		{
+			name:      "identical",
+			newFaults: oldFaults,
+		},
+		{
+			name: "changed resolution",
+			newFaults: []*faults.Fault{
+				{Code: 100, Resolution: "reformat with force"},
+				{Code: 101, Resolution: "stop processes and unmount filesystem"},
+				{Code: 102, Resolution: "replace module"},
+			},
+			expDiff: faults.Diff{Changed: []faults.Code{101}},
+		},
+		{
+			name: "added and removed",
+			newFaults: []*faults.Fault{
+				{Code: 103, Resolution: "reboot"},
+				{Code: 100, Resolution: "reformat with force"},
+				{Code: 104},
+			},
+			expDiff: faults.Diff{
+				Added:   []faults.Code{103, 104},
+				Removed: []faults.Code{101, 102},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := faults.DiffFaults(oldFaults, tc.newFaults)
+			if fmt.Sprintf("%+v", actual) != fmt.Sprintf("%+v", tc.expDiff) {
+				t.Fatalf("expected %+v, got %+v", tc.expDiff, actual)
+			}
+			if actual.IsEmpty() != tc.expDiff.IsEmpty() {
+				t.Fatalf("expected empty %t, got %t", tc.expDiff.IsEmpty(), actual.IsEmpty())
+			}
+		})
+	}
+}
+
+func TestFaultObservers(t *testing.T) {
+	fire := &faults.Fault{Domain: "storage", Code: 123}
+	flood := &faults.Fault{Domain: "storage", Code: 124}
+
+	var mu sync.Mutex
+	var first, second []faults.Code
+	record := func(codes *[]faults.Code) faults.Observer {
+		return func(f *faults.Fault) {
+			mu.Lock()
+			defer mu.Unlock()
+			*codes = append(*codes, f.Code)
+		}
+	}
+
+	unregisterFirst := faults.RegisterObserver(record(&first))
+	unregisterSecond := faults.RegisterObserver(record(&second))
+	defer unregisterSecond()
+
+	if faults.Raise(fire) != fire {
+		t.Fatal("expected raised fault to be returned")
+	}
+
+	unregisterFirst()
+	unregisterFirst() // second call is a no-op
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			faults.Raise(flood)
+		}()
+	}
+	wg.Wait()
+
+	if len(first) != 1 || first[0] != fire.Code {
+		t.Fatalf("unregistered observer: expected [%d], got %v",
+			fire.Code, first)
+	}
+	if len(second) != 11 || second[0] != fire.Code {
+		t.Fatalf("registered observer: expected 11 faults starting "+
+			"with %d, got %v", fire.Code, second)
+	}
+	for _, code := range second[1:] {
+		if code != flood.Code {
+			t.Fatalf("registered observer: expected %d, got %d",
+				flood.Code, code)
+		}
+	}
+}
+
+func TestFaultDomain(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		fault     *faults.Fault
+		expErr    string
+		expDomain string
+	}{
+		{
+			name:      "unknown fault",
+			fault:     faults.UnknownFault,
+			expDomain: "",
+		},
+		{
+			name:      "correct domain",
+			fault:     &faults.Fault{Domain: "storage", Code: faults.CodeStorageUnknown},
+			expDomain: "storage",
+		},
+		{
+			name:      "correct security domain",
+			fault:     &faults.Fault{Domain: "security", Code: faults.CodeSecurityUnknown},
+			expDomain: "security",
+		},
+		{
+			name:      "empty domain",
+			fault:     &faults.Fault{Code: faults.CodeStorageScmNoRegions},
+			expErr:    "fault domain \"\" does not match \"storage\" domain of code 114",
+			expDomain: "storage",
+		},
+		{
+			name:      "mismatched domain",
+			fault:     &faults.Fault{Domain: "stroage", Code: faults.CodeStorageScmNoRegions},
+			expErr:    "fault domain \"stroage\" does not match \"storage\" domain of code 114",
+			expDomain: "storage",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.fault.ValidateDomain()
+			switch {
+			case tc.expErr == "" && err != nil:
+				t.Fatalf("expected valid domain, got %q", err)
+			case tc.expErr != "" && (err == nil || err.Error() != tc.expErr):
+				t.Fatalf("expected %q, got %v", tc.expErr, err)
+			}
+
+			origDomain := tc.fault.Domain
+			raised := faults.Raise(tc.fault)
+			if tc.fault.Domain != origDomain {
+				t.Fatalf("expected raised fault domain %q unchanged, got %q",
+					origDomain, tc.fault.Domain)
+			}
+			if raised.Domain != tc.expDomain {
+				t.Fatalf("expected raised domain %q, got %q",
+					tc.expDomain, raised.Domain)
+			}
+			if err := raised.ValidateDomain(); err != nil {
+				t.Fatalf("expected raised fault to be valid, got %q", err)
+			}
+		})
+	}
+}
+
+func TestFaultCodes(t *testing.T) {
+	// codes are stable on the wire, values must never change once released
+	for name, tc := range map[string]struct {
+		code    faults.Code
+		expCode int
+	}{
+		"unknown":                    {faults.CodeUnknown, 0},
+		"storage unknown":            {faults.CodeStorageUnknown, 101},
+		"storage already formatted":  {faults.CodeStorageAlreadyFormatted, 102},
+		"storage filesystem mounted": {faults.CodeStorageFilesystemMounted, 103},
+		"storage format check":       {faults.CodeStorageFormatCheckFailed, 104},
+		"storage degraded region":    {faults.CodeStorageScmDegradedRegion, 105},
+		"security unknown":           {faults.CodeSecurityUnknown, 205},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if int(tc.code) != tc.expCode {
+				t.Fatalf("expected code %d, got %d", tc.expCode, tc.code)
+			}
+		})
+	}
+}
+
+func TestFaultsWithoutResolution(t *testing.T) {
+	// codes outside of the allocated blocks to avoid clashing with faults
+	// registered by the package
+	resolved := &faults.Fault{
+		Domain:     "test",
+		Code:       9001,
+		Resolution: "go jump in the lake",
+	}
+	unresolved := &faults.Fault{Domain: "test", Code: 9002}
+	alsoUnresolved := &faults.Fault{
+		Domain:     "test",
+		Code:       9003,
+		Resolution: faults.ResolutionEmpty,
+	}
+
+	faults.Register(alsoUnresolved)
+	faults.Register(resolved)
+	faults.Register(unresolved)
+
+	var actual []faults.Code
+	for _, f := range faults.WithoutResolution() {
+		if f.Code >= 9001 && f.Code <= 9003 {
+			actual = append(actual, f.Code)
+		}
+	}
+
+	expected := []faults.Code{unresolved.Code, alsoUnresolved.Code}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestRegisterFault(t *testing.T) {
+	// codes outside of the allocated blocks to avoid clashing with faults
+	// registered by the package
+	fire := &faults.Fault{Domain: "test", Code: 9004, Description: "fire"}
+	flood := &faults.Fault{Domain: "test", Code: 9004, Description: "flood"}
+
+	if err := faults.Register(fire); err != nil {
+		t.Fatalf("expected registration to succeed, got %q", err)
+	}
+
+	expErr := "fault code 9004 already registered"
+	if err := faults.Register(flood); err == nil || err.Error() != expErr {
+		t.Fatalf("expected %q, got %v", expErr, err)
+	}
+
+	func() {
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatal("expected duplicate MustRegister to panic")
+			}
+			if err, ok := r.(error); !ok || err.Error() != expErr {
+				t.Fatalf("expected panic with %q, got %v", expErr, r)
+			}
+		}()
+		faults.MustRegister(flood)
+	}()
+
+	byCode := make(map[faults.Code]*faults.Fault)
+	for _, f := range faults.Registered() {
+		byCode[f.Code] = f
+	}
+	if byCode[faults.CodeUnknown] != faults.UnknownFault {
+		t.Fatalf("expected UnknownFault registered for code %d, got %v",
+			faults.CodeUnknown, byCode[faults.CodeUnknown])
+	}
+	if byCode[fire.Code] != fire {
+		t.Fatalf("expected first registration for code %d, got %v",
+			fire.Code, byCode[fire.Code])
+	}
+}
+
+func TestLookupFault(t *testing.T) {
+	t.Run("registered storage code", func(t *testing.T) {
+		f, ok := faults.LookupByCode(faults.CodeStorageAlreadyFormatted)
+		if !ok || f.Code != faults.CodeStorageAlreadyFormatted {
+			t.Fatalf("expected code %d registered, got %v (%t)",
+				faults.CodeStorageAlreadyFormatted, f, ok)
+		}
+		expRes := "storage: code = 102 resolution = \"reformat with force " +
+			"to wipe and recreate the scm filesystem, destroying all data\""
+		if actual := faults.ShowResolutionFor(f); actual != expRes {
+			t.Fatalf("expected %q, got %q", expRes, actual)
+		}
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		f, ok := faults.LookupByCode(faults.CodeStorageUnknown + 99)
+		if ok || f != faults.UnknownFault {
+			t.Fatalf("expected UnknownFault unregistered, got %v (%t)", f, ok)
+		}
+	})
+
+	t.Run("domain filtering", func(t *testing.T) {
+		storage := faults.LookupByDomain("storage")
+		if len(storage) == 0 || storage[0].Code != faults.CodeStorageUnknown {
+			t.Fatalf("expected storage faults starting with code %d, got %v",
+				faults.CodeStorageUnknown, storage)
+		}
+		for i, f := range storage {
+			if !f.Code.IsStorage() {
+				t.Fatalf("unexpected code %d in storage faults", f.Code)
+			}
+			if i > 0 && f.Code <= storage[i-1].Code {
+				t.Fatalf("storage faults not ordered by code: %v", storage)
+			}
+		}
+
+		security := faults.LookupByDomain("security")
+		if len(security) != 1 || security[0].Code != faults.CodeSecurityUnknown {
+			t.Fatalf("expected security faults [%d], got %v",
+				faults.CodeSecurityUnknown, security)
+		}
+		if actual := faults.LookupByDomain("network"); len(actual) != 0 {
+			t.Fatalf("expected no network faults, got %v", actual)
+		}
+	})
+}
+
+func TestFaultJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		fault   *faults.Fault
+		expJSON string
+	}{
+		{
+			name:  "unknown fault",
+			fault: faults.UnknownFault,
+			expJSON: `{"domain":"","code":0,"description":"","reason":"",` +
+				`"resolution":"no known resolution","key":""}`,
+		},
+		{
+			name: "storage fault",
+			fault: &faults.Fault{
+				Domain:      "storage",
+				Code:        faults.CodeStorageAlreadyFormatted,
+				Description: "storage has already been formatted",
+				Reason:      "already formatted",
+				Resolution:  "reformat with force",
+				Key:         "storage.formatted",
+			},
+			expJSON: `{"domain":"storage","code":102,` +
+				`"description":"storage has already been formatted",` +
+				`"reason":"already formatted",` +
+				`"resolution":"reformat with force","key":"storage.formatted"}`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.fault)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.expJSON {
+				t.Fatalf("expected %s, got %s", tc.expJSON, data)
+			}
+
+			actual := new(faults.Fault)
+			if err := json.Unmarshal(data, actual); err != nil {
+				t.Fatal(err)
+			}
+			if !tc.fault.Equals(actual) {
+				t.Fatalf("expected %v to equal %v", actual, tc.fault)
+			}
+			if *actual != *tc.fault {
+				t.Fatalf("expected %+v, got %+v", *tc.fault, *actual)
+			}
+		})
+	}
+
+	// faults embedded in other values are also decoded
+	var wrapper struct {
+		Fault *faults.Fault `json:"fault"`
+	}
+	if err := json.Unmarshal([]byte(`{"fault":{"code":123}}`), &wrapper); err != nil {
+		t.Fatal(err)
+	}
+	if wrapper.Fault == nil || wrapper.Fault.Code != 123 {
+		t.Fatalf("expected fault with code 123, got %v", wrapper.Fault)
+	}
+}
+
+func TestFaultWrapping(t *testing.T) {
+	testFault := &faults.Fault{
+		Domain:      "test",
+		Code:        123,
+		Description: "the world is on fire",
+		Resolution:  "go jump in the lake",
+	}
+	expRes := "test: code = 123 resolution = \"go jump in the lake\""
+
+	for _, tc := range []struct {
+		name   string
+		err    error
+		expStr string
+	}{
+		{
+			name:   "annotated",
+			err:    faults.Annotate(testFault, "igniting %s", "world"),
+			expStr: "igniting world: " + testFault.Error(),
+		},
+		{
+			name:   "wrapped",
+			err:    faults.Wrap(errors.New("match struck"), testFault),
+			expStr: testFault.Error() + ": match struck",
+		},
+		{
+			name:   "wrapped nil error",
+			err:    faults.Wrap(nil, testFault),
+			expStr: testFault.Error(),
+		},
+		{
+			name: "annotated wrapped",
+			err: errors.WithMessage(
+				faults.Wrap(errors.New("match struck"), testFault), "igniting"),
+			expStr: "igniting: " + testFault.Error() + ": match struck",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err.Error() != tc.expStr {
+				t.Fatalf("expected %q, got %q", tc.expStr, tc.err)
+			}
+			if errors.Cause(tc.err) != testFault {
+				t.Fatalf("expected cause %v, got %v", testFault, errors.Cause(tc.err))
+			}
+			if !testFault.Equals(tc.err) {
+				t.Fatal("expected wrapped error to equal fault")
+			}
+			if !faults.HasResolution(tc.err) {
+				t.Fatal("expected wrapped error to have resolution")
+			}
+			if actual := faults.ShowResolutionFor(tc.err); actual != expRes {
+				t.Fatalf("expected %q, got %q", expRes, actual)
+			}
+		})
+	}
+}
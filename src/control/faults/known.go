@@ -0,0 +1,229 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package faults
+
+// init registers the canonical fault for each allocated code so that a code
+// reported in a log or RPC can be resolved with LookupByCode without access
+// to the package that raised it. Packages raising faults use New to copy the
+// canonical fault and add the specifics of the occurrence to the description.
+func init() {
+	for _, f := range []*Fault{
+		// storage faults
+		{
+			Code:        CodeStorageUnknown,
+			Description: "unknown storage fault",
+			Resolution:  ResolutionUnknown,
+		},
+		{
+			Code:        CodeStorageAlreadyFormatted,
+			Description: "scm storage has already been formatted",
+			Reason:      "scm storage already formatted",
+			Resolution:  "reformat with force to wipe and recreate the scm filesystem, destroying all data",
+		},
+		{
+			Code:        CodeStorageFilesystemMounted,
+			Description: "scm filesystem is mounted and busy",
+			Reason:      "scm filesystem could not be unmounted as it is in use",
+			Resolution:  "stop any DAOS I/O servers and other processes using the scm mount, then retry",
+		},
+		{
+			Code:        CodeStorageFormatCheckFailed,
+			Description: "failed to check whether storage is formatted",
+			Reason:      "storage format check failed",
+			Resolution:  ResolutionUnknown,
+		},
+		{
+			Code:        CodeStorageScmDegradedRegion,
+			Description: "scm region is degraded and cannot be used for namespaces",
+			Reason:      "scm region is degraded",
+			Resolution:  "check all modules in the interleave set are present and healthy (ipmctl show -dimm) then reboot",
+		},
+		{
+			Code:        CodeStorageTmpfsNoMemory,
+			Description: "insufficient memory available to mount tmpfs for scm",
+			Reason:      "insufficient memory for scm tmpfs",
+			Resolution:  "reduce scm_size in config or free system memory",
+		},
+		{
+			Code:        CodeStorageDuplicateScmMount,
+			Description: "scm mount point is already used by another I/O server",
+			Reason:      "scm mount point used by multiple I/O servers",
+			Resolution:  "configure unique scm_mount and scm_mount_list entries for each I/O server",
+		},
+		{
+			Code:        CodeStorageDuplicateScmDevice,
+			Description: "scm_list device is already used by another I/O server",
+			Reason:      "scm_list device used by multiple I/O servers",
+			Resolution:  "configure unique scm_list devices for each I/O server",
+		},
+		{
+			Code:        CodeStorageScmMismatchedCapacities,
+			Description: "scm modules have mismatched capacities, interleaved regions may be imbalanced or fail to be created",
+			Reason:      "scm module capacities differ",
+			Resolution:  "populate all memory channels with scm modules of the same capacity",
+		},
+		{
+			Code:        CodeStorageScmGoalMismatch,
+			Description: "scm allocation goal not applied as requested",
+			Reason:      "scm allocation goal does not match request",
+			Resolution:  "inspect goal with ipmctl show -goal, remove with ipmctl delete -goal and retry",
+		},
+		{
+			Code:        CodeStorageScmPartitionedDevice,
+			Description: "scm device has a partition table",
+			Reason:      "scm device is partitioned",
+			Resolution:  "verify partitions on the scm device are not in use and remove the partition table with wipefs -a before formatting",
+		},
+		{
+			Code:        CodeStorageScmDaxUnsupported,
+			Description: "scm device does not support dax",
+			Reason:      "dax not supported on scm device",
+			Resolution:  "use a kernel built with CONFIG_FS_DAX and ext4 support and an fsdax mode pmem namespace",
+		},
+		{
+			Code:        CodeStorageScmNotPmemNamespace,
+			Description: "scm device is not a pmem namespace block device",
+			Reason:      "scm_list entry is not a pmem namespace",
+			Resolution:  "create namespaces with daos_server storage prep-scm and list the resulting /dev/pmemN devices in scm_list",
+		},
+		{
+			Code:        CodeStorageScmNoRegions,
+			Description: "scm modules have no AppDirect regions and automatic region creation is disabled",
+			Reason:      "scm regions not configured",
+			Resolution:  "schedule a reboot and create regions with ipmctl create -goal PersistentMemoryType=AppDirect, or unset scm_no_auto_regions",
+		},
+		{
+			Code:        CodeStorageScmFirmwareIncompatible,
+			Description: "firmware image is incompatible with scm module",
+			Reason:      "firmware image not intended for scm module model",
+			Resolution:  "obtain the firmware image for the module model reported by daos_server storage scan",
+		},
+		{
+			Code:        CodeStorageScmVerifyFailed,
+			Description: "scm verification failed",
+			Reason:      "scm setup does not match configuration",
+			Resolution:  "run daos_server storage prep-scm and format to provision scm as configured",
+		},
+		{
+			Code:        CodeStorageScmMissingModules,
+			Description: "scm modules missing",
+			Reason:      "fewer scm modules discovered than expected",
+			Resolution:  "check module population and health with ipmctl show -dimm, replace failed modules or correct scm_modules_per_socket",
+		},
+		{
+			Code:        CodeStorageScmClassChanged,
+			Description: "existing scm mount was created for a different scm_class",
+			Reason:      "scm_class changed since scm was last formatted",
+			Resolution:  "reset scm by unmounting and removing the existing scm mount (and resetting dcpm regions if no longer used) before formatting, or restore the previous scm_class",
+		},
+		{
+			Code:        CodeStorageScmMountNotWritable,
+			Description: "scm mount is not writable",
+			Reason:      "scm mount failed read/write probe",
+			Resolution:  "check kernel log (dmesg) for filesystem or dax errors on the scm device and reformat",
+		},
+		{
+			Code:        CodeStorageScmModulesChanged,
+			Description: "scm modules differ from those in existing regions",
+			Reason:      "scm modules changed since regions were created",
+			Resolution:  "reset scm regions and namespaces (ipmctl delete -goal, ipmctl create -goal and reboot) so that regions are recreated for the installed modules",
+		},
+		{
+			Code:        CodeStorageScmMaintenanceMode,
+			Description: "scm operation refused: scm storage is in maintenance mode",
+			Reason:      "mutating scm operations are blocked in maintenance mode",
+			Resolution:  "disable scm_maintenance_mode in config and restart daos_server before retrying the operation",
+		},
+		{
+			Code:        CodeStorageScmMountBaseReadOnly,
+			Description: "cannot create scm mount point on a read-only filesystem",
+			Reason:      "scm mount base path is read-only",
+			Resolution:  "remount the filesystem containing the scm mount base read-write or set scm_mount to a path on a writable filesystem",
+		},
+		{
+			Code:        CodeStorageScmFormatCancelled,
+			Description: "scm format cancelled",
+			Reason:      "format was declined when confirmation was requested",
+			Resolution:  "rerun the format and confirm when prompted",
+		},
+		{
+			Code:        CodeStorageScmConfirmUnavailable,
+			Description: "confirmation of scm format unavailable",
+			Reason:      "failed to obtain confirmation of destructive scm format",
+			Resolution:  "run the format interactively or use the force option to skip confirmation",
+		},
+		{
+			Code:        CodeStorageScmPartialRegions,
+			Description: "scm AppDirect regions exist on only some sockets",
+			Reason:      "scm regions only partially created",
+			Resolution:  "reset scm with daos_server prep-scm --reset and reboot, then rerun prep-scm to create AppDirect regions on all sockets",
+		},
+		{
+			Code:        CodeStorageBadTmpfsSize,
+			Description: "invalid scm_size for ram scm tmpfs",
+			Reason:      "scm tmpfs size must be a positive number of GiB",
+			Resolution:  "set scm_size in config to a positive size in GiB, or to -1 to use the tmpfs default size",
+		},
+		{
+			Code:        CodeStorageScmUnhealthyModules,
+			Description: "unhealthy scm modules",
+			Reason:      "scm modules are failing or near the end of their rated life",
+			Resolution:  "schedule replacement of the listed modules, check ipmctl show -error Media -dimm for details",
+		},
+		{
+			Code:        CodeStorageScmBadMountFlag,
+			Description: "unknown scm mount flag",
+			Reason:      "scm_mount_flags contains a flag that is not supported",
+			Resolution:  "set scm_mount_flags in config to a list of supported flags",
+		},
+
+		// security faults
+		{
+			Code:        CodeSecurityUnknown,
+			Description: "unknown security fault",
+			Resolution:  ResolutionUnknown,
+		},
+	} {
+		f.Domain = f.Code.Domain()
+		MustRegister(f)
+	}
+}
+
+// New returns a copy of the canonical fault registered for the code with
+// the given description, so that the registered fault is never modified.
+// If no fault is registered for the code, the copy has the domain implied by
+// the code and an unknown resolution.
+func New(code Code, description string) *Fault {
+	f := &Fault{
+		Domain:     code.Domain(),
+		Code:       code,
+		Resolution: ResolutionUnknown,
+	}
+	if canonical, ok := LookupByCode(code); ok {
+		*f = *canonical
+	}
+	f.Description = description
+
+	return f
+}
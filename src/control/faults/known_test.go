@@ -0,0 +1,76 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package faults
+
+import "testing"
+
+func TestKnownFaultsRegistered(t *testing.T) {
+	var codes []Code
+	for c := CodeStorageUnknown; c < codeStorageEnd; c++ {
+		codes = append(codes, c)
+	}
+	for c := CodeSecurityUnknown; c < codeSecurityEnd; c++ {
+		codes = append(codes, c)
+	}
+
+	for _, code := range append([]Code{CodeUnknown}, codes...) {
+		f, ok := LookupByCode(code)
+		if !ok {
+			t.Errorf("no fault registered for code %d", code)
+			continue
+		}
+		if f.Code != code {
+			t.Errorf("fault registered for code %d has code %d", code, f.Code)
+		}
+		if err := f.ValidateDomain(); err != nil {
+			t.Errorf("fault registered for code %d: %s", code, err)
+		}
+		if f.resolution() == ResolutionEmpty {
+			t.Errorf("fault registered for code %d has no resolution", code)
+		}
+	}
+}
+
+func TestNewFault(t *testing.T) {
+	canonical, _ := LookupByCode(CodeStorageScmNoRegions)
+
+	f := New(CodeStorageScmNoRegions, "no regions on socket 1")
+	if f == canonical {
+		t.Fatal("expected a copy of the canonical fault")
+	}
+	if f.Description != "no regions on socket 1" ||
+		canonical.Description == f.Description {
+		t.Fatalf("unexpected descriptions %q (canonical %q)",
+			f.Description, canonical.Description)
+	}
+	if f.Domain != "storage" || f.Reason != canonical.Reason ||
+		f.Resolution != canonical.Resolution {
+		t.Fatalf("expected canonical fields, got %+v", f)
+	}
+
+	f = New(codeStorageEnd, "unregistered")
+	if f.Domain != "storage" || f.Resolution != ResolutionUnknown {
+		t.Fatalf("unexpected unregistered fault %+v", f)
+	}
+}
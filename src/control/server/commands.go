@@ -24,8 +24,11 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -52,9 +55,11 @@ type cliOptions struct {
 
 // StorCmd is the struct representing the top-level storage subcommand.
 type StorCmd struct {
-	Scan     ScanStorCmd `command:"scan" alias:"l" description:"Scan SCM and NVMe storage attached to local server"`
-	PrepNvme PrepNvmeCmd `command:"prep-nvme" alias:"pn" description:"Prep NVMe devices for use with SPDK as current user"`
-	PrepScm  PrepScmCmd  `command:"prep-scm" alias:"ps" description:"Prep SCM modules into interleaved AppDirect and create the relevant namespace kernel devices"`
+	Scan      ScanStorCmd  `command:"scan" alias:"l" description:"Scan SCM and NVMe storage attached to local server"`
+	PrepNvme  PrepNvmeCmd  `command:"prep-nvme" alias:"pn" description:"Prep NVMe devices for use with SPDK as current user"`
+	PrepScm   PrepScmCmd   `command:"prep-scm" alias:"ps" description:"Prep SCM modules into interleaved AppDirect and create the relevant namespace kernel devices"`
+	QueryScm  QueryScmCmd  `command:"query-scm" alias:"qs" description:"Query state of locally-attached SCM modules, regions and namespaces"`
+	FormatScm FormatScmCmd `command:"format-scm" alias:"fs" description:"Format SCM mounts specified in config file, prompting for confirmation"`
 }
 
 // ScanStorCmd is the struct representing the command to scan storage.
@@ -152,7 +157,18 @@ func (p *PrepNvmeCmd) Execute(args []string) error {
 // PrepScmCmd is the struct representing the command to prep SCM modules by
 // configuring in AppDirect mode and creating relevant namespaces.
 type PrepScmCmd struct {
-	Reset bool `short:"r" long:"reset" description:"Reset modules to memory mode after removing namespaces"`
+	Reset      bool          `short:"r" long:"reset" description:"Reset modules to memory mode after removing namespaces"`
+	Retries    int           `long:"retries" default:"0" description:"Retry prep up to this many times on recoverable errors, e.g. regions briefly not visible after reboot"`
+	RetryDelay time.Duration `long:"retry-delay" default:"10s" description:"Delay between prep retries"`
+	Wait       time.Duration `long:"wait" description:"Wait up to this long (e.g. 5m) for regions to become available after the reboot following region creation"`
+	DryRun     bool          `long:"dry-run" description:"Print the commands prep would run without making changes"`
+	Events     string        `long:"events" description:"Append prep progress to this file as newline-delimited JSON events"`
+	Workers    int           `long:"namespace-workers" default:"1" description:"Create namespaces in up to this many regions concurrently"`
+	NsAttempts int           `long:"namespace-attempts" default:"3" description:"Attempts made to create each namespace while creation fails transiently, e.g. device busy"`
+	NsDelay    time.Duration `long:"namespace-retry-delay" default:"1s" description:"Delay before retrying namespace creation, doubled on each further retry"`
+	Metrics    string        `long:"metrics" description:"Write prep metrics to this file in Prometheus text format"`
+	Timings    bool          `long:"timings" description:"Print the time taken by each prep step"`
+	CmdTimeout time.Duration `long:"cmd-timeout" description:"Abort ipmctl and ndctl commands taking longer than this (default 5m)"`
 }
 
 // Execute is run when PrepScmCmd activates
@@ -165,6 +181,8 @@ func (p *PrepScmCmd) Execute(args []string) error {
 	}
 
 	config := newConfiguration()
+	config.ScmEventLog = p.Events
+	config.ScmCmdTimeout = p.CmdTimeout
 
 	server, err := newControlService(
 		&config, getDrpcClientConnection(config.SocketDir))
@@ -186,23 +204,316 @@ func (p *PrepScmCmd) Execute(args []string) error {
 		return errors.New(msgScmNoModules)
 	}
 
-	if p.Reset {
+	server.scm.withDryRun(p.DryRun).withNamespaceWorkers(p.Workers).
+		withNamespaceRetry(p.NsAttempts, p.NsDelay)
+	server.scm.withNamespaceProgress(func(created int, dev pmemDev) {
+		fmt.Printf("created namespace %d: %s (/dev/%s) on socket %d\n",
+			created, dev.Name, dev.Blockdev, dev.NumaNode)
+	})
+
+	if p.Reset && p.DryRun {
+		preview, err := server.scm.PrepResetPreview()
+		if err != nil {
+			return errors.WithMessage(err, "SCM prep reset")
+		}
+
+		fmt.Println("dry run, reset would destroy:")
+		for _, ns := range preview.Namespaces {
+			fmt.Printf("\tnamespace %s (%s) on socket %d\n",
+				ns.Name, ns.Blockdev, ns.NumaNode)
+		}
+		for _, region := range preview.Regions {
+			fmt.Printf("\tregion %s (%s) on socket %d: %s\n",
+				region.ISetID, region.MemType, region.SocketID,
+				region.Capacity)
+		}
+	} else if p.Reset {
 		// run reset to remove namespaces and clear regions
 		if err := server.scm.PrepReset(); err != nil {
 			return errors.WithMessage(err, "SCM prep reset")
 		}
 	} else {
+		if p.Wait > 0 {
+			fmt.Println("Waiting for SCM regions to become available...")
+			_, err := server.scm.WaitForRegions(
+				context.Background(), p.Wait, scmRegionsPollInterval)
+			if err != nil {
+				return errors.WithMessage(err, "SCM prep")
+			}
+		}
+
 		// transition to the next state in SCM preparation
-		needsReboot, pmemDevs, err := server.scm.Prep()
+		result, err := server.scm.PrepWithRetry(
+			context.Background(), p.Retries+1, p.RetryDelay)
+		if p.Metrics != "" {
+			if err := server.scm.writeMetricsFile(p.Metrics); err != nil {
+				fmt.Fprintf(os.Stderr, "writing metrics: %s\n", err)
+			}
+		}
+		if p.Timings {
+			for _, st := range server.scm.StepTimings() {
+				fmt.Printf("%s %s %s took %s\n",
+					st.Op, st.Step, st.Device, st.Elapsed)
+			}
+		}
 		if err != nil {
 			return errors.WithMessage(err, "SCM prep")
 		}
 
-		if needsReboot {
+		if p.DryRun {
+			previews, err := server.scm.PreviewNamespaces()
+			if err != nil {
+				return errors.WithMessage(err, "SCM prep")
+			}
+			if len(previews) > 0 {
+				fmt.Println("dry run, namespaces that would be created:")
+			}
+			for _, ns := range previews {
+				fmt.Printf("\t%s in region %s: %s\n",
+					ns.Name, ns.ISetID, humanSize(ns.Size))
+			}
+
+			fmt.Println("dry run, commands that would be run:")
+			for _, cmd := range server.scm.DryRunPlan() {
+				fmt.Printf("\t%s\n", cmd)
+			}
+
+			reboots, err := server.scm.RebootsRequired()
+			if err != nil {
+				return errors.WithMessage(err, "SCM prep")
+			}
+			fmt.Printf("dry run, reboots required to fully provision: %d\n",
+				reboots)
+		}
+
+		if result.NeedsReboot {
 			fmt.Println(msgScmRebootRequired)
 		} else {
-			fmt.Printf("persistent memory kernel devices:\n\t%+v\n", pmemDevs)
+			fmt.Printf("persistent memory kernel devices:\n\t%+v\n",
+				result.Namespaces)
+			for _, region := range result.Regions {
+				fmt.Printf("socket %d regions %v: %s free of %s\n",
+					region.SocketID, region.RegionIDs,
+					humanSize(region.FreeCapacity),
+					humanSize(region.Capacity))
+			}
+		}
+	}
+
+	// exit immediately to avoid continuation of main
+	os.Exit(0)
+	// never reached
+	return nil
+}
+
+// QueryScmCmd is the struct representing the command to query the state of
+// locally-attached SCM without making changes.
+type QueryScmCmd struct {
+	Capacity   bool   `long:"capacity" description:"Show total, used and free pmem capacity per socket"`
+	Health     bool   `long:"health" description:"Show health, remaining life and temperature of each module"`
+	Sensors    bool   `long:"sensors" description:"Show sensor readings of each module"`
+	Errors     bool   `long:"errors" description:"Show thermal and media error log entries of each module"`
+	Layout     bool   `long:"layout" description:"Show count and sizes of namespaces in each region"`
+	Unmounted  bool   `long:"unmounted" description:"Show pmem devices that are not mounted"`
+	Diag       bool   `long:"diag" description:"Print a JSON diagnostic bundle of modules, regions, namespaces, mounts and recent faults"`
+	Verify     bool   `long:"verify" description:"Check that regions, namespaces and mounts match the config file"`
+	ConfigPath string `short:"o" long:"config_path" description:"Server config file path, used to check scm mounts and layout"`
+}
+
+// Execute is run when QueryScmCmd activates
+//
+// Perform task then exit immediately. Config is only parsed to check the
+// mount points of pmem devices and to verify scm setup.
+func (q *QueryScmCmd) Execute(args []string) error {
+	ok, _ := common.CheckSudo()
+	if !ok {
+		return errors.New("subcommand must be run as root or sudo")
+	}
+
+	config := newConfiguration()
+
+	// scm mount points and expected layout are only known from the config
+	// file
+	if q.Unmounted || q.Verify {
+		if err := config.setPath(q.ConfigPath); err != nil {
+			return errors.WithMessage(err, "set config path")
+		}
+		if err := config.loadConfig(); err != nil {
+			return errors.WithMessagef(err, "loading %s", config.Path)
+		}
+	}
+
+	server, err := newControlService(
+		&config, getDrpcClientConnection(config.SocketDir))
+	if err != nil {
+		return errors.WithMessage(err, "initialising ControlService")
+	}
+
+	if err := server.scm.Setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if q.Capacity {
+		capacity, err := server.scm.Capacity()
+		if err != nil {
+			return errors.WithMessage(err, "SCM capacity")
+		}
+		common.PrintStructs("SCM capacity", capacity)
+	}
+
+	if q.Health {
+		health, err := server.scm.Health()
+		if health != nil {
+			common.PrintStructs("SCM module health", health)
+		}
+		if err != nil {
+			return errors.WithMessage(err, "SCM health")
+		}
+	}
+
+	if q.Sensors {
+		sensors, err := server.scm.GetSensors()
+		if err != nil {
+			return errors.WithMessage(err, "SCM sensors")
+		}
+		common.PrintStructs("SCM module sensors", sensors)
+	}
+
+	if q.Errors {
+		logs, err := server.scm.GetErrorLog()
+		if err != nil {
+			return errors.WithMessage(err, "SCM error log")
+		}
+		if len(logs) == 0 {
+			fmt.Println("SCM module error logs: no entries")
+		} else {
+			common.PrintStructs("SCM module error logs", logs)
+		}
+	}
+
+	if q.Layout {
+		layouts, err := server.scm.NamespaceLayout()
+		if err != nil {
+			return errors.WithMessage(err, "SCM namespace layout")
 		}
+		fmt.Println("SCM namespace layout:")
+		for _, rl := range layouts {
+			sizes := make([]string, 0, rl.Count())
+			for _, size := range rl.Sizes {
+				sizes = append(sizes, humanSize(size))
+			}
+			fmt.Printf("\t%s: %d namespaces %v\n",
+				rl.Region, rl.Count(), sizes)
+			if rl.Fragmented() {
+				fmt.Printf("\t%s: multiple namespaces, "+
+					"reset and prep to recreate\n", rl.Region)
+			}
+		}
+	}
+
+	if q.Unmounted {
+		devs, err := server.scm.UnmountedNamespaces()
+		if err != nil {
+			return errors.WithMessage(err, "SCM unmounted namespaces")
+		}
+		common.PrintStructs("Unmounted pmem devices", devs)
+	}
+
+	if q.Diag {
+		out, err := json.MarshalIndent(server.scm.DiagnosticBundle(), "", "  ")
+		if err != nil {
+			return errors.WithMessage(err, "SCM diagnostics")
+		}
+		fmt.Println(string(out))
+	}
+
+	if q.Verify {
+		report := server.scm.Verify()
+		fmt.Println("SCM verification:")
+		for _, check := range report {
+			if check.Passed() {
+				fmt.Printf("\t%s: passed\n", check.Name)
+				continue
+			}
+			fmt.Printf("\t%s: failed: %s\n", check.Name, check.Fault)
+		}
+		if !report.Passed() {
+			return errors.New("SCM verification failed")
+		}
+	}
+
+	// exit immediately to avoid continuation of main
+	os.Exit(0)
+	// never reached
+	return nil
+}
+
+// FormatScmCmd is the struct representing the command to format the SCM
+// mounts of locally-attached storage.
+type FormatScmCmd struct {
+	Force      bool   `short:"f" long:"force" description:"Format without prompting for confirmation"`
+	Reformat   bool   `long:"reformat" description:"Reformat mounts that are already formatted, destroying existing data"`
+	ConfigPath string `short:"o" long:"config_path" description:"Server config file path, specifies the scm mounts to format"`
+}
+
+// Execute is run when FormatScmCmd activates
+//
+// Perform task then exit immediately. Config is parsed to find the scm
+// mounts of each server. Unless forced, the user is prompted on the terminal
+// before each device is formatted and a declined or unanswered prompt fails
+// the format of that mount without making changes.
+func (f *FormatScmCmd) Execute(args []string) error {
+	ok, _ := common.CheckSudo()
+	if !ok {
+		return errors.New("subcommand must be run as root or sudo")
+	}
+
+	config := newConfiguration()
+	if err := config.setPath(f.ConfigPath); err != nil {
+		return errors.WithMessage(err, "set config path")
+	}
+	if err := config.loadConfig(); err != nil {
+		return errors.WithMessagef(err, "loading %s", config.Path)
+	}
+
+	server, err := newControlService(
+		&config, getDrpcClientConnection(config.SocketDir))
+	if err != nil {
+		return errors.WithMessage(err, "initialising ControlService")
+	}
+
+	if err := server.scm.Setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	if !f.Force {
+		server.scm.withConfirm(promptConfirm(os.Stdin, os.Stdout))
+	}
+
+	failed := false
+	for i := range config.Servers {
+		results := common.ScmMountResults{}
+		if f.Reformat {
+			server.scm.Reformat(i, &results)
+		} else {
+			server.scm.Format(i, &results)
+		}
+
+		for _, result := range results {
+			if result.State.Status != pb.ResponseStatus_CTRL_SUCCESS {
+				failed = true
+				fmt.Printf("\t%s: failed: %s\n",
+					result.Mntpoint, result.State.Error)
+				continue
+			}
+			fmt.Printf("\t%s: formatted\n", result.Mntpoint)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
 	}
 
 	// exit immediately to avoid continuation of main
@@ -26,6 +26,7 @@ package server
 import (
 	"hash/fnv"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -46,6 +47,16 @@ const (
 	msgConfigNoPath        = "no config path set"
 	msgConfigNoServers     = "no servers specified in config"
 	msgConfigServerNoIface = "fabric interface not specified in config"
+	msgConfigBadInodeRatio = "scm_inode_ratio must be a power of 2 between 1024 and 67108864"
+	msgConfigBadScmOwner   = "scm_mount_uid and scm_mount_gid must be between 0 and 2147483647"
+	msgConfigBadReservePct = "scm_reserve_percent must be between 0 and 100"
+	msgConfigBadModCount   = "scm_modules_per_socket must not be negative"
+	msgConfigBadStride     = "scm_stride and scm_stripe_width must be positive integers"
+	msgConfigBadDiscard    = "scm_discard must be either discard or nodiscard"
+	msgConfigBadFsType     = "scm_fs_type must be either ext4 or xfs"
+
+	minScmInodeRatio = 1024
+	maxScmInodeRatio = 65536 * 1024
 )
 
 func (c *configuration) loadConfig() error {
@@ -272,16 +283,224 @@ func (c *configuration) validateConfig() error {
 		return errors.New(msgConfigNoServers)
 	}
 
+	if c.ScmModsPerSock < 0 {
+		return errors.New(msgConfigBadModCount)
+	}
+
+	for _, flag := range c.ScmNdctlFlags {
+		if err := checkNdctlFlag(flag); err != nil {
+			return err
+		}
+	}
+
 	for i, srv := range c.Servers {
 		if srv.FabricIface == "" {
 			return errors.Errorf(
 				msgConfigServerNoIface+" for I/O service %d", i)
 		}
+		if !isValidInodeRatio(srv.ScmInodeRatio) {
+			return errors.Errorf(
+				msgConfigBadInodeRatio+" for I/O service %d", i)
+		}
+		if !isValidOwnerID(srv.ScmMountUid) ||
+			!isValidOwnerID(srv.ScmMountGid) {
+
+			return errors.Errorf(
+				msgConfigBadScmOwner+" for I/O service %d", i)
+		}
+		if srv.ScmReservePct < 0 || srv.ScmReservePct > 100 {
+			return errors.Errorf(
+				msgConfigBadReservePct+" for I/O service %d", i)
+		}
+		if srv.ScmStride < 0 || srv.ScmStripeWidth < 0 {
+			return errors.Errorf(
+				msgConfigBadStride+" for I/O service %d", i)
+		}
+		switch srv.ScmDiscard {
+		case "", scmDiscard, scmNoDiscard:
+		default:
+			return errors.Errorf(
+				msgConfigBadDiscard+" for I/O service %d", i)
+		}
+		switch srv.ScmFsType {
+		case "", scmFsExt4, scmFsXfs:
+		default:
+			return errors.Errorf(
+				msgConfigBadFsType+" for I/O service %d", i)
+		}
+		if err := checkMkfsOpts(srv.ScmMkfsOpts); err != nil {
+			return errors.Errorf("%s for I/O service %d", err, i)
+		}
+		if _, err := getMntFlags(&srv); err != nil {
+			return errors.Errorf("%s for I/O service %d", err, i)
+		}
+		if srv.ScmClass == scmRAM && srv.ScmSize <= 0 &&
+			srv.ScmSize != scmSizeTmpfsDefault {
+
+			return FaultScmBadTmpfsSize(srv.ScmSize)
+		}
+	}
+
+	if err := c.checkScmMemory(); err != nil {
+		return err
+	}
+
+	return c.checkScmOverlap()
+}
+
+// checkScmMemory verifies that the ram class tmpfs of all servers fit in
+// system memory together. The check is skipped if system memory can't be
+// determined, mount time checks against available memory still apply.
+func (c *configuration) checkScmMemory() error {
+	reqs := c.scmRequirements()
+	if reqs.TotalRAMGiB == 0 {
+		return nil
+	}
+
+	memTotal, err := memInfoBytes(c.ext, "MemTotal")
+	if err != nil {
+		log.Debugf("skipping scm tmpfs memory check: %s", err)
+		return nil
+	}
+
+	if uint64(reqs.TotalRAMGiB)<<30 > memTotal {
+		return FaultScmTmpfsExceedsMemory(reqs.TotalRAMGiB, memTotal)
+	}
+
+	return nil
+}
+
+// isValidOwnerID verifies uid or gid is non-negative and fits in the 32 bit
+// ids accepted by chown.
+func isValidOwnerID(id int) bool {
+	return id >= 0 && id <= math.MaxInt32
+}
+
+// isValidInodeRatio verifies ext4 bytes-per-inode ratio is within the range
+// accepted by mkfs and a power of 2, zero indicates the mkfs default.
+func isValidInodeRatio(ratio int) bool {
+	if ratio == 0 {
+		return true
+	}
+
+	return ratio >= minScmInodeRatio && ratio <= maxScmInodeRatio &&
+		ratio&(ratio-1) == 0
+}
+
+// scmMountPoints returns the mount points used by a server's scm, the
+// scm_mount and, for multiple dcpm devices, the mount point of each device.
+func scmMountPoints(srv *server) ([]string, error) {
+	var mntPoints []string
+	if srv.ScmMount != "" {
+		mntPoints = append(mntPoints, srv.ScmMount)
+	}
+
+	// per-device mounts are only derived for multiple dcpm devices and
+	// can't be derived without a mount point
+	if srv.ScmClass != scmDCPM || len(srv.ScmList) < 2 ||
+		(srv.ScmMount == "" && len(srv.ScmMountList) == 0) {
+
+		return mntPoints, nil
+	}
+
+	devMounts, err := scmDevMounts(srv)
+	if err != nil {
+		return nil, err
+	}
+	for _, mntPoint := range devMounts {
+		if mntPoint != "" {
+			mntPoints = append(mntPoints, mntPoint)
+		}
+	}
+
+	return mntPoints, nil
+}
+
+// checkScmOverlap verifies that no two servers share an scm mount point or
+// dcpm device, which would result in servers corrupting each other's data.
+//
+// Devices are compared in normalized form, a device path and the stable
+// identity of the same namespace can't be matched until the namespace is
+// resolved at format time.
+func (c *configuration) checkScmOverlap() error {
+	seenMounts := make(map[string]int)
+	seenDevs := make(map[string]int)
+
+	for i, srv := range c.Servers {
+		mntPoints, err := scmMountPoints(&srv)
+		if err != nil {
+			return errors.Errorf("%s for I/O service %d", err, i)
+		}
+		for _, mntPoint := range mntPoints {
+			mntPoint = filepath.Clean(mntPoint)
+			if seenIdx, exists := seenMounts[mntPoint]; exists {
+				return FaultScmDuplicateMount(i, seenIdx, mntPoint)
+			}
+			seenMounts[mntPoint] = i
+		}
+
+		// scm_list is ignored for ram class
+		if srv.ScmClass != scmDCPM {
+			continue
+		}
+
+		for _, dev := range srv.ScmList {
+			key := normalizeScmDev(dev)
+			if seenIdx, exists := seenDevs[key]; exists {
+				return FaultScmDuplicateDevice(i, seenIdx, dev)
+			}
+			seenDevs[key] = i
+		}
 	}
 
 	return nil
 }
 
+// scmRequirement describes the SCM needed by a single I/O server.
+//
+// Only ram class requirements have a size. Config doesn't size dcpm devices,
+// Prep creates a namespace per device filling its region, so dcpm
+// requirements are a device count to be compared against discovered
+// namespaces rather than against capacity.
+type scmRequirement struct {
+	ServerIdx int
+	Class     ScmClass
+	RAMGiB    int      // tmpfs size, ram class only
+	DcpmDevs  []string // pmem kernel devices, dcpm class only
+}
+
+// scmRequirements summarises the SCM needed by all I/O servers on a host so
+// that it can be compared against discovered capacity (ram) and devices
+// (dcpm) before provisioning.
+type scmRequirements struct {
+	Servers       []scmRequirement
+	TotalRAMGiB   int
+	TotalDcpmDevs int // number of devices, not bytes
+}
+
+// scmRequirements computes per-server SCM requirements from config and
+// aggregates host totals.
+func (c *configuration) scmRequirements() (reqs scmRequirements) {
+	for i, srv := range c.Servers {
+		req := scmRequirement{ServerIdx: i, Class: srv.ScmClass}
+
+		switch srv.ScmClass {
+		case scmRAM:
+			if srv.ScmSize > 0 {
+				req.RAMGiB = srv.ScmSize
+			}
+			reqs.TotalRAMGiB += req.RAMGiB
+		case scmDCPM:
+			req.DcpmDevs = srv.ScmList
+			reqs.TotalDcpmDevs += len(req.DcpmDevs)
+		}
+
+		reqs.Servers = append(reqs.Servers, req)
+	}
+
+	return
+}
+
 // getIOParams builds commandline options and environment variables to provide
 // to forked I/O service
 func (c *configuration) getIOParams(cliOpts *cliOptions) error {
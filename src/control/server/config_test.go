@@ -26,6 +26,7 @@ package server
 import (
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -778,3 +779,526 @@ func TestPopulateEnv(t *testing.T) {
 		AssertEqual(t, inEnvs, tt.outEnvs, tt.desc)
 	}
 }
+
+func TestScmRequirements(t *testing.T) {
+	config := newDefaultConfiguration(defaultMockExt())
+
+	ramSrv := newDefaultServer()
+	ramSrv.ScmClass = scmRAM
+	ramSrv.ScmSize = 16
+
+	dcpmSrv := newDefaultServer()
+	dcpmSrv.ScmClass = scmDCPM
+	dcpmSrv.ScmList = []string{"/dev/pmem0"}
+
+	unsizedRamSrv := newDefaultServer()
+	unsizedRamSrv.ScmClass = scmRAM
+
+	config.Servers = []server{ramSrv, dcpmSrv, ramSrv, unsizedRamSrv}
+
+	expReqs := scmRequirements{
+		Servers: []scmRequirement{
+			{ServerIdx: 0, Class: scmRAM, RAMGiB: 16},
+			{ServerIdx: 1, Class: scmDCPM, DcpmDevs: []string{"/dev/pmem0"}},
+			{ServerIdx: 2, Class: scmRAM, RAMGiB: 16},
+			{ServerIdx: 3, Class: scmRAM},
+		},
+		TotalRAMGiB:   32,
+		TotalDcpmDevs: 1,
+	}
+
+	AssertEqual(t, config.scmRequirements(), expReqs,
+		"unexpected scm requirements for mixed ram/dcpm config")
+}
+
+func TestCheckScmOverlap(t *testing.T) {
+	newSrv := func(class ScmClass, mntPoint string, devs ...string) server {
+		srv := newDefaultServer()
+		srv.ScmClass = class
+		srv.ScmMount = mntPoint
+		srv.ScmList = devs
+		return srv
+	}
+	withMountList := func(srv server, mntPoints ...string) server {
+		srv.ScmMountList = mntPoints
+		return srv
+	}
+
+	tests := []struct {
+		desc    string
+		servers []server
+		expErr  error
+	}{
+		{
+			desc: "no overlap",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos0", "/dev/pmem0"),
+				newSrv(scmDCPM, "/mnt/daos1", "/dev/pmem1"),
+			},
+		},
+		{
+			desc: "duplicate device",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos0", "/dev/pmem0"),
+				newSrv(scmDCPM, "/mnt/daos1", "/dev/pmem0"),
+			},
+			expErr: FaultScmDuplicateDevice(1, 0, "/dev/pmem0"),
+		},
+		{
+			desc: "duplicate mount",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos", "/dev/pmem0"),
+				newSrv(scmDCPM, "/mnt/daos/", "/dev/pmem1"),
+			},
+			expErr: FaultScmDuplicateMount(1, 0, "/mnt/daos"),
+		},
+		{
+			desc: "duplicate stable identity",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos0", "uuid:842fc847-28e0-4bb6-8dfc-d24afdba1528"),
+				newSrv(scmDCPM, "/mnt/daos1", "uuid:842FC847-28E0-4BB6-8DFC-D24AFDBA1528"),
+			},
+			expErr: FaultScmDuplicateDevice(1, 0, "uuid:842FC847-28E0-4BB6-8DFC-D24AFDBA1528"),
+		},
+		{
+			desc: "duplicate device path",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos0", "/dev/pmem0"),
+				newSrv(scmDCPM, "/mnt/daos1", "/dev//pmem0"),
+			},
+			expErr: FaultScmDuplicateDevice(1, 0, "/dev//pmem0"),
+		},
+		{
+			desc: "device mounts no overlap",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos0", "/dev/pmem0", "/dev/pmem1"),
+				newSrv(scmDCPM, "/mnt/daos1", "/dev/pmem2", "/dev/pmem3"),
+			},
+		},
+		{
+			desc: "scm_mount overlaps derived device mount",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos", "/dev/pmem0", "/dev/pmem1"),
+				newSrv(scmDCPM, "/mnt/daos/1", "/dev/pmem2"),
+			},
+			expErr: FaultScmDuplicateMount(1, 0, "/mnt/daos/1"),
+		},
+		{
+			desc: "scm_mount_list overlaps scm_mount",
+			servers: []server{
+				newSrv(scmDCPM, "/mnt/daos0", "/dev/pmem0"),
+				withMountList(
+					newSrv(scmDCPM, "", "/dev/pmem1", "/dev/pmem2"),
+					"/mnt/daos1", "/mnt/daos0/"),
+			},
+			expErr: FaultScmDuplicateMount(1, 0, "/mnt/daos0"),
+		},
+		{
+			desc: "scm_mount_list overlaps within server",
+			servers: []server{
+				withMountList(
+					newSrv(scmDCPM, "", "/dev/pmem0", "/dev/pmem1"),
+					"/mnt/daos0", "/mnt/daos0"),
+			},
+			expErr: FaultScmDuplicateMount(0, 0, "/mnt/daos0"),
+		},
+		{
+			desc: "scm_mount_list length mismatch",
+			servers: []server{
+				withMountList(
+					newSrv(scmDCPM, "", "/dev/pmem0", "/dev/pmem1"),
+					"/mnt/daos0"),
+			},
+			expErr: errors.Errorf(msgScmMountListMismatch+" for I/O service 0", 1, 2),
+		},
+		{
+			desc: "ram class devices ignored",
+			servers: []server{
+				newSrv(scmRAM, "/mnt/daos0", "/dev/pmem0"),
+				newSrv(scmDCPM, "/mnt/daos1", "/dev/pmem0"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(defaultMockExt())
+		config.Servers = tt.servers
+
+		err := config.checkScmOverlap()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateInodeRatio(t *testing.T) {
+	tests := []struct {
+		inodeRatio int
+		errMsg     string
+	}{
+		{0, ""},
+		{1024, ""},
+		{1048576, ""},
+		{67108864, ""},
+		{512, msgConfigBadInodeRatio + " for I/O service 0"},
+		{134217728, msgConfigBadInodeRatio + " for I/O service 0"},
+		{1000000, msgConfigBadInodeRatio + " for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmInodeRatio = tt.inodeRatio
+
+		desc := fmt.Sprintf("inode ratio %d", tt.inodeRatio)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateScmOwner(t *testing.T) {
+	tests := []struct {
+		uid    int
+		gid    int
+		errMsg string
+	}{
+		{0, 0, ""},
+		{1001, 1002, ""},
+		{math.MaxInt32, math.MaxInt32, ""},
+		{-1, 0, msgConfigBadScmOwner + " for I/O service 0"},
+		{0, -1, msgConfigBadScmOwner + " for I/O service 0"},
+		{math.MaxInt32 + 1, 0, msgConfigBadScmOwner + " for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmMountUid = tt.uid
+		config.Servers[0].ScmMountGid = tt.gid
+
+		desc := fmt.Sprintf("scm owner %d:%d", tt.uid, tt.gid)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateReservePercent(t *testing.T) {
+	tests := []struct {
+		reservePct int
+		errMsg     string
+	}{
+		{0, ""},
+		{10, ""},
+		{100, ""},
+		{-1, msgConfigBadReservePct + " for I/O service 0"},
+		{101, msgConfigBadReservePct + " for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmReservePct = tt.reservePct
+
+		desc := fmt.Sprintf("reserve percent %d", tt.reservePct)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateModulesPerSocket(t *testing.T) {
+	tests := []struct {
+		count  int
+		errMsg string
+	}{
+		{0, ""},
+		{6, ""},
+		{-1, msgConfigBadModCount},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.ScmModsPerSock = tt.count
+
+		desc := fmt.Sprintf("modules per socket %d", tt.count)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateScmSize(t *testing.T) {
+	memInfo := "MemTotal:       33554432 kB\n" // 32GiB
+
+	tests := []struct {
+		desc    string
+		class   ScmClass
+		sizes   []int // scm_size of each server
+		memInfo string
+		expErr  error
+	}{
+		{
+			desc:    "within memory",
+			class:   scmRAM,
+			sizes:   []int{16, 16},
+			memInfo: memInfo,
+		},
+		{
+			desc:    "tmpfs default",
+			class:   scmRAM,
+			sizes:   []int{scmSizeTmpfsDefault},
+			memInfo: memInfo,
+		},
+		{
+			desc:   "zero",
+			class:  scmRAM,
+			sizes:  []int{0},
+			expErr: FaultScmBadTmpfsSize(0),
+		},
+		{
+			desc:   "negative",
+			class:  scmRAM,
+			sizes:  []int{-2},
+			expErr: FaultScmBadTmpfsSize(-2),
+		},
+		{
+			desc:    "oversized",
+			class:   scmRAM,
+			sizes:   []int{16, 24},
+			memInfo: memInfo,
+			expErr:  FaultScmTmpfsExceedsMemory(40, 32<<30),
+		},
+		{
+			desc:  "oversized with unknown memory",
+			class: scmRAM,
+			sizes: []int{16, 24},
+		},
+		{
+			desc:    "ignored for dcpm",
+			class:   scmDCPM,
+			sizes:   []int{0, 64},
+			memInfo: memInfo,
+		},
+	}
+
+	for _, tt := range tests {
+		ext := &mockExt{}
+		if tt.memInfo != "" {
+			ext.readFileRet = map[string]string{memInfoPath: tt.memInfo}
+		}
+		config := mockConfigFromFile(t, ext, socketsExample)
+		servers := make([]server, 0, len(tt.sizes))
+		for i, size := range tt.sizes {
+			srv := config.Servers[0]
+			srv.ScmClass = tt.class
+			srv.ScmSize = size
+			srv.ScmMount = fmt.Sprintf("/mnt/daos%d", i)
+			srv.ScmList = []string{fmt.Sprintf("/dev/pmem%d", i)}
+			servers = append(servers, srv)
+		}
+		config.Servers = servers
+
+		err := config.validateConfig()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateNdctlFlags(t *testing.T) {
+	tests := []struct {
+		flags  []string
+		errMsg string
+	}{
+		{nil, ""},
+		{[]string{"--no-autolabel", "--sector-size=4096", "-f"}, ""},
+		{[]string{"--no-autolabel", "--map=mem; reboot"},
+			msgScmBadNdctlFlag + `: "--map=mem; reboot"`},
+		{[]string{"-l 4096"}, msgScmBadNdctlFlag + `: "-l 4096"`},
+		{[]string{"$(reboot)"}, msgScmBadNdctlFlag + `: "$(reboot)"`},
+		{[]string{""}, msgScmBadNdctlFlag + `: ""`},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.ScmNdctlFlags = tt.flags
+
+		desc := fmt.Sprintf("ndctl flags %q", tt.flags)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateScmDiscard(t *testing.T) {
+	tests := []struct {
+		discard string
+		errMsg  string
+	}{
+		{"", ""},
+		{scmDiscard, ""},
+		{scmNoDiscard, ""},
+		{"trim", msgConfigBadDiscard + " for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmDiscard = tt.discard
+
+		desc := fmt.Sprintf("discard %q", tt.discard)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateScmFsType(t *testing.T) {
+	tests := []struct {
+		fsType string
+		errMsg string
+	}{
+		{"", ""},
+		{scmFsExt4, ""},
+		{scmFsXfs, ""},
+		{"btrfs", msgConfigBadFsType + " for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmFsType = tt.fsType
+
+		desc := fmt.Sprintf("fs type %q", tt.fsType)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateScmMkfsOpts(t *testing.T) {
+	tests := []struct {
+		mkfsOpts string
+		errMsg   string
+	}{
+		{"", ""},
+		{"-E lazy_itable_init=0,lazy_journal_init=0", ""},
+		{"-T largefile4; rm -rf /", msgScmBadMkfsOpts +
+			": \"-T largefile4; rm -rf /\" for I/O service 0"},
+		{"-L `reboot`", msgScmBadMkfsOpts +
+			": \"-L `reboot`\" for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmMkfsOpts = tt.mkfsOpts
+
+		desc := fmt.Sprintf("mkfs options %q", tt.mkfsOpts)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateScmMountFlags(t *testing.T) {
+	tests := []struct {
+		flags  []string
+		errMsg string
+	}{
+		{nil, ""},
+		{[]string{"noatime", "ro"}, ""},
+		{[]string{"noatime", "atime"},
+			FaultScmBadMountFlag("atime", scmMountFlagNames()).Error() +
+				" for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmMountFlags = tt.flags
+
+		desc := fmt.Sprintf("mount flags %v", tt.flags)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestValidateScmStride(t *testing.T) {
+	tests := []struct {
+		stride      int
+		stripeWidth int
+		errMsg      string
+	}{
+		{0, 0, ""},
+		{1, 6, ""},
+		{-1, 0, msgConfigBadStride + " for I/O service 0"},
+		{0, -6, msgConfigBadStride + " for I/O service 0"},
+	}
+
+	for _, tt := range tests {
+		config := mockConfigFromFile(t, defaultMockExt(), socketsExample)
+		config.Servers[0].ScmStride = tt.stride
+		config.Servers[0].ScmStripeWidth = tt.stripeWidth
+
+		desc := fmt.Sprintf("stride %d stripe width %d",
+			tt.stride, tt.stripeWidth)
+		err := config.validateConfig()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+	}
+}
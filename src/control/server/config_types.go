@@ -30,6 +30,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v2"
@@ -169,7 +170,18 @@ type server struct {
 	ScmMount        string    `yaml:"scm_mount"`
 	ScmClass        ScmClass  `yaml:"scm_class"`
 	ScmList         []string  `yaml:"scm_list"`
+	ScmMountList    []string  `yaml:"scm_mount_list"`
 	ScmSize         int       `yaml:"scm_size"`
+	ScmInodeRatio   int       `yaml:"scm_inode_ratio"`
+	ScmMountUid     int       `yaml:"scm_mount_uid"`
+	ScmMountGid     int       `yaml:"scm_mount_gid"`
+	ScmReservePct   int       `yaml:"scm_reserve_percent"`
+	ScmStride       int       `yaml:"scm_stride"`
+	ScmStripeWidth  int       `yaml:"scm_stripe_width"`
+	ScmDiscard      string    `yaml:"scm_discard"`
+	ScmFsType       string    `yaml:"scm_fs_type"`
+	ScmMkfsOpts     string    `yaml:"scm_mkfs_opts"`
+	ScmMountFlags   []string  `yaml:"scm_mount_flags"`
 	BdevClass       BdevClass `yaml:"bdev_class"`
 	BdevList        []string  `yaml:"bdev_list"`
 	BdevNumber      int       `yaml:"bdev_number"`
@@ -228,6 +240,15 @@ type configuration struct {
 	FaultCb         string                    `yaml:"fault_cb"`
 	FabricIfaces    []string                  `yaml:"fabric_ifaces"`
 	ScmMountPath    string                    `yaml:"scm_mount_path"`
+	ScmNoAutoRegion bool                      `yaml:"scm_no_auto_regions"`
+	ScmInterleaved  bool                      `yaml:"scm_interleaved"`
+	ScmModsPerSock  int                       `yaml:"scm_modules_per_socket"`
+	ScmNdctlFlags   []string                  `yaml:"scm_ndctl_create_flags"`
+	ScmMaintenance  bool                      `yaml:"scm_maintenance_mode"`
+	ScmEventLog     string                    `yaml:"scm_event_log"`
+	ScmRegionTTL    time.Duration             `yaml:"scm_region_cache_ttl"`
+	ScmMetricsFile  string                    `yaml:"scm_metrics_file"`
+	ScmCmdTimeout   time.Duration             `yaml:"scm_cmd_timeout"`
 	BdevInclude     []string                  `yaml:"bdev_include"`
 	BdevExclude     []string                  `yaml:"bdev_exclude"`
 	Hyperthreads    bool                      `yaml:"hyperthreads"`
@@ -279,6 +300,7 @@ func newDefaultConfiguration(ext External) configuration {
 		Port:            10000,
 		TransportConfig: security.DefaultServerTransportConfig(),
 		ScmMountPath:    "/mnt/daos",
+		ScmInterleaved:  true,
 		Hyperthreads:    false,
 		NrHugepages:     1024,
 		Path:            "etc/daos_server.yml",
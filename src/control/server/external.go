@@ -29,11 +29,16 @@ import "C"
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -50,12 +55,35 @@ const (
 	msgRemove       = "os: removeall %s"
 	msgCmd          = "cmd: %s"
 	msgChownR       = "os: walk %s chown %d %d"
+	msgChmod        = "os: chmod %s %#o"
+	msgMountHolders = "os: list processes using %s"
+	msgMounts       = "os: read mount table"
+	msgMountOpts    = "os: read mount options of %s"
+	msgMountType    = "os: read mount type of %s"
+	msgDaxSupport   = "os: check dax support for %s"
+	msgProbeMount   = "os: read/write probe of %s"
+	msgReadOnlyBase = "os: check filesystem of %s is writable"
+	msgDevFsType    = "os: read filesystem signature of %s"
+
+	mountTablePath   = "/proc/mounts"
+	procStatPath     = "/proc/stat"
+	memInfoPath      = "/proc/meminfo"
+	filesystemsPath  = "/proc/filesystems"
+	osReleasePath    = "/proc/sys/kernel/osrelease"
+	kernelConfigBase = "/boot/config-"
+
+	// file written and read back to verify a mount is writable
+	scmProbeFile = ".daos_scm_probe"
+
+	stReadOnly = 0x1 // ST_RDONLY statfs mount flag
 )
 
 // External interface provides methods to support various os operations.
 type External interface {
 	runCommand(string) error
 	writeToFile(string, string) error
+	readFile(string) (string, error)
+	bootTime() (time.Time, error)
 	createEmpty(string, int64) error
 	mount(string, string, string, uintptr, string) error
 	isMountPoint(string) (bool, error)
@@ -68,6 +96,15 @@ type External interface {
 	lookupGroup(string) (*user.Group, error)
 	listGroups(*user.User) ([]string, error)
 	chownR(string, int, int) error
+	chmod(string, os.FileMode) error
+	mountHolders(string) ([]string, error)
+	mounts() (map[string][]string, error)
+	mountOptions(string) ([]string, error)
+	mountType(string) (string, error)
+	daxSupport(string, string) (string, error)
+	probeMount(string) (string, error)
+	readOnlyBase(string) (string, error)
+	deviceFsType(string) (string, error)
 	getHistory() []string
 }
 
@@ -83,17 +120,35 @@ func errPermsAnnotate(err error) (e error) {
 }
 
 type ext struct {
-	history []string
+	sync.Mutex // guards history, operations may be performed concurrently
+	history    []string
 }
 
+// getHistory returns a copy of the operations recorded so far.
 func (e *ext) getHistory() []string {
-	return e.history
+	e.Lock()
+	defer e.Unlock()
+
+	if e.history == nil {
+		return nil
+	}
+	history := make([]string, len(e.history))
+	copy(history, e.history)
+
+	return history
+}
+
+func (e *ext) record(op string) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.history = append(e.history, op)
 }
 
 // runCommand executes command in subshell (to allow redirection) and returns
 // error result.
 func (e *ext) runCommand(cmd string) error {
-	e.history = append(e.history, fmt.Sprintf(msgCmd, cmd))
+	e.record(fmt.Sprintf(msgCmd, cmd))
 
 	return common.Run(cmd)
 }
@@ -104,6 +159,37 @@ func (e *ext) writeToFile(contents string, path string) error {
 	return common.WriteString(path, contents)
 }
 
+// readFile returns the contents of the file at the given path.
+func (e *ext) readFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+
+	return string(data), err
+}
+
+// bootTime returns the time the system was booted as reported by the kernel.
+func (e *ext) bootTime() (time.Time, error) {
+	data, err := ioutil.ReadFile(procStatPath)
+	if err != nil {
+		return time.Time{}, errors.WithMessage(err, "read kernel stats")
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, errors.WithMessage(err, "parse boot time")
+		}
+
+		return time.Unix(secs, 0), nil
+	}
+
+	return time.Time{}, errors.New("boot time not found in " + procStatPath)
+}
+
 // createEmpty creates a file (if it doesn't exist) of specified size in bytes
 // at the given path.
 // If Fallocate not supported by kernel or backing fs, fall back to Truncate.
@@ -144,7 +230,7 @@ func (e *ext) mount(
 	op := fmt.Sprintf(msgMount, dev, mount, mntType, fmt.Sprint(flags), opts)
 
 	log.Debugf(op)
-	e.history = append(e.history, op)
+	e.record(op)
 
 	if flags == 0 {
 		flags = uintptr(syscall.MS_NOATIME | syscall.MS_SILENT)
@@ -160,7 +246,7 @@ func (e *ext) mount(
 // isMountPoint checks if path is likely to be a mount point.straiowhotenoul
 func (e *ext) isMountPoint(path string) (bool, error) {
 	log.Debugf(msgIsMountPoint, path)
-	e.history = append(e.history, fmt.Sprintf(msgIsMountPoint, path))
+	e.record(fmt.Sprintf(msgIsMountPoint, path))
 
 	pStat, err := os.Stat(path)
 	if err != nil {
@@ -184,7 +270,7 @@ func (e *ext) isMountPoint(path string) (bool, error) {
 //       available immediately after
 func (e *ext) unmount(path string) error {
 	log.Debugf(msgUnmount, path)
-	e.history = append(e.history, fmt.Sprintf(msgUnmount, path))
+	e.record(fmt.Sprintf(msgUnmount, path))
 
 	// ignore NOENT errors, treat as success
 	if err := syscall.Unmount(
@@ -204,7 +290,7 @@ func (e *ext) unmount(path string) error {
 // NOTE: may require elevated privileges
 func (e *ext) mkdir(path string) error {
 	log.Debugf(msgMkdir, path)
-	e.history = append(e.history, fmt.Sprintf(msgMkdir, path))
+	e.record(fmt.Sprintf(msgMkdir, path))
 
 	if err := os.MkdirAll(path, 0777); err != nil {
 		return errPermsAnnotate(errors.WithMessage(err, "mkdir"))
@@ -215,7 +301,7 @@ func (e *ext) mkdir(path string) error {
 // NOTE: may require elevated privileges
 func (e *ext) remove(path string) error {
 	log.Debugf(msgRemove, path)
-	e.history = append(e.history, fmt.Sprintf(msgRemove, path))
+	e.record(fmt.Sprintf(msgRemove, path))
 
 	// ignore NOENT errors, treat as success
 	if err := os.RemoveAll(path); err != nil && !os.IsNotExist(err) {
@@ -226,7 +312,7 @@ func (e *ext) remove(path string) error {
 
 func (e *ext) exists(path string) (bool, error) {
 	log.Debugf(msgExists, path)
-	e.history = append(e.history, fmt.Sprintf(msgExists, path))
+	e.record(fmt.Sprintf(msgExists, path))
 
 	if _, err := os.Stat(path); err == nil {
 		return true, nil
@@ -258,7 +344,7 @@ func (e *ext) chownR(root string, uid int, gid int) error {
 	op := fmt.Sprintf(msgChownR, root, uid, gid)
 
 	log.Debugf(op)
-	e.history = append(e.history, op)
+	e.record(op)
 
 	return filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -268,3 +354,307 @@ func (e *ext) chownR(root string, uid int, gid int) error {
 		return os.Chown(name, uid, gid)
 	})
 }
+
+func (e *ext) chmod(path string, mode os.FileMode) error {
+	op := fmt.Sprintf(msgChmod, path, mode)
+
+	log.Debugf(op)
+	e.record(op)
+
+	return errPermsAnnotate(os.Chmod(path, mode))
+}
+
+// mountTableUnescaper decodes the octal escapes used for whitespace and
+// backslashes in mount table fields.
+var mountTableUnescaper = strings.NewReplacer(
+	`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// mountEntry is a single entry of the mount table.
+type mountEntry struct {
+	dev     string
+	target  string
+	fsType  string
+	options []string
+}
+
+// readMountTable returns the entries of the current mount table in order of
+// mounting.
+func readMountTable() ([]mountEntry, error) {
+	data, err := ioutil.ReadFile(mountTablePath)
+	if err != nil {
+		return nil, errPermsAnnotate(
+			errors.WithMessage(err, "read mount table"))
+	}
+
+	var entries []mountEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		entries = append(entries, mountEntry{
+			dev:     mountTableUnescaper.Replace(fields[0]),
+			target:  mountTableUnescaper.Replace(fields[1]),
+			fsType:  fields[2],
+			options: strings.Split(fields[3], ","),
+		})
+	}
+
+	return entries, nil
+}
+
+// mounts returns the current mount table as a map of mounted device to the
+// mount points it is mounted at.
+func (e *ext) mounts() (map[string][]string, error) {
+	log.Debugf(msgMounts)
+	e.record(msgMounts)
+
+	entries, err := readMountTable()
+	if err != nil {
+		return nil, err
+	}
+
+	table := make(map[string][]string)
+	for _, entry := range entries {
+		table[entry.dev] = append(table[entry.dev], entry.target)
+	}
+
+	return table, nil
+}
+
+// mountOptions returns the effective options of the most recent mount at the
+// given mount point, or nil if nothing is mounted there.
+func (e *ext) mountOptions(mntPoint string) (opts []string, err error) {
+	log.Debugf(msgMountOpts, mntPoint)
+	e.record(fmt.Sprintf(msgMountOpts, mntPoint))
+
+	entries, err := readMountTable()
+	if err != nil {
+		return nil, err
+	}
+
+	mntPoint = filepath.Clean(mntPoint)
+	for _, entry := range entries {
+		if entry.target == mntPoint {
+			opts = entry.options
+		}
+	}
+
+	return
+}
+
+// mountType returns the filesystem type of the most recent mount at the
+// given mount point, or an empty string if not mounted.
+func (e *ext) mountType(mntPoint string) (fsType string, err error) {
+	log.Debugf(msgMountType, mntPoint)
+	e.record(fmt.Sprintf(msgMountType, mntPoint))
+
+	entries, err := readMountTable()
+	if err != nil {
+		return "", err
+	}
+
+	mntPoint = filepath.Clean(mntPoint)
+	for _, entry := range entries {
+		if entry.target == mntPoint {
+			fsType = entry.fsType
+		}
+	}
+
+	return
+}
+
+// probeMount writes, reads back and removes a probe file at the root of the
+// mount, returning the reason if the mount is not writable.
+func (e *ext) probeMount(mntPoint string) (string, error) {
+	log.Debugf(msgProbeMount, mntPoint)
+	e.record(fmt.Sprintf(msgProbeMount, mntPoint))
+
+	probePath := filepath.Join(mntPoint, scmProbeFile)
+	probe := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	err := ioutil.WriteFile(probePath, probe, 0600)
+	defer os.Remove(probePath)
+	switch {
+	case isReadOnly(err):
+		return "mount is read-only", nil
+	case err != nil:
+		return "write probe: " + err.Error(), nil
+	}
+
+	data, err := ioutil.ReadFile(probePath)
+	switch {
+	case err != nil:
+		return "read probe: " + err.Error(), nil
+	case string(data) != string(probe):
+		return "probe read back differs from written", nil
+	}
+
+	return "", nil
+}
+
+// readOnlyBase returns the nearest existing ancestor of path (or path itself)
+// if it resides on a read-only filesystem, or an empty string if the
+// filesystem is writable.
+func (e *ext) readOnlyBase(path string) (string, error) {
+	log.Debugf(msgReadOnlyBase, path)
+	e.record(fmt.Sprintf(msgReadOnlyBase, path))
+
+	base := filepath.Clean(path)
+	for {
+		_, err := os.Stat(base)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) || base == filepath.Dir(base) {
+			return "", errors.WithMessage(err, "stat")
+		}
+		base = filepath.Dir(base)
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(base, &st); err != nil {
+		return "", errors.WithMessage(err, "statfs")
+	}
+	if st.Flags&stReadOnly != 0 {
+		return base, nil
+	}
+
+	return "", nil
+}
+
+// isBusy indicates whether err was caused by a busy mount or device.
+func isBusy(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *os.SyscallError:
+		err = e.Err
+	case *os.PathError:
+		err = e.Err
+	}
+
+	return err == syscall.EBUSY
+}
+
+// blkidNoSignature is the exit status of blkid when no signature of the
+// requested type is found on the device.
+const blkidNoSignature = 2
+
+// deviceFsType returns the type of filesystem signature found on the block
+// device by low-level probing, or an empty string if the device holds no
+// filesystem.
+func (e *ext) deviceFsType(devPath string) (string, error) {
+	log.Debugf(msgDevFsType, devPath)
+	e.record(fmt.Sprintf(msgDevFsType, devPath))
+
+	out, err := exec.Command(
+		"blkid", "-p", "-o", "value", "-s", "TYPE", devPath).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok &&
+			status.ExitStatus() == blkidNoSignature {
+
+			return "", nil
+		}
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "blkid %s", devPath)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// isReadOnly indicates whether err was caused by a read-only filesystem.
+func isReadOnly(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+
+	return err == syscall.EROFS
+}
+
+// daxSupport checks that the kernel supports DAX access to the given pmem
+// block device and mounting a filesystem of the given type with the dax
+// option, returning the reason if not supported or an empty string if
+// supported.
+//
+// The kernel config is only checked if available under /boot.
+func (e *ext) daxSupport(devPath string, fsType string) (string, error) {
+	log.Debugf(msgDaxSupport, devPath)
+	e.record(fmt.Sprintf(msgDaxSupport, devPath))
+
+	daxPath := filepath.Join(
+		"/sys/block", filepath.Base(devPath), "queue", "dax")
+	data, err := ioutil.ReadFile(daxPath)
+	switch {
+	case os.IsNotExist(err):
+		return "block device dax attribute not found", nil
+	case err != nil:
+		return "", errPermsAnnotate(errors.WithMessage(err, "read dax attribute"))
+	case strings.TrimSpace(string(data)) != "1":
+		return "block device does not support dax, check namespace mode is fsdax", nil
+	}
+
+	data, err = ioutil.ReadFile(filesystemsPath)
+	if err != nil {
+		return "", errors.WithMessage(err, "read supported filesystems")
+	}
+	if !strings.Contains(string(data), "\t"+fsType+"\n") {
+		return fsType + " filesystem not supported by kernel", nil
+	}
+
+	release, err := ioutil.ReadFile(osReleasePath)
+	if err != nil {
+		return "", errors.WithMessage(err, "read kernel release")
+	}
+	data, err = ioutil.ReadFile(kernelConfigBase + strings.TrimSpace(string(release)))
+	if err != nil {
+		// kernel config not available, assume supported
+		return "", nil
+	}
+	if !strings.Contains(string(data), "\nCONFIG_FS_DAX=y") {
+		return "kernel built without CONFIG_FS_DAX", nil
+	}
+
+	return "", nil
+}
+
+// mountHolders returns descriptions ("pid (command)") of processes with open
+// files or working directories on the given mount point.
+//
+// NOTE: requires elevated privileges to inspect processes of other users
+func (e *ext) mountHolders(mntPoint string) ([]string, error) {
+	log.Debugf(msgMountHolders, mntPoint)
+	e.record(fmt.Sprintf(msgMountHolders, mntPoint))
+
+	mntPoint = filepath.Clean(mntPoint)
+	isUnderMount := func(link string) bool {
+		target, err := os.Readlink(link)
+		if err != nil {
+			return false
+		}
+		return target == mntPoint || strings.HasPrefix(target, mntPoint+"/")
+	}
+
+	procDirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+
+	var holders []string
+	for _, procDir := range procDirs {
+		links, _ := filepath.Glob(filepath.Join(procDir, "fd", "*"))
+		links = append(links, filepath.Join(procDir, "cwd"))
+
+		for _, link := range links {
+			if !isUnderMount(link) {
+				continue
+			}
+
+			comm, _ := ioutil.ReadFile(filepath.Join(procDir, "comm"))
+			holders = append(holders, fmt.Sprintf("%s (%s)",
+				filepath.Base(procDir), strings.TrimSpace(string(comm))))
+			break
+		}
+	}
+
+	return holders, nil
+}
@@ -25,7 +25,10 @@ package server
 
 import (
 	"fmt"
+	"os"
 	"os/user"
+	"sync"
+	"time"
 )
 
 // mockExt implements the External interface.
@@ -47,26 +50,70 @@ type mockExt struct {
 	listGrpsRet     []string    // list of user's groups
 	chownRErr       error
 	history         []string
+	mountHoldersRet [][]string // successive results of mountHolders calls
+	chmodErr        error
+	mountsRet       map[string][]string // mounted device to mount points
+	daxUnsupported  string              // reason dax is not supported
+	mountOptsRet    []string            // effective mount options
+	readFileRet     map[string]string   // file contents keyed by path
+	bootTimeRet     time.Time
+	mountTypeRet    string            // filesystem type of existing mount
+	probeRet        string            // reason mount failed read/write probe
+	readOnlyBaseRet string            // read-only ancestor of a path
+	devFsTypeRet    map[string]string // filesystem signature keyed by device
+	sync.Mutex                        // guards history, mountHoldersRet and files
 }
 
 func (m *mockExt) getHistory() []string {
-	return m.history
+	m.Lock()
+	defer m.Unlock()
+
+	if m.history == nil {
+		return nil
+	}
+	history := make([]string, len(m.history))
+	copy(history, m.history)
+
+	return history
+}
+
+func (m *mockExt) record(op string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.history = append(m.history, op)
 }
 
 var files []string // record file content written in mocks
 
 func (m *mockExt) runCommand(cmd string) error {
-	m.history = append(m.history, fmt.Sprintf(msgCmd, cmd))
+	m.record(fmt.Sprintf(msgCmd, cmd))
 
 	return m.cmdRet
 }
 
 func (m *mockExt) writeToFile(in string, outPath string) error {
+	m.Lock()
+	defer m.Unlock()
+
 	files = append(files, fmt.Sprint(outPath, ":", in))
 
 	return nil
 }
 
+func (m *mockExt) readFile(path string) (string, error) {
+	contents, exists := m.readFileRet[path]
+	if !exists {
+		return "", &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+
+	return contents, nil
+}
+
+func (m *mockExt) bootTime() (time.Time, error) {
+	return m.bootTimeRet, nil
+}
+
 func (m *mockExt) createEmpty(path string, size int64) error {
 	if !m.existsRet {
 		files = append(files, fmt.Sprint(path, ":empty size ", size))
@@ -79,31 +126,31 @@ func (m *mockExt) mount(
 
 	op := fmt.Sprintf(msgMount, dev, mount, typ, fmt.Sprint(flags), opts)
 
-	m.history = append(m.history, op)
+	m.record(op)
 
 	return m.mountRet
 }
 
 func (m *mockExt) isMountPoint(path string) (bool, error) {
-	m.history = append(m.history, fmt.Sprintf(msgIsMountPoint, path))
+	m.record(fmt.Sprintf(msgIsMountPoint, path))
 
 	return m.isMountPointRet, nil
 }
 
 func (m *mockExt) unmount(path string) error {
-	m.history = append(m.history, fmt.Sprintf(msgUnmount, path))
+	m.record(fmt.Sprintf(msgUnmount, path))
 
 	return m.unmountRet
 }
 
 func (m *mockExt) mkdir(path string) error {
-	m.history = append(m.history, fmt.Sprintf(msgMkdir, path))
+	m.record(fmt.Sprintf(msgMkdir, path))
 
 	return m.mkdirRet
 }
 
 func (m *mockExt) remove(path string) error {
-	m.history = append(m.history, fmt.Sprintf(msgRemove, path))
+	m.record(fmt.Sprintf(msgRemove, path))
 
 	return m.removeRet
 }
@@ -129,11 +176,62 @@ func (m *mockExt) listGroups(usr *user.User) ([]string, error) {
 }
 
 func (m *mockExt) chownR(root string, uid int, gid int) error {
-	m.history = append(m.history, fmt.Sprintf(msgChownR, root, uid, gid))
+	m.record(fmt.Sprintf(msgChownR, root, uid, gid))
 
 	return m.chownRErr
 }
 
+func (m *mockExt) chmod(path string, mode os.FileMode) error {
+	m.record(fmt.Sprintf(msgChmod, path, mode))
+
+	return m.chmodErr
+}
+
+func (m *mockExt) mounts() (map[string][]string, error) {
+	m.record(msgMounts)
+
+	return m.mountsRet, nil
+}
+
+func (m *mockExt) mountOptions(string) ([]string, error) {
+	return m.mountOptsRet, nil
+}
+
+func (m *mockExt) mountType(string) (string, error) {
+	return m.mountTypeRet, nil
+}
+
+func (m *mockExt) daxSupport(string, string) (string, error) {
+	return m.daxUnsupported, nil
+}
+
+func (m *mockExt) probeMount(string) (string, error) {
+	return m.probeRet, nil
+}
+
+func (m *mockExt) readOnlyBase(string) (string, error) {
+	return m.readOnlyBaseRet, nil
+}
+
+func (m *mockExt) deviceFsType(devPath string) (string, error) {
+	return m.devFsTypeRet[devPath], nil
+}
+
+func (m *mockExt) mountHolders(mntPoint string) ([]string, error) {
+	m.record(fmt.Sprintf(msgMountHolders, mntPoint))
+
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.mountHoldersRet) == 0 {
+		return nil, nil
+	}
+	holders := m.mountHoldersRet[0]
+	m.mountHoldersRet = m.mountHoldersRet[1:]
+
+	return holders, nil
+}
+
 func newMockExt(
 	cmdRet error, existsRet bool, mountRet error, isMountPointRet bool,
 	unmountRet error, mkdirRet error, removeRet error,
@@ -142,6 +240,8 @@ func newMockExt(
 	return &mockExt{
 		cmdRet, existsRet, mountRet, isMountPointRet, unmountRet, mkdirRet,
 		removeRet, nil, nil, nil, nil, nil, []string{}, nil, []string{},
+		nil, nil, nil, "", nil, nil, time.Time{}, "", "", "", nil,
+		sync.Mutex{},
 	}
 }
 
@@ -0,0 +1,271 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daos-stack/daos/src/control/faults"
+)
+
+// The Reason and Resolution of the faults created here are those of the
+// canonical faults registered for their codes by the faults package, see
+// faults.New.
+
+// FaultScmDegradedRegion creates a fault indicating that the given AppDirect
+// region (interleave set) is degraded and cannot host pmem namespaces.
+func FaultScmDegradedRegion(iSetID, healthState string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmDegradedRegion,
+		fmt.Sprintf(
+			"scm region %s has health state %q and cannot be used for namespaces",
+			iSetID, healthState)))
+}
+
+// FaultScmTmpfsNoMemory creates a fault indicating that a ram class tmpfs
+// of the given size cannot be mounted due to insufficient free memory, either
+// detected before attempting to mount or reported by the mount itself.
+// availBytes is the memory available when known, zero otherwise.
+func FaultScmTmpfsNoMemory(sizeGiB int, availBytes uint64) *faults.Fault {
+	desc := fmt.Sprintf(
+		"insufficient memory available to mount %dGiB tmpfs for scm", sizeGiB)
+	if availBytes != 0 {
+		desc += fmt.Sprintf(" (%.1fGiB available)",
+			float64(availBytes)/(1<<30))
+	}
+
+	return faults.Raise(faults.New(faults.CodeStorageTmpfsNoMemory, desc))
+}
+
+// FaultScmTmpfsExceedsMemory creates a fault indicating that the ram class
+// tmpfs of all servers in the config would not fit in system memory.
+func FaultScmTmpfsExceedsMemory(totalGiB int, memBytes uint64) *faults.Fault {
+	f := faults.New(faults.CodeStorageTmpfsNoMemory,
+		fmt.Sprintf("scm tmpfs of I/O servers totalling %dGiB exceeds %.1fGiB system memory",
+			totalGiB, float64(memBytes)/(1<<30)))
+	f.Resolution = "reduce scm_size in config so that the scm tmpfs of all I/O servers fit in system memory"
+
+	return faults.Raise(f)
+}
+
+// FaultScmDuplicateMount creates a fault indicating that two servers in the
+// config share the same scm mount point.
+func FaultScmDuplicateMount(curIdx, seenIdx int, mntPoint string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageDuplicateScmMount,
+		fmt.Sprintf(
+			"scm mount point %s of I/O server %d is already used by I/O server %d",
+			mntPoint, curIdx, seenIdx)))
+}
+
+// FaultScmDuplicateDevice creates a fault indicating that two servers in the
+// config share the same scm device.
+func FaultScmDuplicateDevice(curIdx, seenIdx int, devPath string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageDuplicateScmDevice,
+		fmt.Sprintf(
+			"scm_list device %s of I/O server %d is already used by I/O server %d",
+			devPath, curIdx, seenIdx)))
+}
+
+// FaultScmMismatchedCapacities creates a fault warning that discovered SCM
+// modules have differing capacities.
+func FaultScmMismatchedCapacities(capacities []uint64) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmMismatchedCapacities,
+		fmt.Sprintf(
+			"scm modules have mismatched capacities %v, interleaved regions may be imbalanced or fail to be created",
+			capacities)))
+}
+
+// FaultScmGoalMismatch creates a fault indicating that the pending memory
+// allocation goal does not match the requested AppDirect configuration.
+func FaultScmGoalMismatch(detail string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmGoalMismatch,
+		"scm allocation goal not applied as requested: "+detail))
+}
+
+// FaultScmPartitionedDevice creates a fault indicating that the scm device
+// to be formatted contains a partition table.
+func FaultScmPartitionedDevice(devPath, ptType string) *faults.Fault {
+	f := faults.New(faults.CodeStorageScmPartitionedDevice,
+		fmt.Sprintf("scm device %s has a %s partition table", devPath, ptType))
+	f.Resolution = fmt.Sprintf("verify partitions on %s are not in use "+
+		"and remove the partition table with wipefs -a %s before formatting",
+		devPath, devPath)
+
+	return faults.Raise(f)
+}
+
+// FaultScmDaxUnsupported creates a fault indicating that the scm device
+// cannot be mounted with DAX as the kernel or filesystem lacks support.
+func FaultScmDaxUnsupported(devPath, reason string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmDaxUnsupported,
+		fmt.Sprintf("scm device %s does not support dax: %s", devPath, reason)))
+}
+
+// FaultScmNotPmemNamespace creates a fault indicating that a configured scm
+// device is not a pmem namespace block device, e.g. a raw module (nmem) or
+// devdax namespace.
+func FaultScmNotPmemNamespace(devPath string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmNotPmemNamespace,
+		fmt.Sprintf("scm device %s is not a pmem namespace "+
+			"block device (expected e.g. /dev/pmem0)", devPath)))
+}
+
+// FaultScmNoRegions creates a fault indicating that scm modules have no
+// AppDirect regions and automatic region creation is disabled.
+func FaultScmNoRegions() *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmNoRegions,
+		"scm modules have no AppDirect regions and automatic region creation is disabled"))
+}
+
+// FaultScmFirmwareIncompatible creates a fault indicating that a firmware
+// image is not intended for the model of the given scm module.
+func FaultScmFirmwareIncompatible(physID uint32, image, reason string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmFirmwareIncompatible,
+		fmt.Sprintf("firmware image %s is incompatible with "+
+			"scm module %d: %s", image, physID, reason)))
+}
+
+// FaultScmVerifyFailed creates a fault indicating that the scm setup does
+// not match the configuration, as detected by the named verification check.
+func FaultScmVerifyFailed(check, detail string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmVerifyFailed,
+		fmt.Sprintf("scm verification %q failed: %s", check, detail)))
+}
+
+// FaultScmMissingModules creates a fault indicating that fewer scm modules
+// were discovered on some sockets than expected, e.g. because of a failed or
+// missing module.
+func FaultScmMissingModules(detail string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmMissingModules,
+		"scm modules missing (discovered of expected per socket): "+detail))
+}
+
+// FaultScmClassChanged creates a fault indicating that the existing scm mount
+// was created for a different scm_class than is now configured.
+func FaultScmClassChanged(mntPoint string, mounted, configured ScmClass) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmClassChanged,
+		fmt.Sprintf("existing scm mount %s is %s but scm_class "+
+			"is now %s", mntPoint, mounted, configured)))
+}
+
+// FaultScmMountNotWritable creates a fault indicating that a newly formatted
+// scm mount failed a read/write probe, e.g. because it is read-only.
+func FaultScmMountNotWritable(mntPoint, reason string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmMountNotWritable,
+		fmt.Sprintf("scm mount %s is not writable: %s", mntPoint, reason)))
+}
+
+// FaultScmModulesChanged creates a fault indicating that the discovered scm
+// modules differ from those interleaved in existing regions, e.g. because
+// modules were added or removed after regions were created.
+func FaultScmModulesChanged(detail string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmModulesChanged,
+		"scm modules differ from those in existing regions: "+detail))
+}
+
+// FaultScmMaintenanceMode creates a fault indicating that a mutating scm
+// operation was refused because scm storage is in maintenance mode.
+func FaultScmMaintenanceMode(op string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmMaintenanceMode,
+		fmt.Sprintf("scm %s refused: scm storage is in maintenance mode", op)))
+}
+
+// FaultScmMountBaseReadOnly creates a fault indicating that an scm mount
+// point cannot be created because its parent path is on a read-only
+// filesystem.
+func FaultScmMountBaseReadOnly(mntPoint, base string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmMountBaseReadOnly,
+		fmt.Sprintf("cannot create scm mount point %s: %s is on a read-only filesystem", mntPoint, base)))
+}
+
+// FaultScmFormatCancelled creates a fault indicating that an scm format was
+// declined when confirmation was requested.
+func FaultScmFormatCancelled(target string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmFormatCancelled,
+		fmt.Sprintf("scm format of %s cancelled", target)))
+}
+
+// FaultScmConfirmUnavailable creates a fault indicating that confirmation of
+// an scm format could not be obtained, e.g. because no terminal is attached.
+func FaultScmConfirmUnavailable(target string, err error) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmConfirmUnavailable,
+		fmt.Sprintf("confirmation of scm format of %s unavailable: %s", target, err)))
+}
+
+// FaultScmAlreadyFormatted creates a fault indicating that format was
+// requested for scm storage that has already been formatted.
+func FaultScmAlreadyFormatted(mntPoint string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageAlreadyFormatted,
+		fmt.Sprintf("scm storage at %s has already been formatted", mntPoint)))
+}
+
+// FaultScmPartialRegions creates a fault indicating that AppDirect regions
+// exist on only some of the sockets with scm modules.
+func FaultScmPartialRegions(appDirect, other []int) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmPartialRegions,
+		fmt.Sprintf("scm AppDirect regions on sockets %v but not on sockets %v", appDirect, other)))
+}
+
+// FaultScmBadTmpfsSize creates a fault indicating that the scm_size of a ram
+// class server can't be used to size the tmpfs.
+func FaultScmBadTmpfsSize(sizeGiB int) *faults.Fault {
+	f := faults.New(faults.CodeStorageBadTmpfsSize,
+		fmt.Sprintf("invalid scm_size %d for ram scm tmpfs", sizeGiB))
+	f.Resolution = fmt.Sprintf("set scm_size in config to a positive size in GiB, or to %d to use the tmpfs default size", scmSizeTmpfsDefault)
+
+	return faults.Raise(f)
+}
+
+// FaultScmUnhealthyModules creates a fault indicating that scm modules are
+// failing or are close to the end of their rated life.
+func FaultScmUnhealthyModules(modules string) *faults.Fault {
+	return faults.Raise(faults.New(faults.CodeStorageScmUnhealthyModules,
+		fmt.Sprintf("unhealthy scm modules: %s", modules)))
+}
+
+// FaultScmFilesystemMounted creates a fault indicating that the scm
+// filesystem couldn't be unmounted because it is in use, holders lists the
+// processes found using the mount point if any.
+func FaultScmFilesystemMounted(mntPoint string, holders []string) *faults.Fault {
+	desc := fmt.Sprintf("scm filesystem at %s is mounted and busy", mntPoint)
+	if len(holders) != 0 {
+		desc += fmt.Sprintf(" (in use by processes: %s)",
+			strings.Join(holders, ", "))
+	}
+
+	f := faults.New(faults.CodeStorageFilesystemMounted, desc)
+	f.Resolution = fmt.Sprintf("stop any DAOS I/O servers and other processes using %s, then retry", mntPoint)
+
+	return faults.Raise(f)
+}
+
+// FaultScmBadMountFlag creates a fault indicating that scm_mount_flags in
+// config contains an unknown mount flag.
+func FaultScmBadMountFlag(flag string, valid []string) *faults.Fault {
+	f := faults.New(faults.CodeStorageScmBadMountFlag,
+		fmt.Sprintf("unknown scm mount flag %q", flag))
+	f.Resolution = fmt.Sprintf("set scm_mount_flags in config to a list of supported flags: %s", strings.Join(valid, ", "))
+
+	return faults.Raise(f)
+}
@@ -0,0 +1,88 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/daos-stack/daos/src/control/common"
+	"github.com/daos-stack/daos/src/control/faults"
+)
+
+func TestFaultsLookupByCode(t *testing.T) {
+	tests := []struct {
+		desc    string
+		fault   *faults.Fault
+		expCode faults.Code
+	}{
+		{
+			desc:    "busy mount",
+			fault:   FaultScmFilesystemMounted("/mnt/daos", []string{"daos_io_server[1234]"}),
+			expCode: faults.CodeStorageFilesystemMounted,
+		},
+		{
+			desc:    "already formatted",
+			fault:   FaultScmAlreadyFormatted("/mnt/daos"),
+			expCode: faults.CodeStorageAlreadyFormatted,
+		},
+		{
+			desc:    "tmpfs memory",
+			fault:   FaultScmTmpfsNoMemory(6, 1<<30),
+			expCode: faults.CodeStorageTmpfsNoMemory,
+		},
+		{
+			desc:    "no regions",
+			fault:   FaultScmNoRegions(),
+			expCode: faults.CodeStorageScmNoRegions,
+		},
+	}
+
+	for _, tt := range tests {
+		// code as reported to a client which doesn't link this package
+		data, err := json.Marshal(tt.fault)
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		var reported faults.Fault
+		if err := json.Unmarshal(data, &reported); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		AssertEqual(t, reported.Code, tt.expCode, tt.desc+": unexpected code")
+
+		canonical, ok := faults.LookupByCode(reported.Code)
+		AssertTrue(t, ok, tt.desc+": code not registered")
+		AssertTrue(t, canonical.Equals(tt.fault), tt.desc+": not equal to canonical")
+		AssertEqual(t, canonical.Domain, tt.fault.Domain, tt.desc+": unexpected domain")
+		AssertEqual(t, canonical.Reason, tt.fault.Reason, tt.desc+": unexpected reason")
+
+		var inDomain bool
+		for _, f := range faults.LookupByDomain(tt.fault.Domain) {
+			if f == canonical {
+				inDomain = true
+			}
+		}
+		AssertTrue(t, inDomain, tt.desc+": canonical fault not found by domain")
+	}
+}
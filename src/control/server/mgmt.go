@@ -93,14 +93,14 @@ func awaitStorageFormat(config *configuration) error {
 		if err == nil && !isMount {
 			log.Debugf("attempting to mount existing SCM dir %s\n", srv.ScmMount)
 
-			mntType, devPath, mntOpts, err := getMntParams(&srv)
+			mntType, devPath, mntOpts, mntFlags, err := getMntParams(&srv)
 			if err != nil {
 				return errors.WithMessage(err, "getting scm mount params")
 			}
 
 			log.Debugf("mounting scm %s at %s (%s)...", devPath, srv.ScmMount, mntType)
 
-			err = config.ext.mount(devPath, srv.ScmMount, mntType, uintptr(0), mntOpts)
+			err = config.ext.mount(devPath, srv.ScmMount, mntType, mntFlags, mntOpts)
 			if err != nil {
 				return errors.WithMessage(err, "mounting existing scm dir")
 			}
@@ -167,9 +167,18 @@ func newControlService(
 		return
 	}
 
+	scmStorage := newScmStorage(config)
+	if config.ScmEventLog != "" {
+		f, err := common.AppendFile(config.ScmEventLog)
+		if err != nil {
+			return nil, errors.WithMessage(err, "create scm event log")
+		}
+		scmStorage.withEventStream(f)
+	}
+
 	cs = &controlService{
 		nvme:              nvmeStorage,
-		scm:               newScmStorage(config),
+		scm:               scmStorage,
 		supportedFeatures: fMap,
 		config:            config,
 		drpc:              client,
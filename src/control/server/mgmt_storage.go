@@ -64,7 +64,13 @@ func (c *controlService) ScanStorage(
 
 // doFormat performs format on storage subsystems, populates response results
 // in storage subsystem routines and broadcasts (closes channel) if successful.
-func (c *controlService) doFormat(i int, resp *pb.FormatStorageResp) error {
+//
+// Already formatted scm is reformatted if forced in the request. In dry run
+// only the scm format is planned, nvme is left untouched and the server is not
+// signalled as formatted.
+func (c *controlService) doFormat(
+	i int, req *pb.FormatStorageReq, resp *pb.FormatStorageResp) error {
+
 	srv := c.config.Servers[i]
 	serverFormatted := false
 
@@ -79,12 +85,27 @@ func (c *controlService) doFormat(i int, resp *pb.FormatStorageResp) error {
 		serverFormatted = true
 	}
 
+	if req.GetScm().GetDryRun() {
+		c.scm.withDryRun(true)
+		defer c.scm.withDryRun(false)
+
+		mountResults := common.ScmMountResults{}
+		c.scm.format(i, req.GetScm().GetForce(), &mountResults)
+		resp.Mrets = mountResults
+
+		return nil
+	}
+
 	ctrlrResults := common.NvmeControllerResults{}
 	c.nvme.Format(i, &ctrlrResults)
 	resp.Crets = ctrlrResults
 
 	mountResults := common.ScmMountResults{}
-	c.scm.Format(i, &mountResults)
+	if req.GetScm().GetForce() {
+		c.scm.Reformat(i, &mountResults)
+	} else {
+		c.scm.Format(i, &mountResults)
+	}
 	resp.Mrets = mountResults
 
 	if !serverFormatted && c.nvme.formatted && c.scm.formatted {
@@ -111,11 +132,17 @@ func (c *controlService) FormatStorage(
 	resp := new(pb.FormatStorageResp)
 
 	for i := range c.config.Servers {
-		if err := c.doFormat(i, resp); err != nil {
+		if err := c.doFormat(i, req, resp); err != nil {
 			return errors.WithMessage(err, "formatting storage")
 		}
 	}
 
+	if c.config.ScmMetricsFile != "" {
+		if err := c.scm.writeMetricsFile(c.config.ScmMetricsFile); err != nil {
+			log.Errorf("writing scm metrics: %s", err)
+		}
+	}
+
 	if err := stream.Send(resp); err != nil {
 		return errors.WithMessagef(err, "sending response (%+v)", resp)
 	}
@@ -216,6 +216,8 @@ func TestScanStorage(t *testing.T) {
 func TestFormatStorage(t *testing.T) {
 	tests := []struct {
 		superblockExists bool
+		force            bool
+		dryRun           bool
 		mountRet         error
 		unmountRet       error
 		mkdirRet         error
@@ -344,12 +346,52 @@ func TestFormatStorage(t *testing.T) {
 			mountRets: ScmMountResults{
 				{
 					Mntpoint: "/mnt/daos",
+					State:    FaultScmAlreadyFormatted("/mnt/daos").ResponseState(),
+				},
+			},
+		},
+		{
+			desc:             "already formatted forced scm reformat",
+			superblockExists: true,
+			force:            true,
+			sMount:           "/mnt/daos",
+			sClass:           scmRAM,
+			sSize:            6,
+			bClass:           bdNVMe,
+			bDevs:            []string{"0000:81:00.0"},
+			expScmFormatted:  true,
+			expNvmeFormatted: true,
+			ctrlrRets: NvmeControllerResults{
+				{
+					Pciaddr: "",
 					State: &pb.ResponseState{
 						Status: pb.ResponseStatus_CTRL_ERR_APP,
-						Error:  msgScmAlreadyFormatted,
+						Error:  msgBdevAlreadyFormatted,
 					},
 				},
 			},
+			mountRets: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State:    new(pb.ResponseState),
+				},
+			},
+		},
+		{
+			desc:      "nvme and dcpm dry run",
+			dryRun:    true,
+			sMount:    "/mnt/daos",
+			sClass:    scmDCPM,
+			sDevs:     []string{"/dev/pmem1"},
+			bClass:    bdNVMe,
+			bDevs:     []string{"0000:81:00.0"},
+			ctrlrRets: NvmeControllerResults{},
+			mountRets: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State:    &pb.ResponseState{Info: msgScmDryRun},
+				},
+			},
 		},
 	}
 
@@ -374,7 +416,12 @@ func TestFormatStorage(t *testing.T) {
 		go func() {
 			// should signal wait group in srv to unlock if
 			// successful once format completed
-			_ = cs.FormatStorage(nil, mock)
+			req := &pb.FormatStorageReq{
+				Scm: &pb.FormatScmReq{
+					Force: tt.force, DryRun: tt.dryRun,
+				},
+			}
+			_ = cs.FormatStorage(req, mock)
 			mockWg.Done()
 		}()
 
@@ -404,12 +451,22 @@ func TestFormatStorage(t *testing.T) {
 				"unexpected pciaddr, "+tt.desc)
 		}
 
+		AssertEqual(
+			t, len(mock.Results[0].Crets), len(tt.ctrlrRets),
+			"unexpected number of controller results, "+tt.desc)
+		AssertEqual(
+			t, len(mock.Results[0].Mrets), len(tt.mountRets),
+			"unexpected number of mount results, "+tt.desc)
 		for i, result := range mock.Results[0].Mrets {
 			expected := tt.mountRets[i]
 			AssertEqual(
 				t, result.State.Error,
 				expected.State.Error,
 				"unexpected result error message, "+tt.desc)
+			AssertEqual(
+				t, result.State.Info,
+				expected.State.Info,
+				"unexpected result info message, "+tt.desc)
 			AssertEqual(
 				t, result.State.Status,
 				expected.State.Status,
@@ -422,6 +479,7 @@ func TestFormatStorage(t *testing.T) {
 
 		AssertEqual(t, cs.nvme.formatted, tt.expNvmeFormatted, tt.desc)
 		AssertEqual(t, cs.scm.formatted, tt.expScmFormatted, tt.desc)
+		AssertEqual(t, cs.scm.dryRun, false, tt.desc)
 	}
 }
 
@@ -84,6 +84,7 @@ func chownAll(config *configuration, usr *user.User, grp *user.Group) error {
 	paths := []string{
 		config.SocketDir,
 		config.ControlLogFile,
+		config.ScmEventLog,
 	}
 
 	for _, srv := range config.Servers {
@@ -12,11 +12,13 @@ func _() {
 	_ = x[scmStateNoRegions-1]
 	_ = x[scmStateFreeCapacity-2]
 	_ = x[scmStateNoCapacity-3]
+	_ = x[scmStatePartialCapacity-4]
+	_ = x[scmStatePartialRegions-5]
 }
 
-const _scmState_name = "scmStateUnknownscmStateNoRegionsscmStateFreeCapacityscmStateNoCapacity"
+const _scmState_name = "scmStateUnknownscmStateNoRegionsscmStateFreeCapacityscmStateNoCapacityscmStatePartialCapacityscmStatePartialRegions"
 
-var _scmState_index = [...]uint8{0, 15, 32, 52, 70}
+var _scmState_index = [...]uint8{0, 15, 32, 52, 70, 93, 115}
 
 func (i scmState) String() string {
 	if i < 0 || i >= scmState(len(_scmState_index)-1) {
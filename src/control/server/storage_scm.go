@@ -24,16 +24,28 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/daos-stack/daos/src/control/common"
 	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
+	"github.com/daos-stack/daos/src/control/faults"
 	"github.com/daos-stack/daos/src/control/log"
 	"github.com/daos-stack/go-ipmctl/ipmctl"
 )
@@ -44,65 +56,389 @@ type scmState int
 const (
 	scmStateUnknown scmState = iota
 	scmStateNoRegions
-	scmStateFreeCapacity
-	scmStateNoCapacity
+	scmStateFreeCapacity    // all regions have free capacity
+	scmStateNoCapacity      // no regions have free capacity
+	scmStatePartialCapacity // some but not all regions have free capacity
+	scmStatePartialRegions  // AppDirect regions on some but not all sockets
 
-	cmdScmShowRegions     = "ipmctl show -d PersistentMemoryType,FreeCapacity -region"
+	cmdScmShowRegions     = "ipmctl show -d PersistentMemoryType,Capacity,FreeCapacity,HealthState,SocketID,DimmID -region"
 	outScmNoRegions       = "\nThere are no Regions defined in the system."
-	cmdScmCreateRegions   = "ipmctl create -f -goal PersistentMemoryType=AppDirect"
+	cmdScmCreateRegions   = "ipmctl create -f -goal PersistentMemoryType=" + scmMemTypeAppDirect
+	cmdScmCreateRegionsNI = "ipmctl create -f -goal PersistentMemoryType=" + scmMemTypeAppDirectNI
+	cmdScmShowGoal        = "ipmctl show -goal"
+	outScmNoGoal          = "\nThere are no goal configs defined in the system."
+	scmGoalFlagPath       = "/var/tmp/daos_scm_goal_pending" // persists over reboot
+	cmdScmShowSensors     = "ipmctl show -sensor MediaTemperature,ControllerTemperature,PowerOnTime"
+	cmdScmShowErrorLog    = "ipmctl show -error %s -dimm"
+	cmdScmShowHealth      = "ipmctl show -d HealthState,PercentageRemaining,Temperature -dimm"
 	cmdScmCreateNamespace = "ndctl create-namespace" // returns json ns info
 	cmdScmListNamespaces  = "ndctl list -N"          // returns json ns info
+	cmdScmListNdRegions   = "ndctl list -R"          // returns json region info
+	cmdScmListNdLayout    = "ndctl list -R -N"       // regions with nested ns
+	cmdScmListSignatures  = "wipefs -n -i -O TYPE"   // returns signature types
+	cmdScmLoadFirmware    = "ipmctl load -source %s -dimm %d"
+	outScmFwReboot        = "reboot is required" // activation pending
 
-	msgScmRebootRequired   = "A reboot is required to process new memory allocation goals."
-	msgScmNoModules        = "no scm modules to prepare"
-	msgScmPrepared         = "scm has been prepared"
-	msgScmNotInited        = "scm storage could not be accessed"
-	msgScmAlreadyFormatted = "scm storage has already been formatted and " +
-		"reformat not implemented"
-	msgScmMountEmpty = "scm mount must be specified in config"
-	msgScmBadDevList = "expecting one scm dcpm pmem device " +
+	msgScmRebootRequired = "A reboot is required to process new memory allocation goals."
+	msgScmNoModules      = "no scm modules to prepare"
+	msgScmPrepared       = "scm has been prepared"
+	msgScmNotInited      = "scm storage could not be accessed"
+	msgScmMountEmpty     = "scm mount must be specified in config"
+	msgScmBadDevList     = "expecting one scm dcpm pmem device " +
 		"per-server in config"
 	msgScmDevEmpty          = "scm dcpm device list must contain path"
 	msgScmClassNotSupported = "operation unsupported on scm class"
 	msgIpmctlDiscoverFail   = "ipmctl module discovery"
 	msgScmUpdateNotImpl     = "scm firmware update not supported"
+	msgScmResetNotImpl      = "scm prep reset not supported"
+	msgScmBadNamespaceName  = "invalid pmem namespace name"
+	msgScmUnknownStableID   = "no pmem namespace with stable identity"
+	msgScmBadNdctlFlag      = "invalid ndctl create-namespace flag"
+	msgScmBadMkfsOpts       = "invalid scm mkfs options"
+	msgNdctlUnknownSchema   = "unrecognised ndctl namespace output"
+	msgNdctlNoJSON          = "no json in ndctl output"
+	msgScmRegionsTimeout    = "timed out waiting for scm regions"
+	msgScmBadServerIdx      = "server index %d out of range (%d servers configured)"
+	msgScmMountListMismatch = "scm_mount_list has %d entries, expecting one for each of %d scm devices"
+	msgScmMountListSingle   = "scm_mount_list only applies to multiple dcpm devices, use scm_mount"
+	msgScmFwImageNotFound   = "scm firmware image not found"
+	msgScmNoModulesUpdate   = "no scm modules to update"
+	msgScmFwRebootRequired  = "A reboot is required to activate the new scm firmware."
+
+	msgScmEffectiveMountOpts = "effective mount options: "
+	msgScmMountOptsDropped   = "requested options not in effect: "
+	msgScmFormatUnchanged    = "scm format parameters unchanged, not reformatted"
+
+	// pmem namespace names are limited to the size of the label name field
+	maxPmemNameLen = 63
+
+	// scm_list entries with this prefix give the stable identity of a pmem
+	// namespace rather than its block device path
+	pmemStableIDPrefix = "uuid:"
+
+	// sizes of pmem namespaces created with a capacity reservation are
+	// rounded down to a multiple of this to satisfy region alignment
+	pmemNamespaceAlign = 1 << 30
+
+	// ext4 blocks in the 4KiB interleave granularity of dcpm AppDirect
+	// regions
+	scmInterleaveBlocks = 1
+
+	// mkfs.ext4 extended options controlling discard of device blocks
+	scmDiscard   = "discard"
+	scmNoDiscard = "nodiscard"
+
+	// filesystems supported on dcpm devices, ext4 if unset in config
+	scmFsExt4 = "ext4"
+	scmFsXfs  = "xfs"
+
+	// mode of scm mount point when owned by a non-root user
+	scmMountMode os.FileMode = 0750
+
+	// suffix appended to a firmware image path to locate metadata
+	// describing the targeted module family and model
+	fwImageMetaSuffix = ".meta"
+
+	// default bound on the duration of an external command, generous enough
+	// for namespace creation on large regions
+	defaultScmCmdTimeout = 5 * time.Minute
+
+	// default bound on namespace creation attempts and delay before the
+	// first retry, doubled before each subsequent retry
+	defaultScmNsAttempts   = 3
+	defaultScmNsRetryDelay = time.Second
+
+	// marker file at the root of an scm mount recording format parameters
+	scmFormatRecordFile = ".daos_scm_format"
+
+	// memory required in addition to the size of a ram class tmpfs, for
+	// kernel allocations needed to manage it
+	tmpfsMemOverhead = 512 << 20
+
+	// scm_size of a ram class server requesting the tmpfs default size, a
+	// proportion of system memory
+	scmSizeTmpfsDefault = -1
+
+	// interval at which scm state is polled when waiting for regions
+	scmRegionsPollInterval = 5 * time.Second
 )
 
+// pmemNameRegexp restricts pmem namespace names to characters that are safe
+// to pass unquoted on the ndctl command line.
+var pmemNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// ndctlFlagRegexp restricts extra ndctl create-namespace flags to a single
+// long or short option with an optional value, so they can be safely passed
+// unquoted on the ndctl command line.
+var ndctlFlagRegexp = regexp.MustCompile(`^--?[a-zA-Z][a-zA-Z0-9-]*(=[a-zA-Z0-9_.:-]+)?$`)
+
+// mkfsOptsRegexp restricts extra mkfs options to characters without special
+// meaning to the shell, so they can be safely appended unquoted to the mkfs
+// command line.
+var mkfsOptsRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.,:=/ -]*$`)
+
+// pmemDevRegexp matches fsdax pmem namespace block device paths, excluding
+// raw module (/dev/nmemN) and devdax namespace (/dev/daxN.M) devices.
+var pmemDevRegexp = regexp.MustCompile(`^/dev/pmem[0-9]+(\.[0-9]+)?$`)
+
+// pmemOwnerRegexp matches names given to pmem namespaces created for an
+// io_server, capturing the index of the owning io_server.
+var pmemOwnerRegexp = regexp.MustCompile(`^daos_io_server_([0-9]+)$`)
+
 type pmemDev struct {
 	UUID     string
 	Blockdev string
+	Name     string
 	NumaNode int `json:"numa_node"`
+	// size in bytes, zero if unavailable e.g. when listed in human
+	// readable units
+	Size uint64 `json:"-"`
+	// index of the owning io_server derived from the namespace name,
+	// -1 if the namespace was not created for an io_server
+	SrvIdx int `json:"server_idx"`
 }
 
 func (pd *pmemDev) String() string {
-	return fmt.Sprintf("%s, numa %d", pd.Blockdev, pd.NumaNode)
+	if pd.SrvIdx < 0 {
+		return fmt.Sprintf("%s (%s), numa %d", pd.Blockdev, pd.Name,
+			pd.NumaNode)
+	}
+
+	return fmt.Sprintf("%s (%s), numa %d, server %d", pd.Blockdev, pd.Name,
+		pd.NumaNode, pd.SrvIdx)
+}
+
+// checkNdctlFlag verifies an extra create-namespace flag can be safely passed
+// to ndctl.
+func checkNdctlFlag(flag string) error {
+	if !ndctlFlagRegexp.MatchString(flag) {
+		return errors.Errorf("%s: %q", msgScmBadNdctlFlag, flag)
+	}
+
+	return nil
+}
+
+// checkMkfsOpts verifies extra mkfs options can be safely passed to mkfs.
+func checkMkfsOpts(opts string) error {
+	if !mkfsOptsRegexp.MatchString(opts) {
+		return errors.Errorf("%s: %q", msgScmBadMkfsOpts, opts)
+	}
+
+	return nil
+}
+
+// stableID returns an identity for the namespace that persists across
+// reboots, unlike the block device name which may be renumbered.
+func (pd *pmemDev) stableID() string {
+	return pmemStableIDPrefix + pd.UUID
+}
+
+// normalizeScmDev returns the canonical form of an scm_list entry so that
+// entries naming the same device compare equal, the uuid of a stable identity
+// is lower cased (as reported by ndctl) and a device path is cleaned.
+func normalizeScmDev(dev string) string {
+	switch {
+	case dev == "":
+		return dev
+	case strings.HasPrefix(dev, pmemStableIDPrefix):
+		return pmemStableIDPrefix +
+			strings.ToLower(strings.TrimPrefix(dev, pmemStableIDPrefix))
+	default:
+		return filepath.Clean(dev)
+	}
+}
+
+// pmemName returns the deterministic name given to the pmem namespace
+// created for the io_server with the given index.
+func pmemName(srvIdx int) string {
+	return fmt.Sprintf("daos_io_server_%d", srvIdx)
+}
+
+// pmemOwner returns the index of the io_server the pmem namespace with the
+// given name was created for, -1 if not created for an io_server.
+func pmemOwner(name string) int {
+	matches := pmemOwnerRegexp.FindStringSubmatch(name)
+	if matches == nil {
+		return -1
+	}
+
+	srvIdx, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return -1
+	}
+
+	return srvIdx
+}
+
+// checkPmemName verifies the namespace name can be safely passed to ndctl.
+func checkPmemName(name string) error {
+	if len(name) > maxPmemNameLen || !pmemNameRegexp.MatchString(name) {
+		return errors.Errorf("%s: %q", msgScmBadNamespaceName, name)
+	}
+
+	return nil
 }
 
 type runCmdFn func(string) (string, error)
 
+// runCmdCtxFn is a runCmdFn variant that kills the command when ctx is done.
+type runCmdCtxFn func(ctx context.Context, cmd string) (string, error)
+
+// formatPhase identifies a stage of scm device format.
+type formatPhase string
+
+const (
+	formatPhaseWipeStart formatPhase = "wipefs started"
+	formatPhaseWipeDone  formatPhase = "wipefs completed"
+	formatPhaseMkfsStart formatPhase = "mkfs started"
+	formatPhaseMkfsDone  formatPhase = "mkfs completed"
+	formatPhaseFailed    formatPhase = "format failed"
+)
+
+// nsProgressFn is called as each pmem namespace is created with the number
+// created so far, so that callers can report progress of long provisioning.
+type nsProgressFn func(created int, dev pmemDev)
+
+// confirmFn is called before a destructive operation, returning whether the
+// user accepted or an error if confirmation could not be obtained.
+type confirmFn func(prompt string) (bool, error)
+
+// promptConfirm returns a confirmFn that writes the prompt to w and reads a
+// yes or no answer from r, failing if no answer can be read, e.g. because
+// input has been closed.
+func promptConfirm(r io.Reader, w io.Writer) confirmFn {
+	reader := bufio.NewReader(r)
+
+	return func(prompt string) (bool, error) {
+		fmt.Fprintf(w, "%s (yes/no)\n", prompt)
+
+		for {
+			line, err := reader.ReadString('\n')
+			switch strings.TrimSpace(line) {
+			case "yes":
+				return true, nil
+			case "no":
+				return false, nil
+			}
+			if err != nil {
+				return false, errors.Wrap(err, "reading response")
+			}
+			fmt.Fprintln(w, "Please type yes or no and then press enter:")
+		}
+	}
+}
+
+// createRegionsFn creates AppDirect regions and reports whether a reboot
+// is required for them to take effect.
+type createRegionsFn func(ctx context.Context) (needsReboot bool, err error)
+
+// secretArgRegexp matches command arguments carrying secrets, either flag
+// style (--passphrase x, --password=x) or ipmctl property style
+// (Passphrase=x, NewPassphrase=x), capturing everything but the secret.
+var secretArgRegexp = regexp.MustCompile(
+	`(?i)(--?(?:passphrase|password|passwd|secret|key)(?:=|\s+)|` +
+		`\b(?:new|confirm)?passphrase=)\S+`)
+
+const redacted = "<redacted>"
+
+// scrubSecrets redacts the values of secret arguments in a command line or
+// output so that they don't end up in logs or errors returned to clients.
+func scrubSecrets(text string) string {
+	return secretArgRegexp.ReplaceAllString(text, "${1}"+redacted)
+}
+
+// newRunCmdError returns an error for a failed external command, any secrets
+// in the command line are scrubbed.
+func newRunCmdError(cmd string, wrapped error, stdout string) *runCmdError {
+	return &runCmdError{
+		cmd:     scrubSecrets(cmd),
+		wrapped: wrapped,
+		stdout:  stdout,
+	}
+}
+
 type runCmdError struct {
+	cmd     string
 	wrapped error
 	stdout  string
 }
 
 func (rce *runCmdError) Error() string {
 	if ee, ok := rce.wrapped.(*exec.ExitError); ok {
-		return fmt.Sprintf("%s: stdout: %s; stderr: %s", ee.ProcessState,
-			rce.stdout, ee.Stderr)
+		return scrubSecrets(fmt.Sprintf("%s: stdout: %s; stderr: %s",
+			ee.ProcessState, rce.stdout, ee.Stderr))
+	}
+	if rce.stdout == "" {
+		return scrubSecrets(rce.wrapped.Error())
+	}
+	return scrubSecrets(
+		fmt.Sprintf("%s: stdout: %s", rce.wrapped.Error(), rce.stdout))
+}
+
+// cmdFailureMsg returns the message for err to be reported in results,
+// appending the failing command if known so that operators can reproduce
+// the failure.
+//
+// Only the command line is added, command output is already included in
+// the error message where available.
+func cmdFailureMsg(err error) string {
+	rce, ok := errors.Cause(err).(*runCmdError)
+	if !ok || rce.cmd == "" {
+		return err.Error()
 	}
-	return fmt.Sprintf("%s: stdout: %s", rce.wrapped.Error(), rce.stdout)
+
+	return fmt.Sprintf("%s (failed command: %s)", err.Error(), rce.cmd)
 }
 
-// run wraps exec.Command().Output() to enable mocking of command output.
+// run wraps runCtx without a deadline to enable mocking of command output.
 func run(cmd string) (string, error) {
-	out, err := exec.Command("bash", "-c", cmd).Output()
+	return runCtx(context.Background(), cmd)
+}
+
+// shellQuote quotes arg so that it is passed as a single literal word in a
+// command line run by runCtx.
+func shellQuote(arg string) string {
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// runCtx wraps exec.CommandContext() to enable mocking of command output.
+//
+// The shell is run in its own process group which is killed when ctx is done
+// so that commands started by the shell don't keep the output pipe open.
+func runCtx(ctx context.Context, cmd string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	c := exec.CommandContext(ctx, "bash", "-c", cmd)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := c.Start(); err != nil {
+		return "", newRunCmdError(cmd, err, "")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func(pgid int) {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		case <-done:
+		}
+	}(c.Process.Pid)
+
+	err := c.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.Wrap(ctx.Err(), "killed by deadline")
+	}
 	if err != nil {
-		return "", &runCmdError{
-			wrapped: err,
-			stdout:  string(out),
+		if ee, ok := err.(*exec.ExitError); ok {
+			ee.Stderr = stderr.Bytes()
 		}
+		return "", newRunCmdError(cmd, err, stdout.String())
 	}
-	return string(out), nil
+
+	return stdout.String(), nil
 }
 
 // scmStorage gives access to underlying storage interface implementation
@@ -113,461 +449,3867 @@ func run(cmd string) (string, error) {
 // Memory modules through libipmctl via go-ipmctl bindings.
 type scmStorage struct {
 	ipmctl      ipmctl.IpmCtl  // ipmctl NVM API interface
+	ndctl       ndctlOps       // ndctl command line tool if unset
 	config      *configuration // server configuration structure
 	runCmd      runCmdFn
+	runCmdCtx   runCmdCtxFn     // overrides runCmd if set
+	cmdTimeout  time.Duration   // defaultScmCmdTimeout if unset
+	regionsFn   createRegionsFn // overrides createRegions if set
+	nsProgress  nsProgressFn
+	confirmFn   confirmFn  // no confirmation requested if unset
+	confirmMu   sync.Mutex // serialises confirmation of concurrent formats
 	modules     common.ScmModules
 	pmemDevs    []pmemDev
+	regions     []scmRegion
 	state       scmState
 	initialized bool
 	formatted   bool
+	metrics     scmMetrics
+	events      scmEvents
+	cmdTrail    scmCmdTrail
+	nsWorkers   int           // max concurrent namespace creations, serial if < 2
+	nsAttempts  int           // defaultScmNsAttempts if unset
+	nsRetry     time.Duration // defaultScmNsRetryDelay if unset
+	logger      scmLogger
+	regionCache scmRegionCache
+	timings     scmTimings
+	dryRun      bool // plan commands of Prep and Format without running
+	plan        []string
+}
+
+// regionSnapshot is the result of a single query of pmem regions.
+type regionSnapshot struct {
+	regions   []scmRegion
+	noRegions bool // no regions are defined in the system
+}
+
+// scmRegionCache holds the most recent region snapshot from which state is
+// established, so that repeated state queries do not each run ipmctl. The
+// snapshot is reused until invalidated and, if ttl is set, for at most ttl.
+type scmRegionCache struct {
+	ttl      time.Duration
+	snapshot regionSnapshot
+	at       time.Time // zero if no snapshot is cached
+}
+
+func (c *scmRegionCache) get() (regionSnapshot, bool) {
+	if c.at.IsZero() || (c.ttl > 0 && time.Since(c.at) >= c.ttl) {
+		return regionSnapshot{}, false
+	}
+
+	return c.snapshot, true
+}
+
+func (c *scmRegionCache) set(snapshot regionSnapshot) {
+	c.snapshot, c.at = snapshot, time.Now()
+}
+
+func (c *scmRegionCache) invalidate() {
+	c.snapshot, c.at = regionSnapshot{}, time.Time{}
+}
+
+// checkMaintenance returns a fault if a mutating operation op is requested
+// while in maintenance mode (scm_maintenance_mode in config), in which
+// mutating operations (Prep, Format, reFormat and Update) are refused and
+// only read-only operations such as Discover are allowed.
+func (s *scmStorage) checkMaintenance(op string) error {
+	if s.config.ScmMaintenance {
+		return FaultScmMaintenanceMode(op)
+	}
+
+	return nil
 }
 
 func (s *scmStorage) withRunCmd(runCmd runCmdFn) *scmStorage {
 	s.runCmd = runCmd
+	s.runCmdCtx = nil
 
 	return s
 }
 
-// TODO: implement remaining methods for scmStorage
-// func (s *scmStorage) Update(req interface{}) interface{} {return nil}
-// func (s *scmStorage) BurnIn(req interface{}) (fioPath string, cmds []string, env string, err error) {
-// return
-// }
+// withRunCmdCtx sets a command runner that kills commands exceeding the
+// command timeout.
+func (s *scmStorage) withRunCmdCtx(runCmd runCmdCtxFn) *scmStorage {
+	s.runCmdCtx = runCmd
 
-// Prep executes commands to configure SCM modules into AppDirect interleaved
-// regions/sets hosting pmem kernel device namespaces.
-//
-// Presents of nonvolatile memory modules is assumed in this method and state
-// is established based on presence and free capacity of regions.
+	return s
+}
+
+// withCmdTimeout bounds the duration of each external command.
+func (s *scmStorage) withCmdTimeout(timeout time.Duration) *scmStorage {
+	s.cmdTimeout = timeout
+
+	return s
+}
+
+// withCreateRegions overrides region creation, decoupling the reboot-required
+// outcome of Prep from ipmctl command output.
+func (s *scmStorage) withCreateRegions(fn createRegionsFn) *scmStorage {
+	s.regionsFn = fn
+
+	return s
+}
+
+// withNamespaceProgress registers a callback to be notified of each pmem
+// namespace created by Prep.
 //
-// Actions based on state:
-// * modules exist and no regions -> create all regions (needs reboot)
-// * regions exist and free capacity -> create all namespaces
-// * regions exist but no free capacity -> no-op
+// Namespace creation is silent if no callback is registered.
+func (s *scmStorage) withNamespaceProgress(fn nsProgressFn) *scmStorage {
+	s.nsProgress = fn
+
+	return s
+}
+
+// withConfirm registers a callback to confirm destructive operations before
+// they are performed.
 //
-// Command output from external tools will be returned.
-func (s *scmStorage) Prep() (needsReboot bool, pmemDevs []pmemDev, err error) {
-	if err := s.getState(); err != nil {
-		return false, nil, errors.WithMessage(err, "establish scm state")
+// Format proceeds without confirmation if no callback is registered.
+func (s *scmStorage) withConfirm(fn confirmFn) *scmStorage {
+	s.confirmFn = fn
+
+	return s
+}
+
+// confirm requests confirmation of a destructive operation on target,
+// distinguishing a decline from failure to obtain confirmation.
+func (s *scmStorage) confirm(target, prompt string) error {
+	if s.confirmFn == nil {
+		return nil
 	}
 
-	log.Debugf("scm in state %s\n", s.state)
+	s.confirmMu.Lock()
+	ok, err := s.confirmFn(prompt)
+	s.confirmMu.Unlock()
 
-	switch s.state {
-	case scmStateNoRegions:
-		needsReboot, err = s.createRegions()
-	case scmStateFreeCapacity:
-		pmemDevs, err = s.createNamespaces()
-	case scmStateNoCapacity:
-		pmemDevs, err = s.getNamespaces()
-	default:
-		err = errors.New("unknown scm state")
+	switch {
+	case err != nil:
+		return FaultScmConfirmUnavailable(target, err)
+	case !ok:
+		return FaultScmFormatCancelled(target)
 	}
 
-	return
+	return nil
 }
 
-// reset executes commands to remove namespaces and regions on SCM models.
-func (s *scmStorage) PrepReset() error {
-	return nil // TODO
+// withNdctl overrides the backend used for pmem namespace operations.
+func (s *scmStorage) withNdctl(ops ndctlOps) *scmStorage {
+	s.ndctl = ops
+
+	return s
 }
 
-// getState establishes state of SCM regions and namespaces on local server.
-func (s *scmStorage) getState() error {
-	s.state = scmStateUnknown
+// ndctlOps returns the backend for pmem namespace operations, defaulting to
+// the ndctl command line tool.
+func (s *scmStorage) ndctlOps(ctx context.Context) ndctlOps {
+	if s.ndctl == nil {
+		return &cliNdctl{runCmd: func(cmd string) (string, error) {
+			return s.execCmdCtx(ctx, cmd)
+		}}
+	}
 
-	// TODO: discovery should provide SCM region details
-	out, err := s.runCmd(cmdScmShowRegions)
-	if err != nil {
-		return err
+	return s.ndctl
+}
+
+// ipmctlOps returns the backend for SCM module queries, the ipmctl bindings
+// if they provide all queries and otherwise the ipmctl command line tool.
+func (s *scmStorage) ipmctlOps(ctx context.Context) ipmctlOps {
+	if ops, ok := s.ipmctl.(ipmctlOps); ok {
+		return ops
 	}
 
-	if out == outScmNoRegions {
-		s.state = scmStateNoRegions
-		return nil
+	return &cliIpmctl{IpmCtl: s.ipmctl, runCmd: func(cmd string) (string, error) {
+		return s.execCmdCtx(ctx, cmd)
+	}}
+}
+
+// withNamespaceWorkers enables concurrent namespace creation across regions
+// with at most the given number of regions processed at a time.
+func (s *scmStorage) withNamespaceWorkers(workers int) *scmStorage {
+	s.nsWorkers = workers
+
+	return s
+}
+
+// withNamespaceRetry bounds the attempts made to create each namespace and
+// the delay before the first retry, which is doubled on each further retry.
+func (s *scmStorage) withNamespaceRetry(attempts int, delay time.Duration) *scmStorage {
+	s.nsAttempts = attempts
+	s.nsRetry = delay
+
+	return s
+}
+
+// withRegionCache limits reuse of the region listing when establishing state
+// to within ttl of the previous query, so that changes made outside of the
+// control plane are noticed. Without a ttl, the listing is reused until the
+// cache is invalidated by region and namespace creation or by RefreshState.
+func (s *scmStorage) withRegionCache(ttl time.Duration) *scmStorage {
+	s.regionCache = scmRegionCache{ttl: ttl}
+
+	return s
+}
+
+// withEventStream registers a writer to which newline-delimited JSON events
+// are written as Prep and Format progress.
+//
+// No events are emitted if no writer is registered.
+func (s *scmStorage) withEventStream(w io.Writer) *scmStorage {
+	s.events.w = w
+
+	return s
+}
+
+// timeStep starts timing a step of op, returning a function to be called
+// when the step completes that records the elapsed time.
+func (s *scmStorage) timeStep(op, step, device string) func() {
+	start := time.Now()
+
+	return func() {
+		elapsed := time.Since(start)
+		s.timings.add(scmStepTiming{
+			Op: op, Step: step, Device: device, Elapsed: elapsed,
+		})
+		log.Debugf("scm %s %s %s took %s", op, step, device, elapsed)
 	}
+}
+
+// StepTimings returns the elapsed time of each step performed, in order of
+// completion.
+func (s *scmStorage) StepTimings() []scmStepTiming {
+	return s.timings.list()
+}
+
+// reportProgress logs each phase of a device format and emits it as an event
+// so that it can be seen that a long format has not hung.
+func (s *scmStorage) reportProgress(devPath string, phase formatPhase) {
+	s.infof("scm format of %s: %s\n", devPath, phase)
+	s.events.emit(scmEvent{
+		Op: scmOpFormat, Type: scmEventFormatPhase,
+		Device: devPath, Message: string(phase),
+	})
+}
+
+// execCmd runs the given external command, recording it in the diagnostic
+// command trail and any failure in metrics.
+func (s *scmStorage) execCmd(cmd string) (string, error) {
+	return s.execCmdCtx(context.Background(), cmd)
+}
+
+// execCmdCtx runs the given external command as execCmd, aborting it when
+// ctx is done or the command timeout expires.
+func (s *scmStorage) execCmdCtx(ctx context.Context, cmd string) (out string, err error) {
+	s.cmdTrail.add(scrubSecrets(cmd))
 
-	ok, err := hasFreeCapacity(out)
+	timeout := s.cmdTimeout
+	if timeout <= 0 {
+		timeout = defaultScmCmdTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case s.runCmdCtx != nil:
+		out, err = s.runCmdCtx(ctx, cmd)
+	case ctx.Err() != nil:
+		err = newRunCmdError(cmd, ctx.Err(), "")
+	default:
+		out, err = s.runCmd(cmd)
+	}
 	if err != nil {
-		return err
+		s.metrics.addCmdFailure(cmd)
 	}
-	if ok {
-		s.state = scmStateFreeCapacity
-		return nil
+
+	return out, err
+}
+
+// WriteMetrics renders metrics collected during scm operations in
+// Prometheus text exposition format.
+func (s *scmStorage) WriteMetrics(w io.Writer) error {
+	return s.metrics.writePrometheus(w)
+}
+
+// ScmSocketRegion describes the AppDirect region capacity on a socket.
+type ScmSocketRegion struct {
+	SocketID     int
+	RegionIDs    []string // interleave set ids of regions on the socket
+	Capacity     uint64   // bytes
+	FreeCapacity uint64   // bytes
+}
+
+// ScmPrepResult is the outcome of Prep, allowing provisioning to be validated
+// against the capacity of regions on each socket.
+type ScmPrepResult struct {
+	NeedsReboot bool
+	Regions     []ScmSocketRegion // in ascending socket order
+	Namespaces  []pmemDev         // created or already existing
+}
+
+// socketRegions aggregates the capacity of AppDirect regions per socket.
+func socketRegions(regions []scmRegion) (sockets []ScmSocketRegion) {
+	idx := make(map[int]int) // socket id to index in sockets
+	for _, region := range regions {
+		if !region.isAppDirect() {
+			continue
+		}
+
+		i, exists := idx[region.socketID]
+		if !exists {
+			i = len(sockets)
+			idx[region.socketID] = i
+			sockets = append(sockets,
+				ScmSocketRegion{SocketID: region.socketID})
+		}
+		sockets[i].RegionIDs = append(sockets[i].RegionIDs, region.iSetID)
+		sockets[i].Capacity += scmSizeFromGiB(region.capacity).Bytes
+		sockets[i].FreeCapacity += region.freeBytes()
 	}
-	s.state = scmStateNoCapacity
+	sort.Slice(sockets, func(i, j int) bool {
+		return sockets[i].SocketID < sockets[j].SocketID
+	})
 
-	return nil
+	return
 }
 
-// hasFreeCapacity takes output from ipmctl and checks for free capacity.
+// Prep executes commands to configure SCM modules into AppDirect regions/sets,
+// interleaved unless disabled in config, hosting pmem kernel device namespaces.
 //
-// external tool commands return:
-// $ ipmctl show -d PersistentMemoryType,FreeCapacity -region
+// Presents of nonvolatile memory modules is assumed in this method and state
+// is established based on presence and free capacity of regions.
 //
-// ---ISetID=0x2aba7f4828ef2ccc---
-//    PersistentMemoryType=AppDirect
-//    FreeCapacity=3012.0 GiB
-// ---ISetID=0x81187f4881f02ccc---
-//    PersistentMemoryType=AppDirect
-//    FreeCapacity=3012.0 GiB
+// Actions based on state:
+// * modules exist and no regions -> create all regions (needs reboot)
+// * regions exist and free capacity -> create all namespaces
+// * some regions have free capacity -> create remaining namespaces
+// * degraded region with free capacity -> refuse to create namespaces
+// * regions exist but no free capacity -> no-op
 //
-// FIXME: implementation to be replaced by using libipmctl directly through bindings
-func hasFreeCapacity(text string) (hasCapacity bool, err error) {
-	lines := strings.Split(text, "\n")
-	if len(lines) < 4 {
-		return false, errors.Errorf("expecting at least 4 lines, got %d",
-			len(lines))
+// Prep can be aborted by cancelling ctx, in which case any pmem devices created
+// before cancellation are returned along with the cancellation error.
+//
+// On success the result reports the capacity of regions on each socket once
+// any namespaces have been created.
+func (s *scmStorage) Prep(ctx context.Context) (result ScmPrepResult, err error) {
+	defer func() {
+		switch {
+		case err != nil:
+			s.events.emit(scmEvent{
+				Op: scmOpPrep, Type: scmEventError, Message: err.Error(),
+			})
+			return
+		case result.NeedsReboot:
+			s.events.emit(scmEvent{
+				Op: scmOpPrep, Type: scmEventRebootRequired,
+			})
+		}
+		result.Regions = socketRegions(s.regions)
+	}()
+
+	if err := s.checkMaintenance("prep"); err != nil {
+		return result, err
 	}
+	s.plan = nil
 
-	for _, line := range lines {
-		entry := strings.TrimSpace(line)
+	if err := s.getState(ctx); err != nil {
+		return result, errors.WithMessage(
+			scmStateError{err}, "establish scm state")
+	}
 
-		kv := strings.Split(entry, "=")
-		if len(kv) != 2 {
-			continue
-		}
+	s.infof("scm in state %s\n", s.state)
+	s.events.emit(scmEvent{
+		Op: scmOpPrep, Type: scmEventState, State: s.state.String(),
+	})
 
-		if kv[0] == "PersistentMemoryType" && kv[1] == "AppDirect" {
-			hasCapacity = true
-			continue
+	if s.state != scmStateNoRegions && s.state != scmStateUnknown {
+		if !s.dryRun {
+			s.clearStaleGoal()
+		}
+		if err := s.CheckRegionModules(); err != nil {
+			s.warnf("%s (%s)\n", err, faults.ShowResolutionFor(err))
 		}
+	}
 
-		if kv[0] != "FreeCapacity" {
-			continue
+	switch s.state {
+	case scmStateNoRegions:
+		if s.config.ScmNoAutoRegion {
+			err = FaultScmNoRegions()
+			break
 		}
 
-		if hasCapacity && kv[1] != "0.0 GiB" {
+		pending, rebooted, goalErr := s.goalRebootStatus()
+		switch {
+		case goalErr != nil:
+			s.warnf("%s\n", goalErr)
+		case pending && !rebooted:
+			s.infof("scm goal created, reboot still pending\n")
+			result.NeedsReboot = true
 			return
+		case pending:
+			s.warnf("scm goal not applied after reboot, recreating\n")
+		}
+
+		if err := s.checkModuleCapacities(); err != nil {
+			s.warnf("%s (%s)\n", err, faults.ShowResolutionFor(err))
+		}
+		if err := s.CheckModuleCount(); err != nil {
+			s.warnf("%s (%s)\n", err, faults.ShowResolutionFor(err))
+		}
+
+		createRegions := s.createRegions
+		if s.regionsFn != nil {
+			createRegions = s.regionsFn
+		}
+		result.NeedsReboot, err = createRegions(ctx)
+	case scmStateFreeCapacity, scmStatePartialCapacity:
+		if err = s.checkRegionsHealthy(); err != nil {
+			break
 		}
+		result.Namespaces, err = s.createNamespaces(ctx)
+	case scmStateNoCapacity:
+		result.Namespaces, err = s.getNamespaces(ctx)
+	case scmStatePartialRegions:
+		err = FaultScmPartialRegions(regionSockets(s.regions))
+	default:
+		err = errors.New("unknown scm state")
+	}
+
+	return
+}
+
+// scmStateError indicates failure to establish scm state, e.g. because a
+// region is briefly not visible after reboot, and is recoverable by retrying.
+type scmStateError struct{ error }
+
+func (e scmStateError) Cause() error { return e.error }
+
+// isRecoverable indicates whether a Prep failure may succeed on retry.
+func isRecoverable(err error) bool {
+	for err != nil {
+		if _, ok := err.(scmStateError); ok {
+			return true
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = causer.Cause()
+	}
+
+	return false
+}
+
+// PrepWithRetry runs Prep up to the given number of attempts, waiting delay
+// between attempts, while it fails with a recoverable error. Each attempt
+// re-establishes scm state and performs the appropriate action.
+//
+// Non-recoverable errors and cancellation of ctx fail immediately, the result
+// of the final attempt is returned.
+func (s *scmStorage) PrepWithRetry(
+	ctx context.Context, attempts int, delay time.Duration,
+) (result ScmPrepResult, err error) {
+
+	for attempt := 1; ; attempt++ {
+		result, err = s.Prep(ctx)
+		if err == nil || !isRecoverable(err) || attempt >= attempts {
+			return
+		}
+
+		s.warnf("scm prep attempt %d of %d failed, retrying: %s",
+			attempt, attempts, err)
+
+		select {
+		case <-ctx.Done():
+			return result, errors.WithMessage(ctx.Err(),
+				"scm prep retry aborted")
+		case <-time.After(delay):
+		}
+	}
+}
+
+// WaitForRegions polls scm state at the given interval until regions are
+// available, e.g. after the reboot following region creation, or the timeout
+// elapses. The final state is returned, with an error if regions did not
+// become available in time or ctx was cancelled.
+//
+// Failures to establish state are retried as regions may briefly be
+// unavailable after reboot, the last failure is reported on timeout.
+func (s *scmStorage) WaitForRegions(
+	ctx context.Context, timeout, interval time.Duration,
+) (scmState, error) {
+
+	expired := time.After(timeout)
+	for {
+		err := s.refreshState(ctx)
+		switch {
+		case err != nil:
+			s.warnf("establish scm state: %s\n", err)
+		case s.state != scmStateNoRegions:
+			return s.state, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return s.state, errors.WithMessage(ctx.Err(),
+				"scm regions wait aborted")
+		case <-expired:
+			if err != nil {
+				return s.state, errors.WithMessage(err,
+					msgScmRegionsTimeout)
+			}
+			return s.state, errors.New(msgScmRegionsTimeout)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// PrepReset is intended to remove namespaces and regions on SCM modules.
+//
+// TODO: implement reset, until then an error is returned so that callers do
+// not treat the reset as having been performed.
+func (s *scmStorage) PrepReset() error {
+	return errors.New(msgScmResetNotImpl)
+}
+
+// resetRegion describes a pmem region that PrepReset would remove.
+type resetRegion struct {
+	ISetID   string
+	MemType  string
+	SocketID int
+	Capacity scmSize
+}
+
+// resetPreview lists the pmem namespaces and regions PrepReset would destroy.
+type resetPreview struct {
+	Namespaces []pmemDev
+	Regions    []resetRegion
+}
+
+// PrepResetPreview returns the namespaces and regions that PrepReset would
+// destroy, without destroying anything.
+func (s *scmStorage) PrepResetPreview() (*resetPreview, error) {
+	if err := s.getState(context.Background()); err != nil {
+		return nil, errors.WithMessage(err, "establish scm state")
+	}
+
+	preview := &resetPreview{}
+	if s.state == scmStateNoRegions {
+		return preview, nil
+	}
+
+	for _, region := range s.regions {
+		preview.Regions = append(preview.Regions, resetRegion{
+			ISetID:   region.iSetID,
+			MemType:  region.memType,
+			SocketID: region.socketID,
+			Capacity: scmSizeFromGiB(region.capacity),
+		})
+	}
+
+	devs, err := s.getNamespaces(context.Background())
+	if err != nil {
+		return nil, errors.WithMessage(err, "list namespaces")
+	}
+	preview.Namespaces = devs
+
+	return preview, nil
+}
+
+// RefreshState re-establishes state of SCM regions without side effects,
+// updating and returning the cached state and a copy of the region details.
+//
+// Intended to be polled, e.g. to confirm regions are available after the
+// reboot following region creation.
+func (s *scmStorage) RefreshState() (scmState, []scmRegion, error) {
+	if err := s.refreshState(context.Background()); err != nil {
+		return s.state, nil, errors.WithMessage(err, "establish scm state")
+	}
+
+	regions := make([]scmRegion, len(s.regions))
+	copy(regions, s.regions)
+
+	return s.state, regions, nil
+}
+
+// RebootsRequired returns the number of reboots expected before SCM is fully
+// provisioned from its current state, region creation requiring a reboot
+// before namespaces can be created.
+func (s *scmStorage) RebootsRequired() (int, error) {
+	if err := s.getState(context.Background()); err != nil {
+		return 0, errors.WithMessage(err, "establish scm state")
+	}
+
+	switch s.state {
+	case scmStateNoRegions:
+		return 1, nil
+	case scmStateFreeCapacity, scmStatePartialCapacity, scmStateNoCapacity:
+		return 0, nil
+	case scmStatePartialRegions:
+		return 0, FaultScmPartialRegions(regionSockets(s.regions))
+	default:
+		return 0, errors.New("unknown scm state")
+	}
+}
+
+// queryRegions enumerates pmem regions through the ipmctl backend.
+func (s *scmStorage) queryRegions(ctx context.Context) (regionSnapshot, error) {
+	regions, err := s.ipmctlOps(ctx).GetRegions()
+	if err != nil {
+		return regionSnapshot{}, err
+	}
+
+	if len(regions) == 0 {
+		return regionSnapshot{noRegions: true}, nil
+	}
+
+	return regionSnapshot{regions: regions}, nil
+}
+
+// refreshState re-establishes state of SCM regions and namespaces on local
+// server, querying regions regardless of any cached region snapshot.
+func (s *scmStorage) refreshState(ctx context.Context) error {
+	s.invalidateState()
+
+	return s.getState(ctx)
+}
+
+// invalidateState forces the next getState to query regions, to be called
+// when regions or namespaces may have been changed.
+func (s *scmStorage) invalidateState() {
+	s.regionCache.invalidate()
+}
+
+// getState establishes state of SCM regions and namespaces on local server.
+// Regions are only queried if no region snapshot is cached, see
+// scmRegionCache.
+//
+// State is partial regions if AppDirect regions exist on only some sockets,
+// otherwise it is based on the free capacity of regions.
+func (s *scmStorage) getState(ctx context.Context) (err error) {
+	s.state = scmStateUnknown
+	s.regions = nil
+
+	snapshot, cached := s.regionCache.get()
+	if !cached {
+		if snapshot, err = s.queryRegions(ctx); err != nil {
+			return err
+		}
+		s.regionCache.set(snapshot)
+	}
+
+	if snapshot.noRegions {
+		s.state = scmStateNoRegions
+		return nil
+	}
+
+	regions := snapshot.regions
+	s.regions = regions
+
+	if appDirect, other := regionSockets(regions); len(appDirect) > 0 &&
+		len(other) > 0 {
+
+		s.state = scmStatePartialRegions
+		return nil
+	}
+
+	numFree := 0
+	for _, region := range regions {
+		if region.hasFreeCapacity() {
+			numFree++
+			continue
+		}
+		if region.hasUnusableCapacity() {
+			log.Debugf("ignoring %d bytes free in region %s, below "+
+				"minimum namespace size %d\n", region.freeBytes(),
+				region.iSetID, region.minNamespaceSize())
+		}
+	}
+
+	switch {
+	case numFree == 0:
+		s.state = scmStateNoCapacity
+	case numFree < len(regions):
+		s.state = scmStatePartialCapacity
+	default:
+		s.state = scmStateFreeCapacity
+	}
+
+	return nil
+}
+
+// Persistent memory types of regions as reported by ipmctl.
+const (
+	scmMemTypeAppDirect   = "AppDirect"               // interleaved
+	scmMemTypeAppDirectNI = "AppDirectNotInterleaved" // one module per region
+)
+
+// scmRegion describes an AppDirect region (interleave set) as reported by
+// ipmctl.
+type scmRegion struct {
+	iSetID       string
+	memType      string
+	capacity     float64 // GiB
+	freeCapacity float64 // GiB
+	healthState  string
+	socketID     int
+	width        int      // modules in interleave set, zero if unknown
+	dimmIDs      []string // ids of interleaved modules, nil if unknown
+}
+
+// regionSockets returns the sockets having AppDirect regions and the sockets
+// having only regions of other persistent memory types, both in ascending
+// order.
+func regionSockets(regions []scmRegion) (appDirect, other []int) {
+	types := make(map[int]bool) // socket has AppDirect region
+	for _, region := range regions {
+		types[region.socketID] = types[region.socketID] ||
+			region.isAppDirect()
+	}
+
+	for socket, isAppDirect := range types {
+		if isAppDirect {
+			appDirect = append(appDirect, socket)
+			continue
+		}
+		other = append(other, socket)
+	}
+	sort.Ints(appDirect)
+	sort.Ints(other)
+
+	return
+}
+
+// isAppDirect indicates whether the region is in AppDirect mode, either
+// interleaved or not.
+func (r *scmRegion) isAppDirect() bool {
+	return r.memType == scmMemTypeAppDirect || r.memType == scmMemTypeAppDirectNI
+}
+
+// hasFreeCapacity indicates whether an AppDirect region has enough free
+// capacity for a namespace to be created, leftover capacity smaller than
+// the minimum namespace size is not usable.
+func (r *scmRegion) hasFreeCapacity() bool {
+	return r.isAppDirect() && r.freeBytes() >= r.minNamespaceSize()
+}
+
+// hasUnusableCapacity indicates whether the region has free capacity too
+// small to be allocated to a namespace.
+func (r *scmRegion) hasUnusableCapacity() bool {
+	return r.isAppDirect() && r.freeCapacity > 0 && !r.hasFreeCapacity()
+}
+
+func (r *scmRegion) freeBytes() uint64 {
+	return uint64(r.freeCapacity * (1 << 30))
+}
+
+// minNamespaceSize returns the smallest namespace that can be created in the
+// region given its namespace alignment.
+func (r *scmRegion) minNamespaceSize() uint64 {
+	return r.namespaceAlign()
+}
+
+// namespaceAlign returns the alignment of namespace sizes in the region,
+// pmemNamespaceAlign per interleaved module if the width is known.
+func (r *scmRegion) namespaceAlign() uint64 {
+	if r.width > 0 {
+		return pmemNamespaceAlign * uint64(r.width)
+	}
+
+	return pmemNamespaceAlign
+}
+
+// usableBytes returns the free capacity of the region in bytes after
+// reserving the given percentage of total region capacity, rounded down to
+// the region's namespace alignment.
+func (r *scmRegion) usableBytes(reservePct int) uint64 {
+	if !r.hasFreeCapacity() {
+		return 0
+	}
+
+	usable := r.freeCapacity - r.capacity*float64(reservePct)/100
+	if usable <= 0 {
+		return 0
+	}
+	bytes := uint64(usable * (1 << 30))
+
+	return bytes - bytes%r.namespaceAlign()
+}
+
+// isDegraded indicates that the interleave set is incomplete or otherwise
+// unhealthy, e.g. a member module is missing.
+//
+// Regions reported without a health state are assumed to be healthy.
+func (r *scmRegion) isDegraded() bool {
+	return r.healthState != "" && r.healthState != "Healthy"
+}
+
+// parseCapacity converts ipmctl capacity strings e.g. "3012.0 GiB" to GiB.
+func parseCapacity(in string) (float64, error) {
+	fields := strings.Fields(in)
+	if len(fields) != 2 {
+		return 0, errors.Errorf("unexpected capacity format %q", in)
+	}
+
+	val, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "parse capacity %q", in)
+	}
+
+	switch fields[1] {
+	case "B":
+		return val / (1 << 30), nil
+	case "MiB":
+		return val / 1024, nil
+	case "GiB":
+		return val, nil
+	case "TiB":
+		return val * 1024, nil
+	default:
+		return 0, errors.Errorf("unexpected capacity unit %q", in)
+	}
+}
+
+// parseRegions takes output from ipmctl and returns details of each region.
+//
+// external tool commands return:
+// $ ipmctl show -d PersistentMemoryType,Capacity,FreeCapacity,HealthState -region
+//
+// ---ISetID=0x2aba7f4828ef2ccc---
+//    PersistentMemoryType=AppDirect
+//    Capacity=3012.0 GiB
+//    FreeCapacity=3012.0 GiB
+//    HealthState=Healthy
+// ---ISetID=0x81187f4881f02ccc---
+//    PersistentMemoryType=AppDirect
+//    Capacity=3012.0 GiB
+//    FreeCapacity=3012.0 GiB
+//    HealthState=Healthy
+func parseRegions(text string) (regions []scmRegion, err error) {
+	lines := strings.Split(text, "\n")
+	if len(lines) < 4 {
+		return nil, errors.Errorf("expecting at least 4 lines, got %d",
+			len(lines))
+	}
+
+	var region *scmRegion
+	for _, line := range lines {
+		entry := strings.TrimSpace(line)
+
+		if strings.HasPrefix(entry, "---") {
+			regions = append(regions, scmRegion{
+				iSetID: strings.TrimPrefix(strings.Trim(entry, "-"), "ISetID="),
+			})
+			region = &regions[len(regions)-1]
+			continue
+		}
+
+		kv := strings.Split(entry, "=")
+		if len(kv) != 2 || region == nil {
+			continue
+		}
+
+		switch kv[0] {
+		case "PersistentMemoryType":
+			region.memType = kv[1]
+		case "Capacity":
+			if region.capacity, err = parseCapacity(kv[1]); err != nil {
+				return nil, err
+			}
+		case "FreeCapacity":
+			if region.freeCapacity, err = parseCapacity(kv[1]); err != nil {
+				return nil, err
+			}
+		case "HealthState":
+			region.healthState = kv[1]
+		case "SocketID":
+			id, err := strconv.ParseUint(
+				strings.TrimPrefix(kv[1], "0x"), 16, 16)
+			if err != nil {
+				return nil, errors.WithMessage(err, "parse region socket")
+			}
+			region.socketID = int(id)
+		case "DimmID":
+			// comma separated ids of interleaved modules
+			for _, id := range strings.Split(kv[1], ",") {
+				region.dimmIDs = append(region.dimmIDs,
+					strings.ToLower(strings.TrimSpace(id)))
+			}
+			region.width = len(region.dimmIDs)
+		}
+	}
+
+	return
+}
+
+// pmemPreview describes a pmem namespace that Prep would create.
+type pmemPreview struct {
+	Name   string
+	ISetID string
+	Size   uint64 // bytes
+}
+
+// PreviewNamespaces returns the namespaces Prep would create given the
+// current free capacity of regions, without creating anything.
+//
+// ndctl allocates the remaining free capacity of a region to each namespace
+// so one namespace is expected per AppDirect region with free capacity,
+// less any capacity reserved for the corresponding io_server.
+func (s *scmStorage) PreviewNamespaces() (previews []pmemPreview, err error) {
+	if err := s.getState(context.Background()); err != nil {
+		return nil, errors.WithMessage(err, "establish scm state")
+	}
+
+	if err := s.checkRegionsHealthy(); err != nil {
+		return nil, err
+	}
+
+	reserving := s.hasReservation()
+	for _, region := range s.regions {
+		if !region.hasFreeCapacity() {
+			continue
+		}
+
+		size := uint64(region.freeCapacity * (1 << 30))
+		if reserving {
+			if region.capacity == 0 || region.freeCapacity < region.capacity {
+				continue
+			}
+			size = region.usableBytes(s.reservePercent(len(previews)))
+			if size == 0 {
+				continue
+			}
+		}
+
+		previews = append(previews, pmemPreview{
+			Name:   pmemName(len(previews)),
+			ISetID: region.iSetID,
+			Size:   size,
+		})
+	}
+
+	return
+}
+
+// checkRegionsHealthy returns a fault if any region with free capacity is
+// degraded, as namespaces may otherwise be created on it.
+func (s *scmStorage) checkRegionsHealthy() error {
+	for _, region := range s.regions {
+		if region.hasFreeCapacity() && region.isDegraded() {
+			return FaultScmDegradedRegion(region.iSetID, region.healthState)
+		}
+	}
+
+	return nil
+}
+
+// scmSensors holds sensor readings for a single module, readings not
+// supported by the module are nil.
+type scmSensors struct {
+	DimmID          string
+	MediaTempC      *int
+	ControllerTempC *int
+	PowerOnSecs     *int
+}
+
+// parseSensorValue converts ipmctl sensor values e.g. "35C" or "2045s" to
+// integers, returning nil if the value is unavailable.
+func parseSensorValue(in string) *int {
+	val, err := strconv.Atoi(strings.TrimRight(in, "Cs"))
+	if err != nil {
+		log.Debugf("sensor value %q unavailable", in)
+		return nil
+	}
+
+	return &val
+}
+
+// parseSensors takes output from ipmctl and returns sensor readings grouped
+// by module.
+//
+// external tool commands return:
+// $ ipmctl show -sensor MediaTemperature,ControllerTemperature,PowerOnTime
+//
+// DimmID | Type                  | CurrentValue
+// =============================================
+// 0x0001 | MediaTemperature      | 35C
+// 0x0001 | ControllerTemperature | 40C
+// 0x0001 | PowerOnTime           | 2045s
+func parseSensors(text string) (sensors []scmSensors) {
+	idxByID := make(map[string]int)
+
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) != 3 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if fields[0] == "DimmID" {
+			continue // header
+		}
+
+		idx, exists := idxByID[fields[0]]
+		if !exists {
+			idx = len(sensors)
+			idxByID[fields[0]] = idx
+			sensors = append(sensors, scmSensors{DimmID: fields[0]})
+		}
+		ms := &sensors[idx]
+
+		switch fields[1] {
+		case "MediaTemperature":
+			ms.MediaTempC = parseSensorValue(fields[2])
+		case "ControllerTemperature":
+			ms.ControllerTempC = parseSensorValue(fields[2])
+		case "PowerOnTime":
+			ms.PowerOnSecs = parseSensorValue(fields[2])
+		}
+	}
+
+	return
+}
+
+// GetSensors returns sensor readings for each SCM module.
+func (s *scmStorage) GetSensors() ([]scmSensors, error) {
+	return s.ipmctlOps(context.Background()).GetSensors()
+}
+
+// scmErrorLogTypes are the module error logs retrieved by GetErrorLog.
+var scmErrorLogTypes = []string{"Thermal", "Media"}
+
+// scmErrorLogEntry holds a single thermal or media error log entry of a
+// module.
+type scmErrorLogEntry struct {
+	DimmID    string
+	Type      string // Thermal or Media
+	Timestamp int64  // seconds since epoch
+	Details   map[string]string
+}
+
+// parseErrorLog takes output from ipmctl and returns error log entries of the
+// given type for each module, modules without entries are omitted.
+//
+// external tool commands return:
+// $ ipmctl show -error Thermal -dimm
+//
+// ---DimmID=0x0001---
+// Thermal Error occurred
+//    System Timestamp : 1527267471
+//    Temperature : 86C
+//    Reported : 4 - Critical
+//    Sequence Number : 1
+// ---DimmID=0x0101---
+// No errors found on DimmID 0x0101
+func parseErrorLog(logType, text string) (entries []scmErrorLogEntry, err error) {
+	var dimmID string
+	var entry *scmErrorLogEntry
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "---"):
+			dimmID = strings.TrimPrefix(strings.Trim(line, "-"), "DimmID=")
+			entry = nil
+		case strings.HasSuffix(line, "Error occurred"):
+			entries = append(entries, scmErrorLogEntry{
+				DimmID:  dimmID,
+				Type:    logType,
+				Details: make(map[string]string),
+			})
+			entry = &entries[len(entries)-1]
+		case entry != nil:
+			kv := strings.SplitN(line, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key, val := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+			if key != "System Timestamp" {
+				entry.Details[key] = val
+				continue
+			}
+			if entry.Timestamp, err = strconv.ParseInt(val, 10, 64); err != nil {
+				return nil, errors.WithMessagef(err,
+					"parse %s error log timestamp", dimmID)
+			}
+		}
+	}
+
+	return
+}
+
+// GetErrorLog returns thermal and media error log entries recorded by each
+// SCM module, keyed by DimmID. Modules without entries are omitted.
+func (s *scmStorage) GetErrorLog() (map[string][]scmErrorLogEntry, error) {
+	logs := make(map[string][]scmErrorLogEntry)
+	ops := s.ipmctlOps(context.Background())
+
+	for _, logType := range scmErrorLogTypes {
+		entries, err := ops.GetErrorLog(logType)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			logs[entry.DimmID] = append(logs[entry.DimmID], entry)
+		}
+	}
+
+	return logs, nil
+}
+
+// scmMinLifePct is the remaining life percentage of a module below which it
+// is reported as unhealthy so that it can be replaced before it fails.
+const scmMinLifePct = 10
+
+// scmModuleHealth holds the health of a single module as reported by
+// ipmctl, readings not supported by the module are nil.
+type scmModuleHealth struct {
+	PhysicalID   uint32
+	DimmID       string
+	HealthState  string // e.g. Healthy, Noncritical, Critical or Fatal
+	LifePct      *int   // percentage of rated life remaining
+	TemperatureC *int
+}
+
+// isUnhealthy indicates whether the module is failing or close to the end of
+// its rated life.
+func (h *scmModuleHealth) isUnhealthy() bool {
+	switch h.HealthState {
+	case "Critical", "Fatal":
+		return true
+	}
+
+	return h.LifePct != nil && *h.LifePct < scmMinLifePct
+}
+
+// String describes the health of the module for use in faults.
+func (h *scmModuleHealth) String() string {
+	desc := fmt.Sprintf("module %d (%s) %s", h.PhysicalID, h.DimmID,
+		h.HealthState)
+	if h.LifePct != nil {
+		desc += fmt.Sprintf(" with %d%% life remaining", *h.LifePct)
+	}
+
+	return desc
+}
+
+// parseHealth takes output from ipmctl and returns the health of each module
+// keyed by DimmID.
+//
+// external tool commands return:
+// $ ipmctl show -d HealthState,PercentageRemaining,Temperature -dimm
+//
+// ---DimmID=0x0001---
+//    HealthState=Healthy
+//    PercentageRemaining=100%
+//    Temperature=35C
+// ---DimmID=0x0101---
+//    HealthState=Critical
+//    PercentageRemaining=2%
+//    Temperature=81C
+func parseHealth(text string) map[string]*scmModuleHealth {
+	health := make(map[string]*scmModuleHealth)
+
+	var mh *scmModuleHealth
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "---") {
+			id := strings.TrimPrefix(strings.Trim(line, "-"), "DimmID=")
+			mh = &scmModuleHealth{DimmID: id}
+			health[id] = mh
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || mh == nil {
+			continue
+		}
+
+		switch kv[0] {
+		case "HealthState":
+			mh.HealthState = kv[1]
+		case "PercentageRemaining":
+			mh.LifePct = parseSensorValue(strings.TrimSuffix(kv[1], "%"))
+		case "Temperature":
+			mh.TemperatureC = parseSensorValue(kv[1])
+		}
+	}
+
+	return health
+}
+
+// Health returns the health of each discovered SCM module keyed by physical
+// id, modules without reported health are omitted.
+//
+// If any module is in a critical state or is close to the end of its rated
+// life the health of all modules is returned along with a fault listing the
+// unhealthy modules.
+func (s *scmStorage) Health() (map[uint32]*scmModuleHealth, error) {
+	out, err := s.execCmd(cmdScmShowHealth)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ipmctl show health")
+	}
+	byDimmID := parseHealth(out)
+
+	health := make(map[uint32]*scmModuleHealth)
+	var unhealthy []string
+	for _, module := range s.modules {
+		mh, exists := byDimmID[moduleDimmID(module)]
+		if !exists {
+			log.Debugf("no health reported for scm module %d",
+				module.Physicalid)
+			continue
+		}
+		mh.PhysicalID = module.Physicalid
+		health[module.Physicalid] = mh
+
+		if mh.isUnhealthy() {
+			unhealthy = append(unhealthy, mh.String())
+		}
+	}
+
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return health, FaultScmUnhealthyModules(strings.Join(unhealthy, ", "))
+	}
+
+	return health, nil
+}
+
+// checkModuleCapacities returns a fault if discovered modules differ in
+// capacity, as AppDirect interleaving may then be suboptimal or fail.
+func (s *scmStorage) checkModuleCapacities() error {
+	var capacities []uint64
+	seen := make(map[uint64]bool)
+
+	for _, module := range s.modules {
+		if !seen[module.Capacity] {
+			seen[module.Capacity] = true
+			capacities = append(capacities, module.Capacity)
+		}
+	}
+
+	if len(capacities) > 1 {
+		return FaultScmMismatchedCapacities(capacities)
+	}
+
+	return nil
+}
+
+// CheckModuleCount returns a fault listing sockets on which fewer modules
+// were discovered than the scm_modules_per_socket expected in config,
+// indicating a failed or missing module. The check is disabled if no count
+// is expected.
+//
+// Sockets without any discovered modules are not known and so not reported.
+func (s *scmStorage) CheckModuleCount() error {
+	expected := s.config.ScmModsPerSock
+	if expected == 0 {
+		return nil
+	}
+
+	counts := make(map[uint32]int)
+	for _, module := range s.modules {
+		counts[module.Loc.Socket]++
+	}
+
+	sockets := make([]int, 0, len(counts))
+	for socket := range counts {
+		sockets = append(sockets, int(socket))
+	}
+	sort.Ints(sockets)
+
+	var short []string
+	for _, socket := range sockets {
+		if n := counts[uint32(socket)]; n < expected {
+			short = append(short,
+				fmt.Sprintf("socket %d: %d of %d", socket, n, expected))
+		}
+	}
+
+	if len(short) > 0 {
+		return FaultScmMissingModules(strings.Join(short, ", "))
+	}
+
+	return nil
+}
+
+// moduleDimmID returns the ipmctl DimmID handle of a module, composed of the
+// socket, memory controller, channel and channel position of the module.
+func moduleDimmID(module *pb.ScmModule) string {
+	loc := module.Loc
+
+	return fmt.Sprintf("0x%x%x%x%x",
+		loc.Socket, loc.Memctrlr, loc.Channel, loc.Channelpos)
+}
+
+// CheckRegionModules returns a fault if the discovered modules differ from
+// those interleaved in existing regions, indicating that modules were added
+// or removed since the regions were created and regions should be recreated.
+//
+// The check is skipped if region membership is unknown.
+func (s *scmStorage) CheckRegionModules() error {
+	inRegions := make(map[string]bool)
+	for _, region := range s.regions {
+		for _, id := range region.dimmIDs {
+			inRegions[id] = true
+		}
+	}
+	if len(inRegions) == 0 {
+		return nil
+	}
+
+	discovered := make(map[string]bool)
+	var added, removed []string
+	for _, module := range s.modules {
+		id := moduleDimmID(module)
+		discovered[id] = true
+		if !inRegions[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range inRegions {
+		if !discovered[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	var detail []string
+	if len(added) > 0 {
+		detail = append(detail, "not in a region: "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		detail = append(detail, "not discovered: "+strings.Join(removed, ", "))
+	}
+	if len(detail) > 0 {
+		return FaultScmModulesChanged(strings.Join(detail, "; "))
+	}
+
+	return nil
+}
+
+// regionMemType returns the AppDirect persistent memory type of regions
+// created by Prep, interleaved unless disabled in config.
+func (s *scmStorage) regionMemType() string {
+	if s.config.ScmInterleaved {
+		return scmMemTypeAppDirect
+	}
+
+	return scmMemTypeAppDirectNI
+}
+
+// createRegionsCmd returns the command creating an allocation goal for
+// regions of the configured persistent memory type.
+func (s *scmStorage) createRegionsCmd() string {
+	if s.config.ScmInterleaved {
+		return cmdScmCreateRegions
+	}
+
+	return cmdScmCreateRegionsNI
+}
+
+// createRegions sets DCPM modules into regions in AppDirect mode, interleaved
+// unless disabled in config.
+//
+// External tool command output will indicate whether a subsequent reboot is needed.
+func (s *scmStorage) createRegions(ctx context.Context) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, errors.WithMessage(err, "scm region creation aborted")
+	}
+	if s.dryRun {
+		s.planCmd(s.createRegionsCmd())
+		return true, nil
+	}
+	defer s.invalidateState()
+	defer s.timeStep(scmOpPrep, scmStepCreateRegions, "")()
+
+	out, err := s.execCmdCtx(ctx, s.createRegionsCmd())
+	if err != nil {
+		return false, err
+	}
+
+	if !strings.Contains(out, msgScmRebootRequired) {
+		return false, nil
+	}
+
+	// goal will be pending until reboot, verify it was accepted
+	if err := s.verifyGoal(); err != nil {
+		return false, err
+	}
+
+	if err := s.config.ext.writeToFile(
+		strconv.FormatInt(time.Now().Unix(), 10), scmGoalFlagPath); err != nil {
+
+		s.warnf("failed to record pending scm goal: %s", err)
+	}
+
+	return true, nil
+}
+
+// goalRebootStatus reports whether an allocation goal created by
+// createRegions is pending and, if so, whether the system has been rebooted
+// since the goal was created by comparing boot time with the time recorded
+// in the pending-goal flag.
+func (s *scmStorage) goalRebootStatus() (pending bool, rebooted bool, err error) {
+	contents, err := s.config.ext.readFile(scmGoalFlagPath)
+	if os.IsNotExist(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, errors.WithMessage(err, "read pending scm goal")
+	}
+
+	secs, err := strconv.ParseInt(strings.TrimSpace(contents), 10, 64)
+	if err != nil {
+		return false, false, errors.WithMessage(err, "parse pending scm goal")
+	}
+
+	booted, err := s.config.ext.bootTime()
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, booted.After(time.Unix(secs, 0)), nil
+}
+
+// clearStaleGoal removes the pending-goal flag once regions are visible so
+// that subsequent runs don't report that a reboot is still pending.
+func (s *scmStorage) clearStaleGoal() {
+	if _, err := s.config.ext.readFile(scmGoalFlagPath); os.IsNotExist(err) {
+		return
+	}
+
+	if err := s.config.ext.remove(scmGoalFlagPath); err != nil {
+		s.warnf("failed to clear stale scm goal: %s\n", err)
+		return
+	}
+	s.infof("scm regions present, cleared stale pending goal\n")
+}
+
+// scmGoal describes the pending memory allocation goal for a single module.
+type scmGoal struct {
+	socketID      uint32
+	dimmID        string
+	memorySize    float64 // GiB
+	appDirectSize float64 // GiB
+}
+
+// parseGoals takes output from ipmctl and returns pending goal of each module.
+//
+// external tool commands return:
+// $ ipmctl show -goal
+//
+// SocketID | DimmID | MemorySize | AppDirect1Size | AppDirect2Size
+// ==================================================================
+// 0x0000   | 0x0001 | 0.0 GiB    | 502.0 GiB      | 0.0 GiB
+// 0x0001   | 0x1001 | 0.0 GiB    | 502.0 GiB      | 0.0 GiB
+func parseGoals(text string) (goals []scmGoal, err error) {
+	colIdx := make(map[string]int)
+
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 4 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		if fields[0] == "SocketID" {
+			for i, name := range fields {
+				colIdx[name] = i
+			}
+			continue
+		}
+
+		for _, name := range []string{"SocketID", "DimmID", "MemorySize", "AppDirect1Size"} {
+			if idx, exists := colIdx[name]; !exists || idx >= len(fields) {
+				return nil, errors.Errorf("goal output missing %s", name)
+			}
+		}
+
+		var goal scmGoal
+		socketID, err := strconv.ParseUint(fields[colIdx["SocketID"]], 0, 32)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse goal socket")
+		}
+		goal.socketID = uint32(socketID)
+		goal.dimmID = fields[colIdx["DimmID"]]
+		if goal.memorySize, err = parseCapacity(fields[colIdx["MemorySize"]]); err != nil {
+			return nil, err
+		}
+		if goal.appDirectSize, err = parseCapacity(fields[colIdx["AppDirect1Size"]]); err != nil {
+			return nil, err
+		}
+
+		goals = append(goals, goal)
+	}
+
+	return
+}
+
+// verifyGoal confirms the pending goal allocates modules on all sockets
+// entirely in AppDirect mode.
+func (s *scmStorage) verifyGoal() error {
+	out, err := s.execCmd(cmdScmShowGoal)
+	if err != nil {
+		return err
+	}
+
+	goals, err := parseGoals(out)
+	if err != nil {
+		return err
+	}
+	if len(goals) == 0 {
+		return FaultScmGoalMismatch("no pending goal")
+	}
+
+	goalSockets := make(map[uint32]bool)
+	for _, goal := range goals {
+		if goal.memorySize != 0 || goal.appDirectSize == 0 {
+			return FaultScmGoalMismatch(fmt.Sprintf(
+				"module %s not allocated as AppDirect", goal.dimmID))
+		}
+		goalSockets[goal.socketID] = true
+	}
+
+	for _, module := range s.modules {
+		if !goalSockets[module.Loc.Socket] {
+			return FaultScmGoalMismatch(fmt.Sprintf(
+				"no goal for socket %d", module.Loc.Socket))
+		}
+	}
+
+	return nil
+}
+
+// ndctlSchema identifies the field names used in ndctl namespace JSON output,
+// which have changed across ndctl versions.
+type ndctlSchema string
+
+const (
+	ndctlSchemaNumaNode ndctlSchema = "numa_node"
+	ndctlSchemaNumanode ndctlSchema = "numanode"
+)
+
+// ndctlFields holds the fields of an ndctl namespace entry used to detect its
+// schema.
+type ndctlFields struct {
+	Blockdev *string `json:"blockdev"`
+	Chardev  *string `json:"chardev"`
+	NumaNode *int    `json:"numa_node"`
+	Numanode *int    `json:"numanode"`
+	// size is listed in bytes, or as a string in human readable units
+	Size json.RawMessage `json:"size"`
+}
+
+// schema returns the schema of the namespace entry, or an error if the
+// entry doesn't describe a namespace device in any known schema.
+func (f ndctlFields) schema() (ndctlSchema, error) {
+	if f.Blockdev == nil && f.Chardev == nil {
+		return "", errors.New(msgNdctlUnknownSchema + ": no namespace device")
+	}
+	if f.NumaNode == nil && f.Numanode != nil {
+		return ndctlSchemaNumanode, nil
+	}
+
+	return ndctlSchemaNumaNode, nil
+}
+
+// ndctlJSON returns the JSON document in ndctl output, stripping any text
+// such as kernel or library warnings emitted before or after it. An empty
+// string is returned if the output is empty.
+func ndctlJSON(out string) (string, error) {
+	if strings.TrimSpace(out) == "" {
+		return "", nil
+	}
+
+	// document starts on the first line opening a valid json object or
+	// array and ends at the last matching closing delimiter
+	offset := 0
+	for _, line := range strings.SplitAfter(out, "\n") {
+		start := offset + len(line) - len(strings.TrimLeft(line, " \t"))
+		offset += len(line)
+
+		closer := ""
+		switch {
+		case strings.HasPrefix(out[start:], "["):
+			closer = "]"
+		case strings.HasPrefix(out[start:], "{"):
+			closer = "}"
+		default:
+			continue
+		}
+
+		end := strings.LastIndex(out, closer)
+		if end < start {
+			continue
+		}
+		if doc := out[start : end+1]; isNdctlListing(doc) {
+			return doc, nil
+		}
+	}
+
+	return "", errors.Errorf("%s: %q", msgNdctlNoJSON, out)
+}
+
+// isNdctlListing indicates whether doc is a JSON object or array of objects as
+// listed by ndctl, rather than e.g. a bracketed kernel log timestamp.
+func isNdctlListing(doc string) bool {
+	var listing interface{} = &map[string]json.RawMessage{}
+	if strings.HasPrefix(doc, "[") {
+		listing = &[]map[string]json.RawMessage{}
+	}
+
+	return json.Unmarshal([]byte(doc), listing) == nil
+}
+
+// ndctlEntries returns the entries listed in ndctl output, which lists a
+// single entry as an object rather than an array.
+func ndctlEntries(out string) ([]json.RawMessage, error) {
+	jsonData, err := ndctlJSON(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// turn single entries into arrays
+	if !strings.HasPrefix(jsonData, "[") {
+		jsonData = "[" + jsonData + "]"
+	}
+
+	var entries []json.RawMessage
+	if err := json.Unmarshal([]byte(jsonData), &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// parsePmemDevs parses ndctl namespace output, mapping alternate field names
+// of older or newer ndctl versions onto pmemDev fields.
+func parsePmemDevs(out string) ([]pmemDev, error) {
+	entries, err := ndctlEntries(out)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse ndctl namespaces")
+	}
+
+	return parseNamespaceEntries(entries)
+}
+
+// parseNamespaceEntries parses ndctl namespace entries, see parsePmemDevs.
+func parseNamespaceEntries(entries []json.RawMessage) ([]pmemDev, error) {
+	devs := make([]pmemDev, 0, len(entries))
+	for _, entry := range entries {
+		var dev pmemDev
+		var fields ndctlFields
+		if err := json.Unmarshal(entry, &dev); err != nil {
+			return nil, errors.WithMessage(err, "parse ndctl namespaces")
+		}
+		if err := json.Unmarshal(entry, &fields); err != nil {
+			return nil, errors.WithMessage(err, "parse ndctl namespaces")
+		}
+
+		schema, err := fields.schema()
+		if err != nil {
+			return nil, err
+		}
+		if schema == ndctlSchemaNumanode {
+			dev.NumaNode = *fields.Numanode
+		}
+		if size, err := strconv.ParseUint(string(fields.Size), 10, 64); err == nil {
+			dev.Size = size
+		}
+		log.Debugf("ndctl namespace %s in %s schema\n", dev.Blockdev, schema)
+
+		dev.SrvIdx = pmemOwner(dev.Name)
+		devs = append(devs, dev)
+	}
+
+	return devs, nil
+}
+
+// createNamespace creates a single pmem namespace labelled with the given name.
+//
+// If size (in bytes) is zero, all free capacity of the region is used.
+func (s *scmStorage) createNamespace(ctx context.Context, name string, size uint64) ([]pmemDev, error) {
+	return s.createRegionNamespace(ctx, "", name, size)
+}
+
+// createRegionNamespace creates a single pmem namespace labelled with the
+// given name in the given ndctl region, ndctl selects the region if empty.
+//
+// If size (in bytes) is zero, all free capacity of the region is used.
+func (s *scmStorage) createRegionNamespace(ctx context.Context, region, name string, size uint64) ([]pmemDev, error) {
+	if err := checkPmemName(name); err != nil {
+		return nil, err
+	}
+
+	for _, flag := range s.config.ScmNdctlFlags {
+		if err := checkNdctlFlag(flag); err != nil {
+			return nil, err
+		}
+	}
+
+	devs, err := s.createNamespaceRetry(ctx, region, name, size)
+	if err != nil {
+		return nil, err
+	}
+	for i := range devs {
+		// older ndctl versions omit name from output
+		if devs[i].Name == "" {
+			devs[i].Name = name
+		}
+		devs[i].SrvIdx = pmemOwner(devs[i].Name)
+	}
+	s.metrics.addNamespacesCreated(devs)
+	for _, dev := range devs {
+		s.events.emit(scmEvent{
+			Op: scmOpPrep, Type: scmEventDeviceCreated,
+			Device: dev.Blockdev, Message: dev.Name,
+		})
+	}
+
+	return devs, nil
+}
+
+// nsPermanentErrs are lower case fragments of namespace creation failures
+// that won't be resolved by retrying.
+var nsPermanentErrs = []string{
+	"no free capacity",
+	"no space left",
+	"parse ndctl namespaces",
+	msgNdctlUnknownSchema,
+}
+
+// isTransientNsErr indicates whether a namespace creation failure may succeed
+// on retry, e.g. a region being busy immediately after creation.
+func isTransientNsErr(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range nsPermanentErrs {
+		if strings.Contains(msg, fragment) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// createNamespaceRetry requests namespace creation from the ndctl backend,
+// retrying transient failures with exponential backoff between attempts.
+func (s *scmStorage) createNamespaceRetry(ctx context.Context, region, name string, size uint64) ([]pmemDev, error) {
+	attempts := s.nsAttempts
+	if attempts < 1 {
+		attempts = defaultScmNsAttempts
+	}
+	delay := s.nsRetry
+	if delay <= 0 {
+		delay = defaultScmNsRetryDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		devs, err := s.ndctlOps(ctx).CreateNamespace(
+			region, name, size, s.config.ScmNdctlFlags)
+		if err == nil || attempt >= attempts || !isTransientNsErr(ctx, err) {
+			return devs, err
+		}
+
+		s.warnf("scm namespace %s creation attempt %d of %d failed, retrying in %s: %s",
+			name, attempt, attempts, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.WithMessage(ctx.Err(),
+				"scm namespace creation retry aborted")
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// reservePercent returns the percentage of region capacity to be reserved
+// from the namespace created for the io_server with the given index.
+func (s *scmStorage) reservePercent(srvIdx int) int {
+	if srvIdx >= len(s.config.Servers) {
+		return 0
+	}
+
+	return s.config.Servers[srvIdx].ScmReservePct
+}
+
+// hasReservation indicates whether any io_server reserves region capacity.
+func (s *scmStorage) hasReservation() bool {
+	for i := range s.config.Servers {
+		if s.reservePercent(i) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reservedNamespaceSize returns the size in bytes of the next namespace to be
+// created leaving the given percentage of region capacity unallocated, zero
+// indicates no region has usable capacity above the reservation.
+//
+// Only regions without existing namespaces are considered so that reserved
+// capacity is not consumed by a subsequent namespace.
+//
+// NOTE: only used if ndctl lists no regions, in which case ndctl selects the
+//       region the namespace is created in and size is derived from the
+//       first region in ipmctl order with usable capacity.
+func (s *scmStorage) reservedNamespaceSize(reservePct int) uint64 {
+	for _, region := range s.regions {
+		if region.capacity == 0 || region.freeCapacity < region.capacity {
+			continue
+		}
+		if size := region.usableBytes(reservePct); size > 0 {
+			return size
+		}
+	}
+
+	return 0
+}
+
+// createNamespaces runs create until no free capacity.
+//
+// Namespaces are named deterministically in order of creation so that
+// they can be correlated with io_server instances.
+//
+// If a capacity reservation is configured for any io_server, namespaces are
+// explicitly sized to leave the corresponding reservation free and creation
+// stops once free capacity has dropped to the reserved threshold.
+//
+// Regions are processed in ascending socket order, as they are with
+// namespace workers, so that the mapping of io_server index to NUMA node is
+// the same whatever the number of workers.
+//
+// Cancellation is checked between each namespace creation. On failure or
+// cancellation, devices created so far are returned alongside the error.
+func (s *scmStorage) createNamespaces(ctx context.Context) (devs []pmemDev, err error) {
+	if s.dryRun {
+		return s.planNamespaces(), nil
+	}
+	defer s.invalidateState()
+	defer s.timeStep(scmOpPrep, scmStepCreateNamespaces, "")()
+
+	if s.nsWorkers > 1 {
+		return s.createNamespacesParallel(ctx)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return devs, errors.WithMessagef(err,
+				"scm namespace creation aborted after %d created",
+				len(devs))
+		}
+
+		region, size, ok, err := s.nextNamespaceRegion(len(devs))
+		if err != nil {
+			return devs, err
+		}
+		if region == "" && s.hasReservation() {
+			size = s.reservedNamespaceSize(s.reservePercent(len(devs)))
+			ok = size != 0
+		}
+		if !ok {
+			log.Debugf("scm free capacity at reserved threshold\n")
+			if len(devs) == 0 {
+				return s.getNamespaces(ctx)
+			}
+			return devs, nil
+		}
+
+		newDevs, err := s.createRegionNamespace(ctx, region,
+			pmemName(len(devs)), size)
+		s.invalidateState()
+		if err != nil {
+			return devs, err
+		}
+		for _, dev := range newDevs {
+			devs = append(devs, dev)
+			s.reportNamespace(len(devs), dev)
+		}
+
+		if err := s.getState(ctx); err != nil {
+			if ctx.Err() != nil {
+				continue // report cancellation with created count
+			}
+			return devs, err
+		}
+
+		switch s.state {
+		case scmStateNoCapacity:
+			return devs, nil
+		case scmStateFreeCapacity, scmStatePartialCapacity:
+			log.Debugf("scm in state %s, creating further namespaces\n",
+				s.state)
+		default:
+			return devs, errors.Errorf("unexpected state: want %s or %s, got %s",
+				scmStateFreeCapacity.String(),
+				scmStatePartialCapacity.String(), s.state.String())
+		}
+	}
+}
+
+// reportNamespace notifies any registered callback of a created namespace.
+func (s *scmStorage) reportNamespace(created int, dev pmemDev) {
+	if s.nsProgress != nil {
+		s.nsProgress(created, dev)
+	}
+}
+
+// ndRegion describes a pmem region as reported by ndctl.
+type ndRegion struct {
+	Dev           string `json:"dev"`
+	Size          uint64 `json:"size"`
+	AvailableSize uint64 `json:"available_size"`
+	NumaNode      int    `json:"numa_node"`
+}
+
+func parseNdRegions(out string) (regions []ndRegion, err error) {
+	entries, err := ndctlEntries(out)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse ndctl regions")
+	}
+
+	regions = make([]ndRegion, 0, len(entries))
+	for _, entry := range entries {
+		var region ndRegion
+		if err = json.Unmarshal(entry, &region); err != nil {
+			return nil, errors.WithMessage(err, "parse ndctl regions")
+		}
+		regions = append(regions, region)
+	}
+
+	return
+}
+
+// namespaceSize returns the size in bytes of the namespace to be created in
+// the given region for the io_server with the given index, zero indicates the
+// whole of the available capacity. ok is false if the region has no usable
+// capacity after any reservation.
+func (s *scmStorage) namespaceSize(region ndRegion, srvIdx int) (size uint64, ok bool) {
+	if region.AvailableSize == 0 {
+		return 0, false
+	}
+
+	reserved := region.Size * uint64(s.reservePercent(srvIdx)) / 100
+	if reserved == 0 {
+		return 0, true
+	}
+	if region.AvailableSize <= reserved {
+		return 0, false
+	}
+
+	size = region.AvailableSize - reserved
+	size -= size % pmemNamespaceAlign
+
+	return size, size > 0
+}
+
+// sortRegionsBySocket orders regions by ascending socket, preserving ndctl
+// order of regions on the same socket.
+func sortRegionsBySocket(regions []ndRegion) {
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].NumaNode < regions[j].NumaNode
+	})
+}
+
+// nextNamespaceRegion returns the ndctl region on the lowest socket with
+// usable capacity for the namespace of the io_server with the given index,
+// and the size of the namespace to be created in it. ok is false if no
+// listed region has usable capacity.
+//
+// If ndctl lists no regions, region is empty and ndctl selects the region.
+func (s *scmStorage) nextNamespaceRegion(srvIdx int) (region string, size uint64, ok bool, err error) {
+	out, err := s.execCmd(cmdScmListNdRegions)
+	if err != nil {
+		return "", 0, false, err
+	}
+	regions, err := parseNdRegions(out)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if len(regions) == 0 {
+		return "", 0, true, nil
+	}
+	sortRegionsBySocket(regions)
+
+	for _, r := range regions {
+		if size, ok = s.namespaceSize(r, srvIdx); ok {
+			return r.Dev, size, true, nil
+		}
+	}
+
+	return "", 0, false, nil
+}
+
+// createNamespacesParallel creates one namespace in each ndctl region with
+// available capacity, processing up to nsWorkers regions concurrently.
+//
+// Namespaces are named in ascending socket order of their regions regardless
+// of ndctl listing or completion order, so that the mapping of io_server
+// index to NUMA node is predictable, and never exceed the available capacity
+// of their region. On failure or cancellation, devices created are returned
+// alongside the first error.
+func (s *scmStorage) createNamespacesParallel(ctx context.Context) (devs []pmemDev, err error) {
+	out, err := s.execCmd(cmdScmListNdRegions)
+	if err != nil {
+		return nil, err
+	}
+	allRegions, err := parseNdRegions(out)
+	if err != nil {
+		return nil, err
+	}
+	sortRegionsBySocket(allRegions)
+
+	type job struct {
+		region ndRegion
+		name   string
+		size   uint64
+	}
+	var jobs []job
+	for _, region := range allRegions {
+		size, ok := s.namespaceSize(region, len(jobs))
+		if !ok {
+			continue
+		}
+		jobs = append(jobs, job{region, pmemName(len(jobs)), size})
+	}
+
+	results := make([][]pmemDev, len(jobs))
+	errs := make([]error, len(jobs))
+	sem := make(chan struct{}, s.nsWorkers)
+	var wg sync.WaitGroup
+	var progressMu sync.Mutex // serialises progress reports
+	created := 0
+
+	for i, j := range jobs {
+		wg.Add(1)
+		go func(i int, j job) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := ctx.Err(); err != nil {
+				errs[i] = errors.WithMessagef(err,
+					"scm namespace creation in %s aborted",
+					j.region.Dev)
+				return
+			}
+
+			results[i], errs[i] = s.createRegionNamespace(ctx,
+				j.region.Dev, j.name, j.size)
+
+			progressMu.Lock()
+			defer progressMu.Unlock()
+			for _, dev := range results[i] {
+				created++
+				s.reportNamespace(created, dev)
+			}
+		}(i, j)
+	}
+	wg.Wait()
+
+	for i := range jobs {
+		devs = append(devs, results[i]...)
+		if err == nil && errs[i] != nil {
+			err = errs[i]
+		}
+	}
+
+	return
+}
+
+func (s *scmStorage) getNamespaces(ctx context.Context) (devs []pmemDev, err error) {
+	return s.ndctlOps(ctx).ListNamespaces()
+}
+
+// resolveStableID returns the current block device path of the pmem namespace
+// with the given stable identity.
+func (s *scmStorage) resolveStableID(id string) (string, error) {
+	devs, err := s.getNamespaces(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	id = normalizeScmDev(id)
+	for _, dev := range devs {
+		if dev.UUID != "" && normalizeScmDev(dev.stableID()) == id {
+			return "/dev/" + dev.Blockdev, nil
+		}
+	}
+
+	return "", errors.Errorf("%s: %s", msgScmUnknownStableID, id)
+}
+
+// resolveDevList returns the given scm device list with any stable namespace
+// identities replaced by current block device paths.
+func (s *scmStorage) resolveDevList(devList []string) ([]string, error) {
+	resolved := make([]string, 0, len(devList))
+	for _, dev := range devList {
+		if strings.HasPrefix(dev, pmemStableIDPrefix) {
+			devPath, err := s.resolveStableID(dev)
+			if err != nil {
+				return nil, err
+			}
+			log.Debugf("scm device %s resolved to %s\n", dev, devPath)
+			dev = devPath
+		}
+		resolved = append(resolved, dev)
+	}
+
+	return resolved, nil
+}
+
+// regionLayout describes the namespaces allocated in an ndctl region.
+type regionLayout struct {
+	Region string
+	Sizes  []uint64 // bytes, one per namespace, zero if unavailable
+}
+
+// Count returns the number of namespaces in the region.
+func (rl regionLayout) Count() int {
+	return len(rl.Sizes)
+}
+
+// Fragmented indicates that the region is split into multiple namespaces
+// rather than the single namespace created by Prep, for example because of
+// namespaces left over from a prior run.
+func (rl regionLayout) Fragmented() bool {
+	return rl.Count() > 1
+}
+
+// NamespaceLayout returns the count and sizes of namespaces in each ndctl
+// region so an unexpected layout can be detected before deciding whether to
+// reset.
+//
+// Example ndctl output:
+// [{"dev":"region0","size":1082331758592,"available_size":0,
+//   "namespaces":[{"dev":"namespace0.0","mode":"fsdax","size":1065418227712,
+//   "blockdev":"pmem0"}]}]
+func (s *scmStorage) NamespaceLayout() ([]regionLayout, error) {
+	out, err := s.execCmd(cmdScmListNdLayout)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ndctlEntries(out)
+	if err != nil {
+		return nil, errors.WithMessage(err, "parse ndctl region layout")
+	}
+
+	layouts := make([]regionLayout, 0, len(entries))
+	for _, entry := range entries {
+		var region struct {
+			Dev        string            `json:"dev"`
+			Namespaces []json.RawMessage `json:"namespaces"`
+		}
+		if err := json.Unmarshal(entry, &region); err != nil {
+			return nil, errors.WithMessage(err, "parse ndctl region layout")
+		}
+
+		devs, err := parseNamespaceEntries(region.Namespaces)
+		if err != nil {
+			return nil, errors.WithMessage(err, "parse ndctl region layout")
+		}
+
+		rl := regionLayout{Region: region.Dev}
+		for _, dev := range devs {
+			rl.Sizes = append(rl.Sizes, dev.Size)
+		}
+		layouts = append(layouts, rl)
+	}
+
+	return layouts, nil
+}
+
+// scmCapacity accounts for the pmem region capacity of a socket.
+//
+// Used only includes namespaces of known size, UnknownNamespaces counts
+// namespaces whose size is unavailable.
+type scmCapacity struct {
+	Socket            int
+	Total             scmSize
+	Used              scmSize
+	Free              scmSize
+	UnknownNamespaces int
+}
+
+// Capacity returns the total capacity of pmem regions, the capacity consumed
+// by namespaces and the remaining free capacity per socket (NUMA node),
+// ordered by socket.
+func (s *scmStorage) Capacity() ([]scmCapacity, error) {
+	out, err := s.execCmd(cmdScmListNdRegions)
+	if err != nil {
+		return nil, err
+	}
+	regions, err := parseNdRegions(out)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := s.getNamespaces(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	type socketBytes struct {
+		total, used, free uint64
+		unknown           int
+	}
+	sockets := make(map[int]*socketBytes)
+	socket := func(numaNode int) *socketBytes {
+		if _, exists := sockets[numaNode]; !exists {
+			sockets[numaNode] = &socketBytes{}
+		}
+		return sockets[numaNode]
+	}
+
+	for _, region := range regions {
+		sb := socket(region.NumaNode)
+		sb.total += region.Size
+		sb.free += region.AvailableSize
+	}
+	for _, ns := range namespaces {
+		sb := socket(ns.NumaNode)
+		if ns.Size == 0 {
+			sb.unknown++
+			continue
+		}
+		sb.used += ns.Size
+	}
+
+	capacities := make([]scmCapacity, 0, len(sockets))
+	for numaNode, sb := range sockets {
+		capacities = append(capacities, scmCapacity{
+			Socket:            numaNode,
+			Total:             newScmSize(sb.total),
+			Used:              newScmSize(sb.used),
+			Free:              newScmSize(sb.free),
+			UnknownNamespaces: sb.unknown,
+		})
+	}
+	sort.Slice(capacities, func(i, j int) bool {
+		return capacities[i].Socket < capacities[j].Socket
+	})
+
+	return capacities, nil
+}
+
+// UnmountedNamespaces returns the pmem namespaces whose block devices are not
+// currently mounted, for example because Format has not yet been run.
+//
+// Devices mounted somewhere other than a configured scm mount point are
+// treated as mounted but are logged as they are unavailable to DAOS.
+func (s *scmStorage) UnmountedNamespaces() (unmounted []pmemDev, err error) {
+	devs, err := s.getNamespaces(context.Background())
+	if err != nil {
+		return nil, errors.WithMessage(err, "list namespaces")
+	}
+
+	table, err := s.config.ext.mounts()
+	if err != nil {
+		return nil, err
+	}
+
+	expMounts := make(map[string]bool)
+	for _, srv := range s.config.Servers {
+		expMounts[filepath.Clean(srv.ScmMount)] = true
+	}
+
+	for _, dev := range devs {
+		mntPoints := table["/dev/"+dev.Blockdev]
+		if len(mntPoints) == 0 {
+			unmounted = append(unmounted, dev)
+			continue
+		}
+
+		for _, mntPoint := range mntPoints {
+			if !expMounts[filepath.Clean(mntPoint)] {
+				s.warnf("pmem device %s mounted at unexpected location %s",
+					dev.Blockdev, mntPoint)
+			}
+		}
+	}
+
+	return
+}
+
+// Setup implementation for scmStorage providing initial device discovery
+func (s *scmStorage) Setup() error {
+	resp := new(pb.ScanStorageResp)
+	s.Discover(resp)
+
+	if resp.Scmstate.Status != pb.ResponseStatus_CTRL_SUCCESS {
+		return errors.New("scm scan: " + resp.Scmstate.Error)
+	}
+
+	s.formatted = s.detectFormatted()
+
+	return nil
+}
+
+// detectFormatted indicates whether the dcpm devices of all servers already
+// hold the filesystem that format would create, so that formatted state
+// survives restarts of the server.
+//
+// Ram class scm is backed by tmpfs which doesn't persist so is never
+// considered formatted.
+func (s *scmStorage) detectFormatted() bool {
+	if len(s.config.Servers) == 0 {
+		return false
+	}
+
+	for i := range s.config.Servers {
+		srv := &s.config.Servers[i]
+		if srv.ScmClass != scmDCPM || len(srv.ScmList) == 0 {
+			return false
+		}
+
+		devList, err := s.resolveDevList(srv.ScmList)
+		if err != nil {
+			log.Debugf("detect scm filesystem: %s", err)
+			return false
+		}
+
+		for _, devPath := range devList {
+			fsType, err := s.config.ext.deviceFsType(devPath)
+			if err != nil {
+				log.Debugf("detect scm filesystem: %s", err)
+				return false
+			}
+			if fsType != scmFsType(srv) {
+				log.Debugf("scm device %s has filesystem %q, want %q",
+					devPath, fsType, scmFsType(srv))
+				return false
+			}
+		}
+	}
+	s.infof("existing scm filesystems found, scm already formatted")
+
+	return true
+}
+
+// Teardown implementation for scmStorage
+func (s *scmStorage) Teardown() error {
+	s.initialized = false
+	return nil
+}
+
+func loadModules(mms []ipmctl.DeviceDiscovery) (pbMms common.ScmModules) {
+	for _, c := range mms {
+		pbMms = append(
+			pbMms,
+			&pb.ScmModule{
+				Loc: &pb.ScmModule_Location{
+					Channel:    uint32(c.Channel_id),
+					Channelpos: uint32(c.Channel_pos),
+					Memctrlr:   uint32(c.Memory_controller_id),
+					Socket:     uint32(c.Socket_id),
+				},
+				Physicalid: uint32(c.Physical_id),
+				Capacity:   c.Capacity,
+			})
+	}
+	return
+}
+
+// loadPmemDevs converts pmem namespace details to protobuf representation so
+// clients can map namespace block devices to NUMA nodes.
+func loadPmemDevs(devs []pmemDev) (pbPmems []*pb.PmemDevice) {
+	for _, dev := range devs {
+		pbPmems = append(
+			pbPmems,
+			&pb.PmemDevice{
+				Uuid:     dev.UUID,
+				Blockdev: dev.Blockdev,
+				NumaNode: uint32(dev.NumaNode),
+			})
+	}
+	return
+}
+
+// Discover method implementation for scmStorage
+func (s *scmStorage) Discover(resp *pb.ScanStorageResp) {
+	addStateDiscover := func(
+		status pb.ResponseStatus, errMsg string,
+		infoMsg string) *pb.ResponseState {
+
+		return addState(
+			status, errMsg, infoMsg, common.UtilLogDepth+1,
+			"scm storage discover")
+	}
+
+	if s.initialized {
+		resp.Scmstate = addStateDiscover(
+			pb.ResponseStatus_CTRL_SUCCESS, "", "")
+		resp.Modules = s.modules
+		resp.Pmems = loadPmemDevs(s.pmemDevs)
+		return
+	}
+
+	mms, err := s.ipmctl.Discover()
+	if err != nil {
+		resp.Scmstate = addStateDiscover(
+			pb.ResponseStatus_CTRL_ERR_SCM,
+			msgIpmctlDiscoverFail+": "+err.Error(), "")
+		return
+	}
+	s.modules = loadModules(mms)
+
+	// namespaces won't exist until scm has been prepared so failure to list
+	// them shouldn't fail module discovery
+	if len(s.modules) > 0 {
+		devs, err := s.getNamespaces(context.Background())
+		if err != nil {
+			log.Debugf("scm storage discover: listing namespaces: %s\n", err)
+		}
+		s.pmemDevs = devs
+	}
+
+	resp.Scmstate = addStateDiscover(pb.ResponseStatus_CTRL_SUCCESS, "", "")
+	resp.Modules = s.modules
+	resp.Pmems = loadPmemDevs(s.pmemDevs)
+
+	s.initialized = true
+}
+
+// mountDrainRetries and mountDrainInterval bound the time spent waiting for
+// processes to stop using a mount point before it is unmounted.
+var (
+	mountDrainRetries  = 5
+	mountDrainInterval = time.Second
+)
+
+// drainMount waits for processes using the mount point to exit, returning a
+// fault identifying them if the mount is still in use after retrying.
+func (s *scmStorage) drainMount(mntPoint string) error {
+	for i := 0; ; i++ {
+		holders, err := s.config.ext.mountHolders(mntPoint)
+		if err != nil {
+			return errors.WithMessage(err, "check scm mount in use")
+		}
+		if len(holders) == 0 {
+			return nil
+		}
+		if i >= mountDrainRetries {
+			return FaultScmFilesystemMounted(mntPoint, holders)
+		}
+
+		s.infof("scm mount %s in use by %v, waiting for release",
+			mntPoint, holders)
+		time.Sleep(mountDrainInterval)
+	}
+}
+
+// clearMount waits for mount point to drain, unmounts then removes it.
+//
+// A mount point that isn't mounted is removed without error, a fault is
+// returned if the filesystem is mounted but can't be unmounted because it is
+// busy.
+//
+// NOTE: requires elevated privileges
+func (s *scmStorage) clearMount(mntPoint string) (err error) {
+	if err = s.drainMount(mntPoint); err != nil {
+		return
+	}
+
+	if err = s.config.ext.unmount(mntPoint); err != nil {
+		if isBusy(err) {
+			return FaultScmFilesystemMounted(mntPoint, nil)
+		}
+		return
+	}
+
+	if err = s.config.ext.remove(mntPoint); err != nil {
+		return
+	}
+
+	return
+}
+
+// mkfsParams holds the filesystem and tuning applied by reFormat, zero values
+// are omitted so that mkfs defaults apply.
+//
+// Inode ratio, stride and stripe width only apply to ext4.
+type mkfsParams struct {
+	fsType      string // ext4 if unset
+	inodeRatio  int    // bytes-per-inode
+	stride      int    // filesystem blocks
+	stripeWidth int    // filesystem blocks
+	discard     string // discard or nodiscard
+	extra       string // appended to mkfs options
+}
+
+// extendedOpts returns the mkfs.ext4 -E option value, empty if not needed.
+func (p mkfsParams) extendedOpts() string {
+	var opts []string
+	if p.stride != 0 {
+		opts = append(opts, fmt.Sprintf("stride=%d", p.stride))
+	}
+	if p.stripeWidth != 0 {
+		opts = append(opts, fmt.Sprintf("stripe_width=%d", p.stripeWidth))
+	}
+	if p.discard != "" {
+		opts = append(opts, p.discard)
+	}
+
+	return strings.Join(opts, ",")
+}
+
+// command returns the mkfs command for the filesystem type.
+func (p mkfsParams) command() string {
+	if p.fsType == scmFsXfs {
+		return "mkfs.xfs"
+	}
+
+	return "mkfs.ext4"
+}
+
+// args returns the mkfs command line options, empty if mkfs defaults apply.
+//
+// Reflinks are disabled on xfs as they cannot be combined with dax.
+func (p mkfsParams) args() string {
+	var args []string
+	if p.fsType == scmFsXfs {
+		args = append(args, "-m reflink=0")
+		if p.discard == scmNoDiscard {
+			args = append(args, "-K")
+		}
+		if p.extra != "" {
+			args = append(args, p.extra)
+		}
+
+		return strings.Join(args, " ")
+	}
+
+	if p.inodeRatio != 0 {
+		args = append(args, fmt.Sprintf("-i %d", p.inodeRatio))
+	}
+	if extOpts := p.extendedOpts(); extOpts != "" {
+		args = append(args, "-E "+extOpts)
+	}
+	if p.extra != "" {
+		args = append(args, p.extra)
+	}
+
+	return strings.Join(args, " ")
+}
+
+// interleaveWidth returns the number of modules interleaved in the region
+// backing the given pmem device. The width reported by ipmctl for the region
+// on the socket of the namespace is used if known, otherwise the number of
+// modules discovered on that socket. Zero is returned if unknown.
+func (s *scmStorage) interleaveWidth(devPath string) int {
+	for _, dev := range s.pmemDevs {
+		if "/dev/"+dev.Blockdev != devPath {
+			continue
+		}
+
+		for _, region := range s.regions {
+			if region.width > 0 && region.socketID == dev.NumaNode {
+				return region.width
+			}
+		}
+
+		width := 0
+		for _, module := range s.modules {
+			if int(module.Loc.Socket) == dev.NumaNode {
+				width++
+			}
+		}
+		return width
+	}
+
+	return 0
+}
+
+// mkfsParams returns the filesystem and tuning for the given device of a
+// server.
+//
+// Unset stride and stripe width are derived from the interleave set width
+// where known, a stride of scmInterleaveBlocks and a stripe across all
+// interleaved modules. Discards are skipped unless explicitly requested as
+// they are meaningless and slow on pmem.
+func (s *scmStorage) mkfsParams(devPath string, srv *server) mkfsParams {
+	params := mkfsParams{
+		fsType:      scmFsType(srv),
+		inodeRatio:  srv.ScmInodeRatio,
+		stride:      srv.ScmStride,
+		stripeWidth: srv.ScmStripeWidth,
+		discard:     srv.ScmDiscard,
+		extra:       strings.Join(strings.Fields(srv.ScmMkfsOpts), " "),
+	}
+	if params.discard == "" {
+		params.discard = scmNoDiscard
+	}
+
+	width := s.interleaveWidth(devPath)
+	if width == 0 {
+		return params
+	}
+	if params.stride == 0 && params.stripeWidth == 0 {
+		params.stride = scmInterleaveBlocks
+	}
+	if params.stripeWidth == 0 {
+		params.stripeWidth = params.stride * width
+	}
+
+	return params
+}
+
+// reFormat wipes fs signatures and formats dev with the filesystem and tuning
+// given by params.
+//
+// NOTE: Requires elevated privileges and is a destructive operation, prompt
+//       user for confirmation before running.
+func (s *scmStorage) reFormat(devPath string, params mkfsParams) (err error) {
+	if err = s.checkMaintenance("reformat"); err != nil {
+		return
+	}
+	defer s.timeStep(scmOpFormat, scmStepReformat, devPath)()
+
+	if err = checkMkfsOpts(params.extra); err != nil {
+		return
+	}
+	if err = s.checkNotPartitioned(devPath); err != nil {
+		return
+	}
+
+	s.infof("wiping all fs identifiers on device %s", devPath)
+
+	s.reportProgress(devPath, formatPhaseWipeStart)
+	cmd := fmt.Sprintf("wipefs -a %s", devPath)
+	if err = s.config.ext.runCommand(cmd); err != nil {
+		s.reportProgress(devPath, formatPhaseFailed)
+		return errors.WithMessage(
+			newRunCmdError(cmd, err, ""), "wipefs")
+	}
+	s.reportProgress(devPath, formatPhaseWipeDone)
+
+	mkfsOpts := params.args()
+	if mkfsOpts != "" {
+		mkfsOpts += " "
+	}
+
+	s.reportProgress(devPath, formatPhaseMkfsStart)
+	cmd = fmt.Sprintf("%s %s%s", params.command(), mkfsOpts, devPath)
+	if err = s.config.ext.runCommand(cmd); err != nil {
+		s.reportProgress(devPath, formatPhaseFailed)
+		return errors.WithMessage(
+			newRunCmdError(cmd, err, ""), "mkfs format")
+	}
+	s.reportProgress(devPath, formatPhaseMkfsDone)
+
+	return
+}
+
+// checkDaxSupport returns a fault if the device cannot be mounted with the
+// given filesystem and the dax option, which would otherwise silently fall
+// back to the page cache.
+func (s *scmStorage) checkDaxSupport(devPath string, fsType string) error {
+	reason, err := s.config.ext.daxSupport(devPath, fsType)
+	if err != nil {
+		return errors.WithMessage(err, "check dax support")
+	}
+	if reason != "" {
+		return FaultScmDaxUnsupported(devPath, reason)
+	}
+
+	return nil
+}
+
+// partitionTableTypes are the wipefs signature types denoting a partition
+// table on the device.
+var partitionTableTypes = map[string]bool{
+	"gpt":  true,
+	"PMBR": true,
+	"dos":  true,
+}
+
+// checkNotPartitioned returns a fault if the device has a partition table.
+//
+// wipefs would erase the partition table along with any filesystem
+// signatures so refuse to format rather than silently discarding partitions.
+func (s *scmStorage) checkNotPartitioned(devPath string) error {
+	out, err := s.execCmd(fmt.Sprintf("%s %s", cmdScmListSignatures, devPath))
+	if err != nil {
+		return errors.WithMessage(err, "list device signatures")
+	}
+
+	for _, sigType := range strings.Fields(out) {
+		if partitionTableTypes[sigType] {
+			return FaultScmPartitionedDevice(devPath, sigType)
+		}
+	}
+
+	return nil
+}
+
+// mntParams holds the parameters used to mount a single scm device.
+type mntParams struct {
+	devPath  string
+	mntPoint string
+	mntType  string
+	opts     string
+	flags    uintptr // mount(2) flags from scm_mount_flags
+}
+
+// scmMountFlagBits maps the names accepted in scm_mount_flags to mount(2)
+// flags.
+var scmMountFlagBits = map[string]uintptr{
+	"ro":         syscall.MS_RDONLY,
+	"noatime":    syscall.MS_NOATIME,
+	"nodiratime": syscall.MS_NODIRATIME,
+	"relatime":   syscall.MS_RELATIME,
+	"nosuid":     syscall.MS_NOSUID,
+	"nodev":      syscall.MS_NODEV,
+	"noexec":     syscall.MS_NOEXEC,
+	"sync":       syscall.MS_SYNCHRONOUS,
+}
+
+// scmMountFlagNames returns the names accepted in scm_mount_flags in
+// alphabetical order.
+func scmMountFlagNames() []string {
+	names := make([]string, 0, len(scmMountFlagBits))
+	for name := range scmMountFlagBits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// getMntFlags translates the scm_mount_flags of a server into a mount(2) flag
+// bitmask, returning a fault for unknown flag names.
+func getMntFlags(srv *server) (flags uintptr, err error) {
+	for _, name := range srv.ScmMountFlags {
+		bit, exists := scmMountFlagBits[name]
+		if !exists {
+			return 0, FaultScmBadMountFlag(name, scmMountFlagNames())
+		}
+		flags |= bit
+	}
+
+	return
+}
+
+// isReadOnlyMount indicates whether mount flags request a read-only mount.
+func isReadOnlyMount(flags uintptr) bool {
+	return flags&syscall.MS_RDONLY != 0
+}
+
+// getDevMntParams returns mount parameters for each scm device of a server.
+//
+// A ram tmpfs or single dcpm device is mounted at scm_mount. Multiple dcpm
+// devices are each mounted at the mount point given by scmDevMounts.
+//
+// Validity of individual device paths of multiple dcpm devices is not
+// checked so that each device can be reported on separately.
+func getDevMntParams(srv *server) ([]mntParams, error) {
+	if srv.ScmClass != scmDCPM || len(srv.ScmList) < 2 {
+		if len(srv.ScmMountList) != 0 {
+			return nil, errors.New(msgScmMountListSingle)
+		}
+
+		mntType, devPath, opts, flags, err := getMntParams(srv)
+		if err != nil {
+			return nil, err
+		}
+
+		return []mntParams{{devPath, srv.ScmMount, mntType, opts, flags}}, nil
+	}
+
+	mntPoints, err := scmDevMounts(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	fsType := scmFsType(srv)
+	if !isScmFsType(fsType) {
+		return nil, errors.New(msgConfigBadFsType)
+	}
+	flags, err := getMntFlags(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]mntParams, 0, len(srv.ScmList))
+	for k, devPath := range srv.ScmList {
+		params = append(params,
+			mntParams{devPath, mntPoints[k], fsType, "dax", flags})
+	}
+
+	return params, nil
+}
+
+// scmFsType returns the filesystem to format dcpm devices of a server with.
+func scmFsType(srv *server) string {
+	if srv.ScmFsType == "" {
+		return scmFsExt4
+	}
+
+	return srv.ScmFsType
+}
+
+// isScmFsType verifies that dcpm devices can be formatted with the filesystem.
+func isScmFsType(fsType string) bool {
+	return fsType == scmFsExt4 || fsType == scmFsXfs
+}
+
+func getMntParams(srv *server) (mntType string, dev string, opts string, flags uintptr, err error) {
+	if flags, err = getMntFlags(srv); err != nil {
+		return
+	}
+
+	switch srv.ScmClass {
+	case scmDCPM:
+		mntType = scmFsType(srv)
+		opts = "dax"
+		if !isScmFsType(mntType) {
+			err = errors.New(msgConfigBadFsType)
+			break
+		}
+		if len(srv.ScmList) != 1 {
+			err = errors.New(msgScmBadDevList)
+			break
+		}
+
+		dev = srv.ScmList[0]
+		switch {
+		case dev == "":
+			err = errors.New(msgScmDevEmpty)
+		case !pmemDevRegexp.MatchString(dev):
+			err = FaultScmNotPmemNamespace(dev)
+		}
+	case scmRAM:
+		dev = "tmpfs"
+		mntType = "tmpfs"
+
+		switch {
+		case srv.ScmSize == scmSizeTmpfsDefault:
+		case srv.ScmSize <= 0:
+			err = FaultScmBadTmpfsSize(srv.ScmSize)
+		default:
+			opts = "size=" + strconv.Itoa(srv.ScmSize) + "g"
+		}
+	default:
+		err = errors.New(string(srv.ScmClass) + ": " + msgScmClassNotSupported)
+	}
+
+	return
+}
+
+// makeMount creates a mount target directory and mounts device there with the
+// given mount(2) flags.
+//
+// If a non-root owner is specified, ownership of the mounted filesystem is
+// transferred and the mount point mode set to scmMountMode. Ownership of
+// read-only mounts can't be changed so is left unset.
+//
+// NOTE: requires elevated privileges
+func (s *scmStorage) makeMount(
+	devPath string, mntPoint string, mntType string, mntOpts string,
+	mntFlags uintptr, uid int, gid int,
+) (err error) {
+	defer s.timeStep(scmOpFormat, scmStepMount, devPath)()
+
+	// detect a read-only base before mkdir fails with a confusing error
+	base, err := s.config.ext.readOnlyBase(mntPoint)
+	if err != nil {
+		return errors.WithMessage(err, "check scm mount base")
+	}
+	if base != "" {
+		return FaultScmMountBaseReadOnly(mntPoint, base)
+	}
+
+	if err = s.config.ext.mkdir(mntPoint); err != nil {
+		return
+	}
+
+	if err = s.config.ext.mount(devPath, mntPoint, mntType, mntFlags, mntOpts); err != nil {
+		return
+	}
+
+	if uid == 0 && gid == 0 {
+		return
+	}
+	if isReadOnlyMount(mntFlags) {
+		s.warnf("scm mount %s is read-only, ownership not set", mntPoint)
+		return
+	}
+
+	if err = s.config.ext.chownR(mntPoint, uid, gid); err != nil {
+		return errors.WithMessage(err, "set scm mount ownership")
+	}
+
+	if err = s.config.ext.chmod(mntPoint, scmMountMode); err != nil {
+		return errors.WithMessage(err, "set scm mount mode")
+	}
+
+	return
+}
+
+// memInfoBytes returns the size in bytes of the given memory field (e.g.
+// MemTotal) as reported by the kernel.
+func memInfoBytes(ext External, field string) (uint64, error) {
+	text, err := ext.readFile(memInfoPath)
+	if err != nil {
+		return 0, err
+	}
+
+	// e.g. "MemAvailable:   16247384 kB"
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != field+":" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.WithMessagef(err, "parse %s", field)
+		}
+		return kb << 10, nil
+	}
+
+	return 0, errors.Errorf("%s not reported in %s", field, memInfoPath)
+}
+
+// memAvailable returns the memory in bytes available for new allocations as
+// reported by the kernel.
+func (s *scmStorage) memAvailable() (uint64, error) {
+	return memInfoBytes(s.config.ext, "MemAvailable")
+}
+
+// checkTmpfsMemory returns a fault if available memory is insufficient for a
+// tmpfs of the given size plus tmpfsMemOverhead. The check is skipped with
+// a warning if available memory cannot be determined.
+func (s *scmStorage) checkTmpfsMemory(sizeGiB int) error {
+	if sizeGiB == scmSizeTmpfsDefault {
+		return nil // tmpfs default size is a proportion of memory
+	}
+
+	avail, err := s.memAvailable()
+	if err != nil {
+		s.warnf("skipping scm tmpfs memory check: %s", err)
+		return nil
+	}
+
+	if uint64(sizeGiB)<<30+tmpfsMemOverhead > avail {
+		return FaultScmTmpfsNoMemory(sizeGiB, avail)
+	}
+
+	return nil
+}
+
+// isNoMemory indicates whether a mount failed due to insufficient memory.
+func isNoMemory(err error) bool {
+	se, ok := errors.Cause(err).(*os.SyscallError)
+	if !ok {
+		return false
+	}
+	errno, ok := se.Err.(syscall.Errno)
+
+	return ok && errno == syscall.ENOMEM
+}
+
+// newMntRet creates and populates NVMe ctrlr result and logs error through
+// addState.
+func newMntRet(
+	op string, mntPoint string, status pb.ResponseStatus, errMsg string,
+	infoMsg string, logDepth int) *pb.ScmMountResult {
+
+	return &pb.ScmMountResult{
+		Mntpoint: mntPoint,
+		State: addState(
+			status, errMsg, infoMsg, logDepth+1, "scm mount "+op),
+	}
+}
+
+// optionInEffect indicates whether the requested flag option (e.g. dax) is
+// present in effective mount options, which may report it with a value
+// e.g. dax=always.
+func optionInEffect(opt string, effective []string) bool {
+	for _, eff := range effective {
+		if eff == opt || eff == opt+"=always" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkMountOpts compares the requested options against those in effect on
+// the given mount point, returning a description of the effective options
+// and any requested options that were silently dropped.
+//
+// Options with values (e.g. size=6g) are not compared as the kernel reports
+// them in canonical form.
+func (s *scmStorage) checkMountOpts(mntPoint, reqOpts string) string {
+	effective, err := s.config.ext.mountOptions(mntPoint)
+	if err != nil {
+		s.warnf("checking mount options of %s: %s", mntPoint, err)
+		return ""
+	}
+	if effective == nil {
+		return ""
+	}
+
+	var dropped []string
+	for _, opt := range strings.Split(reqOpts, ",") {
+		if opt == "" || strings.Contains(opt, "=") {
+			continue
+		}
+		if !optionInEffect(opt, effective) {
+			dropped = append(dropped, opt)
+		}
+	}
+
+	info := msgScmEffectiveMountOpts + strings.Join(effective, ",")
+	if len(dropped) > 0 {
+		s.warnf("%s mounted without requested options %s",
+			mntPoint, strings.Join(dropped, ","))
+		info += "; " + msgScmMountOptsDropped + strings.Join(dropped, ",")
+	}
+
+	return info
+}
+
+// checkServerIdx verifies i indexes a configured server.
+func (s *scmStorage) checkServerIdx(i int) error {
+	if i < 0 || i >= len(s.config.Servers) {
+		return errors.Errorf(msgScmBadServerIdx, i, len(s.config.Servers))
+	}
+
+	return nil
+}
+
+// Format attempts to format (forcefully) SCM mounts on a given server
+// as specified in config file and populates resp ScmMountResult.
+//
+// A fault is reported if the server's scm has already been formatted.
+func (s *scmStorage) Format(i int, results *(common.ScmMountResults)) {
+	s.format(i, false, results)
+}
+
+// Reformat wipes and recreates the filesystem of SCM mounts on a given server
+// even if already formatted, populating resp ScmMountResult.
+//
+// NOTE: destroys all data on the server's scm.
+func (s *scmStorage) Reformat(i int, results *(common.ScmMountResults)) {
+	s.format(i, true, results)
+}
+
+// format formats SCM mounts of the given server, reformatting mounts that are
+// already formatted only if force is set.
+func (s *scmStorage) format(i int, force bool, results *(common.ScmMountResults)) {
+	if err := s.checkServerIdx(i); err != nil {
+		*results = append(
+			*results,
+			newMntRet(
+				"format", "", pb.ResponseStatus_CTRL_ERR_CONF,
+				err.Error(), "", common.UtilLogDepth+1))
+		return
+	}
+
+	srv := s.config.Servers[i]
+	mntPoint := srv.ScmMount
+	s.infof("performing SCM device reset, format and mount")
+	s.plan = nil
+
+	defer s.metrics.addFormatDuration(i, time.Now())
+
+	var mntInfo string // effective mount options or fault resolution
+
+	// wraps around addMret to provide format specific function
+	addMretFormat := func(status pb.ResponseStatus, errMsg string) {
+		ev := scmEvent{
+			Op: scmOpFormat, Type: scmEventFormatted, Device: mntPoint,
+		}
+		if status != pb.ResponseStatus_CTRL_SUCCESS {
+			ev.Type = scmEventError
+			ev.Message = errMsg
+			s.errorf("scm format of %s failed: %s\n", mntPoint, errMsg)
+		}
+		s.events.emit(ev)
+
+		// log depth should be stack layer registering result
+		*results = append(
+			*results,
+			newMntRet(
+				"format", mntPoint, status, errMsg, mntInfo,
+				common.UtilLogDepth+1))
+	}
+
+	// wraps around addMretFormat to report a fault, the resolution of which
+	// is returned as info
+	addMretFault := func(f *faults.Fault) {
+		state := f.ResponseState()
+		mntInfo = state.Info
+		addMretFormat(state.Status, state.Error)
+	}
+
+	if err := s.checkMaintenance("format"); err != nil {
+		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+		return
+	}
+
+	if !s.initialized {
+		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, msgScmNotInited)
+		return
+	}
+
+	if mntPoint == "" {
+		addMretFormat(pb.ResponseStatus_CTRL_ERR_CONF, msgScmMountEmpty)
+		return
+	}
+
+	if s.formatted {
+		if !force {
+			addMretFault(FaultScmAlreadyFormatted(mntPoint))
+			return
+		}
+		s.warnf("forcing reformat of already formatted scm at %s\n", mntPoint)
+		s.formatted = false
+	}
+
+	if err := s.checkMountClass(mntPoint, srv.ScmClass); err != nil {
+		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+		return
+	}
+
+	if srv.ScmClass == scmDCPM {
+		devList, err := s.resolveDevList(srv.ScmList)
+		if err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_CONF, err.Error())
+			return
+		}
+		srv.ScmList = devList
+	}
+
+	if srv.ScmClass == scmDCPM && len(srv.ScmList) > 1 {
+		params, err := getDevMntParams(&srv)
+		if err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_CONF, err.Error())
+			return
+		}
+
+		if s.dryRun {
+			for k, p := range params {
+				action := scmFormatReformat
+				if !force {
+					action = s.formatAction(p.mntPoint, s.newFormatRecord(
+						&srv, p.mntType, p.devPath, p.opts))
+				}
+				s.planFormat(&srv, params[k:k+1], action, results)
+			}
+			return
+		}
+
+		s.formatDevices(context.Background(), srv, force, results)
+		return
+	}
+
+	params, err := getDevMntParams(&srv)
+	if err != nil {
+		addMretFormat(pb.ResponseStatus_CTRL_ERR_CONF, err.Error())
+		return
+	}
+	mntType, devPath, mntOpts := params[0].mntType, params[0].devPath, params[0].opts
+	mntFlags := params[0].flags
+
+	rec := s.newFormatRecord(&srv, mntType, devPath, mntOpts)
+	action := scmFormatReformat
+	if !force {
+		action = s.formatAction(mntPoint, rec)
+	}
+
+	if s.dryRun {
+		s.planFormat(&srv, params, action, results)
+		return
+	}
+
+	switch {
+	case action == scmFormatNone:
+		s.infof("scm format parameters of %s unchanged", mntPoint)
+		mntInfo = msgScmFormatUnchanged
+		addMretFormat(pb.ResponseStatus_CTRL_SUCCESS, "")
+		s.formatted = true
+		return
+	case action == scmFormatRemount:
+		s.infof("scm mount parameters of %s changed, remounting", mntPoint)
+
+		if err := s.clearMount(mntPoint); err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+			return
+		}
+	case srv.ScmClass == scmDCPM:
+		if err := s.checkDaxSupport(devPath, mntType); err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+			return
+		}
+
+		prompt := fmt.Sprintf("format scm device %s, destroying all data?", devPath)
+		if err := s.confirm(devPath, prompt); err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+			return
+		}
+
+		if err := s.clearMount(mntPoint); err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+			return
+		}
+
+		s.infof("formatting scm device %s, should be quick!...", devPath)
+
+		if err := s.reFormat(devPath, s.mkfsParams(devPath, &srv)); err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, cmdFailureMsg(err))
+			return
+		}
+
+		s.infof("scm format complete.\n")
+	case srv.ScmClass == scmRAM:
+		if err := s.checkTmpfsMemory(srv.ScmSize); err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_CONF, err.Error())
+			return
+		}
+
+		if err := s.clearMount(mntPoint); err != nil {
+			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+			return
+		}
+
+		s.infof("no scm_size specified in config for ram tmpfs")
+	}
+
+	s.infof(
+		"mounting scm device %s at %s (%s)...",
+		devPath, mntPoint, mntType)
+
+	err = s.makeMount(devPath, mntPoint, mntType, mntOpts, mntFlags,
+		srv.ScmMountUid, srv.ScmMountGid)
+	if err != nil {
+		if srv.ScmClass == scmRAM && isNoMemory(err) {
+			avail, _ := s.memAvailable() // zero if unknown
+			err = FaultScmTmpfsNoMemory(srv.ScmSize, avail)
+		}
+		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+		return
+	}
+
+	s.infof("scm mount complete.\n")
+	if err := s.probeMount(mntPoint, mntFlags); err != nil {
+		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
+		return
+	}
+	s.writeFormatRecord(mntPoint, rec)
+	mntInfo = s.checkMountOpts(mntPoint, mntOpts)
+	addMretFormat(pb.ResponseStatus_CTRL_SUCCESS, "")
+
+	s.infof("SCM device reset, format and mount completed")
+	s.formatted = true
+}
+
+// probeMount verifies a newly mounted scm filesystem is writable, returning
+// a fault if the read/write probe fails.
+//
+// Mounts made read-only by the mount flags are not probed.
+func (s *scmStorage) probeMount(mntPoint string, mntFlags uintptr) error {
+	if isReadOnlyMount(mntFlags) {
+		return nil
+	}
+
+	reason, err := s.config.ext.probeMount(mntPoint)
+	if err != nil {
+		return errors.WithMessage(err, "probe scm mount")
+	}
+	if reason != "" {
+		return FaultScmMountNotWritable(mntPoint, reason)
+	}
+
+	return nil
+}
+
+// scmFormatRecord records the parameters of the most recent format of an scm
+// mount, persisted in scmFormatRecordFile at the root of the mount.
+type scmFormatRecord struct {
+	Class     ScmClass `json:"class"`
+	Device    string   `json:"device"`
+	FsType    string   `json:"fs_type"`
+	MkfsOpts  string   `json:"mkfs_opts"`
+	Size      int      `json:"size"` // ram tmpfs size in GiB
+	MountOpts string   `json:"mount_opts"`
+	Uid       int      `json:"uid"`
+	Gid       int      `json:"gid"`
+}
+
+// formatChanged indicates whether parameters requiring the device to be
+// reformatted differ between records.
+func (r scmFormatRecord) formatChanged(other scmFormatRecord) bool {
+	return r.Class != other.Class || r.Device != other.Device ||
+		r.FsType != other.FsType || r.MkfsOpts != other.MkfsOpts ||
+		r.Size != other.Size
+}
+
+type scmFormatAction int
+
+const (
+	scmFormatReformat scmFormatAction = iota
+	scmFormatRemount
+	scmFormatNone
+)
+
+// newFormatRecord returns the record of format parameters for the server's
+// scm mount under current config.
+func (s *scmStorage) newFormatRecord(
+	srv *server, mntType, devPath, mntOpts string) scmFormatRecord {
+
+	rec := scmFormatRecord{
+		Class:     srv.ScmClass,
+		Device:    devPath,
+		FsType:    mntType,
+		MountOpts: mntOpts,
+		Uid:       srv.ScmMountUid,
+		Gid:       srv.ScmMountGid,
+	}
+	switch srv.ScmClass {
+	case scmDCPM:
+		rec.MkfsOpts = s.mkfsParams(devPath, srv).args()
+	case scmRAM:
+		rec.Size = srv.ScmSize
+	}
+
+	return rec
+}
+
+// formatAction compares the format record of an existing mount against the
+// current record to decide whether to reformat, just remount (only mount
+// parameters changed) or do nothing (unchanged).
+//
+// Reformat is chosen if there is no existing mount, it has no valid record or
+// the detected filesystem type of the mount differs from the configured type
+// regardless of what the record claims.
+func (s *scmStorage) formatAction(mntPoint string, rec scmFormatRecord) scmFormatAction {
+	fsType, err := s.config.ext.mountType(mntPoint)
+	switch {
+	case err != nil || fsType == "":
+		return scmFormatReformat
+	case fsType != rec.FsType:
+		s.warnf("existing scm mount %s is %s but %s is configured, "+
+			"reformatting\n", mntPoint, fsType, rec.FsType)
+		return scmFormatReformat
+	}
+
+	data, err := s.config.ext.readFile(filepath.Join(mntPoint, scmFormatRecordFile))
+	if err != nil {
+		return scmFormatReformat
+	}
+	var existing scmFormatRecord
+	if err := json.Unmarshal([]byte(data), &existing); err != nil {
+		log.Debugf("ignoring invalid scm format record: %s", err)
+		return scmFormatReformat
+	}
 
-		hasCapacity = false
+	switch {
+	case existing.formatChanged(rec):
+		return scmFormatReformat
+	case existing != rec:
+		return scmFormatRemount
+	default:
+		return scmFormatNone
 	}
+}
 
-	return
+// writeFormatRecord persists the format record at the root of the mount,
+// failure is logged but doesn't fail the format as the next format will
+// then just reformat.
+func (s *scmStorage) writeFormatRecord(mntPoint string, rec scmFormatRecord) {
+	data, err := json.Marshal(rec)
+	if err == nil {
+		err = s.config.ext.writeToFile(
+			string(data), filepath.Join(mntPoint, scmFormatRecordFile))
+	}
+	if err != nil {
+		s.warnf("recording scm format parameters: %s", err)
+	}
 }
 
-// createRegions sets DCPM modules into regions in interleaved AppDirect mode.
+// checkMountClass returns a fault if an existing mount at mntPoint was made
+// for a different scm class than configured, as reformatting would leave an
+// inconsistent state without an explicit reset.
 //
-// External tool command output will indicate whether a subsequent reboot is needed.
-func (s *scmStorage) createRegions() (bool, error) {
-	out, err := s.runCmd(cmdScmCreateRegions)
+// Mounts of filesystem types not created by Format are ignored.
+func (s *scmStorage) checkMountClass(mntPoint string, class ScmClass) error {
+	fsType, err := s.config.ext.mountType(mntPoint)
 	if err != nil {
-		return false, err
+		return errors.WithMessage(err, "check existing scm mount")
 	}
 
-	return strings.Contains(out, msgScmRebootRequired), nil
-}
-
-func parsePmemDevs(jsonData string) (devs []pmemDev) {
-	// turn single entries into arrays
-	if !strings.HasPrefix(jsonData, "[") {
-		jsonData = "[" + jsonData + "]"
+	var mounted ScmClass
+	switch fsType {
+	case "tmpfs":
+		mounted = scmRAM
+	case "ext4", "xfs":
+		mounted = scmDCPM
+	default:
+		return nil
 	}
 
-	json.Unmarshal([]byte(jsonData), &devs)
+	if mounted != class {
+		return FaultScmClassChanged(mntPoint, mounted, class)
+	}
 
-	return
+	return nil
 }
 
-// createNamespaces runs create until no free capacity.
-func (s *scmStorage) createNamespaces() (devs []pmemDev, err error) {
-	for {
-		out, err := s.runCmd(cmdScmCreateNamespace)
-		if err != nil {
-			return nil, err
-		}
-		devs = append(devs, parsePmemDevs(out)...)
+// scmDevMount returns the mount point of the idx'th dcpm device of a server
+// with multiple devices, a numbered subdirectory of the server's scm mount.
+func scmDevMount(mntPoint string, idx int) string {
+	return filepath.Join(mntPoint, strconv.Itoa(idx))
+}
 
-		if err := s.getState(); err != nil {
-			return nil, err
+// scmDevMounts returns the mount point of each dcpm device of a server with
+// multiple devices, taken from scm_mount_list if set and otherwise given by
+// scmDevMount.
+func scmDevMounts(srv *server) ([]string, error) {
+	if len(srv.ScmMountList) == 0 {
+		mntPoints := make([]string, 0, len(srv.ScmList))
+		for k := range srv.ScmList {
+			mntPoints = append(mntPoints, scmDevMount(srv.ScmMount, k))
 		}
+		return mntPoints, nil
+	}
 
-		switch {
-		case s.state == scmStateNoCapacity:
-			return devs, nil
-		case s.state != scmStateFreeCapacity:
-			return nil, errors.Errorf("unexpected state: want %s, got %s",
-				scmStateFreeCapacity.String(), s.state.String())
-		}
+	if len(srv.ScmMountList) != len(srv.ScmList) {
+		return nil, errors.Errorf(msgScmMountListMismatch,
+			len(srv.ScmMountList), len(srv.ScmList))
 	}
+
+	return append([]string{}, srv.ScmMountList...), nil
 }
 
-func (s *scmStorage) getNamespaces() (devs []pmemDev, err error) {
-	out, err := s.runCmd(cmdScmListNamespaces)
+// formatDevices formats and mounts the multiple dcpm devices (one per NUMA
+// node) of the given server concurrently, each device being mounted at the
+// corresponding mount point given by scmDevMounts. As for a single device,
+// devices already formatted are only reformatted if force is set.
+//
+// A result is appended for each device in device order. Failure on one device
+// does not abort the others, but cancelling ctx aborts any device that has
+// not yet completed. The server is only marked as formatted if all devices
+// are formatted and mounted successfully.
+func (s *scmStorage) formatDevices(
+	ctx context.Context, srv server, force bool,
+	results *(common.ScmMountResults)) {
+
+	mntPoints, err := scmDevMounts(&srv)
 	if err != nil {
-		return nil, err
+		*results = append(*results, newMntRet(
+			"format", srv.ScmMount, pb.ResponseStatus_CTRL_ERR_CONF,
+			err.Error(), "", common.UtilLogDepth+1))
+		return
 	}
 
-	return parsePmemDevs(out), nil
-}
+	devResults := make(common.ScmMountResults, len(srv.ScmList))
 
-// Setup implementation for scmStorage providing initial device discovery
-func (s *scmStorage) Setup() error {
-	resp := new(pb.ScanStorageResp)
-	s.Discover(resp)
+	// wraps around newMntRet to record the result of an individual device
+	setResult := func(k int, status pb.ResponseStatus, err error, info string) {
+		mntPoint := mntPoints[k]
+		ev := scmEvent{
+			Op: scmOpFormat, Type: scmEventFormatted, Device: mntPoint,
+		}
+		var errMsg string
+		if err != nil {
+			errMsg = cmdFailureMsg(err)
+			ev.Type = scmEventError
+			ev.Message = errMsg
+			s.errorf("scm format of %s failed: %s\n", mntPoint, errMsg)
+		}
+		s.events.emit(ev)
 
-	if resp.Scmstate.Status != pb.ResponseStatus_CTRL_SUCCESS {
-		return errors.New("scm scan: " + resp.Scmstate.Error)
+		devResults[k] = newMntRet(
+			"format", mntPoint, status, errMsg, info,
+			common.UtilLogDepth+1)
 	}
 
-	return nil
-}
+	var wg sync.WaitGroup
+	for k, devPath := range srv.ScmList {
+		switch {
+		case devPath == "":
+			setResult(k, pb.ResponseStatus_CTRL_ERR_CONF,
+				errors.New(msgScmDevEmpty), "")
+			continue
+		case !pmemDevRegexp.MatchString(devPath):
+			setResult(k, pb.ResponseStatus_CTRL_ERR_CONF,
+				FaultScmNotPmemNamespace(devPath), "")
+			continue
+		}
 
-// Teardown implementation for scmStorage
-func (s *scmStorage) Teardown() error {
-	s.initialized = false
-	return nil
-}
+		wg.Add(1)
+		go func(k int, devPath string) {
+			defer wg.Done()
 
-func loadModules(mms []ipmctl.DeviceDiscovery) (pbMms common.ScmModules) {
-	for _, c := range mms {
-		pbMms = append(
-			pbMms,
-			&pb.ScmModule{
-				Loc: &pb.ScmModule_Location{
-					Channel:    uint32(c.Channel_id),
-					Channelpos: uint32(c.Channel_pos),
-					Memctrlr:   uint32(c.Memory_controller_id),
-					Socket:     uint32(c.Socket_id),
-				},
-				Physicalid: uint32(c.Physical_id),
-				Capacity:   c.Capacity,
-			})
+			info, err := s.formatDevice(ctx, devPath, mntPoints[k], &srv,
+				force)
+			if err != nil {
+				setResult(k, pb.ResponseStatus_CTRL_ERR_APP, err, "")
+				return
+			}
+			setResult(k, pb.ResponseStatus_CTRL_SUCCESS, nil, info)
+		}(k, devPath)
+	}
+	wg.Wait()
+
+	formatted := true
+	for _, result := range devResults {
+		if result.State.Status != pb.ResponseStatus_CTRL_SUCCESS {
+			formatted = false
+		}
+	}
+	*results = append(*results, devResults...)
+
+	if formatted {
+		s.infof("SCM devices reset, format and mount completed")
+		s.formatted = true
 	}
-	return
 }
 
-// Discover method implementation for scmStorage
-func (s *scmStorage) Discover(resp *pb.ScanStorageResp) {
-	addStateDiscover := func(
-		status pb.ResponseStatus, errMsg string,
-		infoMsg string) *pb.ResponseState {
+// formatDevice formats and mounts a single dcpm device, checking ctx for
+// cancellation before each step.
+//
+// The format record of an existing mount decides, unless force is set,
+// whether the device is reformatted, just remounted or left unchanged.
+// Confirmation is requested before the device is wiped. Effective mount
+// options, or that the device is unchanged, are returned on success.
+func (s *scmStorage) formatDevice(
+	ctx context.Context, devPath string, mntPoint string, srv *server,
+	force bool,
+) (string, error) {
 
-		return addState(
-			status, errMsg, infoMsg, common.UtilLogDepth+1,
-			"scm storage discover")
+	aborted := func() error {
+		if err := ctx.Err(); err != nil {
+			return errors.WithMessagef(err,
+				"scm format of %s aborted", devPath)
+		}
+		return nil
 	}
 
-	if s.initialized {
-		resp.Scmstate = addStateDiscover(
-			pb.ResponseStatus_CTRL_SUCCESS, "", "")
-		resp.Modules = s.modules
-		return
+	if err := aborted(); err != nil {
+		return "", err
 	}
 
-	mms, err := s.ipmctl.Discover()
-	if err != nil {
-		resp.Scmstate = addStateDiscover(
-			pb.ResponseStatus_CTRL_ERR_SCM,
-			msgIpmctlDiscoverFail+": "+err.Error(), "")
-		return
+	fsType := scmFsType(srv)
+	rec := s.newFormatRecord(srv, fsType, devPath, "dax")
+	action := scmFormatReformat
+	if !force {
+		action = s.formatAction(mntPoint, rec)
 	}
-	s.modules = loadModules(mms)
 
-	resp.Scmstate = addStateDiscover(pb.ResponseStatus_CTRL_SUCCESS, "", "")
-	resp.Modules = s.modules
+	switch action {
+	case scmFormatNone:
+		s.infof("scm format parameters of %s unchanged", mntPoint)
+		return msgScmFormatUnchanged, nil
+	case scmFormatRemount:
+		s.infof("scm mount parameters of %s changed, remounting", mntPoint)
+		if err := s.clearMount(mntPoint); err != nil {
+			return "", err
+		}
+	default:
+		if err := s.checkDaxSupport(devPath, fsType); err != nil {
+			return "", err
+		}
 
-	s.initialized = true
-}
+		prompt := fmt.Sprintf("format scm device %s, destroying all data?", devPath)
+		if err := s.confirm(devPath, prompt); err != nil {
+			return "", err
+		}
 
-// clearMount unmounts then removes mount point.
-//
-// NOTE: requires elevated privileges
-func (s *scmStorage) clearMount(mntPoint string) (err error) {
-	if err = s.config.ext.unmount(mntPoint); err != nil {
-		return
+		if err := s.clearMount(mntPoint); err != nil {
+			return "", err
+		}
+
+		if err := aborted(); err != nil {
+			return "", err
+		}
+		s.infof("formatting scm device %s, should be quick!...", devPath)
+		if err := s.reFormat(devPath, s.mkfsParams(devPath, srv)); err != nil {
+			return "", err
+		}
 	}
 
-	if err = s.config.ext.remove(mntPoint); err != nil {
-		return
+	if err := aborted(); err != nil {
+		return "", err
+	}
+	mntFlags, err := getMntFlags(srv)
+	if err != nil {
+		return "", err
 	}
+	s.infof("mounting scm device %s at %s (%s)...", devPath, mntPoint, fsType)
+	err = s.makeMount(devPath, mntPoint, fsType, "dax", mntFlags,
+		srv.ScmMountUid, srv.ScmMountGid)
+	if err != nil {
+		return "", err
+	}
+	if err := s.probeMount(mntPoint, mntFlags); err != nil {
+		return "", err
+	}
+	s.writeFormatRecord(mntPoint, rec)
 
-	return
+	return s.checkMountOpts(mntPoint, "dax"), nil
 }
 
-// reFormat wipes fs signatures and formats dev with ext4.
+// fwImageInfo describes the module family and model targeted by a firmware
+// image. Zero value identifiers are not checked.
+type fwImageInfo struct {
+	VendorID          uint16
+	DeviceID          uint16
+	SubsystemDeviceID uint16
+}
+
+// parseFwImageMeta parses the key=value metadata accompanying a firmware
+// image.
 //
-// NOTE: Requires elevated privileges and is a destructive operation, prompt
-//       user for confirmation before running.
-func (s *scmStorage) reFormat(devPath string) (err error) {
-	log.Debugf("wiping all fs identifiers on device %s", devPath)
+// Example metadata:
+// VendorID=0x8086
+// DeviceID=0x979
+// SubsystemDeviceID=0x97a
+func parseFwImageMeta(text string) (info fwImageInfo, err error) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return info, errors.Errorf(
+				"malformed firmware image metadata line %q", line)
+		}
+		key := strings.TrimSpace(kv[0])
 
-	if err = s.config.ext.runCommand(
-		fmt.Sprintf("wipefs -a %s", devPath)); err != nil {
+		id, err := strconv.ParseUint(strings.TrimSpace(kv[1]), 0, 16)
+		if err != nil {
+			return info, errors.WithMessagef(err,
+				"firmware image metadata %s", key)
+		}
 
-		return errors.WithMessage(err, "wipefs")
+		switch key {
+		case "VendorID":
+			info.VendorID = uint16(id)
+		case "DeviceID":
+			info.DeviceID = uint16(id)
+		case "SubsystemDeviceID":
+			info.SubsystemDeviceID = uint16(id)
+		}
 	}
 
-	if err = s.config.ext.runCommand(
-		fmt.Sprintf("mkfs.ext4 %s", devPath)); err != nil {
-
-		return errors.WithMessage(err, "mkfs format")
+	if info.DeviceID == 0 {
+		return info, errors.New(
+			"firmware image metadata missing DeviceID")
 	}
 
 	return
 }
 
-func getMntParams(srv *server) (mntType string, dev string, opts string, err error) {
-	switch srv.ScmClass {
-	case scmDCPM:
-		mntType = "ext4"
-		opts = "dax"
-		if len(srv.ScmList) != 1 {
-			err = errors.New(msgScmBadDevList)
-			break
-		}
-
-		dev = srv.ScmList[0]
-		if dev == "" {
-			err = errors.New(msgScmDevEmpty)
-		}
-	case scmRAM:
-		dev = "tmpfs"
-		mntType = "tmpfs"
+// incompatibility returns a description of why the image cannot be applied
+// to the module, or an empty string if it is compatible.
+func (info fwImageInfo) incompatibility(mm ipmctl.DeviceDiscovery) string {
+	switch {
+	case info.VendorID != 0 && info.VendorID != mm.Vendor_id:
+		return fmt.Sprintf("image vendor %#x, module vendor %#x",
+			info.VendorID, mm.Vendor_id)
+	case info.DeviceID != mm.Device_id:
+		return fmt.Sprintf("image device %#x, module device %#x",
+			info.DeviceID, mm.Device_id)
+	case info.SubsystemDeviceID != 0 &&
+		info.SubsystemDeviceID != mm.Subsystem_device_id:
 
-		if srv.ScmSize >= 0 {
-			opts = "size=" + strconv.Itoa(srv.ScmSize) + "g"
-		}
-	default:
-		err = errors.New(string(srv.ScmClass) + ": " + msgScmClassNotSupported)
+		return fmt.Sprintf("image model %#x, module model %#x",
+			info.SubsystemDeviceID, mm.Subsystem_device_id)
 	}
 
-	return
+	return ""
 }
 
-// makeMount creates a mount target directory and mounts device there.
+// ValidateFirmwareImage checks the module family and model targeted by the
+// firmware image, read from metadata at imagePath+fwImageMetaSuffix, against
+// each discovered scm module.
 //
-// NOTE: requires elevated privileges
-func (s *scmStorage) makeMount(
-	devPath string, mntPoint string, mntType string, mntOpts string,
-) (err error) {
+// Incompatible modules are returned keyed by physical id with a fault
+// describing the mismatch, modules absent from the map are compatible.
+func (s *scmStorage) ValidateFirmwareImage(imagePath string) (map[uint32]error, error) {
+	text, err := s.config.ext.readFile(imagePath + fwImageMetaSuffix)
+	if err != nil {
+		return nil, errors.WithMessage(err, "read firmware image metadata")
+	}
+	info, err := parseFwImageMeta(text)
+	if err != nil {
+		return nil, err
+	}
 
-	if err = s.config.ext.mkdir(mntPoint); err != nil {
-		return
+	mms, err := s.ipmctl.Discover()
+	if err != nil {
+		return nil, errors.WithMessage(err, msgIpmctlDiscoverFail)
 	}
 
-	if err = s.config.ext.mount(devPath, mntPoint, mntType, uintptr(0), mntOpts); err != nil {
-		return
+	incompatible := make(map[uint32]error)
+	for _, mm := range mms {
+		if reason := info.incompatibility(mm); reason != "" {
+			physID := uint32(mm.Physical_id)
+			incompatible[physID] = FaultScmFirmwareIncompatible(
+				physID, imagePath, reason)
+		}
 	}
 
-	return
+	return incompatible, nil
 }
 
-// newMntRet creates and populates NVMe ctrlr result and logs error through
-// addState.
-func newMntRet(
-	op string, mntPoint string, status pb.ResponseStatus, errMsg string,
-	logDepth int) *pb.ScmMountResult {
+// Update stages the firmware image at the path in the request on each
+// discovered scm module, a result is appended for each module. No update is
+// performed if the request has no image path.
+func (s *scmStorage) Update(
+	i int, req *pb.UpdateScmReq, results *(common.ScmModuleResults)) {
 
-	return &pb.ScmMountResult{
-		Mntpoint: mntPoint,
-		State: addState(
-			status, errMsg, "", logDepth+1, "scm mount "+op),
+	if err := s.checkServerIdx(i); err != nil {
+		*results = append(
+			*results,
+			&pb.ScmModuleResult{
+				Loc: &pb.ScmModule_Location{},
+				State: addState(
+					pb.ResponseStatus_CTRL_ERR_CONF, err.Error(), "",
+					common.UtilLogDepth+1, "scm module update"),
+			})
+		return
+	}
+
+	if err := s.checkMaintenance("update"); err != nil {
+		*results = append(
+			*results,
+			&pb.ScmModuleResult{
+				Loc: &pb.ScmModule_Location{},
+				State: addState(
+					pb.ResponseStatus_CTRL_ERR_APP, err.Error(), "",
+					common.UtilLogDepth+1, "scm module update"),
+			})
+		return
+	}
+
+	if req.GetPath() == "" {
+		// respond with single result indicating no implementation
+		*results = append(
+			*results,
+			&pb.ScmModuleResult{
+				Loc: &pb.ScmModule_Location{},
+				State: addState(
+					pb.ResponseStatus_CTRL_NO_IMPL,
+					msgScmUpdateNotImpl, "",
+					common.UtilLogDepth+1, "scm module update"),
+			})
+		return
 	}
+
+	s.updateFirmware(req.GetPath(), results)
 }
 
-// Format attempts to format (forcefully) SCM mounts on a given server
-// as specified in config file and populates resp ScmMountResult.
-func (s *scmStorage) Format(i int, results *(common.ScmMountResults)) {
-	srv := s.config.Servers[i]
-	mntPoint := srv.ScmMount
-	log.Debugf("performing SCM device reset, format and mount")
+// updateFirmware loads the firmware image at fwPath on each scm module with
+// ipmctl, new firmware is activated on the next reboot if ipmctl reports that
+// activation is pending.
+//
+// The image is validated against each module first, see
+// ValidateFirmwareImage, and is not loaded on incompatible modules.
+func (s *scmStorage) updateFirmware(
+	fwPath string, results *(common.ScmModuleResults)) {
 
-	// wraps around addMret to provide format specific function
-	addMretFormat := func(status pb.ResponseStatus, errMsg string) {
+	loc := &pb.ScmModule_Location{}
+
+	// appends results to response to provide update specific function
+	addMretUpdate := func(status pb.ResponseStatus, errMsg, infoMsg string) {
 		// log depth should be stack layer registering result
 		*results = append(
 			*results,
-			newMntRet(
-				"format", mntPoint, status, errMsg,
-				common.UtilLogDepth+1))
+			&pb.ScmModuleResult{
+				Loc: loc,
+				State: addState(
+					status, errMsg, infoMsg,
+					common.UtilLogDepth+1, "scm module update"),
+			})
 	}
 
 	if !s.initialized {
-		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, msgScmNotInited)
+		addMretUpdate(pb.ResponseStatus_CTRL_ERR_SCM, msgScmNotInited, "")
 		return
 	}
 
-	if s.formatted {
-		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, msgScmAlreadyFormatted)
+	exists, err := s.config.ext.exists(fwPath)
+	if err != nil {
+		addMretUpdate(pb.ResponseStatus_CTRL_ERR_APP, err.Error(), "")
+		return
+	}
+	if !exists {
+		addMretUpdate(pb.ResponseStatus_CTRL_ERR_CONF,
+			msgScmFwImageNotFound+": "+fwPath, "")
 		return
 	}
 
-	if mntPoint == "" {
-		addMretFormat(pb.ResponseStatus_CTRL_ERR_CONF, msgScmMountEmpty)
+	if len(s.modules) == 0 {
+		addMretUpdate(pb.ResponseStatus_CTRL_ERR_SCM, msgScmNoModulesUpdate, "")
 		return
 	}
 
-	mntType, devPath, mntOpts, err := getMntParams(&srv)
+	incompatible, err := s.ValidateFirmwareImage(fwPath)
 	if err != nil {
-		addMretFormat(pb.ResponseStatus_CTRL_ERR_CONF, err.Error())
+		addMretUpdate(pb.ResponseStatus_CTRL_ERR_CONF, err.Error(), "")
 		return
 	}
 
-	switch srv.ScmClass {
-	case scmDCPM:
-		if err := s.clearMount(mntPoint); err != nil {
-			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
-			return
+	for _, mm := range s.modules {
+		loc = mm.Loc
+		if loc == nil {
+			loc = &pb.ScmModule_Location{}
 		}
 
-		log.Debugf("formatting scm device %s, should be quick!...", devPath)
-
-		if err := s.reFormat(devPath); err != nil {
-			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
-			return
+		if err, exists := incompatible[mm.Physicalid]; exists {
+			addMretUpdate(pb.ResponseStatus_CTRL_ERR_CONF, err.Error(), "")
+			continue
 		}
 
-		log.Debugf("scm format complete.\n")
-	case scmRAM:
-		if err := s.clearMount(mntPoint); err != nil {
-			addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
-			return
+		s.infof("loading firmware %s on scm module %d\n",
+			fwPath, mm.Physicalid)
+		out, err := s.execCmd(fmt.Sprintf(cmdScmLoadFirmware,
+			shellQuote(fwPath), mm.Physicalid))
+		if err != nil {
+			addMretUpdate(pb.ResponseStatus_CTRL_ERR_SCM,
+				fmt.Sprintf("module %d: %s", mm.Physicalid, err), "")
+			continue
 		}
 
-		log.Debugf("no scm_size specified in config for ram tmpfs")
-	}
-
-	log.Debugf(
-		"mounting scm device %s at %s (%s)...",
-		devPath, mntPoint, mntType)
+		if strings.Contains(strings.ToLower(out), outScmFwReboot) {
+			addMretUpdate(pb.ResponseStatus_CTRL_WAITING, "",
+				msgScmFwRebootRequired)
+			continue
+		}
 
-	if err := s.makeMount(devPath, mntPoint, mntType, mntOpts); err != nil {
-		addMretFormat(pb.ResponseStatus_CTRL_ERR_APP, err.Error())
-		return
+		addMretUpdate(pb.ResponseStatus_CTRL_SUCCESS, "", "")
 	}
-
-	log.Debugf("scm mount complete.\n")
-	addMretFormat(pb.ResponseStatus_CTRL_SUCCESS, "")
-
-	log.Debugf("SCM device reset, format and mount completed")
-	s.formatted = true
-}
-
-// Update is currently a placeholder method stubbing SCM module fw update.
-func (s *scmStorage) Update(
-	i int, req *pb.UpdateScmReq, results *(common.ScmModuleResults)) {
-
-	// respond with single result indicating no implementation
-	*results = append(
-		*results,
-		&pb.ScmModuleResult{
-			Loc: &pb.ScmModule_Location{},
-			State: addState(
-				pb.ResponseStatus_CTRL_NO_IMPL,
-				msgScmUpdateNotImpl, "",
-				common.UtilLogDepth+1, "scm module update"),
-		})
 }
 
 // newScmStorage creates a new instance of ScmStorage struct.
 //
 // NvmMgmt is the implementation of ipmctl interface in go-ipmctl
 func newScmStorage(config *configuration) *scmStorage {
-	return &scmStorage{
-		ipmctl: &ipmctl.NvmMgmt{},
-		config: config,
-		runCmd: run,
+	s := &scmStorage{
+		ipmctl:    &ipmctl.NvmMgmt{},
+		config:    config,
+		runCmd:    run,
+		runCmdCtx: runCtx,
 	}
+
+	return s.withRegionCache(config.ScmRegionTTL).
+		withCmdTimeout(config.ScmCmdTimeout)
 }
@@ -0,0 +1,181 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+//
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/daos-stack/daos/src/control/log"
+)
+
+const (
+	msgScmBurnInNotMounted = "scm burn-in requires mounted scm"
+	msgScmBurnInBadParam   = "invalid scm burn-in parameter"
+
+	// default fio job parameters for scm burn-in
+	scmBurnInRW        = "randwrite"
+	scmBurnInBlockSize = "4k"
+	scmBurnInSize      = "1g"
+	scmBurnInRuntime   = 60 * time.Second
+	scmBurnInJobs      = 1
+)
+
+// fioSizeRegexp matches fio sizes in bytes with an optional binary unit.
+var fioSizeRegexp = regexp.MustCompile(`^[1-9][0-9]*[kKmMgG]?$`)
+
+// fioRWModes are the I/O patterns accepted for scm burn-in.
+var fioRWModes = map[string]bool{
+	"read": true, "write": true, "rw": true,
+	"randread": true, "randwrite": true, "randrw": true,
+}
+
+// ScmBurnInReq overrides the fio job parameters of scm burn-in, zero values
+// select defaults.
+type ScmBurnInReq struct {
+	RW        string        // I/O pattern, randwrite if unset
+	BlockSize string        // e.g. "4k"
+	Size      string        // file size per job, e.g. "1g"
+	Runtime   time.Duration // rounded down to whole seconds
+	NumJobs   int
+}
+
+// withDefaults returns the request with unset parameters set to defaults.
+func (req ScmBurnInReq) withDefaults() ScmBurnInReq {
+	if req.RW == "" {
+		req.RW = scmBurnInRW
+	}
+	if req.BlockSize == "" {
+		req.BlockSize = scmBurnInBlockSize
+	}
+	if req.Size == "" {
+		req.Size = scmBurnInSize
+	}
+	if req.Runtime == 0 {
+		req.Runtime = scmBurnInRuntime
+	}
+	if req.NumJobs == 0 {
+		req.NumJobs = scmBurnInJobs
+	}
+
+	return req
+}
+
+// validate verifies parameters can be safely passed to fio.
+func (req ScmBurnInReq) validate() error {
+	switch {
+	case !fioRWModes[req.RW]:
+		return errors.Errorf("%s: rw %q", msgScmBurnInBadParam, req.RW)
+	case !fioSizeRegexp.MatchString(req.BlockSize):
+		return errors.Errorf("%s: block size %q", msgScmBurnInBadParam,
+			req.BlockSize)
+	case !fioSizeRegexp.MatchString(req.Size):
+		return errors.Errorf("%s: size %q", msgScmBurnInBadParam, req.Size)
+	case req.Runtime < time.Second:
+		return errors.Errorf("%s: runtime %s", msgScmBurnInBadParam,
+			req.Runtime)
+	case req.NumJobs < 1:
+		return errors.Errorf("%s: jobs %d", msgScmBurnInBadParam,
+			req.NumJobs)
+	}
+
+	return nil
+}
+
+// BurnIn method implementation for scmStorage
+// Doesn't run fio, returns cmds to be issued over shell
+//
+// Files are written by fio to the scm mount points of the io_server with the
+// given index, rather than to the devices, so that the filesystem created by
+// format is preserved.
+func (s *scmStorage) BurnIn(srvIdx int, req ScmBurnInReq) (
+	fioPath string, cmds []string, env string, err error) {
+
+	if srvIdx < 0 || srvIdx >= len(s.config.Servers) {
+		err = errors.Errorf("scm burn-in: no io_server with index %d", srvIdx)
+		return
+	}
+	srv := s.config.Servers[srvIdx]
+
+	req = req.withDefaults()
+	if err = req.validate(); err != nil {
+		return
+	}
+
+	params, err := getDevMntParams(&srv)
+	if err != nil {
+		return
+	}
+
+	mntPoints := make([]string, 0, len(params))
+	for _, p := range params {
+		mounted, mntErr := s.config.ext.isMountPoint(p.mntPoint)
+		if mntErr != nil {
+			err = errors.WithMessage(mntErr, "scm burn-in")
+			return
+		}
+		if !mounted {
+			err = errors.Errorf("%s: %s", msgScmBurnInNotMounted,
+				p.mntPoint)
+			return
+		}
+		mntPoints = append(mntPoints, p.mntPoint)
+	}
+
+	fioPath, err = s.config.ext.getAbsInstallPath(fioExecPath)
+	if err != nil {
+		return
+	}
+
+	// tmpfs doesn't support O_DIRECT, dax mounts bypass the page cache
+	direct := 1
+	if srv.ScmClass == scmRAM {
+		direct = 0
+	}
+
+	// eta options provided to trigger periodic client responses, fio
+	// spreads files over colon separated directories.
+	cmds = []string{
+		"--name=daos_scm_burnin",
+		"--directory=" + strings.Join(mntPoints, ":"),
+		"--rw=" + req.RW,
+		"--bs=" + req.BlockSize,
+		"--size=" + req.Size,
+		fmt.Sprintf("--numjobs=%d", req.NumJobs),
+		fmt.Sprintf("--direct=%d", direct),
+		"--time_based",
+		fmt.Sprintf("--runtime=%d", int(req.Runtime/time.Second)),
+		"--eta=always",
+		"--eta-newline=10",
+	}
+	log.Debugf(
+		"BurnIn command string: %s %s %v", env, fioPath, cmds)
+
+	return
+}
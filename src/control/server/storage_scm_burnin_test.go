@@ -0,0 +1,180 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+//
+
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/daos-stack/daos/src/control/common"
+)
+
+func TestBurnInScm(t *testing.T) {
+	defaultArgs := func(dir string, direct int) []string {
+		return []string{
+			"--name=daos_scm_burnin",
+			"--directory=" + dir,
+			"--rw=randwrite",
+			"--bs=4k",
+			"--size=1g",
+			"--numjobs=1",
+			fmt.Sprintf("--direct=%d", direct),
+			"--time_based",
+			"--runtime=60",
+			"--eta=always",
+			"--eta-newline=10",
+		}
+	}
+
+	tests := []struct {
+		desc      string
+		class     ScmClass
+		devs      []string
+		mntList   []string
+		notMnted  bool
+		req       ScmBurnInReq
+		errMsg    string
+		expArgs   []string
+		expMounts []string // mount points checked
+	}{
+		{
+			desc:      "dcpm defaults",
+			class:     scmDCPM,
+			devs:      []string{"/dev/pmem0"},
+			expArgs:   defaultArgs("/mnt/daos", 1),
+			expMounts: []string{"/mnt/daos"},
+		},
+		{
+			desc:      "ram defaults",
+			class:     scmRAM,
+			expArgs:   defaultArgs("/mnt/daos", 0),
+			expMounts: []string{"/mnt/daos"},
+		},
+		{
+			desc:      "multiple dcpm devices",
+			class:     scmDCPM,
+			devs:      []string{"/dev/pmem0", "/dev/pmem1"},
+			mntList:   []string{"/mnt/daos0", "/mnt/daos1"},
+			expArgs:   defaultArgs("/mnt/daos0:/mnt/daos1", 1),
+			expMounts: []string{"/mnt/daos0", "/mnt/daos1"},
+		},
+		{
+			desc:  "overridden parameters",
+			class: scmDCPM,
+			devs:  []string{"/dev/pmem0"},
+			req: ScmBurnInReq{
+				RW:        "randrw",
+				BlockSize: "2m",
+				Size:      "16g",
+				Runtime:   10 * time.Minute,
+				NumJobs:   4,
+			},
+			expArgs: []string{
+				"--name=daos_scm_burnin",
+				"--directory=/mnt/daos",
+				"--rw=randrw",
+				"--bs=2m",
+				"--size=16g",
+				"--numjobs=4",
+				"--direct=1",
+				"--time_based",
+				"--runtime=600",
+				"--eta=always",
+				"--eta-newline=10",
+			},
+			expMounts: []string{"/mnt/daos"},
+		},
+		{
+			desc:     "not mounted",
+			class:    scmDCPM,
+			devs:     []string{"/dev/pmem0"},
+			notMnted: true,
+			errMsg:   msgScmBurnInNotMounted + ": /mnt/daos",
+		},
+		{
+			desc:   "bad device",
+			class:  scmDCPM,
+			devs:   []string{"/dev/nmem0"},
+			errMsg: FaultScmNotPmemNamespace("/dev/nmem0").Error(),
+		},
+		{
+			desc:   "bad rw",
+			class:  scmDCPM,
+			devs:   []string{"/dev/pmem0"},
+			req:    ScmBurnInReq{RW: "trim; reboot"},
+			errMsg: msgScmBurnInBadParam + `: rw "trim; reboot"`,
+		},
+		{
+			desc:   "bad size",
+			class:  scmDCPM,
+			devs:   []string{"/dev/pmem0"},
+			req:    ScmBurnInReq{Size: "1g --filename=/dev/pmem0"},
+			errMsg: msgScmBurnInBadParam + `: size "1g --filename=/dev/pmem0"`,
+		},
+		{
+			desc:   "runtime too short",
+			class:  scmDCPM,
+			devs:   []string{"/dev/pmem0"},
+			req:    ScmBurnInReq{Runtime: time.Millisecond},
+			errMsg: msgScmBurnInBadParam + ": runtime 1ms",
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", tt.class, tt.devs, 16, bdNVMe,
+			[]string{}, false)
+		config.Servers[0].ScmMountList = tt.mntList
+		config.ext.(*mockExt).isMountPointRet = !tt.notMnted
+		ss := defaultMockScmStorage(config)
+
+		fioPath, args, env, err := ss.BurnIn(0, tt.req)
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, fioPath, fioExecPath, tt.desc+": unexpected fio path")
+		AssertEqual(t, args, tt.expArgs, tt.desc+": unexpected arguments")
+		AssertEqual(t, env, "", tt.desc+": unexpected environment")
+
+		var expHistory []string
+		for _, mnt := range tt.expMounts {
+			expHistory = append(expHistory, fmt.Sprintf(msgIsMountPoint, mnt))
+		}
+		AssertEqual(t, config.ext.getHistory(), expHistory,
+			tt.desc+": unexpected mount checks")
+	}
+
+	config := defaultMockConfig(t)
+	if _, _, _, err := defaultMockScmStorage(&config).BurnIn(
+		len(config.Servers), ScmBurnInReq{}); err == nil {
+		t.Fatal("expected error for unknown io_server")
+	}
+}
@@ -0,0 +1,148 @@
+//
+// (C) Copyright 2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/daos-stack/daos/src/control/common"
+)
+
+// sections of the scm diagnostic bundle
+const (
+	scmDiagModules    = "modules"
+	scmDiagRegions    = "regions"
+	scmDiagNamespaces = "namespaces"
+	scmDiagMounts     = "mounts"
+
+	// number of most recent external commands retained for diagnostics
+	maxScmCmdTrail = 64
+)
+
+// scmCmdTrail retains the most recent external commands run.
+//
+// The zero value is ready for use.
+type scmCmdTrail struct {
+	sync.Mutex
+	cmds []string // most recent last
+}
+
+func (t *scmCmdTrail) add(cmd string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.cmds = append(t.cmds, cmd)
+	if len(t.cmds) > maxScmCmdTrail {
+		t.cmds = t.cmds[len(t.cmds)-maxScmCmdTrail:]
+	}
+}
+
+func (t *scmCmdTrail) list() []string {
+	t.Lock()
+	defer t.Unlock()
+
+	return append([]string{}, t.cmds...)
+}
+
+// scmDiagRegion is the serializable form of an scmRegion.
+type scmDiagRegion struct {
+	ISetID          string  `json:"iset_id"`
+	MemoryType      string  `json:"memory_type"`
+	Capacity        scmSize `json:"capacity"`
+	FreeCapacity    scmSize `json:"free_capacity"`
+	HealthState     string  `json:"health_state"`
+	InterleaveWidth int     `json:"interleave_width,omitempty"`
+}
+
+// scmDiagnostics is a serializable snapshot of everything known about scm
+// on this server, intended to be attached to support tickets.
+//
+// Sections that could not be gathered are omitted and the reason recorded
+// in Errors keyed by section name.
+type scmDiagnostics struct {
+	Modules    common.ScmModules   `json:"modules,omitempty"`
+	State      string              `json:"state"`
+	Regions    []scmDiagRegion     `json:"regions,omitempty"`
+	Namespaces []pmemDev           `json:"namespaces,omitempty"`
+	Mounts     map[string][]string `json:"mounts,omitempty"`
+	Faults     []scmEvent          `json:"recent_faults,omitempty"`
+	Commands   []string            `json:"commands,omitempty"`
+	Operations []string            `json:"operations,omitempty"`
+	Timings    []scmStepTiming     `json:"timings,omitempty"`
+	Errors     map[string]string   `json:"errors,omitempty"`
+}
+
+// DiagnosticBundle queries modules, regions, namespaces and mounts and
+// combines them with recent faults and the trail of external commands and
+// operations performed into a single diagnostic snapshot.
+//
+// Failure to gather one section does not prevent the others being gathered.
+func (s *scmStorage) DiagnosticBundle() *scmDiagnostics {
+	diag := &scmDiagnostics{
+		State:  scmStateUnknown.String(),
+		Errors: make(map[string]string),
+	}
+
+	if mms, err := s.ipmctl.Discover(); err != nil {
+		diag.Errors[scmDiagModules] = err.Error()
+	} else {
+		diag.Modules = loadModules(mms)
+	}
+
+	if state, regions, err := s.RefreshState(); err != nil {
+		diag.Errors[scmDiagRegions] = err.Error()
+	} else {
+		diag.State = state.String()
+		for _, r := range regions {
+			diag.Regions = append(diag.Regions, scmDiagRegion{
+				ISetID:          r.iSetID,
+				MemoryType:      r.memType,
+				Capacity:        scmSizeFromGiB(r.capacity),
+				FreeCapacity:    scmSizeFromGiB(r.freeCapacity),
+				HealthState:     r.healthState,
+				InterleaveWidth: r.width,
+			})
+		}
+	}
+
+	if devs, err := s.getNamespaces(context.Background()); err != nil {
+		diag.Errors[scmDiagNamespaces] = err.Error()
+	} else {
+		diag.Namespaces = devs
+	}
+
+	if mounts, err := s.config.ext.mounts(); err != nil {
+		diag.Errors[scmDiagMounts] = err.Error()
+	} else {
+		diag.Mounts = mounts
+	}
+
+	diag.Faults = s.events.recentErrors()
+	diag.Timings = s.StepTimings()
+	diag.Commands = s.cmdTrail.list()
+	diag.Operations = s.config.ext.getHistory()
+
+	return diag
+}
@@ -0,0 +1,126 @@
+//
+// (C) Copyright 2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	. "github.com/daos-stack/daos/src/control/common"
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
+	. "github.com/daos-stack/go-ipmctl/ipmctl"
+)
+
+func TestDiagnosticBundle(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=3012.0 GiB\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"   HealthState=Healthy\n" +
+		"\n"
+	pmemOut := `{"blockdev":"pmem0","name":"daos_io_server_0","numa_node":0}`
+
+	tests := []struct {
+		desc      string
+		nsErr     error
+		expErrors map[string]string
+	}{
+		{
+			desc:      "all sections",
+			expErrors: map[string]string{},
+		},
+		{
+			desc:  "namespace query fails",
+			nsErr: errors.New("ndctl not found"),
+			expErrors: map[string]string{
+				scmDiagNamespaces: "ndctl not found",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		config.ext.(*mockExt).mountsRet = map[string][]string{
+			"/dev/pmem0": {"/mnt/daos"},
+		}
+		ss := defaultMockScmStorage(&config).withRunCmd(
+			func(cmd string) (string, error) {
+				switch cmd {
+				case cmdScmShowRegions:
+					return regionsOut, nil
+				case cmdScmListNamespaces:
+					return pmemOut, tt.nsErr
+				}
+				return "", errors.Errorf("unexpected command %q", cmd)
+			})
+		ss.Discover(new(pb.ScanStorageResp)) // not concerned with response
+		ss.events.emit(scmEvent{
+			Op: scmOpFormat, Type: scmEventError, Message: "format failed",
+		})
+
+		diag := ss.DiagnosticBundle()
+
+		AssertEqual(t, diag.Errors, tt.expErrors, tt.desc+": unexpected errors")
+		AssertEqual(t, diag.Modules, loadModules([]DeviceDiscovery{MockModule()}),
+			tt.desc+": unexpected modules")
+		AssertEqual(t, diag.State, scmStateNoCapacity.String(),
+			tt.desc+": unexpected state")
+		AssertEqual(t, diag.Regions, []scmDiagRegion{
+			{
+				ISetID:       "0x2aba7f4828ef2ccc",
+				MemoryType:   "AppDirect",
+				Capacity:     newScmSize(3012 << 30),
+				FreeCapacity: newScmSize(0),
+				HealthState:  "Healthy",
+			},
+		}, tt.desc+": unexpected regions")
+		if tt.nsErr == nil {
+			AssertEqual(t, diag.Namespaces, mockPmemDevs(t, pmemOut),
+				tt.desc+": unexpected namespaces")
+		} else {
+			AssertEqual(t, len(diag.Namespaces), 0,
+				tt.desc+": unexpected namespaces")
+		}
+		AssertEqual(t, diag.Mounts, config.ext.(*mockExt).mountsRet,
+			tt.desc+": unexpected mounts")
+		AssertEqual(t, diag.Faults, []scmEvent{
+			{Op: scmOpFormat, Type: scmEventError, Message: "format failed"},
+		}, tt.desc+": unexpected recent faults")
+		AssertEqual(t, diag.Commands,
+			[]string{
+				cmdScmListNamespaces, // on discovery
+				cmdScmShowRegions, cmdScmListNamespaces,
+			},
+			tt.desc+": unexpected command trail")
+		AssertEqual(t, diag.Operations, []string{msgMounts},
+			tt.desc+": unexpected operations")
+
+		if _, err := json.Marshal(diag); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daos-stack/daos/src/control/common"
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
+)
+
+const msgScmDryRun = "dry run, no changes made"
+
+// withDryRun sets whether Prep and Format only plan the commands that would
+// provision scm, without running them.
+//
+// Read-only queries are still run so that planned commands reflect the
+// current state of scm.
+func (s *scmStorage) withDryRun(dryRun bool) *scmStorage {
+	s.dryRun = dryRun
+
+	return s
+}
+
+// DryRunPlan returns the commands planned by the last Prep or Format in dry
+// run mode, in order.
+func (s *scmStorage) DryRunPlan() []string {
+	return append([]string{}, s.plan...)
+}
+
+// planCmd records a command that would be run if not in dry run mode.
+func (s *scmStorage) planCmd(cmd string) {
+	s.infof("dry run, would run: %s\n", scrubSecrets(cmd))
+	s.plan = append(s.plan, scrubSecrets(cmd))
+}
+
+// planNamespaces plans the creation of a namespace in each region with free
+// capacity, returning the pmem devices predicted to be created.
+//
+// Block device names are assigned by the kernel on creation so are not
+// predicted.
+func (s *scmStorage) planNamespaces() (devs []pmemDev) {
+	for _, region := range s.regions {
+		if !region.hasFreeCapacity() {
+			continue
+		}
+
+		name := pmemName(len(devs))
+		s.planCmd(createNamespaceCmd("", name, 0, s.config.ScmNdctlFlags))
+		devs = append(devs, pmemDev{Name: name, NumaNode: region.socketID})
+	}
+
+	return
+}
+
+// planFormat plans the format and mount of each scm device of a server,
+// appending a result for each device.
+func (s *scmStorage) planFormat(
+	srv *server, params []mntParams, action scmFormatAction,
+	results *(common.ScmMountResults)) {
+
+	for _, p := range params {
+		if srv.ScmClass == scmDCPM && action == scmFormatReformat {
+			mkfs := s.mkfsParams(p.devPath, srv)
+			mkfsCmd := mkfs.command()
+			if args := mkfs.args(); args != "" {
+				mkfsCmd += " " + args
+			}
+
+			s.planCmd(fmt.Sprintf("wipefs -a %s", p.devPath))
+			s.planCmd(fmt.Sprintf("%s %s", mkfsCmd, p.devPath))
+		}
+		if action != scmFormatNone {
+			mount := []string{"mount", "-t", p.mntType}
+			if p.opts != "" {
+				mount = append(mount, "-o", p.opts)
+			}
+			s.planCmd(strings.Join(
+				append(mount, p.devPath, p.mntPoint), " "))
+		}
+
+		*results = append(
+			*results,
+			newMntRet(
+				"format", p.mntPoint, pb.ResponseStatus_CTRL_SUCCESS,
+				"", msgScmDryRun, common.UtilLogDepth+1))
+	}
+}
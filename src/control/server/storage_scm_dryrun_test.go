@@ -0,0 +1,186 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	. "github.com/daos-stack/daos/src/control/common"
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
+)
+
+func TestPrepDryRun(t *testing.T) {
+	tests := []struct {
+		desc           string
+		free           []bool
+		expPlan        []string
+		expNeedsReboot bool
+		expPmemDevs    []pmemDev
+	}{
+		{
+			desc:           "no regions",
+			expPlan:        []string{cmdScmCreateRegions},
+			expNeedsReboot: true,
+		},
+		{
+			desc: "free capacity",
+			free: []bool{true, true},
+			expPlan: []string{
+				cmdScmCreateNamespace + " -n " + pmemName(0),
+				cmdScmCreateNamespace + " -n " + pmemName(1),
+			},
+			expPmemDevs: []pmemDev{
+				{Name: pmemName(0)},
+				{Name: pmemName(1)},
+			},
+		},
+		{
+			desc: "partial capacity",
+			free: []bool{false, true},
+			expPlan: []string{
+				cmdScmCreateNamespace + " -n " + pmemName(0),
+			},
+			expPmemDevs: []pmemDev{{Name: pmemName(0)}},
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			if in == cmdScmShowRegions {
+				return mockRegionsOut(tt.free), nil
+			}
+			return "", nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun).withDryRun(true)
+
+		result, err := ss.Prep(context.Background())
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, commands, []string{cmdScmShowRegions},
+			tt.desc+": unexpected commands run")
+		AssertEqual(t, ss.DryRunPlan(), tt.expPlan, tt.desc+": unexpected plan")
+		AssertEqual(t, result.NeedsReboot, tt.expNeedsReboot,
+			tt.desc+": unexpected value for is reboot required")
+		AssertEqual(t, result.Namespaces, tt.expPmemDevs,
+			tt.desc+": unexpected predicted pmem devices")
+		AssertEqual(t, len(config.ext.(*mockExt).getHistory()), 0,
+			tt.desc+": unexpected system calls")
+	}
+}
+
+func TestFormatDryRun(t *testing.T) {
+	tests := []struct {
+		desc    string
+		class   ScmClass
+		devs    []string
+		size    int
+		expPlan []string
+		expMnts []string
+	}{
+		{
+			desc:  "dcpm",
+			class: scmDCPM,
+			devs:  []string{"/dev/pmem0"},
+			expPlan: []string{
+				"wipefs -a /dev/pmem0",
+				"mkfs.ext4 -E nodiscard /dev/pmem0",
+				"mount -t ext4 -o dax /dev/pmem0 /mnt/daos",
+			},
+			expMnts: []string{"/mnt/daos"},
+		},
+		{
+			desc:  "multiple dcpm devices",
+			class: scmDCPM,
+			devs:  []string{"/dev/pmem0", "/dev/pmem1"},
+			expPlan: []string{
+				"wipefs -a /dev/pmem0",
+				"mkfs.ext4 -E nodiscard /dev/pmem0",
+				"mount -t ext4 -o dax /dev/pmem0 /mnt/daos/0",
+				"wipefs -a /dev/pmem1",
+				"mkfs.ext4 -E nodiscard /dev/pmem1",
+				"mount -t ext4 -o dax /dev/pmem1 /mnt/daos/1",
+			},
+			expMnts: []string{"/mnt/daos/0", "/mnt/daos/1"},
+		},
+		{
+			desc:  "ram",
+			class: scmRAM,
+			size:  16,
+			expPlan: []string{
+				"mount -t tmpfs -o size=16g tmpfs /mnt/daos",
+			},
+			expMnts: []string{"/mnt/daos"},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", tt.class, tt.devs, tt.size, bdNVMe,
+			[]string{}, false)
+		confirmed := false
+		ss := defaultMockScmStorage(config).
+			withDryRun(true).
+			withConfirm(func(string) (bool, error) {
+				confirmed = true
+				return true, nil
+			})
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, ss.DryRunPlan(), tt.expPlan, tt.desc+": unexpected plan")
+		AssertEqual(t, len(results), len(tt.expMnts),
+			tt.desc+": unexpected number of results")
+		for i, result := range results {
+			AssertEqual(t, result.Mntpoint, tt.expMnts[i],
+				tt.desc+": unexpected mount point")
+			AssertEqual(t, result.State.Status, pb.ResponseStatus_CTRL_SUCCESS,
+				tt.desc+": unexpected status")
+			AssertEqual(t, result.State.Info, msgScmDryRun,
+				tt.desc+": unexpected info")
+		}
+		AssertEqual(t, confirmed, false, tt.desc+": confirmation requested")
+		AssertEqual(t, ss.formatted, false, tt.desc+": unexpected formatted state")
+
+		for _, call := range config.ext.getHistory() {
+			for _, prefix := range []string{
+				"cmd:", "syscall: mount", "syscall: calling unmount",
+				"os: removeall", "os: mkdirall",
+			} {
+				if strings.HasPrefix(call, prefix) {
+					t.Fatalf("%s: unexpected system call %q", tt.desc, call)
+				}
+			}
+		}
+	}
+}
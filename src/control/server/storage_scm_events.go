@@ -0,0 +1,134 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/daos-stack/daos/src/control/log"
+)
+
+type scmEventType string
+
+const (
+	scmEventState          scmEventType = "state"
+	scmEventRebootRequired scmEventType = "reboot_required"
+	scmEventDeviceCreated  scmEventType = "device_created"
+	scmEventFormatPhase    scmEventType = "format_phase"
+	scmEventFormatted      scmEventType = "formatted"
+	scmEventError          scmEventType = "error"
+
+	scmOpPrep   = "prep"
+	scmOpFormat = "format"
+
+	scmStepCreateRegions    = "create regions"
+	scmStepCreateNamespaces = "create namespaces"
+	scmStepReformat         = "reformat"
+	scmStepMount            = "mount"
+
+	// number of most recent error events retained for diagnostics
+	maxRecentScmErrors = 16
+)
+
+// scmEvent is a single event emitted during a long running scm operation.
+type scmEvent struct {
+	Op      string       `json:"op"`
+	Type    scmEventType `json:"type"`
+	State   string       `json:"state,omitempty"`
+	Device  string       `json:"device,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// scmEvents writes events as newline-delimited JSON to an optional writer
+// and retains the most recent error events.
+//
+// The zero value is ready for use and emits nothing.
+type scmEvents struct {
+	sync.Mutex
+	w      io.Writer
+	errors []scmEvent // most recent last
+}
+
+// emit writes the event to the stream if one is set. Failure to write is
+// logged but does not affect the operation being reported.
+func (e *scmEvents) emit(ev scmEvent) {
+	e.Lock()
+	defer e.Unlock()
+
+	if ev.Type == scmEventError {
+		e.errors = append(e.errors, ev)
+		if len(e.errors) > maxRecentScmErrors {
+			e.errors = e.errors[len(e.errors)-maxRecentScmErrors:]
+		}
+	}
+
+	if e.w == nil {
+		return
+	}
+
+	if err := json.NewEncoder(e.w).Encode(ev); err != nil {
+		log.Debugf("failed to write scm event: %s", err)
+	}
+}
+
+// recentErrors returns a copy of the most recently emitted error events,
+// oldest first.
+func (e *scmEvents) recentErrors() []scmEvent {
+	e.Lock()
+	defer e.Unlock()
+
+	return append([]scmEvent{}, e.errors...)
+}
+
+// scmStepTiming records how long a single step of an scm operation took.
+type scmStepTiming struct {
+	Op      string        `json:"op"`
+	Step    string        `json:"step"`
+	Device  string        `json:"device,omitempty"`
+	Elapsed time.Duration `json:"elapsed_ns"`
+}
+
+// scmTimings retains the timing of each step performed, safe for concurrent
+// use by parallel device formats.
+type scmTimings struct {
+	sync.Mutex
+	steps []scmStepTiming
+}
+
+func (t *scmTimings) add(st scmStepTiming) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.steps = append(t.steps, st)
+}
+
+func (t *scmTimings) list() []scmStepTiming {
+	t.Lock()
+	defer t.Unlock()
+
+	return append([]scmStepTiming{}, t.steps...)
+}
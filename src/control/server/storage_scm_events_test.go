@@ -0,0 +1,138 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/daos-stack/daos/src/control/common"
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
+)
+
+func TestScmEventStream(t *testing.T) {
+	regionOut := func(free1, free2 string) string {
+		return "\n" +
+			"---ISetID=0x2aba7f4828ef2ccc---\n" +
+			"   PersistentMemoryType=AppDirect\n" +
+			"   FreeCapacity=" + free1 + "\n" +
+			"---ISetID=0x81187f4881f02ccc---\n" +
+			"   PersistentMemoryType=AppDirect\n" +
+			"   FreeCapacity=" + free2 + "\n" +
+			"\n"
+	}
+
+	tests := []struct {
+		desc      string
+		stream    bool
+		expEvents string
+	}{
+		{
+			desc: "no event stream",
+		},
+		{
+			desc:   "full prep",
+			stream: true,
+			expEvents: `{"op":"prep","type":"state","state":"scmStateFreeCapacity"}` + "\n" +
+				`{"op":"prep","type":"device_created","device":"pmem0","message":"daos_io_server_0"}` + "\n" +
+				`{"op":"prep","type":"device_created","device":"pmem1","message":"daos_io_server_1"}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		created := 0
+		mockRun := func(in string) (string, error) {
+			switch {
+			case in == cmdScmShowRegions:
+				free := []string{"3012.0 GiB", "3012.0 GiB"}
+				for i := 0; i < created; i++ {
+					free[i] = "0.0 GiB"
+				}
+				return regionOut(free[0], free[1]), nil
+			case strings.HasPrefix(in, cmdScmCreateNamespace):
+				created++
+				return fmt.Sprintf(`{"blockdev":"pmem%d","numa_node":%d}`,
+					created-1, created-1), nil
+			}
+			return "", nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		buf := new(bytes.Buffer)
+		if tt.stream {
+			ss.withEventStream(buf)
+		}
+
+		if _, err := ss.Prep(context.Background()); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, buf.String(), tt.expEvents, tt.desc+": unexpected event stream")
+	}
+}
+
+func TestScmStepTimings(t *testing.T) {
+	delay := 20 * time.Millisecond
+
+	config := newMockStorageConfig(
+		nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+		[]string{"/dev/pmem0"}, 0, bdNVMe, []string{}, false)
+	ss := defaultMockScmStorage(config).withRunCmd(func(cmd string) (string, error) {
+		if strings.HasPrefix(cmd, "wipefs") {
+			time.Sleep(delay) // slow reformat
+		}
+		return "", nil
+	})
+	ss.Discover(new(pb.ScanStorageResp))
+
+	results := ScmMountResults{}
+	ss.Format(0, &results)
+	AssertEqual(t, results[0].State.Error, "", "unexpected format error")
+
+	timings := ss.StepTimings()
+	AssertEqual(t, len(timings), 2, "unexpected number of step timings")
+
+	reformat := timings[0]
+	AssertEqual(t, reformat.Op, scmOpFormat, "unexpected op")
+	AssertEqual(t, reformat.Step, scmStepReformat, "unexpected step")
+	AssertEqual(t, reformat.Device, "/dev/pmem0", "unexpected device")
+	if reformat.Elapsed < delay || reformat.Elapsed > time.Minute {
+		t.Fatalf("implausible reformat duration %s", reformat.Elapsed)
+	}
+
+	AssertEqual(t, timings[1].Step, scmStepMount, "unexpected step")
+	if timings[1].Elapsed > reformat.Elapsed {
+		t.Fatalf("mount (%s) should be quicker than slow reformat (%s)",
+			timings[1].Elapsed, reformat.Elapsed)
+	}
+
+	AssertEqual(t, ss.DiagnosticBundle().Timings[:2], timings,
+		"diagnostics should include step timings")
+}
@@ -0,0 +1,88 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/daos-stack/go-ipmctl/ipmctl"
+)
+
+// ipmctlOps extends the libipmctl bindings with the SCM module queries made
+// by scmStorage.
+type ipmctlOps interface {
+	ipmctl.IpmCtl
+	// GetSensors returns sensor readings of each module.
+	GetSensors() ([]scmSensors, error)
+	// GetErrorLog returns entries of the given error log type e.g.
+	// "Thermal" or "Media", modules without entries are omitted.
+	GetErrorLog(logType string) ([]scmErrorLogEntry, error)
+	// GetRegions returns the pmem regions (interleave sets), none if no
+	// regions are defined.
+	GetRegions() ([]scmRegion, error)
+}
+
+// cliIpmctl implements ipmctlOps by discovering modules through the libipmctl
+// bindings and running the ipmctl command line tool for the other queries.
+//
+// TODO: use libipmctl for all queries once the go-ipmctl bindings provide
+// them, they currently only provide module discovery.
+type cliIpmctl struct {
+	ipmctl.IpmCtl
+	runCmd runCmdFn
+}
+
+func (c *cliIpmctl) GetSensors() ([]scmSensors, error) {
+	out, err := c.runCmd(cmdScmShowSensors)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ipmctl show sensors")
+	}
+
+	return parseSensors(out), nil
+}
+
+func (c *cliIpmctl) GetErrorLog(logType string) ([]scmErrorLogEntry, error) {
+	out, err := c.runCmd(fmt.Sprintf(cmdScmShowErrorLog, logType))
+	if err != nil {
+		return nil, errors.WithMessagef(err,
+			"ipmctl show %s error log", logType)
+	}
+
+	return parseErrorLog(logType, out)
+}
+
+func (c *cliIpmctl) GetRegions() ([]scmRegion, error) {
+	out, err := c.runCmd(cmdScmShowRegions)
+	if err != nil {
+		return nil, err
+	}
+
+	if out == outScmNoRegions {
+		return nil, nil
+	}
+
+	return parseRegions(out)
+}
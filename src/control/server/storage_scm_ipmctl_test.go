@@ -0,0 +1,231 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	. "github.com/daos-stack/daos/src/control/common"
+)
+
+// mockIpmctlOps is an in-memory ipmctlOps backend.
+type mockIpmctlOps struct {
+	mockIpmctl
+	sensors    []scmSensors
+	sensorsErr error
+	errorLogs  map[string][]scmErrorLogEntry // keyed by log type
+	logErr     error
+	regions    []scmRegion
+	regionsErr error
+}
+
+func (m *mockIpmctlOps) GetSensors() ([]scmSensors, error) {
+	return m.sensors, m.sensorsErr
+}
+
+func (m *mockIpmctlOps) GetErrorLog(logType string) ([]scmErrorLogEntry, error) {
+	return m.errorLogs[logType], m.logErr
+}
+
+func (m *mockIpmctlOps) GetRegions() ([]scmRegion, error) {
+	return m.regions, m.regionsErr
+}
+
+func TestGetSensorsMockIpmctl(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	sensorsErr := errors.New("nvm_get_sensors failed")
+
+	tests := []struct {
+		desc       string
+		sensors    []scmSensors
+		sensorsErr error
+		expErr     error
+	}{
+		{
+			desc: "sensor readings",
+			sensors: []scmSensors{
+				{
+					DimmID:          "0x0001",
+					MediaTempC:      intPtr(35),
+					ControllerTempC: intPtr(40),
+					PowerOnSecs:     intPtr(2045),
+				},
+				{DimmID: "0x0101", MediaTempC: intPtr(36)},
+			},
+		},
+		{
+			desc:       "query failure",
+			sensorsErr: sensorsErr,
+			expErr:     sensorsErr,
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+			return "", errors.Errorf("unexpected command %q", cmd)
+		})
+		ss.ipmctl = &mockIpmctlOps{
+			sensors: tt.sensors, sensorsErr: tt.sensorsErr,
+		}
+
+		sensors, err := ss.GetSensors()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, sensors, tt.sensors, tt.desc+": unexpected sensor readings")
+	}
+}
+
+func TestGetErrorLogMockIpmctl(t *testing.T) {
+	logErr := errors.New("nvm_get_error_log failed")
+	thermal := scmErrorLogEntry{
+		DimmID: "0x0001", Type: "Thermal", Timestamp: 1527267471,
+		Details: map[string]string{"Temperature": "86C"},
+	}
+	media := scmErrorLogEntry{
+		DimmID: "0x0101", Type: "Media", Timestamp: 1527266471,
+		Details: map[string]string{"DPA": "0x000014c0"},
+	}
+
+	tests := []struct {
+		desc      string
+		errorLogs map[string][]scmErrorLogEntry
+		logErr    error
+		expLogs   map[string][]scmErrorLogEntry
+		expErr    error
+	}{
+		{
+			desc: "entries of each log type",
+			errorLogs: map[string][]scmErrorLogEntry{
+				"Thermal": {thermal},
+				"Media":   {media},
+			},
+			expLogs: map[string][]scmErrorLogEntry{
+				"0x0001": {thermal},
+				"0x0101": {media},
+			},
+		},
+		{
+			desc:    "no log entries",
+			expLogs: map[string][]scmErrorLogEntry{},
+		},
+		{
+			desc:   "query failure",
+			logErr: logErr,
+			expErr: logErr,
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+			return "", errors.Errorf("unexpected command %q", cmd)
+		})
+		ss.ipmctl = &mockIpmctlOps{errorLogs: tt.errorLogs, logErr: tt.logErr}
+
+		logs, err := ss.GetErrorLog()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, logs, tt.expLogs, tt.desc+": unexpected error logs")
+	}
+}
+
+func TestGetStateMockIpmctl(t *testing.T) {
+	regionsErr := errors.New("nvm_get_regions failed")
+	regions := []scmRegion{
+		{
+			iSetID: "0x2aba7f4828ef2ccc", memType: scmMemTypeAppDirect,
+			capacity: 3012, healthState: "Healthy", socketID: 0,
+			width: 2, dimmIDs: []string{"0x0001", "0x0011"},
+		},
+		{
+			iSetID: "0x81187f4881f02ccc", memType: scmMemTypeAppDirect,
+			capacity: 3012, freeCapacity: 3012,
+			healthState: "Healthy", socketID: 1,
+			width: 2, dimmIDs: []string{"0x1001", "0x1011"},
+		},
+	}
+
+	tests := []struct {
+		desc       string
+		regions    []scmRegion
+		regionsErr error
+		expState   scmState
+		expRegions []scmRegion
+		expErr     error
+	}{
+		{
+			desc:     "no regions",
+			expState: scmStateNoRegions,
+		},
+		{
+			desc:       "partial capacity",
+			regions:    regions,
+			expState:   scmStatePartialCapacity,
+			expRegions: regions,
+		},
+		{
+			desc:       "query failure",
+			regionsErr: regionsErr,
+			expErr:     regionsErr,
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+			return "", errors.Errorf("unexpected command %q", cmd)
+		})
+		ss.ipmctl = &mockIpmctlOps{
+			regions: tt.regions, regionsErr: tt.regionsErr,
+		}
+
+		err := ss.getState(context.Background())
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.state, tt.expState, tt.desc+": unexpected scm state")
+		AssertEqual(t, ss.regions, tt.expRegions, tt.desc+": unexpected regions")
+	}
+}
@@ -0,0 +1,108 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/daos-stack/daos/src/control/log"
+)
+
+// scmLogLevel is the severity of a log entry emitted during scm provisioning.
+type scmLogLevel int
+
+const (
+	scmLogInfo  scmLogLevel = iota // progress
+	scmLogWarn                     // recoverable issue
+	scmLogError                    // operation failure
+
+	// frames between an scmStorage logging helper's caller and the
+	// underlying log output
+	scmLogCallDepth = 6
+)
+
+func (l scmLogLevel) String() string {
+	switch l {
+	case scmLogInfo:
+		return "info"
+	case scmLogWarn:
+		return "warning"
+	case scmLogError:
+		return "error"
+	default:
+		return fmt.Sprintf("level %d", l)
+	}
+}
+
+// scmLogger receives leveled log entries from scm provisioning so that
+// operators can filter progress from recoverable issues and failures.
+type scmLogger interface {
+	Logf(level scmLogLevel, format string, args ...interface{})
+}
+
+// defaultScmLogger routes entries to the control plane log, progress is
+// logged at debug level as the control log has no info level.
+type defaultScmLogger struct{}
+
+func (defaultScmLogger) Logf(level scmLogLevel, format string, args ...interface{}) {
+	switch level {
+	case scmLogInfo:
+		log.Debugdf(scmLogCallDepth, format, args...)
+	case scmLogWarn:
+		log.Errordf(scmLogCallDepth, "warning: "+format, args...)
+	default:
+		log.Errordf(scmLogCallDepth, format, args...)
+	}
+}
+
+// withLogger routes scm provisioning log entries to the given logger instead
+// of the control plane log.
+func (s *scmStorage) withLogger(l scmLogger) *scmStorage {
+	s.logger = l
+
+	return s
+}
+
+func (s *scmStorage) logf(level scmLogLevel, format string, args ...interface{}) {
+	l := s.logger
+	if l == nil {
+		l = defaultScmLogger{}
+	}
+	l.Logf(level, format, args...)
+}
+
+// infof logs scm provisioning progress.
+func (s *scmStorage) infof(format string, args ...interface{}) {
+	s.logf(scmLogInfo, format, args...)
+}
+
+// warnf logs an issue scm provisioning has recovered from.
+func (s *scmStorage) warnf(format string, args ...interface{}) {
+	s.logf(scmLogWarn, format, args...)
+}
+
+// errorf logs an scm provisioning failure.
+func (s *scmStorage) errorf(format string, args ...interface{}) {
+	s.logf(scmLogError, format, args...)
+}
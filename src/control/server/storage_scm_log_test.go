@@ -0,0 +1,103 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/daos-stack/daos/src/control/common"
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
+	. "github.com/daos-stack/go-ipmctl/ipmctl"
+)
+
+type scmLogEntry struct {
+	level scmLogLevel
+	msg   string
+}
+
+// mockScmLogger records log entries for inspection.
+type mockScmLogger struct {
+	entries []scmLogEntry
+}
+
+func (l *mockScmLogger) Logf(level scmLogLevel, format string, args ...interface{}) {
+	l.entries = append(l.entries,
+		scmLogEntry{level, fmt.Sprintf(format, args...)})
+}
+
+// levels returns the levels of entries in the order logged.
+func (l *mockScmLogger) levels() (levels []scmLogLevel) {
+	for _, entry := range l.entries {
+		levels = append(levels, entry.level)
+	}
+
+	return
+}
+
+func TestFormatScmLogLevels(t *testing.T) {
+	tests := []struct {
+		desc      string
+		memInfo   map[string]string
+		expLevels []scmLogLevel
+		expLast   string
+	}{
+		{
+			desc: "failed format",
+			memInfo: map[string]string{
+				memInfoPath: "MemAvailable:   1048576 kB\n",
+			},
+			expLevels: []scmLogLevel{scmLogInfo, scmLogError},
+			expLast: "scm format of /mnt/daos failed: " +
+				FaultScmTmpfsNoMemory(6, 1<<30).Error() + "\n",
+		},
+		{
+			desc: "recovered from unreadable meminfo",
+			expLevels: []scmLogLevel{
+				scmLogInfo, scmLogWarn, scmLogInfo, scmLogInfo,
+				scmLogInfo, scmLogInfo,
+			},
+			expLast: "SCM device reset, format and mount completed",
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmRAM, nil, 6, bdNVMe,
+			[]string{}, false)
+		config.ext.(*mockExt).readFileRet = tt.memInfo
+		logger := &mockScmLogger{}
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config).
+			withLogger(logger)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, logger.levels(), tt.expLevels,
+			tt.desc+": unexpected log levels")
+		AssertEqual(t, logger.entries[len(logger.entries)-1].msg, tt.expLast,
+			tt.desc+": unexpected last log entry")
+	}
+}
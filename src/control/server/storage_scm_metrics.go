@@ -0,0 +1,176 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daos-stack/daos/src/control/common"
+)
+
+const (
+	metricScmFormatDuration    = "daos_scm_format_duration_seconds"
+	metricScmCmdFailures       = "daos_scm_command_failures_total"
+	metricScmNamespacesCreated = "daos_scm_namespaces_created_total"
+)
+
+// scmMetrics holds counters collected during scm operations.
+//
+// The zero value is ready for use.
+type scmMetrics struct {
+	sync.Mutex
+	formatDurations   map[int]time.Duration // keyed by server index
+	cmdFailures       map[string]int        // keyed by external tool name
+	namespacesCreated map[int]int           // keyed by socket (numa node)
+}
+
+// addFormatDuration records time elapsed since start for format of the
+// scm mount belonging to the server at the given index.
+//
+// Intended to be deferred at the start of an operation.
+func (m *scmMetrics) addFormatDuration(srvIdx int, start time.Time) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.formatDurations == nil {
+		m.formatDurations = make(map[int]time.Duration)
+	}
+	m.formatDurations[srvIdx] = time.Since(start)
+}
+
+// addCmdFailure records failure of the given external command, grouped
+// by tool name.
+func (m *scmMetrics) addCmdFailure(cmd string) {
+	m.Lock()
+	defer m.Unlock()
+
+	tool := "unknown"
+	if fields := strings.Fields(cmd); len(fields) > 0 {
+		tool = fields[0]
+	}
+
+	if m.cmdFailures == nil {
+		m.cmdFailures = make(map[string]int)
+	}
+	m.cmdFailures[tool]++
+}
+
+// addNamespacesCreated records creation of the given pmem namespaces.
+func (m *scmMetrics) addNamespacesCreated(devs []pmemDev) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.namespacesCreated == nil {
+		m.namespacesCreated = make(map[int]int)
+	}
+	for _, dev := range devs {
+		m.namespacesCreated[dev.NumaNode]++
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// writePrometheus renders collected metrics in Prometheus text exposition
+// format. Metrics that have not been collected are omitted.
+func (m *scmMetrics) writePrometheus(w io.Writer) error {
+	m.Lock()
+	defer m.Unlock()
+
+	buf := new(bytes.Buffer)
+
+	if len(m.formatDurations) > 0 {
+		writeMetricHeader(buf, metricScmFormatDuration, "gauge",
+			"Duration of the most recent scm format per io_server.")
+
+		idxs := make([]int, 0, len(m.formatDurations))
+		for idx := range m.formatDurations {
+			idxs = append(idxs, idx)
+		}
+		sort.Ints(idxs)
+
+		for _, idx := range idxs {
+			fmt.Fprintf(buf, "%s{server=\"%d\"} %g\n",
+				metricScmFormatDuration, idx,
+				m.formatDurations[idx].Seconds())
+		}
+	}
+
+	if len(m.cmdFailures) > 0 {
+		writeMetricHeader(buf, metricScmCmdFailures, "counter",
+			"Number of failed external scm commands per tool.")
+
+		tools := make([]string, 0, len(m.cmdFailures))
+		for tool := range m.cmdFailures {
+			tools = append(tools, tool)
+		}
+		sort.Strings(tools)
+
+		for _, tool := range tools {
+			fmt.Fprintf(buf, "%s{command=%q} %d\n",
+				metricScmCmdFailures, tool, m.cmdFailures[tool])
+		}
+	}
+
+	if len(m.namespacesCreated) > 0 {
+		writeMetricHeader(buf, metricScmNamespacesCreated, "counter",
+			"Number of pmem namespaces created per socket.")
+
+		sockets := make([]int, 0, len(m.namespacesCreated))
+		for socket := range m.namespacesCreated {
+			sockets = append(sockets, socket)
+		}
+		sort.Ints(sockets)
+
+		for _, socket := range sockets {
+			fmt.Fprintf(buf, "%s{socket=\"%d\"} %d\n",
+				metricScmNamespacesCreated, socket,
+				m.namespacesCreated[socket])
+		}
+	}
+
+	_, err := buf.WriteTo(w)
+
+	return err
+}
+
+// writeMetricsFile atomically replaces the file at path with the metrics
+// collected so far, as expected by the Prometheus node exporter textfile
+// collector.
+func (s *scmStorage) writeMetricsFile(path string) error {
+	var buf bytes.Buffer
+	if err := s.WriteMetrics(&buf); err != nil {
+		return err
+	}
+
+	return common.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
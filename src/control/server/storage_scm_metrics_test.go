@@ -0,0 +1,143 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/daos-stack/daos/src/control/common"
+)
+
+func TestScmMetricsPrometheus(t *testing.T) {
+	tests := []struct {
+		desc    string
+		metrics *scmMetrics
+		expOut  string
+	}{
+		{
+			desc:    "no metrics collected",
+			metrics: &scmMetrics{},
+			expOut:  "",
+		},
+		{
+			desc: "known snapshot",
+			metrics: &scmMetrics{
+				formatDurations: map[int]time.Duration{
+					1: 2 * time.Second,
+					0: 1500 * time.Millisecond,
+				},
+				cmdFailures: map[string]int{
+					"ndctl":  2,
+					"ipmctl": 1,
+				},
+				namespacesCreated: map[int]int{
+					1: 1,
+					0: 1,
+				},
+			},
+			expOut: "# HELP daos_scm_format_duration_seconds Duration of the most recent scm format per io_server.\n" +
+				"# TYPE daos_scm_format_duration_seconds gauge\n" +
+				"daos_scm_format_duration_seconds{server=\"0\"} 1.5\n" +
+				"daos_scm_format_duration_seconds{server=\"1\"} 2\n" +
+				"# HELP daos_scm_command_failures_total Number of failed external scm commands per tool.\n" +
+				"# TYPE daos_scm_command_failures_total counter\n" +
+				"daos_scm_command_failures_total{command=\"ipmctl\"} 1\n" +
+				"daos_scm_command_failures_total{command=\"ndctl\"} 2\n" +
+				"# HELP daos_scm_namespaces_created_total Number of pmem namespaces created per socket.\n" +
+				"# TYPE daos_scm_namespaces_created_total counter\n" +
+				"daos_scm_namespaces_created_total{socket=\"0\"} 1\n" +
+				"daos_scm_namespaces_created_total{socket=\"1\"} 1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		if err := tt.metrics.writePrometheus(buf); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, buf.String(), tt.expOut, tt.desc+": unexpected metrics text")
+	}
+}
+
+func TestScmMetricsCollection(t *testing.T) {
+	mockRun := func(in string) (string, error) {
+		if in == cmdScmShowRegions {
+			return "", errors.New("ipmctl example failure")
+		}
+		return `{"blockdev":"pmem1","numa_node":1}`, nil
+	}
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+	if _, err := ss.createNamespace(context.Background(), pmemName(0), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.getState(context.Background()); err == nil {
+		t.Fatal("expected getState to fail")
+	}
+
+	AssertEqual(t, ss.metrics.cmdFailures, map[string]int{"ipmctl": 1},
+		"unexpected command failures")
+	AssertEqual(t, ss.metrics.namespacesCreated, map[int]int{1: 1},
+		"unexpected namespaces created")
+}
+
+func TestScmMetricsFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "scm-metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "daos_scm.prom")
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config)
+	ss.metrics.addCmdFailure("ipmctl show -region")
+
+	for _, desc := range []string{"created", "replaced"} {
+		if err := ss.writeMetricsFile(path); err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+
+		out, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+		buf := new(bytes.Buffer)
+		if err := ss.WriteMetrics(buf); err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+		AssertEqual(t, string(out), buf.String(), desc+": unexpected metrics file")
+	}
+}
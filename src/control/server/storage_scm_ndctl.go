@@ -0,0 +1,88 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import "fmt"
+
+const cmdScmDestroyNamespace = "ndctl destroy-namespace -f"
+
+// ndctlOps abstracts the pmem namespace operations performed with ndctl so
+// that alternative backends, e.g. native library bindings, may be used.
+type ndctlOps interface {
+	// CreateNamespace creates a namespace labelled name in region, the
+	// backend selects the region if empty and all free capacity of the
+	// region is used if size (in bytes) is zero.
+	CreateNamespace(region, name string, size uint64, flags []string) ([]pmemDev, error)
+	ListNamespaces() ([]pmemDev, error)
+	// DestroyNamespace destroys the given namespace e.g. "namespace0.0".
+	DestroyNamespace(namespace string) error
+}
+
+// createNamespaceCmd returns the ndctl command line creating a namespace with
+// the given parameters, see ndctlOps.CreateNamespace.
+func createNamespaceCmd(region, name string, size uint64, flags []string) string {
+	cmd := fmt.Sprintf("%s -n %s", cmdScmCreateNamespace, name)
+	if region != "" {
+		cmd = fmt.Sprintf("%s -r %s", cmd, region)
+	}
+	if size != 0 {
+		cmd = fmt.Sprintf("%s -s %d", cmd, size)
+	}
+	for _, flag := range flags {
+		cmd = fmt.Sprintf("%s %s", cmd, flag)
+	}
+
+	return cmd
+}
+
+// cliNdctl implements ndctlOps by running the ndctl command line tool.
+type cliNdctl struct {
+	runCmd runCmdFn
+}
+
+func (n *cliNdctl) CreateNamespace(
+	region, name string, size uint64, flags []string) ([]pmemDev, error) {
+
+	out, err := n.runCmd(createNamespaceCmd(region, name, size, flags))
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePmemDevs(out)
+}
+
+func (n *cliNdctl) ListNamespaces() ([]pmemDev, error) {
+	out, err := n.runCmd(cmdScmListNamespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePmemDevs(out)
+}
+
+func (n *cliNdctl) DestroyNamespace(namespace string) error {
+	_, err := n.runCmd(fmt.Sprintf("%s %s", cmdScmDestroyNamespace, namespace))
+
+	return err
+}
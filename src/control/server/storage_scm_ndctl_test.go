@@ -0,0 +1,339 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	. "github.com/daos-stack/daos/src/control/common"
+)
+
+// mockNdctl is an in-memory ndctlOps backend.
+type mockNdctl struct {
+	namespaces []pmemDev
+	created    []string // names of namespaces created
+	destroyed  []string
+	createErr  error
+}
+
+func (m *mockNdctl) CreateNamespace(
+	region, name string, size uint64, flags []string) ([]pmemDev, error) {
+
+	if m.createErr != nil {
+		return nil, m.createErr
+	}
+
+	dev := pmemDev{
+		Blockdev: fmt.Sprintf("pmem%d", len(m.namespaces)),
+		Name:     name,
+		NumaNode: len(m.namespaces),
+		SrvIdx:   pmemOwner(name),
+	}
+	m.namespaces = append(m.namespaces, dev)
+	m.created = append(m.created, name)
+
+	return []pmemDev{dev}, nil
+}
+
+func (m *mockNdctl) ListNamespaces() ([]pmemDev, error) {
+	return m.namespaces, nil
+}
+
+func (m *mockNdctl) DestroyNamespace(namespace string) error {
+	m.destroyed = append(m.destroyed, namespace)
+
+	return nil
+}
+
+func TestCreateNamespacesMockNdctl(t *testing.T) {
+	numRegions := 2
+	createErr := errors.New("no space left")
+
+	tests := []struct {
+		desc       string
+		createErr  error
+		expCreated []string
+		expErr     error
+	}{
+		{
+			desc:       "namespace per region",
+			expCreated: []string{pmemName(0), pmemName(1)},
+		},
+		{
+			desc:      "create failure",
+			createErr: createErr,
+			expErr:    createErr,
+		},
+	}
+
+	for _, tt := range tests {
+		nd := &mockNdctl{createErr: tt.createErr}
+
+		// region free capacity consumed by each namespace created
+		mockRun := func(cmd string) (string, error) {
+			free := make([]bool, numRegions)
+			for i := len(nd.namespaces); i < numRegions; i++ {
+				free[i] = true
+			}
+			switch cmd {
+			case cmdScmShowRegions:
+				return mockRegionsOut(free), nil
+			case cmdScmListNdRegions:
+				return mockNdRegionsOut(mockRegionsOut(free)), nil
+			}
+			return "", errors.Errorf("unexpected command %q", cmd)
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun).withNdctl(nd)
+
+		devs, err := ss.createNamespaces(context.Background())
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, nd.created, tt.expCreated, tt.desc+": unexpected namespaces created")
+		AssertEqual(t, devs, nd.namespaces, tt.desc+": unexpected devices")
+		for i, dev := range devs {
+			AssertEqual(t, dev.SrvIdx, i, tt.desc+": unexpected owner")
+		}
+
+		listed, err := ss.getNamespaces(context.Background())
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		AssertEqual(t, listed, nd.namespaces, tt.desc+": unexpected listing")
+	}
+}
+
+func TestCliNdctl(t *testing.T) {
+	var cmds []string
+	nd := &cliNdctl{runCmd: func(cmd string) (string, error) {
+		cmds = append(cmds, cmd)
+		return `{"blockdev":"pmem0","name":"daos_io_server_0","numa_node":0}`, nil
+	}}
+
+	devs, err := nd.CreateNamespace("region1", pmemName(0), 1<<30, []string{"--align=2M"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, len(devs), 1, "unexpected number of devices")
+
+	if _, err := nd.ListNamespaces(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.DestroyNamespace("namespace0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	AssertEqual(t, cmds, []string{
+		cmdScmCreateNamespace + " -n daos_io_server_0 -r region1 -s 1073741824 --align=2M",
+		cmdScmListNamespaces,
+		cmdScmDestroyNamespace + " namespace0.0",
+	}, "unexpected commands")
+}
+
+func TestCreateNamespacesProgress(t *testing.T) {
+	numRegions := 3
+
+	for _, workers := range []int{0, numRegions} {
+		desc := fmt.Sprintf("%d workers", workers)
+		nd := &mockNdctl{}
+		mockRun := func(cmd string) (string, error) {
+			switch cmd {
+			case cmdScmShowRegions:
+				free := make([]bool, numRegions)
+				for i := len(nd.namespaces); i < numRegions; i++ {
+					free[i] = true
+				}
+				return mockRegionsOut(free), nil
+			case cmdScmListNdRegions:
+				return `[{"dev":"region0","size":1,"available_size":1},` +
+					`{"dev":"region1","size":1,"available_size":1},` +
+					`{"dev":"region2","size":1,"available_size":1}]`, nil
+			}
+			return "", errors.Errorf("unexpected command %q", cmd)
+		}
+
+		var created []int
+		var reported []pmemDev
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun).
+			withNdctl(&lockedNdctl{ops: nd}).
+			withNamespaceWorkers(workers).
+			withNamespaceProgress(func(n int, dev pmemDev) {
+				created = append(created, n)
+				reported = append(reported, dev)
+			})
+
+		devs, err := ss.createNamespaces(context.Background())
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+
+		// one report per device with a running count
+		AssertEqual(t, created, []int{1, 2, 3}, desc+": unexpected counts")
+		AssertEqual(t, len(reported), len(devs), desc+": unexpected reports")
+		for _, dev := range devs {
+			found := false
+			for _, r := range reported {
+				found = found || r == dev
+			}
+			AssertTrue(t, found, desc+": device not reported: "+dev.String())
+		}
+	}
+
+	// no callback registered
+	nd := &mockNdctl{}
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withNdctl(nd).withRunCmd(
+		func(cmd string) (string, error) {
+			return mockRegionsOut(make([]bool, len(nd.namespaces))), nil
+		})
+	if _, err := ss.createNamespace(context.Background(), pmemName(0), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lockedNdctl serialises calls to an ndctlOps backend which isn't safe for
+// concurrent use.
+type lockedNdctl struct {
+	sync.Mutex
+	ops ndctlOps
+}
+
+func (l *lockedNdctl) CreateNamespace(
+	region, name string, size uint64, flags []string) ([]pmemDev, error) {
+
+	l.Lock()
+	defer l.Unlock()
+
+	return l.ops.CreateNamespace(region, name, size, flags)
+}
+
+func (l *lockedNdctl) ListNamespaces() ([]pmemDev, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.ops.ListNamespaces()
+}
+
+func (l *lockedNdctl) DestroyNamespace(namespace string) error {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.ops.DestroyNamespace(namespace)
+}
+
+func TestCreateNamespaceRetry(t *testing.T) {
+	pmemOut := `{"blockdev":"pmem0","name":"daos_io_server_0","numa_node":0}`
+	busyErr := errors.New("failed to create namespace: Device or resource busy")
+	fullErr := errors.New("failed to create namespace: No space left on device")
+
+	tests := []struct {
+		desc        string
+		errs        []error // returned by successive attempts
+		expAttempts int
+		expErr      error
+	}{
+		{
+			desc:        "success",
+			expAttempts: 1,
+		},
+		{
+			desc:        "transient failure then success",
+			errs:        []error{busyErr},
+			expAttempts: 2,
+		},
+		{
+			desc:        "transient failure on all attempts",
+			errs:        []error{busyErr, busyErr, busyErr},
+			expAttempts: 3,
+			expErr:      busyErr,
+		},
+		{
+			desc:        "permanent failure",
+			errs:        []error{fullErr},
+			expAttempts: 1,
+			expErr:      fullErr,
+		},
+	}
+
+	for _, tt := range tests {
+		attempts := 0
+		mockRun := func(cmd string) (string, error) {
+			if cmd != cmdScmCreateNamespace+" -n "+pmemName(0) {
+				return "", errors.Errorf("unexpected command %q", cmd)
+			}
+			attempts++
+			if attempts <= len(tt.errs) {
+				return "", tt.errs[attempts-1]
+			}
+			return pmemOut, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun).
+			withNamespaceRetry(3, time.Millisecond)
+
+		devs, err := ss.createNamespace(context.Background(), pmemName(0), 0)
+		AssertEqual(t, attempts, tt.expAttempts, tt.desc+": unexpected attempts")
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		AssertEqual(t, len(devs), 1, tt.desc+": unexpected number of devices")
+	}
+}
+
+func TestCreateNamespaceRetryCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	mockRun := func(cmd string) (string, error) {
+		attempts++
+		cancel() // failures once cancelled are not retried
+		return "", errors.New("Device or resource busy")
+	}
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun).
+		withNamespaceRetry(3, time.Hour)
+
+	_, err := ss.createNamespace(ctx, pmemName(0), 0)
+	ExpectError(t, err, "Device or resource busy", "cancelled retry")
+	AssertEqual(t, attempts, 1, "unexpected attempts")
+}
@@ -0,0 +1,65 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import "fmt"
+
+var sizeUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// scmSize carries a capacity both as an exact byte count and as a formatted
+// human readable string so that clients need not format sizes themselves.
+type scmSize struct {
+	Bytes uint64 `json:"bytes"`
+	Human string `json:"human"`
+}
+
+func newScmSize(bytes uint64) scmSize {
+	return scmSize{Bytes: bytes, Human: humanSize(bytes)}
+}
+
+// scmSizeFromGiB converts capacities reported by ipmctl in GiB.
+func scmSizeFromGiB(gib float64) scmSize {
+	return newScmSize(uint64(gib * float64(1<<30)))
+}
+
+func (ss scmSize) String() string {
+	return ss.Human
+}
+
+// humanSize formats a byte count using binary (IEC) units to two decimal
+// places, byte counts under 1KiB are formatted exactly.
+func humanSize(bytes uint64) string {
+	if bytes < 1<<10 {
+		return fmt.Sprintf("%d %s", bytes, sizeUnits[0])
+	}
+
+	size := float64(bytes)
+	unit := 0
+	for size >= 1<<10 && unit < len(sizeUnits)-1 {
+		size /= 1 << 10
+		unit++
+	}
+
+	return fmt.Sprintf("%.2f %s", size, sizeUnits[unit])
+}
@@ -0,0 +1,69 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/daos-stack/daos/src/control/common"
+)
+
+func TestScmSizeFormat(t *testing.T) {
+	tests := []struct {
+		bytes    uint64
+		expHuman string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.00 KiB"},
+		{1536, "1.50 KiB"},
+		{5 << 20, "5.00 MiB"},
+		{541165879296, "504.00 GiB"},
+		{1082331758592, "1008.00 GiB"},
+		{1082331758592 + 123456789, "1008.11 GiB"},
+		{2164663517184, "1.97 TiB"},
+		{3 << 50, "3.00 PiB"},
+		{^uint64(0), "16.00 EiB"},
+	}
+
+	for _, tt := range tests {
+		size := newScmSize(tt.bytes)
+		AssertEqual(t, size.Bytes, tt.bytes, "unexpected bytes")
+		AssertEqual(t, size.Human, tt.expHuman, "unexpected human string")
+	}
+}
+
+func TestScmSizeFromGiB(t *testing.T) {
+	size := scmSizeFromGiB(502.5)
+	AssertEqual(t, size.Bytes, uint64(1005<<29), "unexpected bytes")
+	AssertEqual(t, size.Human, "502.50 GiB", "unexpected human string")
+
+	out, err := json.Marshal(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, string(out), `{"bytes":539555266560,"human":"502.50 GiB"}`,
+		"unexpected json")
+}
@@ -0,0 +1,87 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+// scmTrigger is the event causing an scm state transition.
+type scmTrigger string
+
+const (
+	scmTriggerPrep   scmTrigger = "prep"
+	scmTriggerReboot scmTrigger = "reboot"
+)
+
+// scmTransition describes the action taken in an scm state on a trigger and
+// the resulting state.
+//
+// Command is the external command issued by Prep to perform the action, empty
+// if the action is performed outside of Prep.
+type scmTransition struct {
+	State   scmState   `json:"state"`
+	Trigger scmTrigger `json:"trigger"`
+	Action  string     `json:"action"`
+	Command string     `json:"command,omitempty"`
+	Next    scmState   `json:"next"`
+}
+
+// scmStateMachine returns the transitions between scm states made by Prep
+// and by the reboot applying a region allocation goal, in order of
+// provisioning.
+func scmStateMachine() []scmTransition {
+	return []scmTransition{
+		{
+			State:   scmStateNoRegions,
+			Trigger: scmTriggerPrep,
+			Action:  "create AppDirect allocation goal, reboot required",
+			Command: cmdScmCreateRegions,
+			Next:    scmStateNoRegions,
+		},
+		{
+			State:   scmStateNoRegions,
+			Trigger: scmTriggerReboot,
+			Action:  "apply allocation goal creating regions",
+			Next:    scmStateFreeCapacity,
+		},
+		{
+			State:   scmStateFreeCapacity,
+			Trigger: scmTriggerPrep,
+			Action:  "create pmem namespaces using free capacity",
+			Command: cmdScmCreateNamespace,
+			Next:    scmStateNoCapacity,
+		},
+		{
+			State:   scmStatePartialCapacity,
+			Trigger: scmTriggerPrep,
+			Action:  "create pmem namespaces using remaining free capacity",
+			Command: cmdScmCreateNamespace,
+			Next:    scmStateNoCapacity,
+		},
+		{
+			State:   scmStateNoCapacity,
+			Trigger: scmTriggerPrep,
+			Action:  "list existing pmem namespaces",
+			Command: cmdScmListNamespaces,
+			Next:    scmStateNoCapacity,
+		},
+	}
+}
@@ -0,0 +1,212 @@
+//
+// (C) Copyright 2018-2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/daos-stack/daos/src/control/common"
+	pb "github.com/daos-stack/daos/src/control/common/proto/mgmt"
+)
+
+// mockRegionsOut returns example ipmctl region output with a region for each
+// entry, which has free capacity if true.
+func mockRegionsOut(free []bool) string {
+	if len(free) == 0 {
+		return outScmNoRegions
+	}
+
+	out := "\n"
+	for i, hasFree := range free {
+		capacity := "0.0"
+		if hasFree {
+			capacity = "3012.0"
+		}
+		out += fmt.Sprintf("---ISetID=0x%016x---\n", i+1) +
+			"   PersistentMemoryType=AppDirect\n" +
+			fmt.Sprintf("   FreeCapacity=%s GiB\n", capacity)
+	}
+
+	return out + "\n"
+}
+
+func TestScmStateMachine(t *testing.T) {
+	initialRegions := map[scmState][]bool{
+		scmStateNoRegions:       nil,
+		scmStateFreeCapacity:    {true, true},
+		scmStatePartialCapacity: {false, true},
+		scmStateNoCapacity:      {false, false},
+	}
+
+	handled := make(map[scmState]bool)
+	for _, tr := range scmStateMachine() {
+		if tr.Trigger != scmTriggerPrep {
+			continue
+		}
+		desc := fmt.Sprintf("%s on %s", tr.State, tr.Trigger)
+		handled[tr.State] = true
+
+		free, exists := initialRegions[tr.State]
+		if !exists {
+			t.Fatalf("%s: no mock for state", desc)
+		}
+		free = append([]bool{}, free...)
+
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			switch {
+			case in == cmdScmShowRegions:
+				return mockRegionsOut(free), nil
+			case in == cmdScmListNdRegions:
+				return mockNdRegionsOut(mockRegionsOut(free)), nil
+			case in == cmdScmCreateRegions:
+				return msgScmRebootRequired + "\n", nil
+			case in == cmdScmShowGoal:
+				return mockGoalOut("0x0004", "0.0 GiB", "502.0 GiB"), nil
+			case strings.HasPrefix(in, cmdScmCreateNamespace):
+				// consume free capacity of the next region
+				for i := range free {
+					if free[i] {
+						free[i] = false
+						break
+					}
+				}
+			}
+			return `{"blockdev":"pmem0","numa_node":0}`, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+		ss.Discover(new(pb.ScanStorageResp)) // not concerned with response
+
+		if _, err := ss.Prep(context.Background()); err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+
+		issued := false
+		for _, cmd := range commands {
+			if strings.HasPrefix(cmd, tr.Command) {
+				issued = true
+			}
+		}
+		if !issued {
+			t.Fatalf("%s: expected %q to be run, got %v", desc,
+				tr.Command, commands)
+		}
+
+		if err := ss.getState(context.Background()); err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+		AssertEqual(t, ss.state, tr.Next, desc+": unexpected next state")
+	}
+
+	for state := range initialRegions {
+		if !handled[state] {
+			t.Fatalf("no prep transition defined for %s", state)
+		}
+	}
+}
+
+func TestGetStatePartialRegions(t *testing.T) {
+	twoSocketOut := func(socket1Type string) string {
+		return "\n" +
+			"---ISetID=0x2aba7f4828ef2ccc---\n" +
+			"   SocketID=0x0000\n" +
+			"   PersistentMemoryType=AppDirect\n" +
+			"   Capacity=3012.0 GiB\n" +
+			"   FreeCapacity=3012.0 GiB\n" +
+			"---ISetID=0x81187f4881f02ccc---\n" +
+			"   SocketID=0x0001\n" +
+			"   PersistentMemoryType=" + socket1Type + "\n" +
+			"   Capacity=3012.0 GiB\n" +
+			"   FreeCapacity=3012.0 GiB\n" +
+			"\n"
+	}
+
+	tests := []struct {
+		desc     string
+		regions  string
+		expState scmState
+		expErr   error
+	}{
+		{
+			desc:     "AppDirect on both sockets",
+			regions:  twoSocketOut("AppDirect"),
+			expState: scmStateFreeCapacity,
+		},
+		{
+			desc:     "AppDirect on socket 0, not interleaved on socket 1",
+			regions:  twoSocketOut("AppDirectNotInterleaved"),
+			expState: scmStateFreeCapacity,
+		},
+		{
+			desc:     "AppDirect on one socket",
+			regions:  twoSocketOut("Unknown"),
+			expState: scmStatePartialRegions,
+			expErr:   FaultScmPartialRegions([]int{0}, []int{1}),
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			if in == cmdScmShowRegions {
+				return tt.regions, nil
+			}
+			return `{"blockdev":"pmem0","numa_node":0}`, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		if err := ss.getState(context.Background()); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		AssertEqual(t, ss.state, tt.expState, tt.desc+": unexpected state")
+
+		if tt.expErr == nil {
+			continue
+		}
+
+		commands = nil
+		result, err := ss.Prep(context.Background())
+		ExpectError(t, err, tt.expErr.Error(), tt.desc)
+		AssertEqual(t, len(result.Namespaces), 0, tt.desc+": unexpected pmem devices")
+		for _, cmd := range commands {
+			if strings.HasPrefix(cmd, cmdScmCreateNamespace) ||
+				cmd == cmdScmCreateRegions {
+
+				t.Fatalf("%s: unexpected command %q", tt.desc, cmd)
+			}
+		}
+
+		_, err = ss.RebootsRequired()
+		ExpectError(t, err, tt.expErr.Error(), tt.desc+": reboots required")
+	}
+}
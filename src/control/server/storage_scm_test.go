@@ -24,9 +24,19 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -59,12 +69,19 @@ func (m *mockIpmctl) Discover() ([]DeviceDiscovery, error) {
 }
 
 // mockScmStorage factory
+// nopRunCmd is the default command runner for mock scm storage and returns
+// no output.
+func nopRunCmd(string) (string, error) {
+	return "", nil
+}
+
 func newMockScmStorage(
 	discoverModulesRet error, mms []DeviceDiscovery, inited bool,
 	c *configuration) *scmStorage {
 
 	return &scmStorage{
 		ipmctl:      &mockIpmctl{discoverModulesRet, mms},
+		runCmd:      nopRunCmd,
 		initialized: inited,
 		config:      c,
 	}
@@ -77,6 +94,47 @@ func defaultMockScmStorage(config *configuration) *scmStorage {
 		nil, []DeviceDiscovery{m}, false, config)
 }
 
+// mockPmemDevs returns pmem devices parsed from example ndctl output.
+func mockPmemDevs(t *testing.T, jsonData string) []pmemDev {
+	devs, err := parsePmemDevs(jsonData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return devs
+}
+
+// mockNdRegionsOut returns example ndctl list -R output consistent with the
+// given ipmctl show -region output, region N being the Nth ipmctl region.
+func mockNdRegionsOut(ipmctlOut string) string {
+	regions, err := parseRegions(ipmctlOut)
+	if ipmctlOut == outScmNoRegions || err != nil {
+		return "[]"
+	}
+
+	ndRegions := make([]ndRegion, 0, len(regions))
+	for i, r := range regions {
+		ndRegions = append(ndRegions, ndRegion{
+			Dev:           fmt.Sprintf("region%d", i),
+			Size:          uint64(r.capacity * (1 << 30)),
+			AvailableSize: uint64(r.freeCapacity * (1 << 30)),
+			NumaNode:      r.socketID,
+		})
+	}
+	data, _ := json.Marshal(ndRegions)
+
+	return string(data)
+}
+
+// mockGoalOut returns example ipmctl show -goal output for a single module.
+func mockGoalOut(socketID, memorySize, appDirectSize string) string {
+	return "\n" +
+		" SocketID | DimmID | MemorySize | AppDirect1Size | AppDirect2Size\n" +
+		"==================================================================\n" +
+		fmt.Sprintf(" %s   | 0x0001 | %s    | %s      | 0.0 GiB\n",
+			socketID, memorySize, appDirectSize)
+}
+
 func TestGetState(t *testing.T) {
 	defer ShowLogOnFailure(t)()
 
@@ -98,22 +156,39 @@ func TestGetState(t *testing.T) {
 	onePmemJson := fmt.Sprintf(pmemOut, 1, 1, 0)
 	twoPmemsJson := "[" + fmt.Sprintf(pmemOut, 1, 1, 0) + "," + fmt.Sprintf(pmemOut, 2, 2, 1) + "]"
 	createRegionsOut := msgScmRebootRequired + "\n"
+	createNsCmd := func(srvIdx, region int) string {
+		return fmt.Sprintf("%s -n %s -r region%d",
+			cmdScmCreateNamespace, pmemName(srvIdx), region)
+	}
+	// created namespaces are expected to be named in order of creation
+	namedPmemDevs := func(jsonData string) []pmemDev {
+		devs := mockPmemDevs(t, jsonData)
+		for i := range devs {
+			devs[i].Name = pmemName(i)
+			devs[i].SrvIdx = i
+		}
+		return devs
+	}
 	pmemId := 1
 
 	mockRun := func(in string) (string, error) {
 		retString := in
 
-		switch in {
-		case cmdScmCreateRegions:
+		switch {
+		case in == cmdScmCreateRegions:
 			retString = createRegionsOut // example successful output
-		case cmdScmShowRegions:
+		case in == cmdScmShowGoal:
+			retString = mockGoalOut("0x0004", "0.0 GiB", "502.0 GiB")
+		case in == cmdScmShowRegions:
 			retString = regionsOut
-		case cmdScmCreateNamespace:
+		case in == cmdScmListNdRegions:
+			retString = mockNdRegionsOut(regionsOut)
+		case strings.HasPrefix(in, cmdScmCreateNamespace):
 			// stimulate free capacity of region being used
 			regionsOut = strings.Replace(regionsOut, "3012.0", "0.0", 1)
 			retString = fmt.Sprintf(pmemOut, pmemId, pmemId, pmemId-1)
 			pmemId += 1
-		case cmdScmListNamespaces:
+		case in == cmdScmListNamespaces:
 			retString = twoPmemsJson
 		}
 
@@ -134,7 +209,7 @@ func TestGetState(t *testing.T) {
 			desc:              "modules but no regions",
 			showRegionOut:     outScmNoRegions,
 			expRebootRequired: true,
-			expCommands:       []string{cmdScmShowRegions, cmdScmCreateRegions},
+			expCommands:       []string{cmdScmShowRegions, cmdScmCreateRegions, cmdScmShowGoal},
 		},
 		{
 			desc: "single region with free capacity",
@@ -146,8 +221,11 @@ func TestGetState(t *testing.T) {
 				"   PersistentMemoryType=AppDirect\n" +
 				"   FreeCapacity=3012.0 GiB\n" +
 				"\n",
-			expCommands: []string{cmdScmShowRegions, cmdScmCreateNamespace, cmdScmShowRegions},
-			expPmemDevs: parsePmemDevs(onePmemJson),
+			expCommands: []string{
+				cmdScmShowRegions, cmdScmListNdRegions, createNsCmd(0, 1),
+				cmdScmShowRegions,
+			},
+			expPmemDevs: namedPmemDevs(onePmemJson),
 		},
 		{
 			desc: "regions with free capacity",
@@ -160,10 +238,11 @@ func TestGetState(t *testing.T) {
 				"   FreeCapacity=3012.0 GiB\n" +
 				"\n",
 			expCommands: []string{
-				cmdScmShowRegions, cmdScmCreateNamespace, cmdScmShowRegions,
-				cmdScmCreateNamespace, cmdScmShowRegions,
+				cmdScmShowRegions, cmdScmListNdRegions, createNsCmd(0, 0),
+				cmdScmShowRegions, cmdScmListNdRegions, createNsCmd(1, 1),
+				cmdScmShowRegions,
 			},
-			expPmemDevs: parsePmemDevs(twoPmemsJson),
+			expPmemDevs: namedPmemDevs(twoPmemsJson),
 		},
 		{
 			desc: "regions with no capacity",
@@ -176,7 +255,7 @@ func TestGetState(t *testing.T) {
 				"   FreeCapacity=0.0 GiB\n" +
 				"\n",
 			expCommands: []string{cmdScmShowRegions, cmdScmListNamespaces},
-			expPmemDevs: parsePmemDevs(twoPmemsJson),
+			expPmemDevs: mockPmemDevs(t, twoPmemsJson),
 		},
 	}
 
@@ -190,7 +269,7 @@ func TestGetState(t *testing.T) {
 		pmemId = 1
 		commands = nil
 
-		needsReboot, pmemDevs, err := ss.Prep()
+		result, err := ss.Prep(context.Background())
 		if tt.errMsg != "" {
 			ExpectError(t, err, tt.errMsg, tt.desc)
 			continue
@@ -200,329 +279,5279 @@ func TestGetState(t *testing.T) {
 		}
 
 		AssertEqual(t, commands, tt.expCommands, tt.desc+": unexpected list of commands run")
-		AssertEqual(t, needsReboot, tt.expRebootRequired, tt.desc+": unexpected value for is reboot required")
-		AssertEqual(t, pmemDevs, tt.expPmemDevs, tt.desc+": unexpected list of pmem kernel device names")
+		AssertEqual(t, result.NeedsReboot, tt.expRebootRequired, tt.desc+": unexpected value for is reboot required")
+		AssertEqual(t, result.Namespaces, tt.expPmemDevs, tt.desc+": unexpected list of pmem kernel device names")
 	}
 }
 
-func TestDiscoverScm(t *testing.T) {
-	mPB := MockModulePB()
-	m := MockModule()
-	config := defaultMockConfig(t)
-
+func TestPrepRebootRequired(t *testing.T) {
 	tests := []struct {
-		inited            bool
-		ipmctlDiscoverRet error
+		desc              string
+		createRegionsErr  error
+		rebootRequired    bool
 		errMsg            string
-		expModules        ScmModules
+		expRebootRequired bool
 	}{
 		{
-			true,
-			nil,
-			"",
-			ScmModules(nil),
+			desc:              "reboot required",
+			rebootRequired:    true,
+			expRebootRequired: true,
 		},
 		{
-			false,
-			nil,
-			"",
-			ScmModules{mPB},
+			desc: "reboot not required",
 		},
 		{
-			false,
-			errors.New("ipmctl example failure"),
-			msgIpmctlDiscoverFail + ": ipmctl example failure",
-			ScmModules{mPB},
+			desc:             "create regions fails",
+			createRegionsErr: errors.New("example failure"),
+			errMsg:           "example failure",
 		},
 	}
 
 	for _, tt := range tests {
-		ss := newMockScmStorage(
-			tt.ipmctlDiscoverRet, []DeviceDiscovery{m}, tt.inited,
-			&config)
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			return outScmNoRegions, nil
+		}
+		mockCreateRegions := func(ctx context.Context) (bool, error) {
+			return tt.rebootRequired, tt.createRegionsErr
+		}
 
-		resp := new(pb.ScanStorageResp)
-		ss.Discover(resp)
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun).
+			withCreateRegions(mockCreateRegions)
+
+		result, err := ss.Prep(context.Background())
 		if tt.errMsg != "" {
-			AssertEqual(t, resp.Scmstate.Error, tt.errMsg, "")
-			AssertTrue(
-				t,
-				resp.Scmstate.Status != pb.ResponseStatus_CTRL_SUCCESS,
-				"")
+			ExpectError(t, err, tt.errMsg, tt.desc)
 			continue
 		}
-		AssertEqual(t, resp.Scmstate.Error, "", "")
-		AssertEqual(t, resp.Scmstate.Status, pb.ResponseStatus_CTRL_SUCCESS, "")
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
 
-		AssertEqual(t, ss.modules, tt.expModules, "unexpected list of modules")
+		// only region state should be queried, creation is mocked
+		AssertEqual(t, commands, []string{cmdScmShowRegions}, tt.desc+": unexpected list of commands run")
+		AssertEqual(t, ss.state, scmStateNoRegions, tt.desc+": unexpected scm state")
+		AssertEqual(t, result.NeedsReboot, tt.expRebootRequired, tt.desc+": unexpected value for is reboot required")
+		AssertEqual(t, len(result.Namespaces), 0, tt.desc+": unexpected pmem devices")
 	}
 }
 
-func TestFormatScm(t *testing.T) {
+func TestGoalRebootStatus(t *testing.T) {
+	goalTime := time.Unix(1570000000, 0)
+
 	tests := []struct {
-		inited    bool
-		formatted bool
-		mountRet  error
-		// log context should be stack layer registering result
-		unmountRet error
-		mkdirRet   error
-		removeRet  error
-		mount      string
-		class      ScmClass
-		devs       []string
-		size       int
-		expCmds    []string // expected arguments in syscall methods
-		expResults ScmMountResults
-		desc       string
+		desc        string
+		flag        map[string]string
+		bootTime    time.Time
+		errMsg      string
+		expPending  bool
+		expRebooted bool
 	}{
 		{
-			inited: false,
-			mount:  "/mnt/daos",
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_ERR_APP,
-						Error:  msgScmNotInited,
-					},
-				},
-			},
-			desc: "not initialised",
-		},
-		{
-			inited:    true,
-			mount:     "/mnt/daos",
-			formatted: true,
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_ERR_APP,
-						Error:  msgScmAlreadyFormatted,
-					},
-				},
-			},
-			desc: "already formatted",
-		},
-		{
-			inited: true,
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "",
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_ERR_CONF,
-						Error:  msgScmMountEmpty,
-					},
-				},
-			},
-			desc: "missing mount point",
-		},
-		{
-			inited: true,
-			mount:  "/mnt/daos",
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_ERR_CONF,
-						Error:  ": " + msgScmClassNotSupported,
-					},
-				},
-			},
-			desc: "no class",
-		},
-		{
-			inited: true,
-			mount:  "/mnt/daos",
-			class:  scmRAM,
-			size:   6,
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State:    &pb.ResponseState{},
-				},
-			},
-			expCmds: []string{
-				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
-				"os: removeall /mnt/daos",
-				"os: mkdirall /mnt/daos, 0777",
-				"syscall: mount tmpfs, /mnt/daos, tmpfs, 0, size=6g",
-			},
-			desc: "ram success",
-		},
-		{
-			inited: true,
-			mount:  "/mnt/daos",
-			class:  scmDCPM,
-			devs:   []string{"/dev/pmem0"},
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State:    &pb.ResponseState{},
-				},
-			},
-			expCmds: []string{
-				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
-				"os: removeall /mnt/daos",
-				"cmd: wipefs -a /dev/pmem0",
-				"cmd: mkfs.ext4 /dev/pmem0",
-				"os: mkdirall /mnt/daos, 0777",
-				"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
-			},
-			desc: "dcpm success",
+			desc:     "no pending goal",
+			bootTime: goalTime.Add(-time.Hour),
 		},
 		{
-			inited: true,
-			mount:  "/mnt/daos",
-			class:  scmDCPM,
-			devs:   []string{},
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_ERR_CONF,
-						Error:  msgScmBadDevList,
-					},
-				},
-			},
-			desc: "dcpm missing dev",
+			desc:       "booted before goal creation",
+			flag:       map[string]string{scmGoalFlagPath: "1570000000\n"},
+			bootTime:   goalTime.Add(-time.Hour),
+			expPending: true,
 		},
 		{
-			inited: true,
-			mount:  "/mnt/daos",
-			class:  scmDCPM,
-			devs:   []string(nil),
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_ERR_CONF,
-						Error:  msgScmBadDevList,
-					},
-				},
-			},
-			desc: "dcpm nil devs",
+			desc:        "booted after goal creation",
+			flag:        map[string]string{scmGoalFlagPath: "1570000000"},
+			bootTime:    goalTime.Add(time.Minute),
+			expPending:  true,
+			expRebooted: true,
 		},
 		{
-			inited: true,
-			mount:  "/mnt/daos",
-			class:  scmDCPM,
-			devs:   []string{""},
-			expResults: ScmMountResults{
-				{
-					Mntpoint: "/mnt/daos",
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_ERR_CONF,
-						Error:  msgScmDevEmpty,
-					},
-				},
-			},
-			desc: "dcpm empty dev",
+			desc:   "corrupt flag",
+			flag:   map[string]string{scmGoalFlagPath: "yesterday"},
+			errMsg: "parse pending scm goal: strconv.ParseInt: parsing \"yesterday\": invalid syntax",
 		},
 	}
 
-	srvIdx := 0
-
 	for _, tt := range tests {
-		config := newMockStorageConfig(
-			tt.mountRet, tt.unmountRet, tt.mkdirRet, tt.removeRet,
-			tt.mount, tt.class, tt.devs, tt.size,
-			bdNVMe, []string{}, false)
-		ss := newMockScmStorage(
-			nil, []DeviceDiscovery{}, false, config)
-		ss.formatted = tt.formatted
-
-		results := ScmMountResults{}
+		config := defaultMockConfig(t)
+		config.ext = &mockExt{readFileRet: tt.flag, bootTimeRet: tt.bootTime}
+		ss := defaultMockScmStorage(&config)
 
-		if tt.inited {
-			// not concerned with response
-			ss.Discover(new(pb.ScanStorageResp))
+		pending, rebooted, err := ss.goalRebootStatus()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
 		}
 
-		ss.Format(srvIdx, &results)
+		AssertEqual(t, pending, tt.expPending, tt.desc+": unexpected pending goal")
+		AssertEqual(t, rebooted, tt.expRebooted, tt.desc+": unexpected reboot detection")
+	}
+}
 
-		// only ocm result in response for the moment
-		AssertEqual(
-			t, len(results), 1,
-			"unexpected number of response results, "+tt.desc)
+func TestPrepGoalPending(t *testing.T) {
+	var commands []string
+	mockRun := func(in string) (string, error) {
+		commands = append(commands, in)
+		return outScmNoRegions, nil
+	}
 
-		result := results[0]
+	config := defaultMockConfig(t)
+	config.ext = &mockExt{
+		readFileRet: map[string]string{scmGoalFlagPath: "1570000000"},
+		bootTimeRet: time.Unix(1560000000, 0),
+	}
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
 
-		AssertEqual(
-			t, result.State.Error, tt.expResults[0].State.Error,
-			"unexpected result error message, "+tt.desc)
-		AssertEqual(
+	result, err := ss.Prep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// goal should not be recreated while reboot is pending
+	AssertEqual(t, commands, []string{cmdScmShowRegions}, "unexpected list of commands run")
+	AssertEqual(t, result.NeedsReboot, true, "unexpected value for is reboot required")
+}
+
+func TestPrepClearsStaleGoal(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"\n"
+
+	tests := []struct {
+		desc       string
+		flag       map[string]string
+		expHistory []string
+	}{
+		{
+			desc: "no pending goal",
+		},
+		{
+			desc: "stale pending goal",
+			flag: map[string]string{scmGoalFlagPath: "1570000000"},
+			expHistory: []string{
+				fmt.Sprintf(msgRemove, scmGoalFlagPath),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			if in == cmdScmShowRegions {
+				return regionsOut, nil
+			}
+			return `{"blockdev":"pmem0","numa_node":0}`, nil
+		}
+
+		config := defaultMockConfig(t)
+		config.ext = &mockExt{
+			readFileRet: tt.flag,
+			bootTimeRet: time.Unix(1560000000, 0),
+		}
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		result, err := ss.Prep(context.Background())
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, result.NeedsReboot, false, tt.desc+": unexpected value for is reboot required")
+		AssertEqual(t, config.ext.(*mockExt).getHistory(), tt.expHistory,
+			tt.desc+": unexpected system calls")
+	}
+}
+
+func TestPrepNoAutoRegions(t *testing.T) {
+	var commands []string
+	mockRun := func(in string) (string, error) {
+		commands = append(commands, in)
+		return outScmNoRegions, nil
+	}
+
+	config := defaultMockConfig(t)
+	config.ScmNoAutoRegion = true
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+	result, err := ss.Prep(context.Background())
+	ExpectError(t, err, FaultScmNoRegions().Error(), "auto region creation disabled")
+
+	// no regions should be created
+	AssertEqual(t, commands, []string{cmdScmShowRegions}, "unexpected list of commands run")
+	AssertEqual(t, result.NeedsReboot, false, "unexpected value for is reboot required")
+	AssertEqual(t, len(result.Namespaces), 0, "unexpected pmem devices")
+}
+
+func TestPrepCancel(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"---ISetID=0x81187f4881f02ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"\n"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var commands []string
+	mockRun := func(in string) (string, error) {
+		commands = append(commands, in)
+		switch in {
+		case cmdScmShowRegions:
+			return regionsOut, nil
+		case cmdScmListNdRegions:
+			return mockNdRegionsOut(regionsOut), nil
+		}
+		// abort after first namespace has been created
+		regionsOut = strings.Replace(regionsOut, "3012.0", "0.0", 1)
+		cancel()
+		return `{"blockdev":"pmem0","numa_node":0}`, nil
+	}
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+	result, err := ss.Prep(ctx)
+	ExpectError(t, err,
+		"scm namespace creation aborted after 1 created: context canceled",
+		"cancel after first namespace")
+	AssertTrue(t, errors.Cause(err) == context.Canceled, "expected cancellation error")
+
+	AssertEqual(t, result.NeedsReboot, false, "unexpected value for is reboot required")
+	AssertEqual(t, result.Namespaces,
+		[]pmemDev{{Blockdev: "pmem0", Name: pmemName(0), NumaNode: 0}},
+		"unexpected partial list of pmem devices")
+	// no commands are run once cancelled
+	AssertEqual(t, commands, []string{
+		cmdScmShowRegions,
+		cmdScmListNdRegions,
+		cmdScmCreateNamespace + " -n " + pmemName(0) + " -r region0",
+	}, "unexpected list of commands run")
+}
+
+func TestPrepDegradedRegion(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"   HealthState=Healthy\n" +
+		"---ISetID=0x81187f4881f02ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=%s\n" +
+		"   HealthState=Partial\n" +
+		"\n"
+
+	tests := []struct {
+		desc         string
+		freeCapacity string
+		expErr       error
+		expCommands  []string
+	}{
+		{
+			desc:         "degraded region with free capacity",
+			freeCapacity: "3012.0 GiB",
+			expErr:       FaultScmDegradedRegion("0x81187f4881f02ccc", "Partial"),
+			expCommands:  []string{cmdScmShowRegions},
+		},
+		{
+			desc:         "degraded region without free capacity",
+			freeCapacity: "0.0 GiB",
+			expCommands: []string{
+				cmdScmShowRegions,
+				cmdScmListNdRegions,
+				cmdScmCreateNamespace + " -n " + pmemName(0) + " -r region0",
+				cmdScmShowRegions,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		showRegionsOut := fmt.Sprintf(regionsOut, tt.freeCapacity)
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			switch in {
+			case cmdScmShowRegions:
+				return showRegionsOut, nil
+			case cmdScmListNdRegions:
+				return mockNdRegionsOut(showRegionsOut), nil
+			}
+			// stimulate free capacity of healthy region being used
+			showRegionsOut = strings.Replace(showRegionsOut, "3012.0", "0.0", 1)
+			return `{"blockdev":"pmem0","numa_node":0}`, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		_, err := ss.Prep(context.Background())
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			AssertTrue(t, FaultScmDegradedRegion("", "").Equals(err),
+				tt.desc+": expected degraded region fault")
+		} else if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, commands, tt.expCommands, tt.desc+": unexpected list of commands run")
+	}
+}
+
+func TestCheckRegionModules(t *testing.T) {
+	module := func(socket, memCtrlr, channel, pos uint16) DeviceDiscovery {
+		m := MockModule()
+		m.Socket_id = socket
+		m.Memory_controller_id = memCtrlr
+		m.Channel_id = channel
+		m.Channel_pos = pos
+		return m
+	}
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"   SocketID=0x0000\n" +
+		"   DimmID=0x0001, 0x0011\n" +
+		"---ISetID=0x81187f4881f02ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"   SocketID=0x0001\n" +
+		"   DimmID=0x1001, 0x1011\n" +
+		"\n"
+
+	tests := []struct {
+		desc       string
+		regionsOut string
+		modules    []DeviceDiscovery
+		expErr     error
+	}{
+		{
+			desc:       "modules match regions",
+			regionsOut: regionsOut,
+			modules: []DeviceDiscovery{
+				module(0, 0, 0, 1), module(0, 0, 1, 1),
+				module(1, 0, 0, 1), module(1, 0, 1, 1),
+			},
+		},
+		{
+			desc:       "module added",
+			regionsOut: regionsOut,
+			modules: []DeviceDiscovery{
+				module(0, 0, 0, 1), module(0, 0, 1, 1),
+				module(1, 0, 0, 1), module(1, 0, 1, 1),
+				module(1, 1, 0, 1),
+			},
+			expErr: FaultScmModulesChanged("not in a region: 0x1101"),
+		},
+		{
+			desc:       "module moved to another slot",
+			regionsOut: regionsOut,
+			modules: []DeviceDiscovery{
+				module(0, 0, 0, 1), module(0, 0, 1, 2),
+				module(1, 0, 0, 1), module(1, 0, 1, 1),
+			},
+			expErr: FaultScmModulesChanged(
+				"not in a region: 0x0012; not discovered: 0x0011"),
+		},
+		{
+			desc: "region membership unknown",
+			regionsOut: "\n" +
+				"---ISetID=0x2aba7f4828ef2ccc---\n" +
+				"   PersistentMemoryType=AppDirect\n" +
+				"   FreeCapacity=0.0 GiB\n" +
+				"\n",
+			modules: []DeviceDiscovery{module(0, 0, 0, 1)},
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			return tt.regionsOut, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := newMockScmStorage(nil, tt.modules, false, &config).
+			withRunCmd(mockRun)
+		ss.Discover(new(pb.ScanStorageResp)) // not concerned with response
+		if err := ss.getState(context.Background()); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		err := ss.CheckRegionModules()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestCheckModuleCount(t *testing.T) {
+	module := func(socket uint16) DeviceDiscovery {
+		m := MockModule()
+		m.Socket_id = socket
+		return m
+	}
+
+	tests := []struct {
+		desc     string
+		expected int
+		modules  []DeviceDiscovery
+		expErr   error
+	}{
+		{
+			desc:    "check disabled",
+			modules: []DeviceDiscovery{module(0)},
+		},
+		{
+			desc:     "matching counts",
+			expected: 2,
+			modules: []DeviceDiscovery{
+				module(0), module(0), module(1), module(1),
+			},
+		},
+		{
+			desc:     "short counts",
+			expected: 3,
+			modules: []DeviceDiscovery{
+				module(0), module(0), module(0), module(1), module(2),
+				module(2),
+			},
+			expErr: FaultScmMissingModules("socket 1: 1 of 3, socket 2: 2 of 3"),
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		config.ScmModsPerSock = tt.expected
+		ss := newMockScmStorage(nil, tt.modules, false, &config)
+		ss.Discover(new(pb.ScanStorageResp)) // not concerned with response
+
+		err := ss.CheckModuleCount()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestCheckModuleCapacities(t *testing.T) {
+	module := func(capacity uint64) DeviceDiscovery {
+		m := MockModule()
+		m.Capacity = capacity
+		return m
+	}
+
+	tests := []struct {
+		desc    string
+		modules []DeviceDiscovery
+		expErr  error
+	}{
+		{
+			desc: "no modules",
+		},
+		{
+			desc:    "uniform capacities",
+			modules: []DeviceDiscovery{module(512), module(512), module(512)},
+		},
+		{
+			desc:    "mixed capacities",
+			modules: []DeviceDiscovery{module(512), module(256), module(512)},
+			expErr:  FaultScmMismatchedCapacities([]uint64{512, 256}),
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := newMockScmStorage(nil, tt.modules, false, &config)
+		ss.Discover(new(pb.ScanStorageResp)) // not concerned with response
+
+		err := ss.checkModuleCapacities()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+	}
+}
+
+func TestCreateRegionsVerifyGoal(t *testing.T) {
+	tests := []struct {
+		desc              string
+		goalOut           string
+		notInterleaved    bool
+		expErr            error
+		expRebootRequired bool
+	}{
+		{
+			desc:              "matching goal",
+			goalOut:           mockGoalOut("0x0004", "0.0 GiB", "502.0 GiB"),
+			expRebootRequired: true,
+		},
+		{
+			desc:              "matching goal not interleaved",
+			goalOut:           mockGoalOut("0x0004", "0.0 GiB", "502.0 GiB"),
+			notInterleaved:    true,
+			expRebootRequired: true,
+		},
+		{
+			desc:    "empty goal",
+			goalOut: outScmNoGoal,
+			expErr:  FaultScmGoalMismatch("no pending goal"),
+		},
+		{
+			desc:    "goal not appdirect",
+			goalOut: mockGoalOut("0x0004", "502.0 GiB", "0.0 GiB"),
+			expErr:  FaultScmGoalMismatch("module 0x0001 not allocated as AppDirect"),
+		},
+		{
+			desc:    "goal missing socket",
+			goalOut: mockGoalOut("0x0000", "0.0 GiB", "502.0 GiB"),
+			expErr:  FaultScmGoalMismatch("no goal for socket 4"),
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			if in == cmdScmShowGoal {
+				return tt.goalOut, nil
+			}
+			return msgScmRebootRequired + "\n", nil
+		}
+
+		config := defaultMockConfig(t)
+		config.ScmInterleaved = !tt.notInterleaved
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+		ss.Discover(new(pb.ScanStorageResp)) // not concerned with response
+
+		createCmd := "ipmctl create -f -goal PersistentMemoryType=AppDirect"
+		if tt.notInterleaved {
+			createCmd = "ipmctl create -f -goal PersistentMemoryType=AppDirectNotInterleaved"
+		}
+
+		needsReboot, err := ss.createRegions(context.Background())
+		AssertEqual(t, commands,
+			[]string{cmdScmListNamespaces, createCmd, cmdScmShowGoal},
+			tt.desc+": unexpected list of commands run")
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, needsReboot, tt.expRebootRequired, tt.desc+": unexpected value for is reboot required")
+	}
+}
+
+func TestGetStateCapacity(t *testing.T) {
+	regionOut := func(freeCapacities ...string) string {
+		out := "\n"
+		for i, fc := range freeCapacities {
+			out += fmt.Sprintf("---ISetID=0x%016x---\n", i) +
+				"   PersistentMemoryType=AppDirect\n" +
+				"   FreeCapacity=" + fc + "\n"
+		}
+		return out + "\n"
+	}
+
+	tests := []struct {
+		desc       string
+		regionsOut string
+		errMsg     string
+		expState   scmState
+		expRegions []scmRegion
+	}{
+		{
+			desc:       "no regions",
+			regionsOut: outScmNoRegions,
+			expState:   scmStateNoRegions,
+		},
+		{
+			desc:       "all regions have free capacity",
+			regionsOut: regionOut("3012.0 GiB", "3012.0 GiB"),
+			expState:   scmStateFreeCapacity,
+			expRegions: []scmRegion{
+				{iSetID: "0x0000000000000000", memType: "AppDirect", freeCapacity: 3012},
+				{iSetID: "0x0000000000000001", memType: "AppDirect", freeCapacity: 3012},
+			},
+		},
+		{
+			desc:       "some regions have free capacity",
+			regionsOut: regionOut("0.0 GiB", "3012.0 GiB"),
+			expState:   scmStatePartialCapacity,
+			expRegions: []scmRegion{
+				{iSetID: "0x0000000000000000", memType: "AppDirect", freeCapacity: 0},
+				{iSetID: "0x0000000000000001", memType: "AppDirect", freeCapacity: 3012},
+			},
+		},
+		{
+			desc:       "no regions have free capacity",
+			regionsOut: regionOut("0.0 GiB", "0.0 GiB"),
+			expState:   scmStateNoCapacity,
+			expRegions: []scmRegion{
+				{iSetID: "0x0000000000000000", memType: "AppDirect", freeCapacity: 0},
+				{iSetID: "0x0000000000000001", memType: "AppDirect", freeCapacity: 0},
+			},
+		},
+		{
+			desc:       "free capacity below minimum namespace size",
+			regionsOut: regionOut("536870912 B", "1073741823 B"),
+			expState:   scmStateNoCapacity,
+			expRegions: []scmRegion{
+				{iSetID: "0x0000000000000000", memType: "AppDirect", freeCapacity: 0.5},
+				{iSetID: "0x0000000000000001", memType: "AppDirect", freeCapacity: float64(1073741823) / (1 << 30)},
+			},
+		},
+		{
+			desc:       "free capacity above minimum namespace size",
+			regionsOut: regionOut("536870912 B", "1610612736 B"),
+			expState:   scmStatePartialCapacity,
+			expRegions: []scmRegion{
+				{iSetID: "0x0000000000000000", memType: "AppDirect", freeCapacity: 0.5},
+				{iSetID: "0x0000000000000001", memType: "AppDirect", freeCapacity: 1.5},
+			},
+		},
+		{
+			desc:       "bad capacity unit",
+			regionsOut: regionOut("3012.0 GB"),
+			errMsg:     `unexpected capacity unit "3012.0 GB"`,
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			return tt.regionsOut, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		err := ss.getState(context.Background())
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.state, tt.expState, tt.desc+": unexpected scm state")
+		AssertEqual(t, ss.regions, tt.expRegions, tt.desc+": unexpected regions")
+	}
+}
+
+func TestRegionMinNamespaceSize(t *testing.T) {
+	tests := []struct {
+		desc        string
+		region      scmRegion
+		expFree     bool
+		expUnusable bool
+	}{
+		{"no free capacity", scmRegion{memType: "AppDirect"}, false, false},
+		{"below threshold", scmRegion{memType: "AppDirect", freeCapacity: 0.75}, false, true},
+		{"at threshold", scmRegion{memType: "AppDirect", freeCapacity: 1}, true, false},
+		{"below interleaved threshold", scmRegion{memType: "AppDirect", freeCapacity: 1.5, width: 2}, false, true},
+		{"above interleaved threshold", scmRegion{memType: "AppDirect", freeCapacity: 2.5, width: 2}, true, false},
+		{"not app direct", scmRegion{memType: "Volatile", freeCapacity: 3012}, false, false},
+	}
+
+	for _, tt := range tests {
+		AssertEqual(t, tt.region.hasFreeCapacity(), tt.expFree,
+			tt.desc+": unexpected free capacity")
+		AssertEqual(t, tt.region.hasUnusableCapacity(), tt.expUnusable,
+			tt.desc+": unexpected unusable capacity")
+	}
+}
+
+func TestRefreshState(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"\n"
+	outputs := []string{outScmNoRegions, regionsOut}
+
+	var commands []string
+	mockRun := func(in string) (string, error) {
+		commands = append(commands, in)
+		out := outputs[0]
+		outputs = outputs[1:]
+		return out, nil
+	}
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+	state, regions, err := ss.RefreshState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, state, scmStateNoRegions, "unexpected state before reboot")
+	AssertEqual(t, len(regions), 0, "unexpected regions before reboot")
+
+	state, regions, err = ss.RefreshState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, state, scmStateFreeCapacity, "unexpected state after reboot")
+	AssertEqual(t, ss.state, scmStateFreeCapacity, "cached state not updated")
+	AssertEqual(t, regions, []scmRegion{
+		{
+			iSetID:       "0x2aba7f4828ef2ccc",
+			memType:      "AppDirect",
+			freeCapacity: 3012,
+		},
+	}, "unexpected regions after reboot")
+
+	// only state queries should be issued
+	AssertEqual(t, commands, []string{cmdScmShowRegions, cmdScmShowRegions},
+		"unexpected list of commands run")
+}
+
+func TestRebootsRequired(t *testing.T) {
+	tests := []struct {
+		desc       string
+		regionsOut string
+		expState   scmState
+		expReboots int
+	}{
+		{
+			desc:       "no regions",
+			regionsOut: outScmNoRegions,
+			expState:   scmStateNoRegions,
+			expReboots: 1,
+		},
+		{
+			desc: "free capacity",
+			regionsOut: "\n" +
+				"---ISetID=0x2aba7f4828ef2ccc---\n" +
+				"   PersistentMemoryType=AppDirect\n" +
+				"   FreeCapacity=3012.0 GiB\n" +
+				"\n",
+			expState: scmStateFreeCapacity,
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			return tt.regionsOut, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		reboots, err := ss.RebootsRequired()
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.state, tt.expState, tt.desc+": unexpected state")
+		AssertEqual(t, reboots, tt.expReboots, tt.desc+": unexpected reboot count")
+	}
+}
+
+func TestScmRegionCache(t *testing.T) {
+	config := defaultMockConfig(t)
+	queries := 0
+	ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+		if cmd == cmdScmShowRegions {
+			queries++
+			return mockRegionsOut([]bool{true}), nil
+		}
+		return "", nil
+	})
+
+	check := func(desc string, fn func(context.Context) error, expQueries int) {
+		t.Helper()
+		if err := fn(context.Background()); err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+		AssertEqual(t, ss.state, scmStateFreeCapacity, desc+": unexpected state")
+		AssertEqual(t, queries, expQueries, desc+": unexpected region queries")
+	}
+	getState := func(desc string, expQueries int) {
+		t.Helper()
+		check(desc, ss.getState, expQueries)
+	}
+	refreshState := func(desc string, expQueries int) {
+		t.Helper()
+		check(desc, ss.refreshState, expQueries)
+	}
+
+	// established state reused until invalidated or refreshed
+	getState("initial", 1)
+	getState("state reused", 1)
+	refreshState("refresh", 2)
+	refreshState("refresh repeated", 3)
+	getState("state reused after refresh", 3)
+
+	if _, err := ss.createRegions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	getState("after region creation", 4)
+	getState("state reused after region creation", 4)
+
+	// namespace creation invalidates even if aborted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ss.createNamespaces(ctx); err == nil {
+		t.Fatal("expected cancelled namespace creation to fail")
+	}
+	getState("after namespace creation", 5)
+
+	if _, _, err := ss.RefreshState(); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, queries, 6, "explicit refresh should re-query regions")
+	getState("state reused after explicit refresh", 6)
+
+	// reuse bounded by ttl if set
+	ss.withRegionCache(time.Hour)
+	getState("cache miss", 7)
+	getState("cache hit within ttl", 7)
+
+	ss.withRegionCache(time.Nanosecond)
+	getState("ttl expired", 8)
+	time.Sleep(time.Millisecond)
+	getState("ttl expired repeated", 9)
+}
+
+func TestScmRegionCacheConfig(t *testing.T) {
+	config := defaultMockConfig(t)
+	AssertEqual(t, newScmStorage(&config).regionCache.ttl, time.Duration(0),
+		"region listing should be reused until invalidated by default")
+
+	config.ScmRegionTTL = time.Minute
+	AssertEqual(t, newScmStorage(&config).regionCache.ttl, time.Minute,
+		"region cache ttl should be taken from config")
+}
+
+func TestScmCmdTimeoutConfig(t *testing.T) {
+	config := defaultMockConfig(t)
+	AssertEqual(t, newScmStorage(&config).cmdTimeout, time.Duration(0),
+		"default command timeout should apply if unset in config")
+
+	config.ScmCmdTimeout = 10 * time.Minute
+	AssertEqual(t, newScmStorage(&config).cmdTimeout, 10*time.Minute,
+		"command timeout should be taken from config")
+}
+
+func TestGetStateReuse(t *testing.T) {
+	numRegions := 2
+	nd := &mockNdctl{}
+	var commands []string
+	// region free capacity consumed by each namespace created
+	mockRun := func(cmd string) (string, error) {
+		free := make([]bool, numRegions)
+		for i := len(nd.namespaces); i < numRegions; i++ {
+			free[i] = true
+		}
+		if cmd == cmdScmListNdRegions {
+			return mockNdRegionsOut(mockRegionsOut(free)), nil
+		}
+		commands = append(commands, cmd)
+		return mockRegionsOut(free), nil
+	}
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun).withNdctl(nd)
+
+	if _, err := ss.RebootsRequired(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.PreviewNamespaces(); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, len(commands), 1, "state not reused by preview")
+
+	// state re-established after each namespace is created
+	result, err := ss.Prep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, len(result.Namespaces), numRegions, "unexpected namespaces")
+	AssertEqual(t, len(commands), 1+numRegions,
+		"unexpected region queries during prep")
+	AssertEqual(t, ss.state, scmStateNoCapacity, "unexpected state")
+
+	// prep invalidates state on completion
+	if _, err := ss.RebootsRequired(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.RebootsRequired(); err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, commands, []string{
+		cmdScmShowRegions, cmdScmShowRegions, cmdScmShowRegions,
+		cmdScmShowRegions,
+	}, "unexpected commands")
+}
+
+func TestWaitForRegions(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"\n"
+
+	tests := []struct {
+		desc       string
+		noRegions  int // polls reporting no regions before regions appear
+		failures   int // polls failing before any regions are reported
+		timeout    time.Duration
+		errMsg     string
+		expState   scmState
+		expCommand int
+	}{
+		{
+			desc:       "regions available",
+			timeout:    time.Second,
+			expState:   scmStateFreeCapacity,
+			expCommand: 1,
+		},
+		{
+			desc:       "regions available after reboot",
+			noRegions:  3,
+			timeout:    time.Second,
+			expState:   scmStateFreeCapacity,
+			expCommand: 4,
+		},
+		{
+			desc:       "state transiently unavailable",
+			failures:   2,
+			noRegions:  1,
+			timeout:    time.Second,
+			expState:   scmStateFreeCapacity,
+			expCommand: 4,
+		},
+		{
+			desc:      "timeout",
+			noRegions: 1000,
+			timeout:   20 * time.Millisecond,
+			errMsg:    msgScmRegionsTimeout,
+			expState:  scmStateNoRegions,
+		},
+	}
+
+	for _, tt := range tests {
+		var commands int
+		mockRun := func(in string) (string, error) {
+			commands++
+			switch {
+			case commands <= tt.failures:
+				return "", errors.New("ipmctl: device busy")
+			case commands <= tt.failures+tt.noRegions:
+				return outScmNoRegions, nil
+			default:
+				return regionsOut, nil
+			}
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		state, err := ss.WaitForRegions(context.Background(), tt.timeout,
+			time.Millisecond)
+		AssertEqual(t, state, tt.expState, tt.desc+": unexpected state")
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		AssertEqual(t, commands, tt.expCommand, tt.desc+": unexpected number of polls")
+	}
+}
+
+func TestPrepReset(t *testing.T) {
+	config := defaultMockConfig(t)
+	var cmds []string
+	ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+		cmds = append(cmds, cmd)
+		return "", nil
+	})
+
+	ExpectError(t, ss.PrepReset(), msgScmResetNotImpl, "prep reset")
+	AssertEqual(t, cmds, []string(nil), "no commands expected from prep reset")
+}
+
+func TestPrepResetPreview(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=3012.0 GiB\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"   SocketID=0x0000\n" +
+		"---ISetID=0x81187f4881f02ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=3012.0 GiB\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"   SocketID=0x0001\n" +
+		"\n"
+	pmemOut := `[{"blockdev":"pmem0","name":"daos_io_server_0","numa_node":0},` +
+		`{"blockdev":"pmem1","name":"scratch","numa_node":1}]`
+
+	tests := []struct {
+		desc       string
+		regionsOut string
+		expPreview *resetPreview
+	}{
+		{
+			desc:       "no regions",
+			regionsOut: outScmNoRegions,
+			expPreview: &resetPreview{},
+		},
+		{
+			desc:       "populated",
+			regionsOut: regionsOut,
+			expPreview: &resetPreview{
+				Namespaces: mockPmemDevs(t, pmemOut),
+				Regions: []resetRegion{
+					{
+						ISetID:   "0x2aba7f4828ef2ccc",
+						MemType:  "AppDirect",
+						Capacity: newScmSize(3012 << 30),
+					},
+					{
+						ISetID:   "0x81187f4881f02ccc",
+						MemType:  "AppDirect",
+						SocketID: 1,
+						Capacity: newScmSize(3012 << 30),
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+			commands = append(commands, cmd)
+			switch cmd {
+			case cmdScmShowRegions:
+				return tt.regionsOut, nil
+			case cmdScmListNamespaces:
+				return pmemOut, nil
+			}
+			return "", errors.Errorf("unexpected command %q", cmd)
+		})
+
+		preview, err := ss.PrepResetPreview()
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, preview, tt.expPreview, tt.desc+": unexpected preview")
+
+		// nothing should be destroyed
+		for _, cmd := range commands {
+			if cmd != cmdScmShowRegions && cmd != cmdScmListNamespaces {
+				t.Fatalf("%s: unexpected command %q", tt.desc, cmd)
+			}
+		}
+	}
+}
+
+func TestPreviewNamespaces(t *testing.T) {
+	tests := []struct {
+		desc        string
+		regionsOut  string
+		expPreviews []pmemPreview
+	}{
+		{
+			desc:       "no regions",
+			regionsOut: outScmNoRegions,
+		},
+		{
+			desc: "single region with free capacity",
+			regionsOut: "\n" +
+				"---ISetID=0x2aba7f4828ef2ccc---\n" +
+				"   PersistentMemoryType=AppDirect\n" +
+				"   FreeCapacity=0.0 GiB\n" +
+				"---ISetID=0x81187f4881f02ccc---\n" +
+				"   PersistentMemoryType=AppDirect\n" +
+				"   FreeCapacity=3012.0 GiB\n" +
+				"\n",
+			expPreviews: []pmemPreview{
+				{Name: pmemName(0), ISetID: "0x81187f4881f02ccc", Size: 3012 << 30},
+			},
+		},
+		{
+			desc: "multiple regions with byte precise free capacity",
+			regionsOut: "\n" +
+				"---ISetID=0x2aba7f4828ef2ccc---\n" +
+				"   PersistentMemoryType=AppDirect\n" +
+				"   FreeCapacity=3234110029824 B\n" +
+				"---ISetID=0x81187f4881f02ccc---\n" +
+				"   PersistentMemoryType=AppDirect\n" +
+				"   FreeCapacity=1617055014912 B\n" +
+				"\n",
+			expPreviews: []pmemPreview{
+				{Name: pmemName(0), ISetID: "0x2aba7f4828ef2ccc", Size: 3234110029824},
+				{Name: pmemName(1), ISetID: "0x81187f4881f02ccc", Size: 1617055014912},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			return tt.regionsOut, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		previews, err := ss.PreviewNamespaces()
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		// nothing should be created
+		AssertEqual(t, commands, []string{cmdScmShowRegions}, tt.desc+": unexpected list of commands run")
+		AssertEqual(t, previews, tt.expPreviews, tt.desc+": unexpected namespace previews")
+	}
+}
+
+func TestCreateNamespacesReserved(t *testing.T) {
+	regionOut := func(capacity, free uint64) string {
+		return "\n" +
+			"---ISetID=0x2aba7f4828ef2ccc---\n" +
+			"   PersistentMemoryType=AppDirect\n" +
+			fmt.Sprintf("   Capacity=%d B\n", capacity) +
+			fmt.Sprintf("   FreeCapacity=%d B\n", free) +
+			"   HealthState=Healthy\n" +
+			"\n"
+	}
+	capacity := uint64(1008<<30) + (512 << 20) // not aligned
+	pmemOut := `{"blockdev":"pmem0","name":"daos_io_server_0","numa_node":0}`
+
+	tests := []struct {
+		desc       string
+		reservePct int
+		free       uint64
+		expCmds    []string
+		expDevs    []pmemDev
+	}{
+		{
+			desc: "no reservation",
+			free: capacity,
+			expCmds: []string{
+				cmdScmListNdRegions,
+				cmdScmCreateNamespace + " -n daos_io_server_0",
+				cmdScmShowRegions,
+			},
+			expDevs: []pmemDev{{Blockdev: "pmem0", Name: pmemName(0)}},
+		},
+		{
+			desc:       "reservation leaves threshold free",
+			reservePct: 10,
+			free:       capacity,
+			expCmds: []string{
+				cmdScmListNdRegions,
+				// 90% of 1008.5 GiB rounded down to whole GiB
+				fmt.Sprintf("%s -n daos_io_server_0 -s %d",
+					cmdScmCreateNamespace, uint64(907<<30)),
+				cmdScmShowRegions,
+				cmdScmListNdRegions,
+			},
+			expDevs: []pmemDev{{Blockdev: "pmem0", Name: pmemName(0)}},
+		},
+		{
+			desc:       "free capacity already at threshold",
+			reservePct: 10,
+			free:       capacity / 10,
+			expCmds:    []string{cmdScmListNdRegions, cmdScmListNamespaces},
+			expDevs:    []pmemDev{{Blockdev: "pmem0", Name: pmemName(0)}},
+		},
+	}
+
+	for _, tt := range tests {
+		free := tt.free
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+
+			switch {
+			case in == cmdScmShowRegions:
+				return regionOut(capacity, free), nil
+			case in == cmdScmListNdRegions:
+				// sized from ipmctl regions if ndctl lists none
+				return "[]", nil
+			case strings.HasPrefix(in, cmdScmCreateNamespace):
+				// remaining capacity after creation
+				free = 0
+				if strings.Contains(in, " -s ") {
+					free = capacity * uint64(tt.reservePct) / 100
+				}
+				return pmemOut, nil
+			case in == cmdScmListNamespaces:
+				return pmemOut, nil
+			}
+
+			return "", nil
+		}
+
+		config := defaultMockConfig(t)
+		config.Servers[0].ScmReservePct = tt.reservePct
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		if err := ss.getState(context.Background()); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		commands = nil
+
+		devs, err := ss.createNamespaces(context.Background())
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, commands, tt.expCmds, tt.desc+": unexpected list of commands run")
+		AssertEqual(t, devs, tt.expDevs, tt.desc+": unexpected devices")
+	}
+}
+
+func TestCreateNamespacesParallel(t *testing.T) {
+	regionsOut := `[` +
+		`{"dev":"region0","size":1082331758592,"available_size":1082331758592,"numa_node":0},` +
+		`{"dev":"region1","size":1082331758592,"available_size":1082331758592,"numa_node":1},` +
+		`{"dev":"region2","size":1082331758592,"available_size":0,"numa_node":0},` +
+		`{"dev":"region3","size":1082331758592,"available_size":1082331758592,"numa_node":1}` +
+		`]`
+	regionNuma := map[string]int{"region0": 0, "region1": 1, "region3": 1}
+
+	tests := []struct {
+		desc       string
+		workers    int
+		reservePct int
+		expCmds    []string
+	}{
+		{
+			desc:    "bounded workers",
+			workers: 2,
+			expCmds: []string{
+				cmdScmCreateNamespace + " -n daos_io_server_0 -r region0",
+				cmdScmCreateNamespace + " -n daos_io_server_1 -r region1",
+				cmdScmCreateNamespace + " -n daos_io_server_2 -r region3",
+			},
+		},
+		{
+			desc:       "reserved capacity",
+			workers:    3,
+			reservePct: 10,
+			expCmds: []string{
+				// 90% of 1008 GiB rounded down to whole GiB
+				fmt.Sprintf("%s -n daos_io_server_0 -r region0 -s %d",
+					cmdScmCreateNamespace, uint64(907<<30)),
+				cmdScmCreateNamespace + " -n daos_io_server_1 -r region1",
+				cmdScmCreateNamespace + " -n daos_io_server_2 -r region3",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var commands []string
+		running, maxRunning := 0, 0
+
+		mockRun := func(in string) (string, error) {
+			if in == cmdScmListNdRegions {
+				return regionsOut, nil
+			}
+
+			mu.Lock()
+			commands = append(commands, in)
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			fields := strings.Fields(in)
+			name, region := fields[3], fields[5]
+			return fmt.Sprintf(`{"blockdev":"pmem%s","name":"%s","numa_node":%d}`,
+				strings.TrimPrefix(region, "region"), name,
+				regionNuma[region]), nil
+		}
+
+		config := defaultMockConfig(t)
+		config.Servers[0].ScmReservePct = tt.reservePct
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun).
+			withNamespaceWorkers(tt.workers)
+
+		devs, err := ss.createNamespaces(context.Background())
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		sort.Strings(commands)
+		AssertEqual(t, commands, tt.expCmds, tt.desc+": unexpected list of commands run")
+		AssertEqual(t, devs, []pmemDev{
+			{Blockdev: "pmem0", Name: pmemName(0), NumaNode: 0},
+			{Blockdev: "pmem1", Name: pmemName(1), NumaNode: 1, SrvIdx: 1},
+			{Blockdev: "pmem3", Name: pmemName(2), NumaNode: 1, SrvIdx: 2},
+		}, tt.desc+": unexpected devices")
+		if maxRunning > tt.workers {
+			t.Fatalf("%s: %d concurrent creations exceeds %d workers",
+				tt.desc, maxRunning, tt.workers)
+		}
+		if maxRunning < 2 {
+			t.Fatalf("%s: expected concurrent creations, got %d",
+				tt.desc, maxRunning)
+		}
+	}
+}
+
+func TestCreateNamespacesSocketOrder(t *testing.T) {
+	// regions listed by ndctl alternating between sockets
+	regionNuma := []int{1, 0, 1, 0}
+
+	for _, workers := range []int{0, 2} {
+		desc := fmt.Sprintf("%d workers", workers)
+
+		var mu sync.Mutex
+		var commands []string
+		created := make(map[string]bool)
+		mockRun := func(in string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch in {
+			case cmdScmListNdRegions:
+				var regions []string
+				for i, numa := range regionNuma {
+					region := fmt.Sprintf("region%d", i)
+					avail := 1082331758592
+					if created[region] {
+						avail = 0
+					}
+					regions = append(regions, fmt.Sprintf(
+						`{"dev":"%s","size":1082331758592,"available_size":%d,"numa_node":%d}`,
+						region, avail, numa))
+				}
+				return "[" + strings.Join(regions, ",") + "]", nil
+			case cmdScmShowRegions:
+				free := make([]bool, len(regionNuma))
+				for i := range regionNuma {
+					free[i] = !created[fmt.Sprintf("region%d", i)]
+				}
+				return mockRegionsOut(free), nil
+			}
+
+			commands = append(commands, in)
+
+			var name, region string
+			fmt.Sscanf(strings.TrimPrefix(in, cmdScmCreateNamespace),
+				" -n %s -r %s", &name, &region)
+			created[region] = true
+			var idx int
+			fmt.Sscanf(region, "region%d", &idx)
+			return fmt.Sprintf(`{"blockdev":"pmem%d","name":"%s","numa_node":%d}`,
+				idx, name, regionNuma[idx]), nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun).
+			withNamespaceWorkers(workers)
+
+		devs, err := ss.createNamespaces(context.Background())
+		if err != nil {
+			t.Fatal(desc + ": " + err.Error())
+		}
+
+		sort.Strings(commands)
+		AssertEqual(t, commands, []string{
+			cmdScmCreateNamespace + " -n daos_io_server_0 -r region1",
+			cmdScmCreateNamespace + " -n daos_io_server_1 -r region3",
+			cmdScmCreateNamespace + " -n daos_io_server_2 -r region0",
+			cmdScmCreateNamespace + " -n daos_io_server_3 -r region2",
+		}, desc+": unexpected creation order")
+		AssertEqual(t, devs, []pmemDev{
+			{Blockdev: "pmem1", Name: pmemName(0), NumaNode: 0},
+			{Blockdev: "pmem3", Name: pmemName(1), NumaNode: 0, SrvIdx: 1},
+			{Blockdev: "pmem0", Name: pmemName(2), NumaNode: 1, SrvIdx: 2},
+			{Blockdev: "pmem2", Name: pmemName(3), NumaNode: 1, SrvIdx: 3},
+		}, desc+": unexpected devices")
+	}
+}
+
+func TestUnmountedNamespaces(t *testing.T) {
+	nsOut := `[{"blockdev":"pmem0","numa_node":0},` +
+		`{"blockdev":"pmem1","numa_node":1},` +
+		`{"blockdev":"pmem2","numa_node":0}]`
+
+	tests := []struct {
+		desc    string
+		mounts  map[string][]string
+		expDevs []pmemDev
+	}{
+		{
+			desc: "none mounted",
+			expDevs: []pmemDev{
+				{Blockdev: "pmem0", NumaNode: 0, SrvIdx: -1},
+				{Blockdev: "pmem1", NumaNode: 1, SrvIdx: -1},
+				{Blockdev: "pmem2", NumaNode: 0, SrvIdx: -1},
+			},
+		},
+		{
+			desc: "some mounted",
+			mounts: map[string][]string{
+				"/dev/pmem0": {"/mnt/daos"},
+				"tmpfs":      {"/dev/shm"},
+			},
+			expDevs: []pmemDev{
+				{Blockdev: "pmem1", NumaNode: 1, SrvIdx: -1},
+				{Blockdev: "pmem2", NumaNode: 0, SrvIdx: -1},
+			},
+		},
+		{
+			desc: "mounted at unexpected location",
+			mounts: map[string][]string{
+				"/dev/pmem0": {"/mnt/daos/"},
+				"/dev/pmem2": {"/mnt/other"},
+			},
+			expDevs: []pmemDev{
+				{Blockdev: "pmem1", NumaNode: 1, SrvIdx: -1},
+			},
+		},
+		{
+			desc: "all mounted",
+			mounts: map[string][]string{
+				"/dev/pmem0": {"/mnt/daos"},
+				"/dev/pmem1": {"/mnt/daos1"},
+				"/dev/pmem2": {"/mnt/daos2", "/mnt/other"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			return nsOut, nil
+		}
+
+		config := defaultMockConfig(t)
+		config.ext = &mockExt{mountsRet: tt.mounts}
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		devs, err := ss.UnmountedNamespaces()
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, devs, tt.expDevs, tt.desc+": unexpected unmounted devices")
+	}
+}
+
+func TestCreateNamespace(t *testing.T) {
+	pmemOut := `{
+   "dev":"namespace1.0",
+   "mode":"fsdax",
+   "blockdev":"pmem1",
+   %s
+   "numa_node":1
+}
+`
+	tests := []struct {
+		desc       string
+		name       string
+		nameOut    string
+		errMsg     string
+		expCommand string
+		expDev     pmemDev
+	}{
+		{
+			desc:       "name reported by ndctl",
+			name:       pmemName(1),
+			nameOut:    `"name":"daos_io_server_1",`,
+			expCommand: cmdScmCreateNamespace + " -n daos_io_server_1",
+			expDev: pmemDev{
+				Blockdev: "pmem1", Name: "daos_io_server_1", NumaNode: 1,
+				SrvIdx: 1,
+			},
+		},
+		{
+			desc:       "name not reported by ndctl",
+			name:       "daos.pmem-0",
+			expCommand: cmdScmCreateNamespace + " -n daos.pmem-0",
+			expDev: pmemDev{
+				Blockdev: "pmem1", Name: "daos.pmem-0", NumaNode: 1,
+				SrvIdx: -1,
+			},
+		},
+		{
+			desc:   "empty name",
+			errMsg: msgScmBadNamespaceName + `: ""`,
+		},
+		{
+			desc:   "name with invalid characters",
+			name:   "daos; rm -rf /",
+			errMsg: msgScmBadNamespaceName + `: "daos; rm -rf /"`,
+		},
+		{
+			desc: "name too long",
+			name: strings.Repeat("a", maxPmemNameLen+1),
+			errMsg: fmt.Sprintf("%s: %q", msgScmBadNamespaceName,
+				strings.Repeat("a", maxPmemNameLen+1)),
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			return fmt.Sprintf(pmemOut, tt.nameOut), nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		devs, err := ss.createNamespace(context.Background(), tt.name, 0)
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			AssertEqual(t, len(commands), 0, tt.desc+": unexpected commands run")
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, commands, []string{tt.expCommand}, tt.desc+": unexpected command")
+		AssertEqual(t, devs, []pmemDev{tt.expDev}, tt.desc+": unexpected pmem devices")
+	}
+}
+
+func TestNamespaceOwner(t *testing.T) {
+	nsOut := `[{"blockdev":"pmem0","name":"daos_io_server_1","numa_node":0},` +
+		`{"blockdev":"pmem1","name":"daos_io_server_0","numa_node":1},` +
+		`{"blockdev":"pmem2","name":"other_1","numa_node":0},` +
+		`{"blockdev":"pmem3","numa_node":1}]`
+
+	mockRun := func(in string) (string, error) {
+		return nsOut, nil
+	}
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+	devs, err := ss.getNamespaces(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertEqual(t, devs, []pmemDev{
+		{Blockdev: "pmem0", Name: pmemName(1), NumaNode: 0, SrvIdx: 1},
+		{Blockdev: "pmem1", Name: pmemName(0), NumaNode: 1, SrvIdx: 0},
+		{Blockdev: "pmem2", Name: "other_1", NumaNode: 0, SrvIdx: -1},
+		{Blockdev: "pmem3", NumaNode: 1, SrvIdx: -1},
+	}, "unexpected namespace owners")
+	AssertEqual(t, devs[0].String(), "pmem0 (daos_io_server_1), numa 0, server 1",
+		"unexpected owned namespace description")
+	AssertEqual(t, devs[2].String(), "pmem2 (other_1), numa 0",
+		"unexpected unowned namespace description")
+}
+
+func TestParsePmemDevsSchema(t *testing.T) {
+	tests := []struct {
+		desc    string
+		out     string
+		errMsg  string
+		expDevs []pmemDev
+	}{
+		{
+			desc: "numa_node field",
+			out:  `[{"blockdev":"pmem0","numa_node":0},{"blockdev":"pmem1","numa_node":1}]`,
+			expDevs: []pmemDev{
+				{Blockdev: "pmem0", NumaNode: 0, SrvIdx: -1},
+				{Blockdev: "pmem1", NumaNode: 1, SrvIdx: -1},
+			},
+		},
+		{
+			desc: "numanode field",
+			out:  `[{"blockdev":"pmem0","numanode":0},{"blockdev":"pmem1","numanode":1}]`,
+			expDevs: []pmemDev{
+				{Blockdev: "pmem0", NumaNode: 0, SrvIdx: -1},
+				{Blockdev: "pmem1", NumaNode: 1, SrvIdx: -1},
+			},
+		},
+		{
+			desc: "single entry numanode field",
+			out:  `{"blockdev":"pmem1","name":"daos_io_server_0","numanode":1}`,
+			expDevs: []pmemDev{
+				{Blockdev: "pmem1", Name: pmemName(0), NumaNode: 1},
+			},
+		},
+		{
+			desc: "no numa node",
+			out:  `{"blockdev":"pmem0"}`,
+			expDevs: []pmemDev{
+				{Blockdev: "pmem0", SrvIdx: -1},
+			},
+		},
+		{
+			desc:    "no namespaces",
+			expDevs: []pmemDev{},
+		},
+		{
+			desc:   "unknown schema",
+			out:    `[{"device":"pmem0","numanode":0}]`,
+			errMsg: msgNdctlUnknownSchema + ": no namespace device",
+		},
+		{
+			desc:   "not json",
+			out:    "ndctl: unknown option",
+			errMsg: "parse ndctl namespaces: " + msgNdctlNoJSON + `: "ndctl: unknown option"`,
+		},
+	}
+
+	for _, tt := range tests {
+		devs, err := parsePmemDevs(tt.out)
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, devs, tt.expDevs, tt.desc+": unexpected pmem devices")
+	}
+}
+
+func TestParsePmemDevsNoise(t *testing.T) {
+	pmem0 := `{"blockdev":"pmem0","numa_node":0}`
+	pmem1 := `{"blockdev":"pmem1","numa_node":1}`
+	expOne := []pmemDev{{Blockdev: "pmem0", NumaNode: 0, SrvIdx: -1}}
+	expTwo := []pmemDev{
+		{Blockdev: "pmem0", NumaNode: 0, SrvIdx: -1},
+		{Blockdev: "pmem1", NumaNode: 1, SrvIdx: -1},
+	}
+
+	tests := []struct {
+		desc    string
+		out     string
+		errMsg  string
+		expDevs []pmemDev
+	}{
+		{
+			desc:    "empty",
+			expDevs: []pmemDev{},
+		},
+		{
+			desc:    "whitespace",
+			out:     " \n\t\n",
+			expDevs: []pmemDev{},
+		},
+		{
+			desc:    "single object",
+			out:     pmem0 + "\n",
+			expDevs: expOne,
+		},
+		{
+			desc:    "array of objects",
+			out:     "[\n  " + pmem0 + ",\n  " + pmem1 + "\n]\n",
+			expDevs: expTwo,
+		},
+		{
+			desc: "leading warning line",
+			out: "libndctl: ndctl_namespace_enable: namespace1.0: failed to enable\n" +
+				pmem0 + "\n",
+			expDevs: expOne,
+		},
+		{
+			desc: "bracketed leading warning and trailing text",
+			out: "[  102.345] nd_pmem namespace0.0: unable to guarantee persistence\n" +
+				"[" + pmem0 + "," + pmem1 + "]\n" +
+				"created 2 namespaces\n",
+			expDevs: expTwo,
+		},
+		{
+			desc: "bracketed leading warning and single object",
+			out: "[  102.345] nd_pmem namespace0.0: unable to guarantee persistence\n" +
+				pmem0 + "\n",
+			expDevs: expOne,
+		},
+		{
+			desc:   "warning only",
+			out:    "libndctl: ndctl_region_get_available_size: region0: invalid\n",
+			errMsg: msgNdctlNoJSON,
+		},
+		{
+			desc:   "truncated json",
+			out:    "warning\n[" + pmem0 + ",",
+			errMsg: msgNdctlNoJSON,
+		},
+	}
+
+	for _, tt := range tests {
+		devs, err := parsePmemDevs(tt.out)
+		if tt.errMsg != "" {
+			ExpectError(t, err,
+				fmt.Sprintf("parse ndctl namespaces: %s: %q", tt.errMsg, tt.out),
+				tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, devs, tt.expDevs, tt.desc+": unexpected pmem devices")
+	}
+}
+
+func TestCreateNamespaceExtraFlags(t *testing.T) {
+	tests := []struct {
+		desc       string
+		flags      []string
+		errMsg     string
+		expCommand string
+	}{
+		{
+			desc:       "no extra flags",
+			expCommand: cmdScmCreateNamespace + " -n daos_io_server_0",
+		},
+		{
+			desc:  "extra flags",
+			flags: []string{"--no-autolabel", "--sector-size=4096"},
+			expCommand: cmdScmCreateNamespace + " -n daos_io_server_0 " +
+				"-s 1073741824 --no-autolabel --sector-size=4096",
+		},
+		{
+			desc:   "unsafe flag",
+			flags:  []string{"--no-autolabel", "-f; rm -rf /"},
+			errMsg: msgScmBadNdctlFlag + `: "-f; rm -rf /"`,
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			return `{"blockdev":"pmem0","numa_node":0}`, nil
+		}
+
+		config := defaultMockConfig(t)
+		config.ScmNdctlFlags = tt.flags
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		var size uint64
+		if len(tt.flags) != 0 {
+			size = 1 << 30
+		}
+		_, err := ss.createNamespace(context.Background(), pmemName(0), size)
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			AssertEqual(t, len(commands), 0, tt.desc+": unexpected commands run")
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, commands, []string{tt.expCommand}, tt.desc+": unexpected command")
+	}
+}
+
+func TestResolveStableID(t *testing.T) {
+	nsOut := `[{"uuid":"842fc847-28e0-4bb6-8dfc-d24afdba1528","blockdev":"pmem1","numa_node":0},` +
+		`{"uuid":"2ec7b8d6-8ef0-4e5b-bd59-4c5e2f4f6a90","blockdev":"pmem0","numa_node":1},` +
+		`{"blockdev":"pmem2","numa_node":1}]`
+
+	tests := []struct {
+		desc       string
+		devList    []string
+		errMsg     string
+		expDevList []string
+		expCmds    []string
+	}{
+		{
+			desc:       "device paths",
+			devList:    []string{"/dev/pmem0", "/dev/pmem1"},
+			expDevList: []string{"/dev/pmem0", "/dev/pmem1"},
+		},
+		{
+			desc: "stable identities",
+			devList: []string{
+				"uuid:842fc847-28e0-4bb6-8dfc-d24afdba1528",
+				"uuid:2ec7b8d6-8ef0-4e5b-bd59-4c5e2f4f6a90",
+			},
+			expDevList: []string{"/dev/pmem1", "/dev/pmem0"},
+			expCmds:    []string{cmdScmListNamespaces, cmdScmListNamespaces},
+		},
+		{
+			desc: "mixed",
+			devList: []string{
+				"/dev/pmem2",
+				"uuid:2ec7b8d6-8ef0-4e5b-bd59-4c5e2f4f6a90",
+			},
+			expDevList: []string{"/dev/pmem2", "/dev/pmem0"},
+			expCmds:    []string{cmdScmListNamespaces},
+		},
+		{
+			desc:    "unknown identity",
+			devList: []string{"uuid:6cb3b8bd-7d4e-4e49-b1d4-8b0d9fd0f3b5"},
+			errMsg: msgScmUnknownStableID +
+				": uuid:6cb3b8bd-7d4e-4e49-b1d4-8b0d9fd0f3b5",
+			expCmds: []string{cmdScmListNamespaces},
+		},
+		{
+			desc:    "namespace without uuid",
+			devList: []string{"uuid:"},
+			errMsg:  msgScmUnknownStableID + ": uuid:",
+			expCmds: []string{cmdScmListNamespaces},
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			return nsOut, nil
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		devList, err := ss.resolveDevList(tt.devList)
+		AssertEqual(t, commands, tt.expCmds, tt.desc+": unexpected commands")
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, devList, tt.expDevList, tt.desc+": unexpected device list")
+	}
+}
+
+func TestGetErrorLog(t *testing.T) {
+	thermalOut := "\n" +
+		"---DimmID=0x0001---\n" +
+		"Thermal Error occurred\n" +
+		"   System Timestamp : 1527267471\n" +
+		"   Temperature : 86C\n" +
+		"   Reported : 4 - Critical\n" +
+		"   Sequence Number : 1\n" +
+		"---DimmID=0x0101---\n" +
+		"No errors found on DimmID 0x0101\n"
+	mediaOut := "\n" +
+		"---DimmID=0x0001---\n" +
+		"Media Error occurred\n" +
+		"   System Timestamp : 1527266471\n" +
+		"   DPA : 0x000014c0\n" +
+		"   Error Type : 4 - Data path error\n" +
+		"Media Error occurred\n" +
+		"   System Timestamp : 1527266472\n" +
+		"   DPA : 0x000014c8\n" +
+		"   Error Type : 4 - Data path error\n" +
+		"---DimmID=0x0101---\n" +
+		"No errors found on DimmID 0x0101\n"
+	noErrorsOut := "\n" +
+		"---DimmID=0x0001---\n" +
+		"No errors found on DimmID 0x0001\n"
+
+	tests := []struct {
+		desc    string
+		outputs map[string]string
+		cmdErr  error
+		errMsg  string
+		expLogs map[string][]scmErrorLogEntry
+	}{
+		{
+			desc: "thermal and media errors",
+			outputs: map[string]string{
+				"Thermal": thermalOut,
+				"Media":   mediaOut,
+			},
+			expLogs: map[string][]scmErrorLogEntry{
+				"0x0001": {
+					{
+						DimmID: "0x0001", Type: "Thermal", Timestamp: 1527267471,
+						Details: map[string]string{
+							"Temperature":     "86C",
+							"Reported":        "4 - Critical",
+							"Sequence Number": "1",
+						},
+					},
+					{
+						DimmID: "0x0001", Type: "Media", Timestamp: 1527266471,
+						Details: map[string]string{
+							"DPA":        "0x000014c0",
+							"Error Type": "4 - Data path error",
+						},
+					},
+					{
+						DimmID: "0x0001", Type: "Media", Timestamp: 1527266472,
+						Details: map[string]string{
+							"DPA":        "0x000014c8",
+							"Error Type": "4 - Data path error",
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "no log entries",
+			outputs: map[string]string{
+				"Thermal": noErrorsOut,
+				"Media":   noErrorsOut,
+			},
+			expLogs: map[string][]scmErrorLogEntry{},
+		},
+		{
+			desc:   "command failure",
+			cmdErr: errors.New("example failure"),
+			errMsg: "ipmctl show Thermal error log: example failure",
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			for logType, out := range tt.outputs {
+				if in == fmt.Sprintf(cmdScmShowErrorLog, logType) {
+					return out, nil
+				}
+			}
+			return "", tt.cmdErr
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		logs, err := ss.GetErrorLog()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, logs, tt.expLogs, tt.desc+": unexpected error logs")
+	}
+}
+
+func TestGetSensors(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	tests := []struct {
+		desc       string
+		sensorsOut string
+		cmdErr     error
+		errMsg     string
+		expSensors []scmSensors
+	}{
+		{
+			desc: "multiple modules",
+			sensorsOut: "\n" +
+				" DimmID | Type                  | CurrentValue\n" +
+				"==============================================\n" +
+				" 0x0001 | MediaTemperature      | 35C\n" +
+				" 0x0001 | ControllerTemperature | 40C\n" +
+				" 0x0001 | PowerOnTime           | 2045s\n" +
+				" 0x0101 | MediaTemperature      | 36C\n" +
+				" 0x0101 | ControllerTemperature | 41C\n" +
+				" 0x0101 | PowerOnTime           | 2046s\n",
+			expSensors: []scmSensors{
+				{
+					DimmID:          "0x0001",
+					MediaTempC:      intPtr(35),
+					ControllerTempC: intPtr(40),
+					PowerOnSecs:     intPtr(2045),
+				},
+				{
+					DimmID:          "0x0101",
+					MediaTempC:      intPtr(36),
+					ControllerTempC: intPtr(41),
+					PowerOnSecs:     intPtr(2046),
+				},
+			},
+		},
+		{
+			desc: "unsupported sensors",
+			sensorsOut: "\n" +
+				" DimmID | Type                  | CurrentValue\n" +
+				"==============================================\n" +
+				" 0x0001 | MediaTemperature      | 35C\n" +
+				" 0x0001 | ControllerTemperature | N/A\n",
+			expSensors: []scmSensors{
+				{DimmID: "0x0001", MediaTempC: intPtr(35)},
+			},
+		},
+		{
+			desc:   "command failure",
+			cmdErr: errors.New("example failure"),
+			errMsg: "ipmctl show sensors: example failure",
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			AssertEqual(t, in, cmdScmShowSensors, tt.desc+": unexpected command")
+			return tt.sensorsOut, tt.cmdErr
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+
+		sensors, err := ss.GetSensors()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, sensors, tt.expSensors, tt.desc+": unexpected sensor readings")
+	}
+}
+
+func TestScmHealth(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+
+	mm1 := MockModule()
+	mm2 := MockModule()
+	mm2.Physical_id = 2
+	mm2.Channel_pos = 1
+	modules := loadModules([]DeviceDiscovery{mm1, mm2})
+	id1, id2 := moduleDimmID(modules[0]), moduleDimmID(modules[1])
+	pid1, pid2 := modules[0].Physicalid, modules[1].Physicalid
+
+	healthOut := func(state2, life2 string) string {
+		return "\n" +
+			"---DimmID=" + id1 + "---\n" +
+			"   HealthState=Healthy\n" +
+			"   PercentageRemaining=100%\n" +
+			"   Temperature=35C\n" +
+			"---DimmID=" + id2 + "---\n" +
+			"   HealthState=" + state2 + "\n" +
+			"   PercentageRemaining=" + life2 + "\n" +
+			"   Temperature=81C\n" +
+			"\n"
+	}
+	healthy := &scmModuleHealth{
+		PhysicalID:   pid1,
+		DimmID:       id1,
+		HealthState:  "Healthy",
+		LifePct:      intPtr(100),
+		TemperatureC: intPtr(35),
+	}
+
+	tests := []struct {
+		desc      string
+		healthOut string
+		cmdErr    error
+		expHealth map[uint32]*scmModuleHealth
+		expErr    error
+	}{
+		{
+			desc:      "healthy",
+			healthOut: healthOut("Healthy", "98%"),
+			expHealth: map[uint32]*scmModuleHealth{
+				pid1: healthy,
+				pid2: {
+					PhysicalID:   pid2,
+					DimmID:       id2,
+					HealthState:  "Healthy",
+					LifePct:      intPtr(98),
+					TemperatureC: intPtr(81),
+				},
+			},
+		},
+		{
+			desc:      "critical",
+			healthOut: healthOut("Critical", "N/A"),
+			expHealth: map[uint32]*scmModuleHealth{
+				pid1: healthy,
+				pid2: {
+					PhysicalID:   pid2,
+					DimmID:       id2,
+					HealthState:  "Critical",
+					TemperatureC: intPtr(81),
+				},
+			},
+			expErr: FaultScmUnhealthyModules(
+				fmt.Sprintf("module %d (%s) Critical", pid2, id2)),
+		},
+		{
+			desc:      "low remaining life",
+			healthOut: healthOut("Noncritical", "2%"),
+			expHealth: map[uint32]*scmModuleHealth{
+				pid1: healthy,
+				pid2: {
+					PhysicalID:   pid2,
+					DimmID:       id2,
+					HealthState:  "Noncritical",
+					LifePct:      intPtr(2),
+					TemperatureC: intPtr(81),
+				},
+			},
+			expErr: FaultScmUnhealthyModules(fmt.Sprintf(
+				"module %d (%s) Noncritical with 2%% life remaining",
+				pid2, id2)),
+		},
+		{
+			desc:      "module missing from output",
+			healthOut: "\n---DimmID=" + id1 + "---\n   HealthState=Healthy\n   PercentageRemaining=100%\n   Temperature=35C\n",
+			expHealth: map[uint32]*scmModuleHealth{pid1: healthy},
+		},
+		{
+			desc:   "command failure",
+			cmdErr: errors.New("example failure"),
+			expErr: errors.New("ipmctl show health: example failure"),
+		},
+	}
+
+	for _, tt := range tests {
+		mockRun := func(in string) (string, error) {
+			AssertEqual(t, in, cmdScmShowHealth, tt.desc+": unexpected command")
+			return tt.healthOut, tt.cmdErr
+		}
+
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
+		ss.modules = modules
+
+		health, err := ss.Health()
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+		} else if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, health, tt.expHealth, tt.desc+": unexpected module health")
+	}
+}
+
+func TestDiscoverScm(t *testing.T) {
+	mPB := MockModulePB()
+	m := MockModule()
+	config := defaultMockConfig(t)
+
+	tests := []struct {
+		inited            bool
+		ipmctlDiscoverRet error
+		errMsg            string
+		expModules        ScmModules
+	}{
+		{
+			true,
+			nil,
+			"",
+			ScmModules(nil),
+		},
+		{
+			false,
+			nil,
+			"",
+			ScmModules{mPB},
+		},
+		{
+			false,
+			errors.New("ipmctl example failure"),
+			msgIpmctlDiscoverFail + ": ipmctl example failure",
+			ScmModules{mPB},
+		},
+	}
+
+	for _, tt := range tests {
+		ss := newMockScmStorage(
+			tt.ipmctlDiscoverRet, []DeviceDiscovery{m}, tt.inited,
+			&config)
+
+		resp := new(pb.ScanStorageResp)
+		ss.Discover(resp)
+		if tt.errMsg != "" {
+			AssertEqual(t, resp.Scmstate.Error, tt.errMsg, "")
+			AssertTrue(
+				t,
+				resp.Scmstate.Status != pb.ResponseStatus_CTRL_SUCCESS,
+				"")
+			continue
+		}
+		AssertEqual(t, resp.Scmstate.Error, "", "")
+		AssertEqual(t, resp.Scmstate.Status, pb.ResponseStatus_CTRL_SUCCESS, "")
+
+		AssertEqual(t, ss.modules, tt.expModules, "unexpected list of modules")
+	}
+}
+
+func TestSetupDetectFormatted(t *testing.T) {
+	tests := []struct {
+		desc         string
+		class        ScmClass
+		fsType       string
+		devFsTypes   map[string]string
+		expFormatted bool
+	}{
+		{
+			desc:         "existing ext4 filesystem",
+			class:        scmDCPM,
+			devFsTypes:   map[string]string{"/dev/pmem0": "ext4"},
+			expFormatted: true,
+		},
+		{
+			desc:  "blank device",
+			class: scmDCPM,
+		},
+		{
+			desc:       "filesystem differs from config",
+			class:      scmDCPM,
+			fsType:     scmFsXfs,
+			devFsTypes: map[string]string{"/dev/pmem0": "ext4"},
+		},
+		{
+			desc:       "ram",
+			class:      scmRAM,
+			devFsTypes: map[string]string{"/dev/pmem0": "ext4"},
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		config.Servers[0].ScmClass = tt.class
+		config.Servers[0].ScmList = []string{"/dev/pmem0"}
+		config.Servers[0].ScmFsType = tt.fsType
+		config.ext.(*mockExt).devFsTypeRet = tt.devFsTypes
+
+		ss := newMockScmStorage(nil, []DeviceDiscovery{MockModule()}, false,
+			&config)
+
+		if err := ss.Setup(); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		AssertEqual(t, ss.formatted, tt.expFormatted,
+			tt.desc+": unexpected formatted state")
+	}
+}
+
+func TestDiscoverScmPmems(t *testing.T) {
+	// ndctl list -N output for namespaces on regions of different sockets
+	nsOut := `[
+  {
+    "dev":"namespace1.0",
+    "mode":"fsdax",
+    "map":"dev",
+    "size":3183575302144,
+    "uuid":"842fc847-28e0-4bb6-8dfc-d24afdba1528",
+    "sector_size":512,
+    "blockdev":"pmem1",
+    "name":"daos_io_server_0",
+    "numa_node":1
+  },
+  {
+    "dev":"namespace0.0",
+    "mode":"fsdax",
+    "map":"dev",
+    "size":3183575302144,
+    "uuid":"da3d8a71-c1ba-4e29-a0a3-01e2fbbff0b7",
+    "sector_size":512,
+    "blockdev":"pmem0",
+    "name":"daos_io_server_1",
+    "numa_node":0
+  }
+]
+`
+	expPmems := []*pb.PmemDevice{
+		{
+			Uuid:     "842fc847-28e0-4bb6-8dfc-d24afdba1528",
+			Blockdev: "pmem1",
+			NumaNode: 1,
+		},
+		{
+			Uuid:     "da3d8a71-c1ba-4e29-a0a3-01e2fbbff0b7",
+			Blockdev: "pmem0",
+			NumaNode: 0,
+		},
+	}
+
+	tests := []struct {
+		desc     string
+		nsErr    error
+		expPmems []*pb.PmemDevice
+	}{
+		{"namespaces", nil, expPmems},
+		{"list namespaces fails", errors.New("ndctl failed"), nil},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := newMockScmStorage(
+			nil, []DeviceDiscovery{MockModule()}, false, &config).
+			withRunCmd(func(cmd string) (string, error) {
+				if cmd != cmdScmListNamespaces {
+					return "", errors.New("unexpected command " + cmd)
+				}
+				return nsOut, tt.nsErr
+			})
+
+		resp := new(pb.ScanStorageResp)
+		ss.Discover(resp)
+
+		// namespace listing failure shouldn't fail module discovery
+		AssertEqual(t, resp.Scmstate.Status, pb.ResponseStatus_CTRL_SUCCESS,
+			tt.desc+": unexpected status")
+		AssertEqual(t, resp.Pmems, tt.expPmems, tt.desc+": unexpected pmems")
+
+		// cached namespaces returned once initialized
+		resp = new(pb.ScanStorageResp)
+		ss.Discover(resp)
+		AssertEqual(t, resp.Pmems, tt.expPmems,
+			tt.desc+": unexpected pmems once initialized")
+	}
+}
+
+func TestFormatScm(t *testing.T) {
+	tests := []struct {
+		inited    bool
+		formatted bool
+		mountRet  error
+		// log context should be stack layer registering result
+		unmountRet error
+		mkdirRet   error
+		removeRet  error
+		mount      string
+		class      ScmClass
+		devs       []string
+		size       int
+		expCmds    []string // expected arguments in syscall methods
+		expResults ScmMountResults
+		desc       string
+	}{
+		{
+			inited: false,
+			mount:  "/mnt/daos",
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_APP,
+						Error:  msgScmNotInited,
+					},
+				},
+			},
+			desc: "not initialised",
+		},
+		{
+			inited:    true,
+			mount:     "/mnt/daos",
+			formatted: true,
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State:    FaultScmAlreadyFormatted("/mnt/daos").ResponseState(),
+				},
+			},
+			desc: "already formatted",
+		},
+		{
+			inited: true,
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error:  msgScmMountEmpty,
+					},
+				},
+			},
+			desc: "missing mount point",
+		},
+		{
+			inited: true,
+			mount:  "/mnt/daos",
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error:  ": " + msgScmClassNotSupported,
+					},
+				},
+			},
+			desc: "no class",
+		},
+		{
+			inited: true,
+			mount:  "/mnt/daos",
+			class:  scmRAM,
+			size:   6,
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State:    &pb.ResponseState{},
+				},
+			},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount tmpfs, /mnt/daos, tmpfs, 0, size=6g",
+			},
+			desc: "ram success",
+		},
+		{
+			inited:   true,
+			mount:    "/mnt/daos",
+			class:    scmRAM,
+			size:     6,
+			mountRet: os.NewSyscallError("mount", syscall.ENOMEM),
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_APP,
+						Error:  FaultScmTmpfsNoMemory(6, 0).Error(),
+					},
+				},
+			},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount tmpfs, /mnt/daos, tmpfs, 0, size=6g",
+			},
+			desc: "ram insufficient memory",
+		},
+		{
+			inited:   true,
+			mount:    "/mnt/daos",
+			class:    scmRAM,
+			size:     6,
+			mountRet: os.NewSyscallError("mount", syscall.EPERM),
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_APP,
+						Error:  "mount: operation not permitted",
+					},
+				},
+			},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount tmpfs, /mnt/daos, tmpfs, 0, size=6g",
+			},
+			desc: "ram generic mount failure",
+		},
+		{
+			inited: true,
+			mount:  "/mnt/daos",
+			class:  scmDCPM,
+			devs:   []string{"/dev/pmem0"},
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State:    &pb.ResponseState{},
+				},
+			},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+			},
+			desc: "dcpm success",
+		},
+		{
+			inited: true,
+			mount:  "/mnt/daos",
+			class:  scmDCPM,
+			devs:   []string{},
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error:  msgScmBadDevList,
+					},
+				},
+			},
+			desc: "dcpm missing dev",
+		},
+		{
+			inited: true,
+			mount:  "/mnt/daos",
+			class:  scmDCPM,
+			devs:   []string(nil),
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error:  msgScmBadDevList,
+					},
+				},
+			},
+			desc: "dcpm nil devs",
+		},
+		{
+			inited: true,
+			mount:  "/mnt/daos",
+			class:  scmDCPM,
+			devs:   []string{""},
+			expResults: ScmMountResults{
+				{
+					Mntpoint: "/mnt/daos",
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error:  msgScmDevEmpty,
+					},
+				},
+			},
+			desc: "dcpm empty dev",
+		},
+	}
+
+	srvIdx := 0
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			tt.mountRet, tt.unmountRet, tt.mkdirRet, tt.removeRet,
+			tt.mount, tt.class, tt.devs, tt.size,
+			bdNVMe, []string{}, false)
+		ss := newMockScmStorage(
+			nil, []DeviceDiscovery{}, false, config)
+		ss.formatted = tt.formatted
+
+		results := ScmMountResults{}
+
+		if tt.inited {
+			// not concerned with response
+			ss.Discover(new(pb.ScanStorageResp))
+		}
+
+		ss.Format(srvIdx, &results)
+
+		// only ocm result in response for the moment
+		AssertEqual(
+			t, len(results), 1,
+			"unexpected number of response results, "+tt.desc)
+
+		result := results[0]
+
+		AssertEqual(
+			t, result.State.Error, tt.expResults[0].State.Error,
+			"unexpected result error message, "+tt.desc)
+		AssertEqual(
+			t, result.State.Status, tt.expResults[0].State.Status,
+			"unexpected response status, "+tt.desc)
+		AssertEqual(
+			t, result.Mntpoint, tt.expResults[0].Mntpoint,
+			"unexpected mntpoint, "+tt.desc)
+
+		if result.State.Status == pb.ResponseStatus_CTRL_SUCCESS {
+			AssertEqual(
+				t, ss.formatted,
+				true, "expect formatted state, "+tt.desc)
+		}
+
+		cmds := ss.config.ext.getHistory()
+		AssertEqual(
+			t, len(cmds), len(tt.expCmds), "number of cmds, "+tt.desc)
+		for i, s := range cmds {
+			AssertEqual(
+				t, s, tt.expCmds[i],
+				fmt.Sprintf("commands don't match (%s)", tt.desc))
+		}
+	}
+}
+
+func TestScrubSecrets(t *testing.T) {
+	tests := []struct {
+		desc   string
+		cmd    string
+		expCmd string
+	}{
+		{
+			desc:   "no secrets",
+			cmd:    "ipmctl load -source /tmp/fw.bin -dimm",
+			expCmd: "ipmctl load -source /tmp/fw.bin -dimm",
+		},
+		{
+			desc:   "flag separated by space",
+			cmd:    "fwupdate --passphrase s3cr3t -d /dev/nmem0",
+			expCmd: "fwupdate --passphrase <redacted> -d /dev/nmem0",
+		},
+		{
+			desc:   "flag separated by equals",
+			cmd:    "fwupdate --password=s3cr3t -d /dev/nmem0",
+			expCmd: "fwupdate --password=<redacted> -d /dev/nmem0",
+		},
+		{
+			desc: "ipmctl properties",
+			cmd: "ipmctl set -dimm 0x0001 Passphrase=old " +
+				"NewPassphrase=new ConfirmPassphrase=new",
+			expCmd: "ipmctl set -dimm 0x0001 Passphrase=<redacted> " +
+				"NewPassphrase=<redacted> ConfirmPassphrase=<redacted>",
+		},
+	}
+
+	for _, tt := range tests {
+		rce := newRunCmdError(tt.cmd,
+			errors.Errorf("exit status 1: %s", tt.cmd), "")
+
+		AssertEqual(t, rce.cmd, tt.expCmd, tt.desc+": unexpected command")
+		AssertEqual(t, rce.Error(), "exit status 1: "+tt.expCmd,
+			tt.desc+": unexpected error")
+		AssertEqual(t, cmdFailureMsg(rce),
+			"exit status 1: "+tt.expCmd+" (failed command: "+tt.expCmd+")",
+			tt.desc+": unexpected failure message")
+	}
+}
+
+func TestRunCmdDeadline(t *testing.T) {
+	tests := []struct {
+		desc string
+		cmd  string
+	}{
+		{"single command", "sleep 10"},
+		{"command started by shell", "sleep 10; echo done"},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).
+			withRunCmdCtx(runCtx).
+			withCmdTimeout(100 * time.Millisecond)
+
+		start := time.Now()
+		_, err := ss.execCmd(tt.cmd)
+		elapsed := time.Since(start)
+
+		AssertTrue(t, elapsed < 5*time.Second,
+			fmt.Sprintf("%s: command not killed promptly, took %s",
+				tt.desc, elapsed))
+		if err == nil {
+			t.Fatalf("%s: expected error", tt.desc)
+		}
+		rce, ok := err.(*runCmdError)
+		AssertTrue(t, ok, tt.desc+": expected runCmdError")
+		AssertTrue(t, errors.Cause(rce.wrapped) == context.DeadlineExceeded,
+			tt.desc+": expected deadline exceeded")
+		AssertTrue(t, strings.Contains(err.Error(), "killed by deadline"),
+			tt.desc+": unexpected error "+err.Error())
+	}
+
+	// commands completing within the deadline are unaffected
+	out, err := runCtx(context.Background(), "echo done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, out, "done\n", "unexpected command output")
+}
+
+func TestExecCmdTimeout(t *testing.T) {
+	tests := []struct {
+		desc       string
+		timeout    time.Duration
+		expTimeout time.Duration
+	}{
+		{"default", 0, defaultScmCmdTimeout},
+		{"configured", time.Minute, time.Minute},
+	}
+
+	for _, tt := range tests {
+		var remaining time.Duration
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).
+			withRunCmdCtx(func(ctx context.Context, cmd string) (string, error) {
+				deadline, ok := ctx.Deadline()
+				AssertTrue(t, ok, tt.desc+": expected deadline")
+				remaining = time.Until(deadline)
+				return "", nil
+			}).
+			withCmdTimeout(tt.timeout)
+
+		if _, err := ss.execCmd(cmdScmShowRegions); err != nil {
+			t.Fatal(err)
+		}
+
+		AssertTrue(t, remaining > tt.expTimeout-time.Second &&
+			remaining <= tt.expTimeout,
+			fmt.Sprintf("%s: unexpected deadline %s", tt.desc, remaining))
+	}
+
+	// cancelled context prevents commands from running
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config := defaultMockConfig(t)
+	ran := false
+	ss := defaultMockScmStorage(&config).withRunCmd(func(string) (string, error) {
+		ran = true
+		return "", nil
+	})
+	err := ss.getState(ctx)
+	ExpectError(t, err, context.Canceled.Error(), "state with cancelled context")
+	AssertEqual(t, ran, false, "command run after cancellation")
+}
+
+func TestFormatScmCmdFailure(t *testing.T) {
+	config := newMockStorageConfig(
+		nil, nil, nil, nil, "/mnt/daos", scmDCPM, []string{"/dev/pmem0"}, 0,
+		bdNVMe, []string{}, false)
+	config.ext = newMockExt(
+		errors.New("example failure"), false, nil, true, nil, nil, nil)
+	ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+	ss.Discover(new(pb.ScanStorageResp))
+
+	results := ScmMountResults{}
+	ss.Format(0, &results)
+
+	AssertEqual(t, len(results), 1, "unexpected number of response results")
+	AssertEqual(t, results[0].State.Status, pb.ResponseStatus_CTRL_ERR_APP,
+		"unexpected response status")
+	AssertEqual(t, results[0].State.Error,
+		"wipefs: example failure (failed command: wipefs -a /dev/pmem0)",
+		"unexpected result error message")
+}
+
+func TestFormatScmClassChanged(t *testing.T) {
+	tests := []struct {
+		desc         string
+		class        ScmClass
+		devs         []string
+		mountType    string
+		expErr       error
+		expFormatted bool
+	}{
+		{
+			desc:         "no existing mount",
+			class:        scmDCPM,
+			devs:         []string{"/dev/pmem0"},
+			expFormatted: true,
+		},
+		{
+			desc:         "existing dcpm mount",
+			class:        scmDCPM,
+			devs:         []string{"/dev/pmem0"},
+			mountType:    "ext4",
+			expFormatted: true,
+		},
+		{
+			desc:      "existing ram mount now dcpm",
+			class:     scmDCPM,
+			devs:      []string{"/dev/pmem0"},
+			mountType: "tmpfs",
+			expErr:    FaultScmClassChanged("/mnt/daos", scmRAM, scmDCPM),
+		},
+		{
+			desc:      "existing dcpm mount now ram",
+			class:     scmRAM,
+			mountType: "ext4",
+			expErr:    FaultScmClassChanged("/mnt/daos", scmDCPM, scmRAM),
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", tt.class, tt.devs, 0, bdNVMe,
+			[]string{}, false)
+		config.ext.(*mockExt).mountTypeRet = tt.mountType
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		if tt.expErr != nil {
+			AssertEqual(t, results[0].State.Status,
+				pb.ResponseStatus_CTRL_ERR_APP,
+				tt.desc+": unexpected response status")
+			AssertEqual(t, results[0].State.Error, tt.expErr.Error(),
+				tt.desc+": unexpected result error message")
+			AssertEqual(t, ss.config.ext.getHistory(), []string{},
+				tt.desc+": existing mount should not be modified")
+		}
+		AssertEqual(t, ss.formatted, tt.expFormatted,
+			tt.desc+": unexpected formatted state")
+	}
+}
+
+func TestFormatActionMountType(t *testing.T) {
+	tests := []struct {
+		desc      string
+		mounted   string // detected type of existing mount
+		fsType    string // configured type
+		expAction scmFormatAction
+	}{
+		{"no existing mount", "", "ext4", scmFormatReformat},
+		{"same type", "ext4", "ext4", scmFormatNone},
+		{"ext4 mounted xfs configured", "ext4", "xfs", scmFormatReformat},
+		{"xfs mounted ext4 configured", "xfs", "ext4", scmFormatReformat},
+	}
+
+	for _, tt := range tests {
+		rec := scmFormatRecord{
+			Class:     scmDCPM,
+			Device:    "/dev/pmem0",
+			FsType:    tt.fsType,
+			MountOpts: "dax",
+		}
+		// existing record claims the configured type
+		data, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := defaultMockConfig(t)
+		config.ext = &mockExt{
+			mountTypeRet: tt.mounted,
+			readFileRet: map[string]string{
+				"/mnt/daos/" + scmFormatRecordFile: string(data),
+			},
+		}
+		ss := defaultMockScmStorage(&config)
+
+		AssertEqual(t, ss.formatAction("/mnt/daos", rec), tt.expAction,
+			tt.desc+": unexpected format action")
+	}
+}
+
+func TestFormatScmRecordedParams(t *testing.T) {
+	current := scmFormatRecord{
+		Class:     scmDCPM,
+		Device:    "/dev/pmem0",
+		FsType:    "ext4",
+		MkfsOpts:  "-E nodiscard",
+		MountOpts: "dax",
+	}
+	withMkfsOpts := current
+	withMkfsOpts.MkfsOpts = "-i 1048576"
+	withUid := current
+	withUid.Uid = 1001
+
+	formatCmds := []string{
+		"os: list processes using /mnt/daos",
+		"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+		"os: removeall /mnt/daos",
+		"cmd: wipefs -a /dev/pmem0",
+		"cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+		"os: mkdirall /mnt/daos, 0777",
+		"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+	}
+
+	tests := []struct {
+		desc     string
+		mounted  bool
+		recorded *scmFormatRecord
+		expInfo  string
+		expCmds  []string
+	}{
+		{
+			desc:    "no existing mount",
+			expCmds: formatCmds,
+		},
+		{
+			desc:    "existing mount without record",
+			mounted: true,
+			expCmds: formatCmds,
+		},
+		{
+			desc:     "changed options",
+			mounted:  true,
+			recorded: &withMkfsOpts,
+			expCmds:  formatCmds,
+		},
+		{
+			desc:     "unchanged",
+			mounted:  true,
+			recorded: &current,
+			expInfo:  msgScmFormatUnchanged,
+			expCmds:  []string{},
+		},
+		{
+			desc:     "changed mount only",
+			mounted:  true,
+			recorded: &withUid,
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+			[]string{"/dev/pmem0"}, 0, bdNVMe, []string{}, false)
+		if tt.mounted {
+			config.ext.(*mockExt).mountTypeRet = "ext4"
+		}
+		if tt.recorded != nil {
+			data, err := json.Marshal(tt.recorded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.ext.(*mockExt).readFileRet = map[string]string{
+				"/mnt/daos/" + scmFormatRecordFile: string(data),
+			}
+		}
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		AssertEqual(t, results[0].State.Status, pb.ResponseStatus_CTRL_SUCCESS,
+			tt.desc+": unexpected response status")
+		AssertEqual(t, results[0].State.Info, tt.expInfo,
+			tt.desc+": unexpected result info")
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds,
+			tt.desc+": unexpected commands")
+		AssertEqual(t, ss.formatted, true, tt.desc+": not marked formatted")
+	}
+}
+
+func TestFormatScmTmpfsMemory(t *testing.T) {
+	memInfo := func(availKiB uint64) string {
+		return "MemTotal:       65702312 kB\n" +
+			"MemFree:        30113200 kB\n" +
+			fmt.Sprintf("MemAvailable:   %d kB\n", availKiB)
+	}
+
+	tests := []struct {
+		desc     string
+		size     int
+		memInfo  string
+		expState *pb.ResponseState
+		expCmds  []string
+	}{
+		{
+			desc:     "sufficient memory",
+			size:     6,
+			memInfo:  memInfo(8 << 20),
+			expState: &pb.ResponseState{},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount tmpfs, /mnt/daos, tmpfs, 0, size=6g",
+			},
+		},
+		{
+			desc:    "insufficient memory",
+			size:    6,
+			memInfo: memInfo(6 << 20),
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_CONF,
+				Error: FaultScmTmpfsNoMemory(
+					6, 6<<30).Error(),
+			},
+			expCmds: []string{},
+		},
+		{
+			desc:    "oversized",
+			size:    1 << 20,
+			memInfo: memInfo(8 << 20),
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_CONF,
+				Error: FaultScmTmpfsNoMemory(
+					1<<20, 8<<30).Error(),
+			},
+			expCmds: []string{},
+		},
+		{
+			desc:    "zero size",
+			memInfo: memInfo(8 << 20),
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_CONF,
+				Error:  FaultScmBadTmpfsSize(0).Error(),
+			},
+			expCmds: []string{},
+		},
+		{
+			desc:    "negative size",
+			size:    -2,
+			memInfo: memInfo(8 << 20),
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_CONF,
+				Error:  FaultScmBadTmpfsSize(-2).Error(),
+			},
+			expCmds: []string{},
+		},
+		{
+			desc:     "tmpfs default size",
+			size:     scmSizeTmpfsDefault,
+			memInfo:  memInfo(1 << 20),
+			expState: &pb.ResponseState{},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount tmpfs, /mnt/daos, tmpfs, 0, ",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmRAM, nil, tt.size, bdNVMe,
+			[]string{}, false)
+		config.ext.(*mockExt).readFileRet = map[string]string{
+			memInfoPath: tt.memInfo,
+		}
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		AssertEqual(t, results[0].State.Status, tt.expState.Status,
+			tt.desc+": unexpected response status")
+		AssertEqual(t, results[0].State.Error, tt.expState.Error,
+			tt.desc+": unexpected result error message")
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds,
+			tt.desc+": unexpected commands")
+	}
+}
+
+func TestFormatScmDaxSupport(t *testing.T) {
+	reason := "kernel built without CONFIG_FS_DAX"
+
+	tests := []struct {
+		desc           string
+		daxUnsupported string
+		expState       *pb.ResponseState
+		expCmds        []string
+	}{
+		{
+			desc:     "dax supported",
+			expState: &pb.ResponseState{},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+			},
+		},
+		{
+			desc:           "dax unsupported",
+			daxUnsupported: reason,
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  FaultScmDaxUnsupported("/dev/pmem0", reason).Error(),
+			},
+			expCmds: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+			[]string{"/dev/pmem0"}, 0, bdNVMe, []string{}, false)
+		config.ext.(*mockExt).daxUnsupported = tt.daxUnsupported
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		AssertEqual(t, results[0].State.Status, tt.expState.Status,
+			tt.desc+": unexpected response status")
+		AssertEqual(t, results[0].State.Error, tt.expState.Error,
+			tt.desc+": unexpected result error message")
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds,
+			tt.desc+": unexpected commands")
+	}
+}
+
+func TestReformatScm(t *testing.T) {
+	formatCmds := []string{
+		"os: list processes using /mnt/daos",
+		"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+		"os: removeall /mnt/daos",
+		"cmd: wipefs -a /dev/pmem0",
+		"cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+		"os: mkdirall /mnt/daos, 0777",
+		"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+	}
+
+	tests := []struct {
+		desc      string
+		force     bool
+		mount     string
+		expState  *pb.ResponseState
+		expCmds   []string
+		expWarn   bool
+		expFormat bool
+	}{
+		{
+			desc:      "already formatted",
+			mount:     "/mnt/daos",
+			expState:  FaultScmAlreadyFormatted("/mnt/daos").ResponseState(),
+			expCmds:   []string{},
+			expFormat: true,
+		},
+		{
+			desc:      "forced reformat",
+			force:     true,
+			mount:     "/mnt/daos",
+			expState:  &pb.ResponseState{},
+			expCmds:   formatCmds,
+			expWarn:   true,
+			expFormat: true,
+		},
+		{
+			desc:  "forced reformat without mount point",
+			force: true,
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_CONF,
+				Error:  msgScmMountEmpty,
+			},
+			expCmds:   []string{},
+			expFormat: true,
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, tt.mount, scmDCPM,
+			[]string{"/dev/pmem0"}, 0, bdNVMe, []string{}, false)
+		// existing record matching the configuration would otherwise
+		// result in no reformat
+		rec, err := json.Marshal(scmFormatRecord{
+			Class: scmDCPM, Device: "/dev/pmem0", FsType: "ext4",
+			MountOpts: "dax",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.ext.(*mockExt).mountTypeRet = "ext4"
+		config.ext.(*mockExt).readFileRet = map[string]string{
+			"/mnt/daos/" + scmFormatRecordFile: string(rec),
+		}
+		logger := &mockScmLogger{}
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config).withLogger(logger)
+		ss.Discover(new(pb.ScanStorageResp))
+		ss.formatted = true
+
+		results := ScmMountResults{}
+		if tt.force {
+			ss.Reformat(0, &results)
+		} else {
+			ss.Format(0, &results)
+		}
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		AssertEqual(t, results[0].State.Status, tt.expState.Status,
+			tt.desc+": unexpected response status")
+		AssertEqual(t, results[0].State.Error, tt.expState.Error,
+			tt.desc+": unexpected result error message")
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds,
+			tt.desc+": unexpected commands")
+		AssertEqual(t, ss.formatted, tt.expFormat,
+			tt.desc+": unexpected formatted state")
+
+		warned := false
+		for _, entry := range logger.entries {
+			if entry.level == scmLogWarn &&
+				strings.Contains(entry.msg, "forcing reformat") {
+				warned = true
+			}
+		}
+		AssertEqual(t, warned, tt.expWarn, tt.desc+": unexpected forced reformat log")
+	}
+}
+
+func TestFormatScmConfirm(t *testing.T) {
+	noTTY := errors.New("no tty attached")
+
+	tests := []struct {
+		desc       string
+		devs       []string
+		confirm    confirmFn
+		expState   *pb.ResponseState
+		expPrompts int
+	}{
+		{
+			desc:     "no callback",
+			devs:     []string{"/dev/pmem0"},
+			expState: &pb.ResponseState{},
+		},
+		{
+			desc:       "accepted",
+			devs:       []string{"/dev/pmem0"},
+			confirm:    func(string) (bool, error) { return true, nil },
+			expState:   &pb.ResponseState{},
+			expPrompts: 1,
+		},
+		{
+			desc:    "declined",
+			devs:    []string{"/dev/pmem0"},
+			confirm: func(string) (bool, error) { return false, nil },
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  FaultScmFormatCancelled("/dev/pmem0").Error(),
+			},
+			expPrompts: 1,
+		},
+		{
+			desc:    "confirmation unavailable",
+			devs:    []string{"/dev/pmem0"},
+			confirm: func(string) (bool, error) { return false, noTTY },
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  FaultScmConfirmUnavailable("/dev/pmem0", noTTY).Error(),
+			},
+			expPrompts: 1,
+		},
+		{
+			desc:    "multiple devices declined",
+			devs:    []string{"/dev/pmem0", "/dev/pmem1"},
+			confirm: func(string) (bool, error) { return false, nil },
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  FaultScmFormatCancelled("/dev/pmem0").Error(),
+			},
+			expPrompts: 2,
+		},
+		{
+			desc:    "multiple devices confirmation unavailable",
+			devs:    []string{"/dev/pmem0", "/dev/pmem1"},
+			confirm: func(string) (bool, error) { return true, noTTY },
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  FaultScmConfirmUnavailable("/dev/pmem0", noTTY).Error(),
+			},
+			expPrompts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+			tt.devs, 0, bdNVMe, []string{}, false)
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		var prompts []string
+		if tt.confirm != nil {
+			ss.withConfirm(func(prompt string) (bool, error) {
+				prompts = append(prompts, prompt)
+				return tt.confirm(prompt)
+			})
+		}
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(prompts), tt.expPrompts,
+			tt.desc+": unexpected number of prompts")
+		AssertEqual(t, results[0].State.Status, tt.expState.Status,
+			tt.desc+": unexpected response status")
+		AssertEqual(t, results[0].State.Error, tt.expState.Error,
+			tt.desc+": unexpected result error message")
+		if tt.expState.Status != pb.ResponseStatus_CTRL_SUCCESS {
+			AssertEqual(t, len(results), len(tt.devs),
+				tt.desc+": unexpected number of results")
+			for _, result := range results {
+				AssertEqual(t, result.State.Status, tt.expState.Status,
+					tt.desc+": unexpected response status of "+
+						result.Mntpoint)
+			}
+			AssertEqual(t, ss.config.ext.getHistory(), []string{},
+				tt.desc+": no commands expected without confirmation")
+		}
+	}
+}
+
+func TestPromptConfirm(t *testing.T) {
+	tests := []struct {
+		desc      string
+		input     string
+		expResult bool
+		expErr    error
+		expOutput string
+	}{
+		{
+			desc:      "yes",
+			input:     "yes\n",
+			expResult: true,
+			expOutput: "format? (yes/no)\n",
+		},
+		{
+			desc:      "no",
+			input:     "no\n",
+			expOutput: "format? (yes/no)\n",
+		},
+		{
+			desc:      "yes without newline",
+			input:     "yes",
+			expResult: true,
+			expOutput: "format? (yes/no)\n",
+		},
+		{
+			desc:      "invalid then yes",
+			input:     "maybe\nyes\n",
+			expResult: true,
+			expOutput: "format? (yes/no)\n" +
+				"Please type yes or no and then press enter:\n",
+		},
+		{
+			desc:      "input closed",
+			input:     "",
+			expErr:    errors.New("reading response: EOF"),
+			expOutput: "format? (yes/no)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+
+		result, err := promptConfirm(strings.NewReader(tt.input), &out)("format?")
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+		} else if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+
+		AssertEqual(t, result, tt.expResult, tt.desc+": unexpected result")
+		AssertEqual(t, out.String(), tt.expOutput, tt.desc+": unexpected output")
+	}
+}
+
+func TestFormatScmMountProbe(t *testing.T) {
+	reason := "mount is read-only"
+
+	tests := []struct {
+		desc      string
+		class     ScmClass
+		devs      []string
+		probeFail string
+		expState  *pb.ResponseState
+	}{
+		{
+			desc:     "writable dcpm mount",
+			class:    scmDCPM,
+			devs:     []string{"/dev/pmem0"},
+			expState: &pb.ResponseState{},
+		},
+		{
+			desc:      "read-only dcpm mount",
+			class:     scmDCPM,
+			devs:      []string{"/dev/pmem0"},
+			probeFail: reason,
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  FaultScmMountNotWritable("/mnt/daos", reason).Error(),
+			},
+		},
+		{
+			desc:     "writable ram mount",
+			class:    scmRAM,
+			expState: &pb.ResponseState{},
+		},
+		{
+			desc:      "read-only ram mount",
+			class:     scmRAM,
+			probeFail: reason,
+			expState: &pb.ResponseState{
+				Status: pb.ResponseStatus_CTRL_ERR_APP,
+				Error:  FaultScmMountNotWritable("/mnt/daos", reason).Error(),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", tt.class, tt.devs, 6,
+			bdNVMe, []string{}, false)
+		config.ext.(*mockExt).probeRet = tt.probeFail
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		AssertEqual(t, results[0].State.Status, tt.expState.Status,
+			tt.desc+": unexpected response status")
+		AssertEqual(t, results[0].State.Error, tt.expState.Error,
+			tt.desc+": unexpected result error message")
+		AssertEqual(t, ss.formatted, tt.probeFail == "",
+			tt.desc+": unexpected formatted state")
+	}
+}
+
+func TestGetMntParamsPmemDev(t *testing.T) {
+	tests := []struct {
+		devPath string
+		expErr  error
+	}{
+		{devPath: "/dev/pmem0"},
+		{devPath: "/dev/pmem12"},
+		{devPath: "/dev/pmem1.1"},
+		{devPath: "/dev/nmem0", expErr: FaultScmNotPmemNamespace("/dev/nmem0")},
+		{devPath: "/dev/dax0.0", expErr: FaultScmNotPmemNamespace("/dev/dax0.0")},
+		{devPath: "/dev/sda", expErr: FaultScmNotPmemNamespace("/dev/sda")},
+		{devPath: "pmem0", expErr: FaultScmNotPmemNamespace("pmem0")},
+	}
+
+	for _, tt := range tests {
+		srv := newDefaultServer()
+		srv.ScmClass = scmDCPM
+		srv.ScmList = []string{tt.devPath}
+
+		_, dev, _, _, err := getMntParams(&srv)
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.devPath)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.devPath + ": " + err.Error())
+		}
+		AssertEqual(t, dev, tt.devPath, "unexpected device")
+	}
+}
+
+func TestGetMntFlags(t *testing.T) {
+	tests := []struct {
+		desc     string
+		flags    []string
+		expFlags uintptr
+		expErr   error
+	}{
+		{
+			desc: "no flags",
+		},
+		{
+			desc:     "noatime",
+			flags:    []string{"noatime"},
+			expFlags: syscall.MS_NOATIME,
+		},
+		{
+			desc:     "noatime and ro",
+			flags:    []string{"noatime", "ro"},
+			expFlags: syscall.MS_NOATIME | syscall.MS_RDONLY,
+		},
+		{
+			desc:   "unknown flag",
+			flags:  []string{"noatime", "dax"},
+			expErr: FaultScmBadMountFlag("dax", scmMountFlagNames()),
+		},
+	}
+
+	for _, tt := range tests {
+		for _, class := range []ScmClass{scmDCPM, scmRAM} {
+			desc := fmt.Sprintf("%s (%s)", tt.desc, class)
+
+			srv := newDefaultServer()
+			srv.ScmClass = class
+			srv.ScmList = []string{"/dev/pmem0"}
+			srv.ScmSize = 16
+			srv.ScmMountFlags = tt.flags
+
+			_, _, _, flags, err := getMntParams(&srv)
+			if tt.expErr != nil {
+				ExpectError(t, err, tt.expErr.Error(), desc)
+				continue
+			}
+			if err != nil {
+				t.Fatal(desc + ": " + err.Error())
+			}
+			AssertEqual(t, flags, tt.expFlags, desc+": unexpected mount flags")
+		}
+	}
+
+	// flags are applied to each of multiple dcpm devices
+	srv := newDefaultServer()
+	srv.ScmList = []string{"/dev/pmem0", "/dev/pmem1"}
+	srv.ScmMountFlags = []string{"noatime", "ro"}
+
+	params, err := getDevMntParams(&srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range params {
+		AssertEqual(t, p.flags, uintptr(syscall.MS_NOATIME|syscall.MS_RDONLY),
+			p.devPath+": unexpected mount flags")
+	}
+}
+
+func TestGetDevMntParams(t *testing.T) {
+	tests := []struct {
+		desc      string
+		devs      []string
+		mntList   []string
+		expParams []mntParams
+		expErr    error
+	}{
+		{
+			desc: "one device",
+			devs: []string{"/dev/pmem0"},
+			expParams: []mntParams{
+				{"/dev/pmem0", "/mnt/daos", "ext4", "dax", 0},
+			},
+		},
+		{
+			desc: "two devices",
+			devs: []string{"/dev/pmem0", "/dev/pmem1"},
+			expParams: []mntParams{
+				{"/dev/pmem0", "/mnt/daos/0", "ext4", "dax", 0},
+				{"/dev/pmem1", "/mnt/daos/1", "ext4", "dax", 0},
+			},
+		},
+		{
+			desc:    "two devices with mount list",
+			devs:    []string{"/dev/pmem0", "/dev/pmem1"},
+			mntList: []string{"/mnt/daos_a", "/mnt/daos_b"},
+			expParams: []mntParams{
+				{"/dev/pmem0", "/mnt/daos_a", "ext4", "dax", 0},
+				{"/dev/pmem1", "/mnt/daos_b", "ext4", "dax", 0},
+			},
+		},
+		{
+			desc:    "mount list shorter than device list",
+			devs:    []string{"/dev/pmem0", "/dev/pmem1"},
+			mntList: []string{"/mnt/daos_a"},
+			expErr:  errors.Errorf(msgScmMountListMismatch, 1, 2),
+		},
+		{
+			desc:    "mount list longer than device list",
+			devs:    []string{"/dev/pmem0", "/dev/pmem1"},
+			mntList: []string{"/mnt/daos_a", "/mnt/daos_b", "/mnt/daos_c"},
+			expErr:  errors.Errorf(msgScmMountListMismatch, 3, 2),
+		},
+		{
+			desc:    "mount list with one device",
+			devs:    []string{"/dev/pmem0"},
+			mntList: []string{"/mnt/daos_a"},
+			expErr:  errors.New(msgScmMountListSingle),
+		},
+		{
+			desc:   "no devices",
+			devs:   []string{},
+			expErr: errors.New(msgScmBadDevList),
+		},
+	}
+
+	for _, tt := range tests {
+		srv := newDefaultServer()
+		srv.ScmMount = "/mnt/daos"
+		srv.ScmClass = scmDCPM
+		srv.ScmList = tt.devs
+		srv.ScmMountList = tt.mntList
+
+		params, err := getDevMntParams(&srv)
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+		AssertEqual(t, params, tt.expParams, tt.desc+": unexpected mount params")
+	}
+}
+
+func TestFormatScmMountList(t *testing.T) {
+	tests := []struct {
+		desc       string
+		mntList    []string
+		expResults []string // mount point of each result
+		expErr     string
+	}{
+		{
+			desc:       "mount point per device",
+			mntList:    []string{"/mnt/daos_a", "/mnt/daos_b"},
+			expResults: []string{"/mnt/daos_a", "/mnt/daos_b"},
+		},
+		{
+			desc:       "mismatched mount points",
+			mntList:    []string{"/mnt/daos_a"},
+			expResults: []string{"/mnt/daos"},
+			expErr:     fmt.Sprintf(msgScmMountListMismatch, 1, 2),
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+			[]string{"/dev/pmem0", "/dev/pmem1"}, 0, bdNVMe, []string{},
+			false)
+		config.Servers[0].ScmMountList = tt.mntList
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), len(tt.expResults),
+			tt.desc+": unexpected number of results")
+		for i, result := range results {
+			AssertEqual(t, result.Mntpoint, tt.expResults[i],
+				tt.desc+": unexpected mount point")
+			AssertEqual(t, result.State.Error, tt.expErr,
+				tt.desc+": unexpected result error message")
+		}
+	}
+}
+
+func TestFormatScmFsType(t *testing.T) {
+	tests := []struct {
+		desc    string
+		fsType  string
+		expCmds []string
+		expErr  string
+	}{
+		{
+			desc:   "default",
+			fsType: "",
+			expCmds: []string{
+				"cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+				"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+			},
+		},
+		{
+			desc:   "ext4",
+			fsType: scmFsExt4,
+			expCmds: []string{
+				"cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+				"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+			},
+		},
+		{
+			desc:   "xfs",
+			fsType: scmFsXfs,
+			expCmds: []string{
+				"cmd: mkfs.xfs -m reflink=0 -K /dev/pmem0",
+				"syscall: mount /dev/pmem0, /mnt/daos, xfs, 0, dax",
+			},
+		},
+		{
+			desc:    "unsupported",
+			fsType:  "btrfs",
+			expCmds: []string{},
+			expErr:  msgConfigBadFsType,
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+			[]string{"/dev/pmem0"}, 0, bdNVMe, []string{}, false)
+		config.Servers[0].ScmFsType = tt.fsType
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		AssertEqual(t, results[0].State.Error, tt.expErr,
+			tt.desc+": unexpected result error message")
+
+		var cmds []string
+		for _, cmd := range ss.config.ext.getHistory() {
+			if strings.HasPrefix(cmd, "cmd: mkfs") ||
+				strings.HasPrefix(cmd, "syscall: mount") {
+
+				cmds = append(cmds, cmd)
+			}
+		}
+		if cmds == nil {
+			cmds = []string{}
+		}
+		AssertEqual(t, cmds, tt.expCmds, tt.desc+": unexpected commands")
+	}
+}
+
+func TestFormatScmMountOpts(t *testing.T) {
+	tests := []struct {
+		desc      string
+		effective []string
+		expInfo   string
+	}{
+		{
+			desc: "effective options unknown",
+		},
+		{
+			desc:      "dax in effect",
+			effective: []string{"rw", "relatime", "dax"},
+			expInfo:   msgScmEffectiveMountOpts + "rw,relatime,dax",
+		},
+		{
+			desc:      "dax in effect with value",
+			effective: []string{"rw", "relatime", "dax=always"},
+			expInfo:   msgScmEffectiveMountOpts + "rw,relatime,dax=always",
+		},
+		{
+			desc:      "dax silently dropped",
+			effective: []string{"rw", "relatime"},
+			expInfo: msgScmEffectiveMountOpts + "rw,relatime; " +
+				msgScmMountOptsDropped + "dax",
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+			[]string{"/dev/pmem0"}, 0, bdNVMe, []string{}, false)
+		config.ext.(*mockExt).mountOptsRet = tt.effective
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+
+		results := ScmMountResults{}
+		ss.Format(0, &results)
+
+		AssertEqual(t, len(results), 1, tt.desc+": unexpected number of results")
+		AssertEqual(t, results[0].State.Status, pb.ResponseStatus_CTRL_SUCCESS,
+			tt.desc+": unexpected response status")
+		AssertEqual(t, results[0].State.Info, tt.expInfo,
+			tt.desc+": unexpected result info message")
+	}
+}
+
+func TestReFormatProgress(t *testing.T) {
+	tests := []struct {
+		desc      string
+		cmdRet    error
+		errMsg    string
+		expPhases []formatPhase
+	}{
+		{
+			desc: "success",
+			expPhases: []formatPhase{
+				formatPhaseWipeStart, formatPhaseWipeDone,
+				formatPhaseMkfsStart, formatPhaseMkfsDone,
+			},
+		},
+		{
+			desc:      "failure",
+			cmdRet:    errors.New("example failure"),
+			errMsg:    "wipefs: example failure",
+			expPhases: []formatPhase{formatPhaseWipeStart, formatPhaseFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(
+			newMockExt(tt.cmdRet, false, nil, true, nil, nil, nil))
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config)
+
+		buf := new(bytes.Buffer)
+		ss.withEventStream(buf)
+
+		err := ss.reFormat("/dev/pmem0", mkfsParams{})
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+		} else if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		var phases []formatPhase
+		dec := json.NewDecoder(buf)
+		for dec.More() {
+			var ev scmEvent
+			if err := dec.Decode(&ev); err != nil {
+				t.Fatal(tt.desc + ": " + err.Error())
+			}
+			if ev.Type != scmEventFormatPhase {
+				continue
+			}
+			AssertEqual(t, ev.Device, "/dev/pmem0", tt.desc+": unexpected device")
+			phases = append(phases, formatPhase(ev.Message))
+		}
+
+		AssertEqual(t, phases, tt.expPhases, tt.desc+": unexpected format phases")
+	}
+}
+
+func TestReFormatInodeRatio(t *testing.T) {
+	tests := []struct {
+		desc       string
+		inodeRatio int
+		expCmds    []string
+	}{
+		{
+			desc: "default inode ratio",
+			expCmds: []string{
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 /dev/pmem0",
+			},
+		},
+		{
+			desc:       "custom inode ratio",
+			inodeRatio: 1048576,
+			expCmds: []string{
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 -i 1048576 /dev/pmem0",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(
+			newMockExt(nil, false, nil, true, nil, nil, nil))
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config)
+
+		params := mkfsParams{inodeRatio: tt.inodeRatio}
+		if err := ss.reFormat("/dev/pmem0", params); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds, tt.desc+": unexpected commands")
+	}
+}
+
+func TestReFormatMkfsOpts(t *testing.T) {
+	tests := []struct {
+		desc     string
+		fsType   string
+		mkfsOpts string
+		expCmds  []string
+		expErr   error
+	}{
+		{
+			desc: "no extra options",
+			expCmds: []string{
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+			},
+		},
+		{
+			desc:     "extra options",
+			mkfsOpts: " -E lazy_itable_init=0,lazy_journal_init=0  -T largefile4",
+			expCmds: []string{
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 -E nodiscard -E lazy_itable_init=0," +
+					"lazy_journal_init=0 -T largefile4 /dev/pmem0",
+			},
+		},
+		{
+			desc:     "extra options xfs",
+			fsType:   scmFsXfs,
+			mkfsOpts: "-d agcount=4",
+			expCmds: []string{
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.xfs -m reflink=0 -K -d agcount=4 /dev/pmem0",
+			},
+		},
+		{
+			desc:     "shell injection",
+			mkfsOpts: "-T largefile4; rm -rf /",
+			expCmds:  []string{},
+			expErr: errors.Errorf("%s: %q", msgScmBadMkfsOpts,
+				"-T largefile4; rm -rf /"),
+		},
+		{
+			desc:     "command substitution",
+			mkfsOpts: "-L $(reboot)",
+			expCmds:  []string{},
+			expErr: errors.Errorf("%s: %q", msgScmBadMkfsOpts,
+				"-L $(reboot)"),
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(
+			newMockExt(nil, false, nil, true, nil, nil, nil))
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config)
+
+		srv := newDefaultServer()
+		srv.ScmFsType = tt.fsType
+		srv.ScmMkfsOpts = tt.mkfsOpts
+
+		err := ss.reFormat("/dev/pmem0", ss.mkfsParams("/dev/pmem0", &srv))
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+		} else if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds,
+			tt.desc+": unexpected commands")
+	}
+}
+
+func TestReFormatStride(t *testing.T) {
+	tests := []struct {
+		desc        string
+		stride      int
+		stripeWidth int
+		discard     string
+		numModules  int // modules interleaved on socket of device
+		expMkfs     string
+	}{
+		{
+			desc:    "unknown interleave width",
+			expMkfs: "cmd: mkfs.ext4 -E nodiscard /dev/pmem0",
+		},
+		{
+			desc:    "explicit discard",
+			discard: scmDiscard,
+			expMkfs: "cmd: mkfs.ext4 -E discard /dev/pmem0",
+		},
+		{
+			desc:       "explicit nodiscard",
+			discard:    scmNoDiscard,
+			numModules: 6,
+			expMkfs:    "cmd: mkfs.ext4 -E stride=1,stripe_width=6,nodiscard /dev/pmem0",
+		},
+		{
+			desc:        "explicit values",
+			stride:      16,
+			stripeWidth: 64,
+			numModules:  6,
+			expMkfs:     "cmd: mkfs.ext4 -E stride=16,stripe_width=64,nodiscard /dev/pmem0",
+		},
+		{
+			desc:        "explicit values unknown interleave width",
+			stride:      16,
+			stripeWidth: 64,
+			expMkfs:     "cmd: mkfs.ext4 -E stride=16,stripe_width=64,nodiscard /dev/pmem0",
+		},
+		{
+			desc:       "derived defaults",
+			numModules: 6,
+			expMkfs:    "cmd: mkfs.ext4 -E stride=1,stripe_width=6,nodiscard /dev/pmem0",
+		},
+		{
+			desc:       "stripe width derived from stride",
+			stride:     4,
+			numModules: 3,
+			expMkfs:    "cmd: mkfs.ext4 -E stride=4,stripe_width=12,nodiscard /dev/pmem0",
+		},
+	}
+
+	for _, tt := range tests {
+		var modules []DeviceDiscovery
+		for i := 0; i < tt.numModules; i++ {
+			m := MockModule()
+			m.Socket_id = 0
+			modules = append(modules, m)
+		}
+		// module on another socket shouldn't count towards width
+		other := MockModule()
+		other.Socket_id = 1
+		modules = append(modules, other)
+
+		config := newDefaultConfiguration(
+			newMockExt(nil, false, nil, true, nil, nil, nil))
+		ss := newMockScmStorage(nil, modules, false, &config)
+		ss.Discover(new(pb.ScanStorageResp)) // not concerned with response
+		ss.pmemDevs = []pmemDev{{Blockdev: "pmem0", NumaNode: 0}}
+
+		srv := newDefaultServer()
+		srv.ScmStride = tt.stride
+		srv.ScmStripeWidth = tt.stripeWidth
+		srv.ScmDiscard = tt.discard
+
+		params := ss.mkfsParams("/dev/pmem0", &srv)
+		if err := ss.reFormat("/dev/pmem0", params); err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.config.ext.getHistory(),
+			[]string{"cmd: wipefs -a /dev/pmem0", tt.expMkfs},
+			tt.desc+": unexpected commands")
+	}
+}
+
+func TestReFormatPartitioned(t *testing.T) {
+	tests := []struct {
+		desc       string
+		signatures string
+		expErr     error
+		expCmds    []string
+	}{
+		{
+			desc: "unpartitioned device",
+			expCmds: []string{
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 /dev/pmem0",
+			},
+		},
+		{
+			desc:       "device with filesystem",
+			signatures: "ext4\n",
+			expCmds: []string{
+				"cmd: wipefs -a /dev/pmem0",
+				"cmd: mkfs.ext4 /dev/pmem0",
+			},
+		},
+		{
+			desc:       "device with gpt partition table",
+			signatures: "gpt\nPMBR\n",
+			expErr:     FaultScmPartitionedDevice("/dev/pmem0", "gpt"),
+			expCmds:    []string{},
+		},
+		{
+			desc:       "device with dos partition table",
+			signatures: "dos\n",
+			expErr:     FaultScmPartitionedDevice("/dev/pmem0", "dos"),
+			expCmds:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		var commands []string
+		mockRun := func(in string) (string, error) {
+			commands = append(commands, in)
+			return tt.signatures, nil
+		}
+
+		config := newDefaultConfiguration(
+			newMockExt(nil, false, nil, true, nil, nil, nil))
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config).
+			withRunCmd(mockRun)
+
+		err := ss.reFormat("/dev/pmem0", mkfsParams{})
+		AssertEqual(t, commands,
+			[]string{cmdScmListSignatures + " /dev/pmem0"},
+			tt.desc+": unexpected signature listing")
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+		} else if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds, tt.desc+": unexpected commands")
+	}
+}
+
+func TestMakeMountOwner(t *testing.T) {
+	mountCmd := fmt.Sprintf(
+		msgMount, "/dev/pmem0", "/mnt/daos", "ext4", "0", "dax")
+
+	tests := []struct {
+		desc    string
+		flags   uintptr
+		uid     int
+		gid     int
+		expCmds []string
+	}{
+		{
+			desc: "default ownership",
+			expCmds: []string{
+				"os: mkdirall /mnt/daos, 0777",
+				mountCmd,
+			},
+		},
+		{
+			desc: "configured ownership",
+			uid:  1001,
+			gid:  1002,
+			expCmds: []string{
+				"os: mkdirall /mnt/daos, 0777",
+				mountCmd,
+				"os: walk /mnt/daos chown 1001 1002",
+				"os: chmod /mnt/daos 0750",
+			},
+		},
+		{
+			desc:  "configured ownership of read-only mount",
+			flags: syscall.MS_RDONLY | syscall.MS_NOATIME,
+			uid:   1001,
+			gid:   1002,
+			expCmds: []string{
+				"os: mkdirall /mnt/daos, 0777",
+				fmt.Sprintf(msgMount, "/dev/pmem0", "/mnt/daos", "ext4",
+					fmt.Sprint(syscall.MS_RDONLY|syscall.MS_NOATIME), "dax"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(
+			newMockExt(nil, false, nil, true, nil, nil, nil))
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config)
+
+		err := ss.makeMount(
+			"/dev/pmem0", "/mnt/daos", "ext4", "dax", tt.flags, tt.uid, tt.gid)
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds, tt.desc+": unexpected commands")
+	}
+}
+
+func TestMakeMountReadOnlyBase(t *testing.T) {
+	tests := []struct {
+		desc    string
+		roBase  string
+		expErr  error
+		expCmds []string
+	}{
+		{
+			desc: "writable base",
+			expCmds: []string{
+				"os: mkdirall /mnt/daos, 0777",
+				"syscall: mount /dev/pmem0, /mnt/daos, ext4, 0, dax",
+			},
+		},
+		{
+			desc:    "read-only base",
+			roBase:  "/mnt",
+			expErr:  FaultScmMountBaseReadOnly("/mnt/daos", "/mnt"),
+			expCmds: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(
+			newMockExt(nil, false, nil, true, nil, nil, nil))
+		config.ext.(*mockExt).readOnlyBaseRet = tt.roBase
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config)
+
+		err := ss.makeMount("/dev/pmem0", "/mnt/daos", "ext4", "dax", 0, 0, 0)
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+		} else if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.config.ext.getHistory(), tt.expCmds,
+			tt.desc+": unexpected commands")
+	}
+
+	// real filesystem backing a temporary directory should be writable
+	tmpDir, err := ioutil.TempDir("", "scm-base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	base, err := (&ext{}).readOnlyBase(filepath.Join(tmpDir, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, base, "", "unexpected read-only base for temporary directory")
+}
+
+func TestDrainMount(t *testing.T) {
+	defer func(interval time.Duration) {
+		mountDrainInterval = interval
+	}(mountDrainInterval)
+	mountDrainInterval = 0
+
+	busy := []string{"1234 (daos_io_server)"}
+
+	tests := []struct {
+		desc        string
+		holdersRets [][]string
+		expErr      error
+		expCmds     []string
+	}{
+		{
+			desc:    "not in use",
+			expCmds: []string{"os: list processes using /mnt/daos"},
+		},
+		{
+			desc:        "released after retry",
+			holdersRets: [][]string{busy, nil},
+			expCmds: []string{
+				"os: list processes using /mnt/daos",
+				"os: list processes using /mnt/daos",
+			},
+		},
+		{
+			desc: "remains busy",
+			holdersRets: [][]string{
+				busy, busy, busy, busy, busy, busy, busy,
+			},
+			expErr: FaultScmFilesystemMounted("/mnt/daos", busy),
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(&mockExt{mountHoldersRet: tt.holdersRets})
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config)
+
+		err := ss.clearMount("/mnt/daos")
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+			AssertEqual(t, len(ss.config.ext.getHistory()), mountDrainRetries+1,
+				tt.desc+": unexpected number of drain attempts")
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		expCmds := append(tt.expCmds,
+			"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+			"os: removeall /mnt/daos")
+		AssertEqual(t, ss.config.ext.getHistory(), expCmds, tt.desc+": unexpected commands")
+	}
+}
+
+func TestClearMountBusy(t *testing.T) {
+	tests := []struct {
+		desc       string
+		unmountErr error
+		expErr     error
+		expCmds    []string
+	}{
+		{
+			desc: "not mounted",
+			expCmds: []string{
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+				"os: removeall /mnt/daos",
+			},
+		},
+		{
+			desc:       "mounted and busy",
+			unmountErr: os.NewSyscallError("umount", syscall.EBUSY),
+			expErr:     FaultScmFilesystemMounted("/mnt/daos", nil),
+			expCmds: []string{
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+			},
+		},
+		{
+			desc:       "other unmount failure",
+			unmountErr: os.NewSyscallError("umount", syscall.EPERM),
+			expErr:     os.NewSyscallError("umount", syscall.EPERM),
+			expCmds: []string{
+				"syscall: calling unmount with /mnt/daos, MNT_DETACH",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newDefaultConfiguration(&mockExt{unmountRet: tt.unmountErr})
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, &config)
+
+		err := ss.clearMount("/mnt/daos")
+		if tt.expErr != nil {
+			ExpectError(t, err, tt.expErr.Error(), tt.desc)
+		} else if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, ss.config.ext.getHistory(),
+			append([]string{"os: list processes using /mnt/daos"}, tt.expCmds...),
+			tt.desc+": unexpected commands")
+	}
+}
+
+func TestScmMaintenanceMode(t *testing.T) {
+	config := defaultMockConfig(t)
+	config.ScmMaintenance = true
+	var cmds []string
+	ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+		cmds = append(cmds, cmd)
+		return "", nil
+	})
+
+	// read-only operations remain allowed
+	resp := new(pb.ScanStorageResp)
+	ss.Discover(resp)
+	AssertEqual(t, resp.Scmstate.Status, pb.ResponseStatus_CTRL_SUCCESS,
+		"discover should be allowed in maintenance mode")
+	if err := ss.getState(context.Background()); FaultScmMaintenanceMode("").Equals(err) {
+		t.Fatal("state query should be allowed in maintenance mode")
+	}
+
+	// mutating operations are refused
+	expErr := FaultScmMaintenanceMode("prep")
+	_, err := ss.Prep(context.Background())
+	ExpectError(t, err, expErr.Error(), "prep in maintenance mode")
+
+	expErr = FaultScmMaintenanceMode("reformat")
+	err = ss.reFormat("/dev/pmem0", mkfsParams{})
+	ExpectError(t, err, expErr.Error(), "reformat in maintenance mode")
+
+	fResults := ScmMountResults{}
+	ss.Format(0, &fResults)
+	AssertEqual(t, len(fResults), 1, "unexpected number of format results")
+	AssertEqual(t, fResults[0].State.Error,
+		FaultScmMaintenanceMode("format").Error(),
+		"format in maintenance mode")
+
+	uResults := ScmModuleResults{}
+	ss.Update(0, &pb.UpdateScmReq{}, &uResults)
+	AssertEqual(t, len(uResults), 1, "unexpected number of update results")
+	AssertEqual(t, uResults[0].State.Status, pb.ResponseStatus_CTRL_ERR_APP,
+		"update in maintenance mode")
+	AssertEqual(t, uResults[0].State.Error,
+		FaultScmMaintenanceMode("update").Error(),
+		"update in maintenance mode")
+
+	// only the read-only state query should have run commands
+	for _, cmd := range cmds {
+		if strings.Contains(cmd, "wipefs") || strings.Contains(cmd, "mkfs") ||
+			strings.Contains(cmd, "create-namespace") {
+			t.Fatalf("mutating command %q run in maintenance mode", cmd)
+		}
+	}
+
+	// mutating operations allowed again once disabled
+	ss.config.ScmMaintenance = false
+	uResults = ScmModuleResults{}
+	ss.Update(0, &pb.UpdateScmReq{}, &uResults)
+	AssertEqual(t, uResults[0].State.Status, pb.ResponseStatus_CTRL_NO_IMPL,
+		"update after leaving maintenance mode")
+}
+
+func TestScmServerIdxBounds(t *testing.T) {
+	config := defaultMockConfig(t)
+	numSrvs := len(config.Servers)
+
+	for _, idx := range []int{-1, numSrvs} {
+		ss := defaultMockScmStorage(&config)
+		ss.Discover(new(pb.ScanStorageResp))
+		expErr := fmt.Sprintf(msgScmBadServerIdx, idx, numSrvs)
+
+		fResults := ScmMountResults{}
+		ss.Format(idx, &fResults)
+		AssertEqual(t, len(fResults), 1, "unexpected number of format results")
+		AssertEqual(t, fResults[0].State.Status, pb.ResponseStatus_CTRL_ERR_CONF,
+			fmt.Sprintf("format index %d: unexpected status", idx))
+		AssertEqual(t, fResults[0].State.Error, expErr,
+			fmt.Sprintf("format index %d: unexpected error", idx))
+
+		uResults := ScmModuleResults{}
+		ss.Update(idx, &pb.UpdateScmReq{}, &uResults)
+		AssertEqual(t, len(uResults), 1, "unexpected number of update results")
+		AssertEqual(t, uResults[0].State.Status, pb.ResponseStatus_CTRL_ERR_CONF,
+			fmt.Sprintf("update index %d: unexpected status", idx))
+		AssertEqual(t, uResults[0].State.Error, expErr,
+			fmt.Sprintf("update index %d: unexpected error", idx))
+	}
+}
+
+// TestUpdateScm currently just verifies that response is populated with not
+// implemented state in result.
+func TestUpdateScm(t *testing.T) {
+	tests := []struct {
+		expResults ScmModuleResults
+		desc       string
+	}{
+		{
+			expResults: ScmModuleResults{
+				{
+					Loc: &pb.ScmModule_Location{},
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_NO_IMPL,
+						Error:  msgScmUpdateNotImpl,
+					},
+				},
+			},
+			desc: "not implemented",
+		},
+	}
+
+	srvIdx := 0
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := newMockScmStorage(
+			nil, []DeviceDiscovery{}, false, &config)
+
+		results := ScmModuleResults{}
+
+		req := &pb.UpdateScmReq{}
+		ss.Update(srvIdx, req, &results)
+
+		// only ocm result in response for the moment
+		AssertEqual(
+			t, len(results), 1,
+			"unexpected number of response results, "+tt.desc)
+
+		result := results[0]
+
+		AssertEqual(
+			t, result.State.Error, tt.expResults[0].State.Error,
+			"unexpected result error message, "+tt.desc)
+		AssertEqual(
 			t, result.State.Status, tt.expResults[0].State.Status,
 			"unexpected response status, "+tt.desc)
 		AssertEqual(
-			t, result.Mntpoint, tt.expResults[0].Mntpoint,
-			"unexpected mntpoint, "+tt.desc)
+			t, result.Loc, tt.expResults[0].Loc,
+			"unexpected module location, "+tt.desc)
+	}
+}
+
+func TestUpdateScmFirmware(t *testing.T) {
+	image := "/tmp/fw dir/dcpm.bin"
+	meta := "VendorID=0x8086\nDeviceID=0x979\n"
+	mm1 := MockModule()
+	mm1.Vendor_id, mm1.Device_id, mm1.Subsystem_device_id = 0x8086, 0x979, 0x97a
+	mm2 := mm1
+	mm2.Physical_id = 2
+	mm2.Channel_pos = 1
+	mm2.Subsystem_device_id = 0x97b
+	loc1 := loadModules([]DeviceDiscovery{mm1})[0].Loc
+	loc2 := loadModules([]DeviceDiscovery{mm2})[0].Loc
+	loadCmd := func(id uint16) string {
+		// image path passed to the shell as a single word
+		return fmt.Sprintf("ipmctl load -source '/tmp/fw dir/dcpm.bin' -dimm %d", id)
+	}
+
+	tests := []struct {
+		desc       string
+		imageFound bool
+		meta       string
+		cmdOut     map[string]string
+		cmdErr     map[string]error
+		expResults ScmModuleResults
+		expCmds    []string
+	}{
+		{
+			desc:       "success",
+			imageFound: true,
+			meta:       meta,
+			expResults: ScmModuleResults{
+				{Loc: loc1, State: &pb.ResponseState{}},
+				{Loc: loc2, State: &pb.ResponseState{}},
+			},
+			expCmds: []string{loadCmd(mm1.Physical_id), loadCmd(mm2.Physical_id)},
+		},
+		{
+			desc: "image not found",
+			expResults: ScmModuleResults{
+				{
+					Loc: &pb.ScmModule_Location{},
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error:  msgScmFwImageNotFound + ": " + image,
+					},
+				},
+			},
+			expCmds: []string{},
+		},
+		{
+			desc:       "image metadata missing",
+			imageFound: true,
+			expResults: ScmModuleResults{
+				{
+					Loc: &pb.ScmModule_Location{},
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error: "read firmware image metadata: open " +
+							image + fwImageMetaSuffix + ": file does not exist",
+					},
+				},
+			},
+			expCmds: []string{},
+		},
+		{
+			desc:       "incompatible module",
+			imageFound: true,
+			meta:       meta + "SubsystemDeviceID=0x97a\n",
+			expResults: ScmModuleResults{
+				{Loc: loc1, State: &pb.ResponseState{}},
+				{
+					Loc: loc2,
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_CONF,
+						Error: FaultScmFirmwareIncompatible(2, image,
+							"image model 0x97a, module model 0x97b").Error(),
+					},
+				},
+			},
+			expCmds: []string{loadCmd(mm1.Physical_id)},
+		},
+		{
+			desc:       "activation pending",
+			imageFound: true,
+			meta:       meta,
+			cmdOut: map[string]string{
+				loadCmd(mm2.Physical_id): "Load FW on DIMM (0x0002): Success, " +
+					"a platform reboot is required to activate the FW.",
+			},
+			expResults: ScmModuleResults{
+				{Loc: loc1, State: &pb.ResponseState{}},
+				{
+					Loc: loc2,
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_WAITING,
+						Info:   msgScmFwRebootRequired,
+					},
+				},
+			},
+			expCmds: []string{loadCmd(mm1.Physical_id), loadCmd(mm2.Physical_id)},
+		},
+		{
+			desc:       "load failure",
+			imageFound: true,
+			meta:       meta,
+			cmdErr: map[string]error{
+				loadCmd(mm1.Physical_id): errors.New("exit status 1"),
+			},
+			expResults: ScmModuleResults{
+				{
+					Loc: loc1,
+					State: &pb.ResponseState{
+						Status: pb.ResponseStatus_CTRL_ERR_SCM,
+						Error: fmt.Sprintf("module %d: exit status 1",
+							mm1.Physical_id),
+					},
+				},
+				{Loc: loc2, State: &pb.ResponseState{}},
+			},
+			expCmds: []string{loadCmd(mm1.Physical_id), loadCmd(mm2.Physical_id)},
+		},
+	}
 
-		if result.State.Status == pb.ResponseStatus_CTRL_SUCCESS {
-			AssertEqual(
-				t, ss.formatted,
-				true, "expect formatted state, "+tt.desc)
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		config.ext.(*mockExt).existsRet = tt.imageFound
+		if tt.meta != "" {
+			config.ext.(*mockExt).readFileRet = map[string]string{
+				image + fwImageMetaSuffix: tt.meta,
+			}
 		}
+		cmds := []string{}
+		ss := newMockScmStorage(
+			nil, []DeviceDiscovery{mm1, mm2}, false, &config).
+			withRunCmd(func(cmd string) (string, error) {
+				cmds = append(cmds, cmd)
+				return tt.cmdOut[cmd], tt.cmdErr[cmd]
+			})
+		ss.Discover(new(pb.ScanStorageResp))
+		cmds = []string{} // not concerned with discovery commands
 
-		cmds := ss.config.ext.getHistory()
-		AssertEqual(
-			t, len(cmds), len(tt.expCmds), "number of cmds, "+tt.desc)
-		for i, s := range cmds {
-			AssertEqual(
-				t, s, tt.expCmds[i],
-				fmt.Sprintf("commands don't match (%s)", tt.desc))
+		results := ScmModuleResults{}
+		ss.Update(0, &pb.UpdateScmReq{Path: image}, &results)
+
+		AssertEqual(t, results, tt.expResults, tt.desc+": unexpected results")
+		AssertEqual(t, cmds, tt.expCmds, tt.desc+": unexpected commands")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for arg, exp := range map[string]string{
+		"/tmp/fw.bin":            `'/tmp/fw.bin'`,
+		"/tmp/fw dir/fw.bin":     `'/tmp/fw dir/fw.bin'`,
+		"/tmp/fw.bin; reboot":    `'/tmp/fw.bin; reboot'`,
+		"/tmp/o'brien/fw.bin":    `'/tmp/o'\''brien/fw.bin'`,
+		"/tmp/$(touch x)/fw.bin": `'/tmp/$(touch x)/fw.bin'`,
+	} {
+		AssertEqual(t, shellQuote(arg), exp, "unexpected quoting of "+arg)
+	}
+}
+
+// failDevExt fails external commands matching failCmd, other operations
+// are delegated to the embedded mockExt.
+type failDevExt struct {
+	*mockExt
+	failCmd string
+}
+
+func (e *failDevExt) runCommand(cmd string) error {
+	if err := e.mockExt.runCommand(cmd); err != nil {
+		return err
+	}
+	if cmd == e.failCmd {
+		return errors.New("exit status 1")
+	}
+
+	return nil
+}
+
+func TestFormatScmMultiDevice(t *testing.T) {
+	tests := []struct {
+		desc         string
+		failCmd      string
+		cancel       bool
+		expStatuses  []pb.ResponseStatus
+		expErrors    []string
+		expFormatted bool
+	}{
+		{
+			desc: "all devices succeed",
+			expStatuses: []pb.ResponseStatus{
+				pb.ResponseStatus_CTRL_SUCCESS,
+				pb.ResponseStatus_CTRL_SUCCESS,
+			},
+			expErrors:    []string{"", ""},
+			expFormatted: true,
+		},
+		{
+			desc:    "second device format fails",
+			failCmd: "mkfs.ext4 -E nodiscard /dev/pmem1",
+			expStatuses: []pb.ResponseStatus{
+				pb.ResponseStatus_CTRL_SUCCESS,
+				pb.ResponseStatus_CTRL_ERR_APP,
+			},
+			expErrors: []string{
+				"",
+				"mkfs format: exit status 1 " +
+					"(failed command: mkfs.ext4 -E nodiscard /dev/pmem1)",
+			},
+		},
+		{
+			desc:   "cancelled",
+			cancel: true,
+			expStatuses: []pb.ResponseStatus{
+				pb.ResponseStatus_CTRL_ERR_APP,
+				pb.ResponseStatus_CTRL_ERR_APP,
+			},
+			expErrors: []string{
+				"scm format of /dev/pmem0 aborted: context canceled",
+				"scm format of /dev/pmem1 aborted: context canceled",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := newMockStorageConfig(
+			nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+			[]string{"/dev/pmem0", "/dev/pmem1"}, 0, bdNVMe, []string{},
+			false)
+		ext := &failDevExt{config.ext.(*mockExt), tt.failCmd}
+		config.ext = ext
+		ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+		ss.Discover(new(pb.ScanStorageResp))
+		files = []string{}
+
+		results := ScmMountResults{}
+		if tt.cancel {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			ss.formatDevices(ctx, ss.config.Servers[0], false, &results)
+		} else {
+			ss.Format(0, &results)
+		}
+
+		AssertEqual(t, len(results), 2, tt.desc+": unexpected number of results")
+		for i, result := range results {
+			AssertEqual(t, result.Mntpoint, fmt.Sprintf("/mnt/daos/%d", i),
+				tt.desc+": unexpected mount point")
+			AssertEqual(t, result.State.Status, tt.expStatuses[i],
+				tt.desc+": unexpected response status")
+			AssertEqual(t, result.State.Error, tt.expErrors[i],
+				tt.desc+": unexpected result error message")
+		}
+		AssertEqual(t, ss.formatted, tt.expFormatted,
+			tt.desc+": unexpected formatted state")
+
+		// format record only written for each device mounted
+		for i, status := range tt.expStatuses {
+			recPath := fmt.Sprintf("/mnt/daos/%d/%s", i, scmFormatRecordFile)
+			written := false
+			for _, file := range files {
+				if !strings.HasPrefix(file, recPath+":") {
+					continue
+				}
+				var rec scmFormatRecord
+				err := json.Unmarshal([]byte(strings.TrimPrefix(file, recPath+":")), &rec)
+				if err != nil {
+					t.Fatal(tt.desc + ": " + err.Error())
+				}
+				AssertEqual(t, rec.Device, fmt.Sprintf("/dev/pmem%d", i),
+					tt.desc+": unexpected recorded device")
+				written = true
+			}
+			AssertEqual(t, written, status == pb.ResponseStatus_CTRL_SUCCESS,
+				tt.desc+": unexpected format record of "+recPath)
+		}
+
+		// the successful device is mounted regardless of the other failing
+		mounted := 0
+		for _, op := range ext.getHistory() {
+			if strings.HasPrefix(op, "syscall: mount /dev/pmem0") {
+				mounted++
+			}
+		}
+		if !tt.cancel {
+			AssertEqual(t, mounted, 1, tt.desc+": pmem0 not mounted")
 		}
 	}
 }
 
-// TestUpdateScm currently just verifies that response is populated with not
-// implemented state in result.
-func TestUpdateScm(t *testing.T) {
+func TestFormatScmMultiDeviceRecordedParams(t *testing.T) {
+	config := newMockStorageConfig(
+		nil, nil, nil, nil, "/mnt/daos", scmDCPM,
+		[]string{"/dev/pmem0", "/dev/pmem1"}, 0, bdNVMe, []string{}, false)
+	config.ext.(*mockExt).mountTypeRet = "ext4"
+	config.ext.(*mockExt).readFileRet = map[string]string{}
+	for i := 0; i < 2; i++ {
+		data, err := json.Marshal(scmFormatRecord{
+			Class:     scmDCPM,
+			Device:    fmt.Sprintf("/dev/pmem%d", i),
+			FsType:    "ext4",
+			MkfsOpts:  "-E nodiscard",
+			MountOpts: "dax",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recPath := fmt.Sprintf("/mnt/daos/%d/%s", i, scmFormatRecordFile)
+		config.ext.(*mockExt).readFileRet[recPath] = string(data)
+	}
+	ss := newMockScmStorage(nil, []DeviceDiscovery{}, false, config)
+	ss.Discover(new(pb.ScanStorageResp))
+
+	results := ScmMountResults{}
+	ss.Format(0, &results)
+
+	AssertEqual(t, len(results), 2, "unexpected number of results")
+	for i, result := range results {
+		AssertEqual(t, result.Mntpoint, fmt.Sprintf("/mnt/daos/%d", i),
+			"unexpected mount point")
+		AssertEqual(t, result.State.Status, pb.ResponseStatus_CTRL_SUCCESS,
+			"unexpected response status of "+result.Mntpoint)
+		AssertEqual(t, result.State.Info, msgScmFormatUnchanged,
+			"unexpected result info of "+result.Mntpoint)
+	}
+	AssertEqual(t, ss.config.ext.getHistory(), []string{},
+		"no device expected to be reformatted or remounted")
+	AssertEqual(t, ss.formatted, true, "not marked formatted")
+}
+
+func TestValidateFirmwareImage(t *testing.T) {
+	image := "/tmp/fw.bin"
+	compatible := DeviceDiscovery{
+		Physical_id: 1, Vendor_id: 0x8086, Device_id: 0x979,
+		Subsystem_device_id: 0x97a,
+	}
+	otherModel := compatible
+	otherModel.Physical_id = 2
+	otherModel.Subsystem_device_id = 0x97b
+
+	tests := []struct {
+		desc            string
+		meta            map[string]string
+		mms             []DeviceDiscovery
+		expIncompatible map[uint32]error
+		errMsg          string
+	}{
+		{
+			desc: "compatible module",
+			meta: map[string]string{
+				image + ".meta": "VendorID=0x8086\nDeviceID=0x979\n" +
+					"SubsystemDeviceID=0x97a\n",
+			},
+			mms:             []DeviceDiscovery{compatible},
+			expIncompatible: map[uint32]error{},
+		},
+		{
+			desc: "incompatible module model",
+			meta: map[string]string{
+				image + ".meta": "VendorID=0x8086\nDeviceID=0x979\n" +
+					"SubsystemDeviceID=0x97a\n",
+			},
+			mms: []DeviceDiscovery{compatible, otherModel},
+			expIncompatible: map[uint32]error{
+				2: FaultScmFirmwareIncompatible(2, image,
+					"image model 0x97a, module model 0x97b"),
+			},
+		},
+		{
+			desc: "incompatible module family",
+			meta: map[string]string{
+				image + ".meta": "DeviceID=0x97c\n",
+			},
+			mms: []DeviceDiscovery{compatible},
+			expIncompatible: map[uint32]error{
+				1: FaultScmFirmwareIncompatible(1, image,
+					"image device 0x97c, module device 0x979"),
+			},
+		},
+		{
+			desc: "missing device id",
+			meta: map[string]string{
+				image + ".meta": "VendorID=0x8086\n",
+			},
+			mms:    []DeviceDiscovery{compatible},
+			errMsg: "firmware image metadata missing DeviceID",
+		},
+		{
+			desc:   "missing metadata",
+			mms:    []DeviceDiscovery{compatible},
+			errMsg: "read firmware image metadata: open /tmp/fw.bin.meta: file does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		config.ext.(*mockExt).readFileRet = tt.meta
+		ss := newMockScmStorage(nil, tt.mms, false, &config)
+
+		incompatible, err := ss.ValidateFirmwareImage(image)
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, incompatible, tt.expIncompatible, tt.desc)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	regionsOut := `[` +
+		`{"dev":"region0","size":1082331758592,"available_size":0,"numa_node":0},` +
+		`{"dev":"region1","size":1082331758592,"available_size":1082331758592,"numa_node":1},` +
+		`{"dev":"region2","size":1082331758592,"available_size":541165879296,"numa_node":0},` +
+		`{"dev":"region3","size":1082331758592,"available_size":0,"numa_node":1}` +
+		`]`
+	nsOut := `[` +
+		`{"blockdev":"pmem0","size":1082331758592,"numa_node":0},` +
+		`{"blockdev":"pmem2","size":541165879296,"numa_node":0},` +
+		`{"blockdev":"pmem3","size":"1008.00 GiB (1082.33 GB)","numa_node":1}` +
+		`]`
+
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+		switch cmd {
+		case cmdScmListNdRegions:
+			return regionsOut, nil
+		case cmdScmListNamespaces:
+			return nsOut, nil
+		}
+		return "", errors.Errorf("unexpected command %q", cmd)
+	})
+
+	capacities, err := ss.Capacity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertEqual(t, capacities, []scmCapacity{
+		{
+			Socket: 0,
+			Total:  newScmSize(2164663517184),
+			Used:   newScmSize(1623497637888),
+			Free:   newScmSize(541165879296),
+		},
+		{
+			Socket:            1,
+			Total:             newScmSize(2164663517184),
+			Used:              newScmSize(0),
+			Free:              newScmSize(1082331758592),
+			UnknownNamespaces: 1,
+		},
+	}, "unexpected capacity accounting")
+}
+
+func TestCapacityNdctlOutput(t *testing.T) {
+	regionsOut := `{"dev":"region0","size":1082331758592,` +
+		`"available_size":541165879296,"numa_node":1}`
+	expCapacities := []scmCapacity{
+		{
+			Socket: 1,
+			Total:  newScmSize(1082331758592),
+			Used:   newScmSize(541165879296),
+			Free:   newScmSize(541165879296),
+		},
+	}
+
 	tests := []struct {
-		expResults ScmModuleResults
 		desc       string
+		regionsOut string
+		nsOut      string
+		errMsg     string
 	}{
 		{
-			expResults: ScmModuleResults{
+			desc:       "warning preamble",
+			regionsOut: "libndctl: ndctl_region_get_available_size: region1: invalid\n" + regionsOut,
+			nsOut: "[  102.345] nd_pmem namespace0.0: unable to guarantee persistence\n" +
+				`{"blockdev":"pmem0","size":541165879296,"numa_node":1}` + "\n",
+		},
+		{
+			desc:       "numanode schema",
+			regionsOut: regionsOut,
+			nsOut:      `[{"blockdev":"pmem0","size":541165879296,"numanode":1}]`,
+		},
+		{
+			desc:       "regions not json",
+			regionsOut: "ndctl: unknown option",
+			errMsg: "parse ndctl regions: " + msgNdctlNoJSON +
+				`: "ndctl: unknown option"`,
+		},
+		{
+			desc:       "namespaces not json",
+			regionsOut: regionsOut,
+			nsOut:      "ndctl: unknown option",
+			errMsg: "parse ndctl namespaces: " + msgNdctlNoJSON +
+				`: "ndctl: unknown option"`,
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(func(cmd string) (string, error) {
+			switch cmd {
+			case cmdScmListNdRegions:
+				return tt.regionsOut, nil
+			case cmdScmListNamespaces:
+				return tt.nsOut, nil
+			}
+			return "", errors.Errorf("unexpected command %q", cmd)
+		})
+
+		capacities, err := ss.Capacity()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, capacities, expCapacities,
+			tt.desc+": unexpected capacity accounting")
+	}
+}
+
+func TestNamespaceLayout(t *testing.T) {
+	tests := []struct {
+		desc          string
+		out           string
+		expLayouts    []regionLayout
+		expFragmented []bool
+		errMsg        string
+	}{
+		{
+			desc: "single large namespace",
+			out: `{"dev":"region0","size":1082331758592,"available_size":0,` +
+				`"namespaces":[{"dev":"namespace0.0","mode":"fsdax",` +
+				`"size":1065418227712,"blockdev":"pmem0"}]}`,
+			expLayouts: []regionLayout{
+				{Region: "region0", Sizes: []uint64{1065418227712}},
+			},
+			expFragmented: []bool{false},
+		},
+		{
+			desc: "many small namespaces",
+			out: `[{"dev":"region0","size":1082331758592,"available_size":0,` +
+				`"namespaces":[` +
+				`{"dev":"namespace0.2","size":4294967296,"blockdev":"pmem0.2"},` +
+				`{"dev":"namespace0.1","size":4294967296,"blockdev":"pmem0.1"},` +
+				`{"dev":"namespace0.0","size":4294967296,"blockdev":"pmem0"}]},` +
+				`{"dev":"region1","size":1082331758592,` +
+				`"available_size":1082331758592}]`,
+			expLayouts: []regionLayout{
 				{
-					Loc: &pb.ScmModule_Location{},
-					State: &pb.ResponseState{
-						Status: pb.ResponseStatus_CTRL_NO_IMPL,
-						Error:  msgScmUpdateNotImpl,
-					},
+					Region: "region0",
+					Sizes:  []uint64{4294967296, 4294967296, 4294967296},
 				},
+				{Region: "region1"},
 			},
-			desc: "not implemented",
+			expFragmented: []bool{true, false},
+		},
+		{
+			desc: "warning preamble",
+			out: "[  102.345] nd_pmem namespace0.0: unable to guarantee persistence\n" +
+				`{"dev":"region0","size":1082331758592,"available_size":0,` +
+				`"namespaces":[{"dev":"namespace0.0","mode":"fsdax",` +
+				`"size":1065418227712,"blockdev":"pmem0","numanode":0}]}` + "\n",
+			expLayouts: []regionLayout{
+				{Region: "region0", Sizes: []uint64{1065418227712}},
+			},
+			expFragmented: []bool{false},
+		},
+		{
+			desc: "human readable size",
+			out: `{"dev":"region0","size":"1008.00 GiB (1082.33 GB)",` +
+				`"namespaces":[{"dev":"namespace0.0","mode":"fsdax",` +
+				`"size":"992.25 GiB (1065.42 GB)","blockdev":"pmem0"}]}`,
+			expLayouts: []regionLayout{
+				{Region: "region0", Sizes: []uint64{0}},
+			},
+			expFragmented: []bool{false},
+		},
+		{
+			desc: "unknown namespace schema",
+			out: `{"dev":"region0","namespaces":[` +
+				`{"dev":"namespace0.0","size":1065418227712}]}`,
+			errMsg: "parse ndctl region layout: " + msgNdctlUnknownSchema +
+				": no namespace device",
+		},
+		{
+			desc: "not json",
+			out:  "ndctl: unknown option",
+			errMsg: "parse ndctl region layout: " + msgNdctlNoJSON +
+				`: "ndctl: unknown option"`,
 		},
 	}
 
-	srvIdx := 0
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		ss := defaultMockScmStorage(&config).withRunCmd(
+			func(cmd string) (string, error) {
+				if cmd != cmdScmListNdLayout {
+					return "", errors.Errorf("unexpected command %q", cmd)
+				}
+				return tt.out, nil
+			})
+
+		layouts, err := ss.NamespaceLayout()
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
+
+		AssertEqual(t, layouts, tt.expLayouts, tt.desc+": unexpected layout")
+		for i, rl := range layouts {
+			AssertEqual(t, rl.Fragmented(), tt.expFragmented[i],
+				tt.desc+": unexpected fragmentation of "+rl.Region)
+		}
+	}
+}
+
+func TestPrepWithRetry(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"\n"
+	pmemOut := `{"blockdev":"pmem0","numa_node":0}`
+
+	tests := []struct {
+		desc        string
+		noAuto      bool
+		showErrs    int // number of failing region queries
+		attempts    int
+		expDevs     []pmemDev
+		expAttempts int
+		errMsg      string
+	}{
+		{
+			desc:        "state detection fails once",
+			showErrs:    1,
+			attempts:    3,
+			expDevs:     []pmemDev{{Blockdev: "pmem0", SrvIdx: -1}},
+			expAttempts: 2,
+		},
+		{
+			desc:        "attempts exhausted",
+			showErrs:    3,
+			attempts:    2,
+			expAttempts: 2,
+			errMsg:      "establish scm state: region not visible",
+		},
+		{
+			desc:        "non-recoverable",
+			noAuto:      true,
+			attempts:    3,
+			expAttempts: 1,
+			errMsg:      FaultScmNoRegions().Error(),
+		},
+	}
 
 	for _, tt := range tests {
+		showCalls := 0
+		mockRun := func(cmd string) (string, error) {
+			switch cmd {
+			case cmdScmShowRegions:
+				showCalls++
+				if showCalls <= tt.showErrs {
+					return "", errors.New("region not visible")
+				}
+				if tt.noAuto {
+					return outScmNoRegions, nil
+				}
+				return regionsOut, nil
+			case cmdScmListNamespaces:
+				return pmemOut, nil
+			}
+			return "", errors.Errorf("unexpected command %q", cmd)
+		}
+
 		config := defaultMockConfig(t)
-		ss := newMockScmStorage(
-			nil, []DeviceDiscovery{}, false, &config)
+		config.ScmNoAutoRegion = tt.noAuto
+		ss := defaultMockScmStorage(&config).withRunCmd(mockRun)
 
-		results := ScmModuleResults{}
+		result, err := ss.PrepWithRetry(context.Background(), tt.attempts, 0)
+		AssertEqual(t, showCalls, tt.expAttempts,
+			tt.desc+": unexpected number of attempts")
+		if tt.errMsg != "" {
+			ExpectError(t, err, tt.errMsg, tt.desc)
+			continue
+		}
+		if err != nil {
+			t.Fatal(tt.desc + ": " + err.Error())
+		}
 
-		req := &pb.UpdateScmReq{}
-		ss.Update(srvIdx, req, &results)
+		AssertEqual(t, result.Namespaces, tt.expDevs, tt.desc+": unexpected pmem devices")
+	}
+}
 
-		// only ocm result in response for the moment
-		AssertEqual(
-			t, len(results), 1,
-			"unexpected number of response results, "+tt.desc)
+func TestPrepResultRegions(t *testing.T) {
+	// regions listed out of socket order, two regions on socket 1
+	regionsOut := "\n" +
+		"---ISetID=0x81187f4881f02ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=1008.0 GiB\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"   HealthState=Healthy\n" +
+		"   SocketID=0x0001\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=3012.0 GiB\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"   HealthState=Healthy\n" +
+		"   SocketID=0x0000\n" +
+		"---ISetID=0x91187f4881f02ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=504.0 GiB\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"   HealthState=Healthy\n" +
+		"   SocketID=0x0001\n" +
+		"\n"
+	nsOut := `[{"blockdev":"pmem0","name":"daos_io_server_0","numa_node":0},` +
+		`{"blockdev":"pmem1","name":"daos_io_server_1","numa_node":1}]`
 
-		result := results[0]
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(
+		func(cmd string) (string, error) {
+			switch cmd {
+			case cmdScmShowRegions:
+				return regionsOut, nil
+			case cmdScmListNamespaces:
+				return nsOut, nil
+			}
+			return "", nil
+		})
 
-		AssertEqual(
-			t, result.State.Error, tt.expResults[0].State.Error,
-			"unexpected result error message, "+tt.desc)
-		AssertEqual(
-			t, result.State.Status, tt.expResults[0].State.Status,
-			"unexpected response status, "+tt.desc)
-		AssertEqual(
-			t, result.Loc, tt.expResults[0].Loc,
-			"unexpected module location, "+tt.desc)
+	result, err := ss.Prep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AssertEqual(t, result.NeedsReboot, false, "unexpected reboot required")
+	AssertEqual(t, result.Namespaces, mockPmemDevs(t, nsOut),
+		"unexpected namespaces")
+	AssertEqual(t, result.Regions, []ScmSocketRegion{
+		{
+			SocketID:  0,
+			RegionIDs: []string{"0x2aba7f4828ef2ccc"},
+			Capacity:  3012 << 30,
+		},
+		{
+			SocketID:  1,
+			RegionIDs: []string{"0x81187f4881f02ccc", "0x91187f4881f02ccc"},
+			Capacity:  1512 << 30,
+		},
+	}, "unexpected socket regions")
+
+	// free capacity is aggregated and only AppDirect regions are counted
+	AssertEqual(t, socketRegions([]scmRegion{
+		{iSetID: "0x1", memType: "AppDirect", capacity: 1008, freeCapacity: 8, socketID: 1},
+		{iSetID: "0x2", memType: "Volatile", capacity: 1008, freeCapacity: 1008, socketID: 1},
+		{iSetID: "0x3", memType: "AppDirect", capacity: 1008, freeCapacity: 0.5, socketID: 1},
+	}), []ScmSocketRegion{
+		{
+			SocketID:     1,
+			RegionIDs:    []string{"0x1", "0x3"},
+			Capacity:     2016 << 30,
+			FreeCapacity: 8<<30 + 1<<29,
+		},
+	}, "unexpected aggregation")
+}
+
+func TestRegionInterleaveWidth(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=3012.0 GiB\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"   HealthState=Healthy\n" +
+		"   SocketID=0x0001\n" +
+		"   DimmID=0x1001, 0x1011, 0x1101, 0x1111, 0x1201, 0x1211\n" +
+		"---ISetID=0x81187f4881f02ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   Capacity=3012.0 GiB\n" +
+		"   FreeCapacity=3012.0 GiB\n" +
+		"   HealthState=Healthy\n" +
+		"\n"
+
+	regions, err := parseRegions(regionsOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AssertEqual(t, regions, []scmRegion{
+		{
+			iSetID:       "0x2aba7f4828ef2ccc",
+			memType:      "AppDirect",
+			capacity:     3012,
+			freeCapacity: 3012,
+			healthState:  "Healthy",
+			socketID:     1,
+			width:        6,
+			dimmIDs: []string{
+				"0x1001", "0x1011", "0x1101", "0x1111", "0x1201",
+				"0x1211",
+			},
+		},
+		{
+			iSetID:       "0x81187f4881f02ccc",
+			memType:      "AppDirect",
+			capacity:     3012,
+			freeCapacity: 3012,
+			healthState:  "Healthy",
+		},
+	}, "unexpected regions")
+
+	// namespace sizes align to the interleave set width where known
+	AssertEqual(t, regions[0].usableBytes(10), uint64(2706<<30),
+		"unexpected usable bytes with known width")
+	AssertEqual(t, regions[1].usableBytes(10), uint64(2710<<30),
+		"unexpected usable bytes with unknown width")
+
+	// mkfs stripe width follows the interleave set width of the region
+	// rather than the number of modules discovered on the socket
+	config := defaultMockConfig(t)
+	ss := defaultMockScmStorage(&config).withRunCmd(
+		func(cmd string) (string, error) {
+			return regionsOut, nil
+		})
+	if err := ss.getState(context.Background()); err != nil {
+		t.Fatal(err)
 	}
+	ss.pmemDevs = []pmemDev{{Blockdev: "pmem1", NumaNode: 1}}
+	srv := newDefaultServer()
+	AssertEqual(t, ss.mkfsParams("/dev/pmem1", &srv),
+		mkfsParams{fsType: scmFsExt4, stride: 1, stripeWidth: 6, discard: scmNoDiscard},
+		"unexpected mkfs params")
 }
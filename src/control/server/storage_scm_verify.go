@@ -0,0 +1,191 @@
+//
+// (C) Copyright 2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// names of checks performed by Verify
+const (
+	scmCheckRegions    = "regions"
+	scmCheckNamespaces = "namespaces"
+	scmCheckMounts     = "mounts"
+)
+
+// scmCheck records the outcome of a single scm verification check, Fault is
+// set if the check failed.
+type scmCheck struct {
+	Name  string
+	Fault error
+}
+
+func (c scmCheck) Passed() bool {
+	return c.Fault == nil
+}
+
+// scmVerifyReport lists the outcome of scm verification checks in the order
+// they were performed.
+type scmVerifyReport []scmCheck
+
+// Passed indicates whether all checks in the report passed.
+func (r scmVerifyReport) Passed() bool {
+	for _, check := range r {
+		if !check.Passed() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Verify checks that the scm setup matches configuration: AppDirect regions
+// exist in the configured interleave mode, each region has namespaces and configured scm
+// mount points are mounted with the expected options.
+//
+// Verify is read-only, no changes are made to scm or to mounts.
+func (s *scmStorage) Verify() scmVerifyReport {
+	return scmVerifyReport{
+		{Name: scmCheckRegions, Fault: s.verifyRegions()},
+		{Name: scmCheckNamespaces, Fault: s.verifyNamespaces()},
+		{Name: scmCheckMounts, Fault: s.verifyMounts()},
+	}
+}
+
+func (s *scmStorage) verifyRegions() error {
+	regions, err := s.ipmctlOps(context.Background()).GetRegions()
+	if err != nil {
+		return FaultScmVerifyFailed(scmCheckRegions, err.Error())
+	}
+	if len(regions) == 0 {
+		return FaultScmVerifyFailed(scmCheckRegions, "no regions")
+	}
+
+	for _, region := range regions {
+		if region.memType != s.regionMemType() {
+			return FaultScmVerifyFailed(scmCheckRegions, fmt.Sprintf(
+				"region %s is %s, want %s",
+				region.iSetID, region.memType, s.regionMemType()))
+		}
+	}
+
+	return nil
+}
+
+// verifyNamespaces checks that capacity has been allocated to namespaces in
+// each ndctl region.
+func (s *scmStorage) verifyNamespaces() error {
+	out, err := s.execCmd(cmdScmListNdRegions)
+	if err != nil {
+		return FaultScmVerifyFailed(scmCheckNamespaces, err.Error())
+	}
+	regions, err := parseNdRegions(out)
+	if err != nil {
+		return FaultScmVerifyFailed(scmCheckNamespaces, err.Error())
+	}
+
+	var empty []string
+	for _, region := range regions {
+		if region.AvailableSize >= region.Size {
+			empty = append(empty, region.Dev)
+		}
+	}
+	if len(empty) > 0 {
+		return FaultScmVerifyFailed(scmCheckNamespaces,
+			"no namespaces in "+strings.Join(empty, ", "))
+	}
+
+	return nil
+}
+
+// verifyMounts checks that the scm mount points of each server are mounted
+// with the requested options in effect.
+func (s *scmStorage) verifyMounts() error {
+	var problems []string
+
+	for _, srv := range s.config.Servers {
+		mntPoints := []string{srv.ScmMount}
+		mntOpts := ""
+		switch {
+		case srv.ScmClass == scmDCPM && len(srv.ScmList) > 1:
+			var err error
+			if mntPoints, err = scmDevMounts(&srv); err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			mntOpts = "dax"
+		default:
+			_, _, opts, _, err := getMntParams(&srv)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			if srv.ScmClass == scmDCPM {
+				mntOpts = opts
+			}
+		}
+
+		for _, mntPoint := range mntPoints {
+			if problem := s.verifyMount(mntPoint, mntOpts); problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return FaultScmVerifyFailed(scmCheckMounts,
+			strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// verifyMount returns a description of the problem with the given mount, or
+// an empty string if it is mounted with all requested flag options.
+func (s *scmStorage) verifyMount(mntPoint, reqOpts string) string {
+	mounted, err := s.config.ext.isMountPoint(mntPoint)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", mntPoint, err)
+	}
+	if !mounted {
+		return mntPoint + " not mounted"
+	}
+	if reqOpts == "" {
+		return ""
+	}
+
+	effective, err := s.config.ext.mountOptions(mntPoint)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", mntPoint, err)
+	}
+	for _, opt := range strings.Split(reqOpts, ",") {
+		if !optionInEffect(opt, effective) {
+			return fmt.Sprintf("%s mounted without %s", mntPoint, opt)
+		}
+	}
+
+	return ""
+}
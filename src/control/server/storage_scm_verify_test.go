@@ -0,0 +1,116 @@
+//
+// (C) Copyright 2019 Intel Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// GOVERNMENT LICENSE RIGHTS-OPEN SOURCE SOFTWARE
+// The Government's rights to use, modify, reproduce, release, perform, display,
+// or disclose this software are subject to the terms of the Apache License as
+// provided in Contract No. 8F-30005.
+// Any reproduction of computer software, computer software documentation, or
+// portions thereof marked with this legend must also reproduce the markings.
+//
+
+package server
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	. "github.com/daos-stack/daos/src/control/common"
+)
+
+func TestVerify(t *testing.T) {
+	regionsOut := "\n" +
+		"---ISetID=0x2aba7f4828ef2ccc---\n" +
+		"   PersistentMemoryType=AppDirect\n" +
+		"   FreeCapacity=0.0 GiB\n" +
+		"\n"
+	ndRegionsOut := `[{"dev":"region0","size":1082331758592,` +
+		`"available_size":0,"numa_node":0}]`
+
+	tests := []struct {
+		desc           string
+		mounted        bool
+		notInterleaved bool
+		expFaults      []error
+	}{
+		{
+			desc:      "matches config",
+			mounted:   true,
+			expFaults: []error{nil, nil, nil},
+		},
+		{
+			desc: "missing mount",
+			expFaults: []error{
+				nil, nil,
+				FaultScmVerifyFailed(scmCheckMounts,
+					"/mnt/daos not mounted"),
+			},
+		},
+		{
+			desc:           "interleave mode mismatch",
+			mounted:        true,
+			notInterleaved: true,
+			expFaults: []error{
+				FaultScmVerifyFailed(scmCheckRegions,
+					"region 0x2aba7f4828ef2ccc is AppDirect, want AppDirectNotInterleaved"),
+				nil, nil,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		config := defaultMockConfig(t)
+		config.ScmInterleaved = !tt.notInterleaved
+		config.Servers[0].ScmClass = scmDCPM
+		config.Servers[0].ScmList = []string{"/dev/pmem0"}
+		config.ext.(*mockExt).isMountPointRet = tt.mounted
+		config.ext.(*mockExt).mountOptsRet = []string{"rw", "relatime", "dax"}
+
+		var commands []string
+		ss := defaultMockScmStorage(&config).withRunCmd(
+			func(cmd string) (string, error) {
+				commands = append(commands, cmd)
+				switch cmd {
+				case cmdScmShowRegions:
+					return regionsOut, nil
+				case cmdScmListNdRegions:
+					return ndRegionsOut, nil
+				}
+				return "", errors.Errorf("unexpected command %q", cmd)
+			})
+
+		report := ss.Verify()
+
+		AssertEqual(t, len(report), len(tt.expFaults),
+			tt.desc+": unexpected number of checks")
+		expPassed := true
+		for i, check := range report {
+			AssertEqual(t, check.Fault, tt.expFaults[i],
+				tt.desc+": unexpected result of check "+check.Name)
+			expPassed = expPassed && tt.expFaults[i] == nil
+		}
+		AssertEqual(t, report.Passed(), expPassed,
+			tt.desc+": unexpected report outcome")
+
+		// only queries should be issued
+		AssertEqual(t, commands,
+			[]string{cmdScmShowRegions, cmdScmListNdRegions},
+			tt.desc+": unexpected commands")
+		AssertEqual(t, config.ext.getHistory(),
+			[]string{"check if dir /mnt/daos is mounted"},
+			tt.desc+": unexpected operations")
+	}
+}